@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,8 +16,22 @@ import (
 type Fakeserver struct {
 	server  *http.Server
 	objects map[string]map[string]interface{}
-	debug   bool
-	running bool
+	/* Held while handling a request for objects, which come in concurrently */
+	objectsMutex sync.Mutex
+	debug        bool
+	running      bool
+
+	/* Simulated API behavior - see simulate */
+	username   string
+	password   string
+	token      string
+	latency    time.Duration
+	errorRate  float64
+	asyncDelay time.Duration
+	mutex      sync.Mutex
+	operations map[string]*operation
+
+	persistFile string
 }
 
 /*NewFakeServer creates a HTTP server used for tests and debugging*/
@@ -24,9 +39,10 @@ func NewFakeServer(iPort int, iObjects map[string]map[string]interface{}, iStart
 	serverMux := http.NewServeMux()
 
 	svr := &Fakeserver{
-		debug:   iDebug,
-		objects: iObjects,
-		running: false,
+		debug:      iDebug,
+		objects:    iObjects,
+		running:    false,
+		operations: make(map[string]*operation),
 	}
 
 	//If we were passed an argument for where to serve /static from...
@@ -43,10 +59,11 @@ func NewFakeServer(iPort int, iObjects map[string]map[string]interface{}, iStart
 	}
 
 	serverMux.HandleFunc("/api/", svr.handleAPIObject)
+	serverMux.HandleFunc("/api/operations/", svr.handleOperation)
 
 	apiObjectServer := &http.Server{
 		Addr:    fmt.Sprintf("127.0.0.1:%d", iPort),
-		Handler: serverMux,
+		Handler: svr.simulate(serverMux),
 	}
 
 	svr.server = apiObjectServer
@@ -63,7 +80,7 @@ func NewFakeServer(iPort int, iObjects map[string]map[string]interface{}, iStart
 
 /*StartInBackground starts the HTTP server in the background*/
 func (svr *Fakeserver) StartInBackground() {
-	go svr.server.ListenAndServe()
+	go svr.ListenAndServe()
 
 	/* Let the server start */
 	time.Sleep(1 * time.Second)
@@ -94,6 +111,9 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 	/* Assume this will never fail */
 	b, _ := ioutil.ReadAll(r.Body)
 
+	svr.objectsMutex.Lock()
+	defer svr.objectsMutex.Unlock()
+
 	if svr.debug {
 		log.Printf("fakeserver.go: Recieved request: %+v\n", r)
 		log.Printf("fakeserver.go: Headers:\n")
@@ -169,6 +189,7 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "DELETE" {
 		/* Get rid of this one */
 		delete(svr.objects, id)
+		svr.persist()
 		if svr.debug {
 			log.Printf("fakeserver.go: Object deleted.\n")
 		}
@@ -182,11 +203,10 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 
 		err := json.Unmarshal(b, &obj)
 		if err != nil {
-			/* Failure goes back to the user as a 500. Log data here for
-			   debugging (which shouldn't ever fail!) */
-			log.Fatalf("fakeserver.go: Unmarshal of request failed: %s\n", err)
-			log.Fatalf("\nBEGIN passed data:\n%s\nEND passed data.", string(b))
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			/* Failure goes back to the user as a 400, rather than
+			   stopping the server (and any tests running it) */
+			log.Printf("fakeserver.go: Unmarshal of request failed: %s\nBEGIN passed data:\n%s\nEND passed data.\n", err, string(b))
+			http.Error(w, fmt.Sprintf("Request body is not a JSON object: %s", err), http.StatusBadRequest)
 			return
 		}
 		/* In the case of POST above, id is not yet known - set it here */
@@ -211,6 +231,7 @@ func (svr *Fakeserver) handleAPIObject(w http.ResponseWriter, r *http.Request) {
 			log.Printf("fakeserver.go: Overwriting %s with new data:%+v\n", id, obj)
 		}
 		svr.objects[id] = obj
+		svr.persist()
 
 		/* Coax the data we were sent back to JSON and send it to the user */
 		b, _ := json.Marshal(obj)
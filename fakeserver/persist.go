@@ -0,0 +1,133 @@
+package fakeserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+SetPersistFile loads the server's objects from file, if it exists, and
+
+	saves them to it after every change so they survive restarts
+*/
+func (svr *Fakeserver) SetPersistFile(file string) error {
+	svr.persistFile = file
+	if file == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	objects := make(map[string]map[string]interface{})
+	if err := json.Unmarshal(b, &objects); err != nil {
+		return fmt.Errorf("persisted objects in '%s' are not valid: %v", file, err)
+	}
+	for id, obj := range objects {
+		svr.objects[id] = obj
+	}
+	if svr.debug {
+		log.Printf("fakeserver.go: Loaded %d objects from '%s'\n", len(objects), file)
+	}
+	return nil
+}
+
+/*Save the objects to the persist file, if one is set. Must hold objectsMutex*/
+func (svr *Fakeserver) persist() {
+	if svr.persistFile == "" {
+		return
+	}
+	b, err := json.MarshalIndent(svr.objects, "", "  ")
+	if err == nil {
+		err = writeFile(svr.persistFile, b)
+	}
+	if err != nil {
+		log.Printf("fakeserver.go: WARNING: Failed to save objects to '%s': %s\n", svr.persistFile, err)
+	}
+}
+
+/*
+Replace file with b through a uniquely named temporary file that is
+
+	renamed into place, so a crash never leaves half a file
+*/
+func writeFile(file string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+/*
+EnableTLS makes the server serve https with a self-signed certificate
+
+	for 127.0.0.1 and localhost, generated now. Returns the certificate
+	in PEM format, for clients to trust (such as with cacerts_file)
+*/
+func (svr *Fakeserver) EnableTLS() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fakeserver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	svr.server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+/*ListenAndServe serves until the server is shut down, over https if EnableTLS was called*/
+func (svr *Fakeserver) ListenAndServe() error {
+	if svr.server.TLSConfig != nil {
+		return svr.server.ListenAndServeTLS("", "")
+	}
+	return svr.server.ListenAndServe()
+}
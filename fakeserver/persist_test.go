@@ -0,0 +1,103 @@
+package fakeserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPersistFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "objects.json")
+
+	svr := NewFakeServer(0, map[string]map[string]interface{}{}, false, false, "")
+	if err := svr.SetPersistFile(file); err != nil {
+		t.Fatalf("persist_test.go: %s", err)
+	}
+	ts := httptest.NewServer(svr.GetServer().Handler)
+	for _, id := range []string{"1", "2"} {
+		resp, err := http.Post(ts.URL+"/api/objects", "application/json", strings.NewReader(`{"id":"`+id+`","name":"foo"}`))
+		if err != nil {
+			t.Fatalf("persist_test.go: %s", err)
+		}
+		resp.Body.Close()
+	}
+	req, _ := http.NewRequest("DELETE", ts.URL+"/api/objects/2", nil)
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("persist_test.go: %s", err)
+	}
+	ts.Close()
+
+	/* A restarted server has the objects left before */
+	objects := map[string]map[string]interface{}{}
+	restarted := NewFakeServer(0, objects, false, false, "")
+	if err := restarted.SetPersistFile(file); err != nil {
+		t.Fatalf("persist_test.go: %s", err)
+	}
+	if len(objects) != 1 || objects["1"]["name"] != "foo" {
+		t.Fatalf("persist_test.go: Expected object 1 to be loaded but got %v", objects)
+	}
+}
+
+func TestPersistFileConcurrent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "objects.json")
+	svr := NewFakeServer(0, map[string]map[string]interface{}{}, false, false, "")
+	if err := svr.SetPersistFile(file); err != nil {
+		t.Fatalf("persist_test.go: %s", err)
+	}
+	ts := httptest.NewServer(svr.GetServer().Handler)
+	defer ts.Close()
+
+	/* As terraform apply -parallelism=10 would */
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			resp, err := http.Post(ts.URL+"/api/objects", "application/json", strings.NewReader(fmt.Sprintf(`{"id":"%d"}`, id)))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	objects := map[string]map[string]interface{}{}
+	if err := NewFakeServer(0, objects, false, false, "").SetPersistFile(file); err != nil || len(objects) != 20 {
+		t.Fatalf("persist_test.go: Expected all 20 objects to be saved but got %d (%v)", len(objects), err)
+	}
+}
+
+func TestEnableTLS(t *testing.T) {
+	svr := NewFakeServer(0, map[string]map[string]interface{}{"1": {"id": "1"}}, false, false, "")
+	cert, err := svr.EnableTLS()
+	if err != nil {
+		t.Fatalf("persist_test.go: %s", err)
+	}
+
+	ts := httptest.NewUnstartedServer(svr.GetServer().Handler)
+	ts.TLS = svr.GetServer().TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(cert) {
+		t.Fatalf("persist_test.go: Expected a PEM certificate but got %s", cert)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get(ts.URL + "/api/objects/1")
+	if err != nil {
+		t.Fatalf("persist_test.go: Expected the generated certificate to be trusted: %s", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != `{"id":"1"}` {
+		t.Fatalf("persist_test.go: Unexpected response %s", b)
+	}
+}
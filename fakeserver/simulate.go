@@ -0,0 +1,151 @@
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+/*An operation started by a request while async is simulated*/
+type operation struct {
+	done   time.Time
+	status int
+	body   []byte
+}
+
+/*SetBasicAuth makes the server refuse requests to /api/ without these credentials*/
+func (svr *Fakeserver) SetBasicAuth(username string, password string) {
+	svr.username = username
+	svr.password = password
+}
+
+/*SetBearerToken makes the server refuse requests to /api/ without this bearer token*/
+func (svr *Fakeserver) SetBearerToken(token string) {
+	svr.token = token
+}
+
+/*SetLatency delays every response from /api/ by latency*/
+func (svr *Fakeserver) SetLatency(latency time.Duration) {
+	svr.latency = latency
+}
+
+/*
+SetErrorRate makes the server answer this fraction (0 to 1) of requests
+
+	to /api/ with a 429 Too Many Requests (with a Retry-After of one
+	second) or a 500 Internal Server Error, before doing anything else
+*/
+func (svr *Fakeserver) SetErrorRate(rate float64) {
+	svr.errorRate = rate
+}
+
+/*
+SetAsyncDelay makes creates, updates and deletes answer 202 Accepted
+
+	with a Location of /api/operations/{id}, which answers 202 with
+	{"status": "running"} until delay has passed, and then with the
+	response the request would have had. Objects are changed at once
+*/
+func (svr *Fakeserver) SetAsyncDelay(delay time.Duration) {
+	svr.asyncDelay = delay
+}
+
+/*Wrap next with the simulated latency, auth, errors and async operations*/
+func (svr *Fakeserver) simulate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if svr.latency > 0 {
+			time.Sleep(svr.latency)
+		}
+
+		if !svr.authorized(r) {
+			if svr.debug {
+				log.Printf("fakeserver.go: Refusing unauthorized request %s %s\n", r.Method, r.URL.Path)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="fakeserver"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if svr.errorRate > 0 && rand.Float64() < svr.errorRate {
+			status := http.StatusInternalServerError
+			if rand.Intn(2) == 0 {
+				status = http.StatusTooManyRequests
+				w.Header().Set("Retry-After", "1")
+			}
+			if svr.debug {
+				log.Printf("fakeserver.go: Simulating a %d for %s %s\n", status, r.Method, r.URL.Path)
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		if svr.asyncDelay <= 0 || r.Method == "GET" || strings.HasPrefix(r.URL.Path, "/api/operations/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		/* Keep the response until the operation is done */
+		recorder := httptest.NewRecorder()
+		next.ServeHTTP(recorder, r)
+		if recorder.Code < 200 || recorder.Code >= 300 {
+			for name, values := range recorder.Header() {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(recorder.Code)
+			w.Write(recorder.Body.Bytes())
+			return
+		}
+
+		svr.mutex.Lock()
+		id := fmt.Sprintf("%d", len(svr.operations)+1)
+		svr.operations[id] = &operation{done: time.Now().Add(svr.asyncDelay), status: recorder.Code, body: recorder.Body.Bytes()}
+		svr.mutex.Unlock()
+
+		w.Header().Set("Location", "/api/operations/"+id)
+		w.WriteHeader(http.StatusAccepted)
+		b, _ := json.Marshal(map[string]string{"operation_id": id, "status": "running"})
+		w.Write(b)
+	})
+}
+
+/*Whether r has the credentials set with SetBasicAuth or SetBearerToken, if any*/
+func (svr *Fakeserver) authorized(r *http.Request) bool {
+	if svr.username == "" && svr.token == "" {
+		return true
+	}
+	if username, password, ok := r.BasicAuth(); ok && svr.username != "" && username == svr.username && password == svr.password {
+		return true
+	}
+	return svr.token != "" && r.Header.Get("Authorization") == "Bearer "+svr.token
+}
+
+func (svr *Fakeserver) handleOperation(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/operations/")
+
+	svr.mutex.Lock()
+	op, ok := svr.operations[id]
+	svr.mutex.Unlock()
+	if !ok || r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if time.Now().Before(op.done) {
+		w.WriteHeader(http.StatusAccepted)
+		b, _ := json.Marshal(map[string]string{"operation_id": id, "status": "running"})
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(op.status)
+	w.Write(op.body)
+}
@@ -0,0 +1,68 @@
+package fakeserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	svr := NewFakeServer(0, map[string]map[string]interface{}{}, false, false, "")
+	svr.SetBasicAuth("admin", "secret")
+	svr.SetBearerToken("abc")
+	svr.SetAsyncDelay(200 * time.Millisecond)
+	ts := httptest.NewServer(svr.GetServer().Handler)
+	defer ts.Close()
+
+	send := func(method string, path string, body string, auth func(*http.Request)) (*http.Response, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if auth != nil {
+			auth(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("simulate_test.go: %s", err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(b)
+	}
+	basic := func(r *http.Request) { r.SetBasicAuth("admin", "secret") }
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc") }
+
+	if resp, _ := send("GET", "/api/objects", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("simulate_test.go: Expected a request without credentials to be refused but got %d", resp.StatusCode)
+	}
+	if resp, _ := send("GET", "/api/objects", "", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("simulate_test.go: Expected a wrong password to be refused but got %d", resp.StatusCode)
+	}
+
+	resp, _ := send("POST", "/api/objects", `{"id":"1","name":"foo"}`, basic)
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusAccepted || location != "/api/operations/1" {
+		t.Fatalf("simulate_test.go: Expected the create to start an operation but got %d at '%s'", resp.StatusCode, location)
+	}
+	if resp, body := send("GET", location, "", bearer); resp.StatusCode != http.StatusAccepted || !strings.Contains(body, `"running"`) {
+		t.Fatalf("simulate_test.go: Expected the operation to be running but got %d %s", resp.StatusCode, body)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if resp, body := send("GET", location, "", bearer); resp.StatusCode != http.StatusOK || body != `{"id":"1","name":"foo"}` {
+		t.Fatalf("simulate_test.go: Expected the operation to finish with the object but got %d %s", resp.StatusCode, body)
+	}
+
+	svr.SetAsyncDelay(0)
+	svr.SetErrorRate(1)
+	if resp, _ := send("GET", "/api/objects/1", "", bearer); resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("simulate_test.go: Expected a simulated error but got %d", resp.StatusCode)
+	}
+
+	svr.SetErrorRate(0)
+	svr.SetLatency(100 * time.Millisecond)
+	start := time.Now()
+	if resp, _ := send("GET", "/api/objects/1", "", bearer); resp.StatusCode != http.StatusOK || time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("simulate_test.go: Expected a delayed response but got %d after %s", resp.StatusCode, time.Since(start))
+	}
+}
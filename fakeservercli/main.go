@@ -14,16 +14,47 @@ func main() {
 	port := flag.Int("port", 8080, "The port fakeserver will listen on")
 	debug := flag.Bool("debug", false, "Enable debug output of the server")
 	staticDir := flag.String("static_dir", "", "Serve static content from this directory")
+	username := flag.String("username", "", "Require basic auth with this username (and -password)")
+	password := flag.String("password", "", "The password for -username")
+	token := flag.String("token", "", "Require this bearer token (or the -username credentials)")
+	latency := flag.Duration("latency", 0, "Delay every response by this long, such as 250ms")
+	errorRate := flag.Float64("error_rate", 0, "Answer this fraction (0 to 1) of requests with a 429 or 500")
+	asyncDelay := flag.Duration("async_delay", 0, "Answer creates, updates and deletes with 202 Accepted and an operation that finishes after this long")
+	persistFile := flag.String("persist_file", "", "Load objects from this file at start, and save them to it after every change")
+	useTLS := flag.Bool("tls", false, "Serve https with a self-signed certificate generated at start")
+	certFile := flag.String("cert_file", "fakeserver.pem", "With -tls, write the generated certificate here for clients to trust")
 
 	flag.Parse()
 
 	svr := fakeserver.NewFakeServer(*port, apiServerObjects, false, *debug, *staticDir)
+	svr.SetBasicAuth(*username, *password)
+	svr.SetBearerToken(*token)
+	svr.SetLatency(*latency)
+	svr.SetErrorRate(*errorRate)
+	svr.SetAsyncDelay(*asyncDelay)
+	if err := svr.SetPersistFile(*persistFile); err != nil {
+		fmt.Printf("Error loading objects: %s\n", err)
+		os.Exit(1)
+	}
+
+	scheme := "http"
+	if *useTLS {
+		cert, err := svr.EnableTLS()
+		if err == nil {
+			err = os.WriteFile(*certFile, cert, 0o644)
+		}
+		if err != nil {
+			fmt.Printf("Error setting up TLS: %s\n", err)
+			os.Exit(1)
+		}
+		scheme = "https"
+		fmt.Printf("Wrote the server's certificate to %s\n", *certFile)
+	}
 
-	fmt.Printf("Starting server on port %d...\n", *port)
+	fmt.Printf("Starting server on %s://127.0.0.1:%d...\n", scheme, *port)
 	fmt.Println("Objects are at /api/objects/{id}")
 
-	internalServer := svr.GetServer()
-	err := internalServer.ListenAndServe()
+	err := svr.ListenAndServe()
 	if nil != err {
 		fmt.Printf("Error with the internal TCP server: %s", err)
 		os.Exit(1)
@@ -0,0 +1,284 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Wait for an operation the API finishes in the background, as set by
+
+	the operation's *_async block or follow_accepted. resp and body are
+	the response that started the operation. Returns the object at the
+	operation's result_uri_key, or "" if it has none. Does nothing if
+	the operation is not async
+*/
+func (obj *APIObject) waitForOperation(operation string, resp *http.Response, body string) (string, error) {
+	settings := obj.asyncFor(operation, resp)
+	if settings == nil {
+		return "", nil
+	}
+	status, err := obj.awaitOperation(operation, settings, resp, body)
+	if err != nil || settings.ResultUriKey == "" {
+		return "", err
+	}
+
+	value, err := GetStringAtKey(status, settings.ResultUriKey, obj.debug)
+	if err != nil {
+		return "", fmt.Errorf("the %s of '%s' finished, but its status has no result at result_uri_key '%s': %v", operation, obj.id, settings.ResultUriKey, err)
+	}
+	resultPath, err := apiPath(value, obj.apiClient.currentURI())
+	if err != nil {
+		return "", err
+	}
+	result, _, err := obj.exchange("GET", resultPath, "", obj.requestHeaders("read", nil))
+	if err != nil {
+		return "", fmt.Errorf("the %s of '%s' finished, but its result could not be read from %s: %v", operation, obj.id, resultPath, err)
+	}
+	return result, nil
+}
+
+/* Wait for an operation with settings, returning its final status */
+func (obj *APIObject) awaitOperation(operation string, settings *AsyncSettings, resp *http.Response, body string) (map[string]interface{}, error) {
+	/* The operation's callback or event is found by the id in the response */
+	callbackID := ""
+	if settings.CallbackKey != "" {
+		var started map[string]interface{}
+		if err := decodeJSON([]byte(body), &started); err == nil {
+			callbackID, _ = GetStringAtKey(started, settings.CallbackKey, obj.debug)
+		}
+		if callbackID == "" {
+			return nil, fmt.Errorf("the %s of '%s' started, but the response has no '%s' to find its callback by", operation, obj.id, settings.CallbackKey)
+		}
+	}
+
+	if settings.EventsPath != "" {
+		return obj.watchOperation(operation, settings, resp, body, callbackID)
+	}
+	if settings.WebsocketPath != "" {
+		return obj.watchWebSocket(operation, settings, resp, body, callbackID)
+	}
+
+	statusPath, err := obj.operationStatusPath(settings, resp, body)
+	if err != nil {
+		return nil, fmt.Errorf("the %s of '%s' started, but %v", operation, obj.id, err)
+	}
+
+	pollInterval := time.Duration(settings.PollInterval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(settings.MaximumPollingDuration) * time.Second)
+
+	/* The API may say when to check first */
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if err := sleepContext(obj.context(), wait); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for {
+		statusBody, statusResp, err := obj.exchange("GET", statusPath, "", obj.requestHeaders("read", nil))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check the status of the %s of '%s' at %s: %v", operation, obj.id, statusPath, err)
+		}
+
+		/* Without a status_key, the operation URL answers 202 Accepted until the operation is done */
+		value := "202 Accepted"
+		var status map[string]interface{}
+		switch {
+		case settings.CallbackKey != "":
+			value = "no callback yet"
+			status, err = findCallback(statusBody, settings.CallbackKey, callbackID)
+		case settings.SearchKey == "":
+			if statusResp.StatusCode != http.StatusAccepted {
+				obj.apiClient.log(logAsync, "INFO", "Operation is done", map[string]interface{}{"operation": operation, "id": obj.id, "status_path": statusPath, "status_code": statusResp.StatusCode})
+				decodeJSON([]byte(statusBody), &status)
+				return status, nil
+			}
+			if decodeJSON([]byte(statusBody), &status) == nil {
+				obj.logProgress(operation, settings, status)
+				status = nil
+			}
+		default:
+			err = decodeJSON([]byte(statusBody), &status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("the status of the %s of '%s' at %s is not valid: %v", operation, obj.id, statusPath, err)
+		}
+
+		if status != nil {
+			var done bool
+			if done, value, err = obj.operationStatus(operation, settings, status, statusBody); done || err != nil {
+				return status, err
+			}
+		}
+
+		wait := pollInterval
+		if retryAfter, ok := parseRetryAfter(statusResp.Header.Get("Retry-After"), time.Now()); ok {
+			wait = retryAfter
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("timed out after %d seconds waiting for the %s of '%s' to finish (%s is '%s')", settings.MaximumPollingDuration, operation, obj.id, statusPath, value)
+		}
+		if obj.debug {
+			obj.apiClient.log(logAsync, "DEBUG", "Operation is still running", map[string]interface{}{"operation": operation, "id": obj.id, "status_path": statusPath, "status": value, "next_check": wait.String()})
+		}
+		if err := sleepContext(obj.context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+/*
+Whether status (from the operation URL, a callback or an event) says
+
+	the operation is done, along with its status for logging. An error
+	means the operation failed
+*/
+func (obj *APIObject) operationStatus(operation string, settings *AsyncSettings, status map[string]interface{}, raw string) (bool, string, error) {
+	if settings.SearchKey == "" {
+		obj.apiClient.log(logAsync, "INFO", "Operation is done (its callback or event was received)", map[string]interface{}{"operation": operation, "id": obj.id})
+		return true, "", nil
+	}
+	value, err := GetStringAtKey(status, settings.SearchKey, obj.debug)
+	if err == nil && settings.isDone(value) {
+		obj.apiClient.log(logAsync, "INFO", "Operation is done", map[string]interface{}{"operation": operation, "id": obj.id, "status_key": settings.SearchKey, "status": value})
+		return true, value, nil
+	}
+	if err == nil && settings.matches(settings.FailureValues, value) {
+		return false, value, fmt.Errorf("the %s of '%s' failed: %s is %s (%s)", operation, obj.id, settings.SearchKey, value, raw)
+	}
+	obj.logProgress(operation, settings, status)
+	return false, value, nil
+}
+
+/* Whether value is SearchValue or one of SearchValues */
+func (settings *AsyncSettings) isDone(value string) bool {
+	return (settings.SearchValue != "" && settings.matches([]string{settings.SearchValue}, value)) || settings.matches(settings.SearchValues, value)
+}
+
+/* Whether value is one of values, ignoring case with CaseInsensitive */
+func (settings *AsyncSettings) matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || (settings.CaseInsensitive && strings.EqualFold(v, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Log the value at ProgressKey in the status of an unfinished operation,
+
+	so long operations show movement in the provider's logs
+*/
+func (obj *APIObject) logProgress(operation string, settings *AsyncSettings, status map[string]interface{}) {
+	if settings.ProgressKey == "" {
+		return
+	}
+	if progress, err := GetStringAtKey(status, settings.ProgressKey, false); err == nil {
+		obj.apiClient.log(logAsync, "INFO", "Operation is in progress", map[string]interface{}{"operation": operation, "id": obj.id, "progress_key": settings.ProgressKey, "progress": progress})
+	}
+}
+
+/*
+The async settings for an operation: its *_async block or, with
+
+	follow_accepted, polling the Location of a 202 Accepted response
+	until it stops answering 202. nil if the operation is not async
+*/
+func (obj *APIObject) asyncFor(operation string, resp *http.Response) *AsyncSettings {
+	if settings := obj.asyncSettings[operation]; settings != nil {
+		return settings
+	}
+	if obj.followAccepted && resp != nil && resp.StatusCode == http.StatusAccepted && resp.Header.Get("Location") != "" {
+		return &AsyncSettings{PollInterval: 5, MaximumPollingDuration: 300}
+	}
+	return nil
+}
+
+/*
+The path to poll for the status of an operation: StatusPath with its
+
+	placeholders filled from the response (see fillFromResponse), the
+	value at RedirectUriKey in the response, or its Location header
+*/
+func (obj *APIObject) operationStatusPath(settings *AsyncSettings, resp *http.Response, body string) (string, error) {
+	location := ""
+	if settings.StatusPath != "" {
+		var err error
+		if location, err = obj.fillFromResponse(settings.StatusPath, body); err != nil {
+			return "", err
+		}
+	} else if settings.RedirectUriKey != "" {
+		var data map[string]interface{}
+		if err := decodeJSON([]byte(body), &data); err != nil {
+			return "", fmt.Errorf("the response is not a JSON object to find redirect_uri_key '%s' in: %v", settings.RedirectUriKey, err)
+		}
+		value, err := GetStringAtKey(data, settings.RedirectUriKey, false)
+		if err != nil {
+			return "", fmt.Errorf("the response has no operation URL at redirect_uri_key '%s': %v", settings.RedirectUriKey, err)
+		}
+		location = value
+	} else if resp != nil {
+		location = resp.Header.Get("Location")
+	}
+	if location == "" {
+		return "", fmt.Errorf("the response has no Location header to poll for its status; set redirect_uri_key")
+	}
+
+	return apiPath(location, obj.apiClient.currentURI())
+}
+
+/*
+The path relative to base (the provider's uri) of a URL from the API.
+
+	Absolute URLs are taken to be on the API server. They, and paths
+	from the server's root, include base's path (such as /api/v1) when
+	it has one, which is left out so it is not sent twice
+*/
+func apiPath(location string, base string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("the URL '%s' from the API is invalid: %v", location, err)
+	}
+	path := location
+	if u.IsAbs() {
+		path = u.RequestURI()
+	}
+	if b, err := url.Parse(base); err == nil {
+		basePath := strings.TrimSuffix(b.Path, "/")
+		if basePath != "" && (u.IsAbs() || strings.HasPrefix(path, "/")) && (path == basePath || strings.HasPrefix(path, basePath+"/") || strings.HasPrefix(path, basePath+"?")) {
+			path = strings.TrimPrefix(path, basePath)
+		}
+	}
+	return path, nil
+}
+
+/*
+The entry of a callback queue (a JSON list) whose value at key is id,
+
+	or nil if the API has not called back yet
+*/
+func findCallback(body string, key string, id string) (map[string]interface{}, error) {
+	var queue []interface{}
+	if err := decodeJSON([]byte(body), &queue); err != nil {
+		return nil, fmt.Errorf("the callback queue is not a JSON list: %v", err)
+	}
+	for _, entry := range queue {
+		callback, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, err := GetStringAtKey(callback, key, false); err == nil && value == id {
+			return callback, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,424 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPIObjectDestroyAsync(t *testing.T) {
+	var polls int32
+	var svr *httptest.Server
+	svr = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE" && r.URL.Path == "/api/objects/1":
+			w.Header().Set("Location", svr.URL+"/operations/op-1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"operation":{"href":"/operations/op-2"}}`))
+		case r.URL.Path == "/operations/op-1":
+			if atomic.AddInt32(&polls, 1) < 3 {
+				w.Write([]byte(`{"status":"RUNNING"}`))
+				return
+			}
+			w.Write([]byte(`{"status":"SUCCEEDED"}`))
+		default:
+			w.Write([]byte(`{"status":"FAILED","error":"in use"}`))
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	settings := &AsyncSettings{SearchKey: "status", SearchValue: "SUCCEEDED", FailureValues: []string{"FAILED"}, PollInterval: 1, MaximumPollingDuration: 10}
+
+	/* The Location header points at the operation */
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if atomic.LoadInt32(&polls) != 3 {
+		t.Fatalf("api_async_test.go: Expected the operation to be polled until it succeeded but it was polled %d times", polls)
+	}
+
+	/* The operation URL is in the response body, and the operation fails */
+	failing := *settings
+	failing.RedirectUriKey = "operation/href"
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "2", asyncSettings: map[string]*AsyncSettings{"destroy": &failing}})
+	if err := object.deleteObject(); err == nil || !strings.Contains(err.Error(), "failed: status is FAILED") {
+		t.Fatalf("api_async_test.go: Expected the failed operation to fail the destroy but got %v", err)
+	}
+}
+
+func TestAPIObjectCreateAndUpdateAsync(t *testing.T) {
+	var done int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"1","operation":"/operations/create"}`))
+		case r.Method == "PUT":
+			w.Header().Set("Location", "/operations/update")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/operations/create":
+			atomic.StoreInt32(&done, 1)
+			w.Write([]byte(`{"id":"op-1","state":"done","result":"/api/objects/1"}`))
+		case r.URL.Path == "/operations/update":
+			atomic.StoreInt32(&done, 2)
+			w.Write([]byte(`{"phase":"COMPLETE"}`))
+		case r.URL.Path == "/api/objects/1":
+			if atomic.LoadInt32(&done) == 2 {
+				w.Write([]byte(`{"id":"1","name":"updated"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"1","name":"created"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path: "/api/objects",
+		data: `{"name":"created"}`,
+		asyncSettings: map[string]*AsyncSettings{
+			"create": {RedirectUriKey: "operation", SearchKey: "state", SearchValue: "done", ResultUriKey: "result", PollInterval: 1, MaximumPollingDuration: 10},
+			"update": {SearchKey: "phase", SearchValue: "COMPLETE", PollInterval: 1, MaximumPollingDuration: 10},
+		},
+	})
+
+	/* The id and object come from the operation's result, not the operation itself */
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if object.id != "1" || object.apiData["name"] != "created" {
+		t.Fatalf("api_async_test.go: Expected the created object to be read after the operation but got id '%s' and %v", object.id, object.apiData)
+	}
+
+	/* Without a result, the operation's id is not taken for the object's */
+	noResult := *object.asyncSettings["create"]
+	noResult.ResultUriKey = ""
+	unknown, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"name":"created"}`, asyncSettings: map[string]*AsyncSettings{"create": &noResult}})
+	if err := unknown.createObject(); err == nil || !strings.Contains(err.Error(), "id is not known") || unknown.id != "" {
+		t.Fatalf("api_async_test.go: Expected an error rather than the operation's id but got id '%s' and %v", unknown.id, err)
+	}
+
+	object.data["name"] = "updated"
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if object.apiData["name"] != "updated" {
+		t.Fatalf("api_async_test.go: Expected the updated object to be read after the operation but got %v", object.apiData)
+	}
+}
+
+func TestOperationStatusPath(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Location": []string{"https://api.example.com/operations/7?view=full"}}}
+	body := `{"operation_id":"op 1","meta":{"region":"eu"}}`
+	client, _ := NewAPIClient(&apiClientOpt{uri: "https://api.example.com", timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/objects", id: "1", data: `{"kind":"vm"}`})
+
+	cases := []struct {
+		settings AsyncSettings
+		expected string
+		err      string
+	}{
+		{AsyncSettings{}, "/operations/7?view=full", ""},
+		{AsyncSettings{StatusPath: "/operations/{operation_id}"}, "/operations/op%201", ""},
+		{AsyncSettings{StatusPath: "/{meta/region}/objects/{id}/operations/{operation_id}"}, "/eu/objects/1/operations/op%201", ""},
+		{AsyncSettings{StatusPath: "/{data.kind}/operations/{operation_id}"}, "/vm/operations/op%201", ""},
+		{AsyncSettings{StatusPath: "/operations/{job_id}"}, "", "no 'job_id'"},
+	}
+	for _, c := range cases {
+		path, err := object.operationStatusPath(&c.settings, resp, body)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("api_async_test.go: Expected an error containing '%s' for '%s' but got %v", c.err, c.settings.StatusPath, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("api_async_test.go: %s", err)
+		}
+		if path != c.expected {
+			t.Fatalf("api_async_test.go: Expected '%s' for '%s' but got '%s'", c.expected, c.settings.StatusPath, path)
+		}
+	}
+}
+
+func TestAPIPath(t *testing.T) {
+	cases := []struct {
+		location string
+		base     string
+		expected string
+	}{
+		{"https://host/api/v1/ops/1", "https://host/api/v1", "/ops/1"},
+		{"https://host/api/v1/ops/1?view=full", "https://host/api/v1/", "/ops/1?view=full"},
+		{"/api/v1/ops/1", "https://host/api/v1", "/ops/1"},
+		{"/ops/1", "https://host/api/v1", "/ops/1"},
+		{"https://host/api/v10/ops/1", "https://host/api/v1", "/api/v10/ops/1"},
+		{"https://host/ops/1", "https://host", "/ops/1"},
+	}
+	for _, c := range cases {
+		path, err := apiPath(c.location, c.base)
+		if err != nil || path != c.expected {
+			t.Fatalf("api_async_test.go: Expected '%s' on '%s' to be '%s' but got '%s' (%v)", c.location, c.base, c.expected, path, err)
+		}
+	}
+}
+
+func TestAPIObjectFollowAcceptedBasePath(t *testing.T) {
+	var polled []string
+	var svrURL string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT":
+			/* An absolute Location, as RFC 9110 has it */
+			w.Header().Set("Location", svrURL+"/api/v1/queue/1")
+			w.WriteHeader(http.StatusAccepted)
+		case strings.Contains(r.URL.Path, "/queue/"):
+			polled = append(polled, r.URL.Path)
+			w.Write([]byte(`{"id":"1","name":"updated"}`))
+		default:
+			w.Write([]byte(`{"id":"1","name":"updated"}`))
+		}
+	}))
+	defer svr.Close()
+	svrURL = svr.URL
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL + "/api/v1", timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/objects", id: "1", data: `{"name":"updated"}`, followAccepted: true})
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if len(polled) != 1 || polled[0] != "/api/v1/queue/1" {
+		t.Fatalf("api_async_test.go: Expected the Location to be polled without repeating the uri's path but got %v", polled)
+	}
+}
+
+func TestAPIObjectFollowAccepted(t *testing.T) {
+	var polls int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT":
+			w.Header().Set("Location", "/queue/1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/queue/1":
+			if atomic.AddInt32(&polls, 1) < 2 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.Write([]byte(`{"id":"1","name":"updated"}`))
+		default:
+			w.Write([]byte(`{"id":"1","name":"updated"}`))
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+
+	/* Without follow_accepted, the 202 is taken as the result */
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", data: `{"name":"updated"}`})
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if atomic.LoadInt32(&polls) != 0 {
+		t.Fatalf("api_async_test.go: Expected the Location not to be polled without follow_accepted")
+	}
+
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", data: `{"name":"updated"}`, followAccepted: true})
+	start := time.Now()
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if atomic.LoadInt32(&polls) != 2 || time.Since(start) < time.Second {
+		t.Fatalf("api_async_test.go: Expected the Location to be polled after Retry-After until it stopped returning 202 but it was polled %d times in %s", polls, time.Since(start))
+	}
+	if object.apiData["name"] != "updated" {
+		t.Fatalf("api_async_test.go: Expected the object to be read after the operation but got %v", object.apiData)
+	}
+}
+
+func TestAPIObjectAsyncCallbackQueue(t *testing.T) {
+	var polls int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE" && r.URL.Path == "/api/objects/1":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"status":"queued"}`))
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"job_id":"job-2"}`))
+		case r.URL.Path == "/callbacks":
+			if atomic.AddInt32(&polls, 1) < 2 {
+				w.Write([]byte(`[{"job_id":"job-1","result":"ok"}]`))
+				return
+			}
+			w.Write([]byte(`[{"job_id":"job-1","result":"ok"},{"job_id":"job-2","result":"ok"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	settings := &AsyncSettings{StatusPath: "/callbacks", CallbackKey: "job_id", PollInterval: 1, MaximumPollingDuration: 10}
+
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+	if err := object.deleteObject(); err == nil || !strings.Contains(err.Error(), "no 'job_id'") {
+		t.Fatalf("api_async_test.go: Expected a response without the callback key to fail but got %v", err)
+	}
+
+	/* The queue is polled until it has the operation's callback */
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "2", asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if atomic.LoadInt32(&polls) != 2 {
+		t.Fatalf("api_async_test.go: Expected the queue to be polled until the callback arrived but it was polled %d times", polls)
+	}
+
+	/* A callback's status is checked like an operation's */
+	failing := *settings
+	failing.SearchKey, failing.SearchValue, failing.FailureValues = "result", "done", []string{"ok"}
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "2", asyncSettings: map[string]*AsyncSettings{"destroy": &failing}})
+	if err := object.deleteObject(); err == nil || !strings.Contains(err.Error(), "failed: result is ok") {
+		t.Fatalf("api_async_test.go: Expected the callback's status to fail the destroy but got %v", err)
+	}
+}
+
+func TestAPIObjectAsyncResultURI(t *testing.T) {
+	var svr *httptest.Server
+	svr = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			w.Header().Set("Location", "/operations/1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == "PUT":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"operation":"/operations/2"}`))
+		case r.URL.Path == "/operations/1":
+			w.Write([]byte(`{"status":"done","links":{"result":"` + svr.URL + `/api/objects/abc"}}`))
+		case r.URL.Path == "/operations/2":
+			w.Write([]byte(`{"status":"done"}`))
+		case r.URL.Path == "/api/objects/abc":
+			w.Write([]byte(`{"id":"abc","name":"created"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	settings := &AsyncSettings{SearchKey: "status", SearchValue: "done", ResultUriKey: "links/result", PollInterval: 1, MaximumPollingDuration: 10}
+
+	/* The object (and its id) come from the result, even without write_returns_object */
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"name":"created"}`, asyncSettings: map[string]*AsyncSettings{"create": settings}})
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if object.id != "abc" || object.apiData["name"] != "created" {
+		t.Fatalf("api_async_test.go: Expected the object from the result URI but got id '%s' and %v", object.id, object.apiData)
+	}
+
+	/* An operation without a result fails */
+	noResult := *settings
+	noResult.RedirectUriKey = "operation"
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "abc", data: `{"name":"created"}`, asyncSettings: map[string]*AsyncSettings{"update": &noResult}})
+	if err := object.updateObject(); err == nil || !strings.Contains(err.Error(), "no result at result_uri_key") {
+		t.Fatalf("api_async_test.go: Expected an operation without a result to fail but got %v", err)
+	}
+}
+
+func TestAPIObjectAsyncProgress(t *testing.T) {
+	var polls int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE":
+			w.Header().Set("Location", "/operations/1")
+			w.WriteHeader(http.StatusAccepted)
+		case atomic.AddInt32(&polls, 1) == 1:
+			w.Write([]byte(`{"status":"RUNNING","progress":{"percent":40}}`))
+		default:
+			w.Write([]byte(`{"status":"SUCCEEDED","progress":{"percent":100}}`))
+		}
+	}))
+	defer svr.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	settings := &AsyncSettings{SearchKey: "status", SearchValue: "SUCCEEDED", ProgressKey: "progress/percent", PollInterval: 1, MaximumPollingDuration: 10}
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_async_test.go: %s", err)
+	}
+	if !strings.Contains(logs.String(), "Operation is in progress: id=1 operation=destroy progress=40") || strings.Contains(logs.String(), "progress=100") {
+		t.Fatalf("api_async_test.go: Expected the progress of the running operation to be logged but got:\n%s", logs.String())
+	}
+}
+
+func TestAPIObjectOperationStatus(t *testing.T) {
+	object := &APIObject{id: "1", apiClient: &APIClient{}}
+	status := map[string]interface{}{
+		"state":      map[string]interface{}{"phase": "Completed"},
+		"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+	}
+
+	cases := []struct {
+		settings AsyncSettings
+		done     bool
+		failed   bool
+	}{
+		{AsyncSettings{SearchKey: "state/phase", SearchValue: "Completed"}, true, false},
+		{AsyncSettings{SearchKey: "state/phase", SearchValue: "COMPLETED"}, false, false},
+		{AsyncSettings{SearchKey: "state/phase", SearchValue: "COMPLETED", CaseInsensitive: true}, true, false},
+		{AsyncSettings{SearchKey: "state/phase", SearchValues: []string{"Succeeded", "Completed"}}, true, false},
+		{AsyncSettings{SearchKey: "state/phase", SearchValue: "Succeeded", FailureValues: []string{"completed"}, CaseInsensitive: true}, false, true},
+		{AsyncSettings{SearchKey: "$.conditions[?(@.type=='Ready')].status", SearchValue: "True"}, true, false},
+	}
+	for _, c := range cases {
+		done, _, err := object.operationStatus("create", &c.settings, status, "")
+		if done != c.done || (err != nil) != c.failed {
+			t.Fatalf("api_async_test.go: Expected done=%t and failed=%t for %+v but got done=%t and %v", c.done, c.failed, c.settings, done, err)
+		}
+	}
+}
+
+func TestAPIObjectAsyncCancel(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/objects/slow" {
+			time.Sleep(2 * time.Second)
+		}
+		w.Header().Set("Location", "/operations/op-1")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 10})
+	settings := &AsyncSettings{PollInterval: 5, MaximumPollingDuration: 60}
+
+	/* Cancelling stops the polling, and a request already in flight */
+	for _, id := range []string{"1", "slow"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		object, _ := NewAPIObject(client, &apiObjectOpts{ctx: ctx, path: "/api/objects", id: id, asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+		start := time.Now()
+		err := object.deleteObject()
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+			t.Fatalf("api_async_test.go: Expected the destroy of '%s' to stop when its context was done but got %v after %s", id, err, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+auditLog records every create, update and destroy to audit_log: a
+
+	file that each record is appended to as a line of JSON, or an
+	http(s) URL that each record is POSTed to
+*/
+type auditLog struct {
+	mutex      sync.Mutex
+	target     string
+	metadata   map[string]string
+	httpClient *http.Client
+}
+
+/* One create, update or destroy, as recorded in audit_log */
+type auditRecord struct {
+	Time       string            `json:"time"`
+	Operation  string            `json:"operation"`
+	ID         string            `json:"id"`
+	Method     string            `json:"method"`
+	URI        string            `json:"uri"`
+	Path       string            `json:"path"`
+	StatusCode int               `json:"status_code"`
+	Result     string            `json:"result"`
+	Error      string            `json:"error,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+/* An audit log for audit_log, or nil if it is not set */
+func newAuditLog(target string, metadata map[string]string) *auditLog {
+	if target == "" {
+		return nil
+	}
+	audit := &auditLog{target: target, metadata: metadata}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		audit.httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return audit
+}
+
+/*
+Record an operation on the object in audit_log, with the path and
+
+	status code of its request ("" and 0 if it failed before sending
+	one). Since the change has been made (or not) either way, failing
+	to record it is only logged
+*/
+func (obj *APIObject) audit(operation string, id string, method string, path string, statusCode int, err error) {
+	audit := obj.apiClient.audit
+	if audit == nil {
+		return
+	}
+
+	record := auditRecord{
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Operation:  operation,
+		ID:         id,
+		Method:     method,
+		URI:        obj.apiClient.activeURI(),
+		Path:       path,
+		StatusCode: statusCode,
+		Result:     "succeeded",
+		Metadata:   audit.metadata,
+	}
+	/* The resource's audit_metadata is merged over the provider's */
+	if len(obj.auditMetadata) > 0 {
+		record.Metadata = make(map[string]string, len(audit.metadata)+len(obj.auditMetadata))
+		for k, v := range audit.metadata {
+			record.Metadata[k] = v
+		}
+		for k, v := range obj.auditMetadata {
+			record.Metadata[k] = v
+		}
+	}
+	if err != nil {
+		record.Result = "failed"
+		record.Error = err.Error()
+	}
+
+	if err := audit.write(record); err != nil {
+		obj.apiClient.log(logTransport, "WARN", "Failed to record the operation in audit_log", map[string]interface{}{"operation": operation, "id": id, "error": err.Error()})
+	}
+}
+
+func (audit *auditLog) write(record auditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if audit.httpClient != nil {
+		resp, err := audit.httpClient.Post(audit.target, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s responded with %d", audit.target, resp.StatusCode)
+		}
+		return nil
+	}
+
+	/* Records are only ever appended, one line each */
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	f, err := os.OpenFile(audit.target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
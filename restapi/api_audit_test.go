@@ -0,0 +1,88 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIObjectAuditLog(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			http.Error(w, "locked", http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"id":"1","name":"web"}`))
+	}))
+	defer svr.Close()
+
+	file := filepath.Join(t.TempDir(), "audit.log")
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, auditLog: file, auditMetadata: map[string]string{"ticket": "CHG-1"}})
+	object, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":"1","name":"web"}`, auditMetadata: map[string]string{"owner": "team-a"}})
+	if err != nil {
+		t.Fatalf("api_audit_test.go: %s", err)
+	}
+
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_audit_test.go: %s", err)
+	}
+	if err := object.updateObject(); err == nil {
+		t.Fatalf("api_audit_test.go: Expected the update to fail")
+	}
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_audit_test.go: %s", err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("api_audit_test.go: Expected audit_log to be written: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("api_audit_test.go: Expected a record for each operation but got %s", b)
+	}
+
+	expected := []auditRecord{
+		{Operation: "create", ID: "1", Method: "POST", Path: "/api/objects", StatusCode: 200, Result: "succeeded"},
+		{Operation: "update", ID: "1", Method: "PUT", Path: "/api/objects/1", StatusCode: 409, Result: "failed"},
+		{Operation: "destroy", ID: "1", Method: "DELETE", Path: "/api/objects/1", StatusCode: 200, Result: "succeeded"},
+	}
+	for i, line := range lines {
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("api_audit_test.go: Expected a line of JSON but got %s", line)
+		}
+		e := expected[i]
+		if record.Operation != e.Operation || record.ID != e.ID || record.Method != e.Method || record.Path != e.Path || record.StatusCode != e.StatusCode || record.Result != e.Result {
+			t.Errorf("api_audit_test.go: Expected %+v but got %s", e, line)
+		}
+		if record.URI != svr.URL || record.Metadata["ticket"] != "CHG-1" || record.Metadata["owner"] != "team-a" || record.Time == "" {
+			t.Errorf("api_audit_test.go: Expected the time, URI and both audit_metadata to be recorded but got %s", line)
+		}
+		if (record.Error != "") != (e.Result == "failed") {
+			t.Errorf("api_audit_test.go: Expected only failures to have an error but got %s", line)
+		}
+	}
+}
+
+func TestAuditLogEndpoint(t *testing.T) {
+	var received []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = append(received, r.Header.Get("Content-Type")+" "+string(b))
+	}))
+	defer svr.Close()
+
+	audit := newAuditLog(svr.URL+"/audit", nil)
+	if err := audit.write(auditRecord{Operation: "create", ID: "1"}); err != nil {
+		t.Fatalf("api_audit_test.go: %s", err)
+	}
+	if len(received) != 1 || !strings.HasPrefix(received[0], `application/json {"time":"","operation":"create","id":"1"`) {
+		t.Fatalf("api_audit_test.go: Expected the record to be POSTed as JSON but got %v", received)
+	}
+}
@@ -1,10 +1,15 @@
 package restapi
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/oauth2/google"
 )
 
@@ -33,3 +38,152 @@ func GetGCPOauthReuseTokenSource(gcpOauthConfig *GCPOauthConfig) (*oauth2.TokenS
 	reuseTokenSource := oauth2.ReuseTokenSource(nil, tokenSource)
 	return &reuseTokenSource, err
 }
+
+/*
+Authenticator adds credentials to requests. Invalidate is called when
+
+	the API rejects them with a 401, so that cached credentials (such
+	as an OAuth token) are fetched again for the next request
+*/
+type Authenticator interface {
+	Apply(req *http.Request) error
+	Invalidate()
+}
+
+/*
+An authentication scheme the provider can be configured with. build is
+
+	only called when configured says the scheme's attributes are set.
+	Any requests it makes for credentials (such as for OAuth tokens) go
+	through httpClient, so the client's TLS settings, rate limits,
+	debug log and cassette apply to them
+*/
+type authScheme struct {
+	name       string
+	configured func(opt *apiClientOpt) bool
+	build      func(opt *apiClientOpt, httpClient *http.Client) (Authenticator, error)
+	logFields  func(opt *apiClientOpt) map[string]interface{}
+}
+
+/* The schemes requests can be authenticated with. The first one configured is used */
+var authSchemes = []authScheme{
+	{
+		name: "a GCP service account",
+		configured: func(opt *apiClientOpt) bool {
+			return opt.GCPOauthConfig != nil && opt.GCPOauthConfig.serviceAccountKey != ""
+		},
+		/* Tokens are signed locally, so no requests are made */
+		build: func(opt *apiClientOpt, _ *http.Client) (Authenticator, error) {
+			return newTokenAuthenticator(func() (oauth2.TokenSource, error) {
+				source, err := GetGCPOauthReuseTokenSource(opt.GCPOauthConfig)
+				if err != nil {
+					return nil, err
+				}
+				return *source, nil
+			})
+		},
+	},
+	{
+		name: "OAuth client credentials",
+		configured: func(opt *apiClientOpt) bool {
+			return opt.oauthClientID != "" && opt.oauthClientSecret != "" && opt.oauthTokenURL != ""
+		},
+		build: func(opt *apiClientOpt, httpClient *http.Client) (Authenticator, error) {
+			config := clientcredentials.Config{
+				ClientID:       opt.oauthClientID,
+				ClientSecret:   opt.oauthClientSecret,
+				TokenURL:       opt.oauthTokenURL,
+				Scopes:         opt.oauthScopes,
+				EndpointParams: opt.oauthEndpointParams,
+			}
+			return newTokenAuthenticator(func() (oauth2.TokenSource, error) {
+				return config.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)), nil
+			})
+		},
+		logFields: func(opt *apiClientOpt) map[string]interface{} {
+			return map[string]interface{}{"client_id": opt.oauthClientID, "token_url": opt.oauthTokenURL, "scopes": opt.oauthScopes}
+		},
+	},
+	{
+		name: "basic auth",
+		configured: func(opt *apiClientOpt) bool {
+			return opt.username != "" && opt.password != ""
+		},
+		build: func(opt *apiClientOpt, _ *http.Client) (Authenticator, error) {
+			return &basicAuthenticator{username: opt.username, password: opt.password}, nil
+		},
+		logFields: func(opt *apiClientOpt) map[string]interface{} {
+			return map[string]interface{}{"username": opt.username}
+		},
+	},
+}
+
+/* The Authenticator for the first of authSchemes configured in opt, or nil if there is none */
+func newAuthenticator(opt *apiClientOpt, httpClient *http.Client) (Authenticator, *authScheme, error) {
+	for i := range authSchemes {
+		if authSchemes[i].configured(opt) {
+			authenticator, err := authSchemes[i].build(opt, httpClient)
+			return authenticator, &authSchemes[i], err
+		}
+	}
+	return nil, nil, nil
+}
+
+type basicAuthenticator struct {
+	username string
+	password string
+}
+
+func (a *basicAuthenticator) Apply(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuthenticator) Invalidate() {}
+
+/*
+Sets a bearer token from an OAuth token source, which caches it until
+
+	it expires. Invalidating it starts again with a new source
+*/
+type tokenAuthenticator struct {
+	mutex     sync.Mutex
+	newSource func() (oauth2.TokenSource, error)
+	source    oauth2.TokenSource
+}
+
+/* Build the first token source now, so that bad credentials fail the provider's configuration */
+func newTokenAuthenticator(newSource func() (oauth2.TokenSource, error)) (*tokenAuthenticator, error) {
+	source, err := newSource()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenAuthenticator{newSource: newSource, source: source}, nil
+}
+
+func (a *tokenAuthenticator) Apply(req *http.Request) error {
+	a.mutex.Lock()
+	if a.source == nil {
+		source, err := a.newSource()
+		if err != nil {
+			a.mutex.Unlock()
+			return err
+		}
+		a.source = source
+	}
+	source := a.source
+	a.mutex.Unlock()
+
+	token, err := source.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get an OAuth token: %w", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+func (a *tokenAuthenticator) Invalidate() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.source = nil
+}
@@ -0,0 +1,114 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOAuthTokenInvalidated(t *testing.T) {
+	tokens := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			tokens++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, tokens)
+		case r.Header.Get("Authorization") == "Bearer token-1":
+			/* The API revoked the first token before it expired */
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Write([]byte(r.Header.Get("Authorization")))
+		}
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, oauthClientID: "id", oauthClientSecret: "secret", oauthTokenURL: svr.URL + "/token"})
+	if err != nil {
+		t.Fatalf("api_auth_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); responseCode(err) != http.StatusUnauthorized {
+		t.Fatalf("api_auth_test.go: Expected the first token to be refused but got %v", err)
+	}
+	if body, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil || body != "Bearer token-2" {
+		t.Fatalf("api_auth_test.go: Expected a new token after the 401 but got %s (%v)", body, err)
+	}
+	if body, _ := client.sendRequest("GET", "/api/objects/1", ""); body != "Bearer token-2" || tokens != 2 {
+		t.Fatalf("api_auth_test.go: Expected the new token to be reused but got %s after %d tokens", body, tokens)
+	}
+}
+
+/* Signs requests with a header, as a scheme the provider does not have might */
+type headerAuthenticator struct {
+	invalidated int
+}
+
+func (a *headerAuthenticator) Apply(req *http.Request) error {
+	req.Header.Set("X-Signature", "signed "+req.Method)
+	return nil
+}
+
+func (a *headerAuthenticator) Invalidate() {
+	a.invalidated++
+}
+
+func TestCustomAuthenticator(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok || r.Header.Get("X-Signature") != "signed DELETE" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer svr.Close()
+
+	authenticator := &headerAuthenticator{}
+	client, err := NewClient(ClientOptions{URI: svr.URL, Username: "admin", Password: "secret", Authenticator: authenticator})
+	if err != nil {
+		t.Fatalf("api_auth_test.go: %s", err)
+	}
+	if _, err := client.Do(context.Background(), "DELETE", "/api/objects/1", "", nil); err != nil {
+		t.Fatalf("api_auth_test.go: Expected the Authenticator to replace basic auth but got %v", err)
+	}
+	if _, err := client.Do(context.Background(), "GET", "/api/objects/1", "", nil); responseCode(err) != http.StatusUnauthorized || authenticator.invalidated != 1 {
+		t.Fatalf("api_auth_test.go: Expected the 401 to invalidate the Authenticator but got %v", err)
+	}
+}
+
+func TestOAuthTokenCassette(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"token-1","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+
+	file := filepath.Join(t.TempDir(), "cassette.yaml")
+	opt := apiClientOpt{uri: svr.URL, timeout: 2, oauthClientID: "id", oauthClientSecret: "secret", oauthTokenURL: svr.URL + "/token", cassetteFile: file, cassetteMode: "record"}
+	client, err := NewAPIClient(&opt)
+	if err != nil {
+		t.Fatalf("api_auth_test.go: %s", err)
+	}
+	if body, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil || body != "Bearer token-1" {
+		t.Fatalf("api_auth_test.go: Expected the token to be sent but got %s (%v)", body, err)
+	}
+	svr.Close()
+	if b, _ := os.ReadFile(file); strings.Contains(string(b), `"access_token":"token-1"`) {
+		t.Fatalf("api_auth_test.go: Expected the access token to be masked in the cassette:\n%s", b)
+	}
+
+	/* The token is fetched through the cassette too, so replaying needs no server at all */
+	opt.cassetteMode = "replay"
+	client, err = NewAPIClient(&opt)
+	if err != nil {
+		t.Fatalf("api_auth_test.go: %s", err)
+	}
+	if body, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil || body != "Bearer token-1" {
+		t.Fatalf("api_auth_test.go: Expected the replayed token and response but got %s (%v)", body, err)
+	}
+}
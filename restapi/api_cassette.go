@@ -0,0 +1,195 @@
+package restapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* Valid values for cassette_mode */
+var cassetteModes = []string{"record", "replay"}
+
+/*
+A cassette of the requests a client made and the responses it got,
+
+	for cassette_file. In record mode, requests are sent to the API and
+	each exchange is added to the file, after those already in it, since
+	Terraform runs plan, apply and each test step in a provider process
+	of its own. In replay mode, nothing is sent:
+	each request is answered with the first unused recorded response to
+	the same method, path and query, and body. Bodies are masked before
+	they are recorded or matched, so secrets stay out of the file
+*/
+type cassette struct {
+	mutex        sync.Mutex
+	file         string
+	mode         string
+	base         http.RoundTripper
+	isSensitive  func(string) bool
+	mask         func(string) string
+	Interactions []*cassetteInteraction `yaml:"interactions"`
+	used         []bool
+}
+
+type cassetteInteraction struct {
+	Request  cassetteRequest  `yaml:"request"`
+	Response cassetteResponse `yaml:"response"`
+}
+
+type cassetteRequest struct {
+	Method  string              `yaml:"method"`
+	URI     string              `yaml:"uri"`
+	Headers map[string][]string `yaml:"headers,omitempty"`
+	Body    string              `yaml:"body,omitempty"`
+}
+
+type cassetteResponse struct {
+	StatusCode int                 `yaml:"status_code"`
+	Headers    map[string][]string `yaml:"headers,omitempty"`
+	Body       string              `yaml:"body,omitempty"`
+}
+
+/*
+A cassette for cassette_file that sends requests with base, or nil if
+
+	it is not set. In replay mode, the file must already exist. Headers
+	for which isSensitive is true are redacted, and bodies passed
+	through mask
+*/
+func newCassette(file string, mode string, base http.RoundTripper, isSensitive func(string) bool, mask func(string) string) (*cassette, error) {
+	if file == "" {
+		return nil, nil
+	}
+	if mode == "" {
+		mode = "replay"
+	}
+	if !contains(cassetteModes, mode) {
+		return nil, fmt.Errorf("cassette_mode '%s' is invalid - must be one of %v", mode, cassetteModes)
+	}
+
+	c := &cassette{file: file, mode: mode, base: base, isSensitive: isSensitive, mask: mask, Interactions: []*cassetteInteraction{}}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) && mode == "record" {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cassette_file '%s' could not be read: %v", file, err)
+	}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("cassette_file '%s' is not a valid cassette: %v", file, err)
+	}
+	c.used = make([]bool, len(c.Interactions))
+	return c, nil
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = string(b)
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	if c.mode == "replay" {
+		return c.replay(req, body)
+	}
+	return c.record(req, body)
+}
+
+/*
+Answer req with the first unused interaction recorded for it. Bodies
+
+	that differ from every recording (such as templates with env values
+	or timestamps) fall back to the first unused one with the same
+	method, path and query
+*/
+func (c *cassette) replay(req *http.Request, body string) (*http.Response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	i := c.find(req, c.mask(body), true)
+	if i < 0 {
+		i = c.find(req, "", false)
+	}
+	if i >= 0 {
+		c.used[i] = true
+		response := c.Interactions[i].Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)),
+			StatusCode:    response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header(response.Headers),
+			Body:          io.NopCloser(bytes.NewReader([]byte(response.Body))),
+			ContentLength: int64(len(response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette_file '%s' has no recorded response left for %s %s", c.file, req.Method, req.URL.RequestURI())
+}
+
+/* The first unused interaction for req (and body, when matchBody is set), or -1 */
+func (c *cassette) find(req *http.Request, body string, matchBody bool) int {
+	for i, interaction := range c.Interactions {
+		recorded := interaction.Request
+		if c.used[i] || recorded.Method != req.Method || recorded.URI != req.URL.RequestURI() || (matchBody && recorded.Body != body) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+/* Send req and add the exchange to the cassette, which is rewritten each time */
+func (c *cassette) record(req *http.Request, body string) (*http.Response, error) {
+	resp, err := c.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+
+	interaction := &cassetteInteraction{
+		Request:  cassetteRequest{Method: req.Method, URI: req.URL.RequestURI(), Headers: c.headers(req.Header), Body: c.mask(body)},
+		Response: cassetteResponse{StatusCode: resp.StatusCode, Headers: c.headers(resp.Header), Body: c.mask(string(b))},
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+	out, err := yaml.Marshal(c)
+	if err == nil {
+		err = writeFileAtomic(c.file, out, 0o600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cassette_file '%s' could not be written: %v", c.file, err)
+	}
+	return resp, nil
+}
+
+/* Headers to record, with the values of sensitive headers redacted */
+func (c *cassette) headers(header http.Header) map[string][]string {
+	recorded := make(map[string][]string, len(header))
+	for name, values := range header {
+		if c.isSensitive(name) {
+			recorded[name] = []string{sensitiveMask}
+			continue
+		}
+		recorded[name] = values
+	}
+	return recorded
+}
@@ -0,0 +1,99 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassetteSensitiveKeys(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1","password":"p4ss"}`))
+	}))
+
+	file := filepath.Join(t.TempDir(), "cassette.yaml")
+	opt := apiClientOpt{uri: svr.URL, timeout: 2, sensitiveKeys: []string{"password"}, cassetteFile: file, cassetteMode: "record"}
+	client, _ := NewAPIClient(&opt)
+	if _, err := client.sendRequest("POST", "/api/objects", `{"id":"1","password":"p4ss"}`); err != nil {
+		t.Fatalf("api_cassette_test.go: %s", err)
+	}
+	svr.Close()
+	if b, _ := os.ReadFile(file); strings.Contains(string(b), "p4ss") {
+		t.Fatalf("api_cassette_test.go: Expected sensitive_keys to be masked in the cassette:\n%s", b)
+	}
+
+	/* The live body is masked before matching, so it still matches the recording */
+	opt.cassetteMode = "replay"
+	client, _ = NewAPIClient(&opt)
+	if body, err := client.sendRequest("POST", "/api/objects", `{"id":"1","password":"p4ss"}`); err != nil || !strings.Contains(body, sensitiveMask) {
+		t.Fatalf("api_cassette_test.go: Expected the masked recording to be replayed but got %s (%v)", body, err)
+	}
+}
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	version := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"1","version":%d}`, version)
+	}))
+
+	/* Each request is recorded by a client of its own, as Terraform runs
+	   each step in a new provider process, and all are kept */
+	file := filepath.Join(t.TempDir(), "cassette.yaml")
+	for _, data := range []string{`{"id":"1"}`, "", ""} {
+		method := "GET"
+		if data != "" {
+			method = "POST"
+		}
+		client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, headers: map[string]string{"X-Api-Key": "key-secret"}, cassetteFile: file, cassetteMode: "record"})
+		if err != nil {
+			t.Fatalf("api_cassette_test.go: %s", err)
+		}
+		if _, err := client.sendRequest(method, "/api/objects/1?full=true", data); err != nil {
+			t.Fatalf("api_cassette_test.go: %s", err)
+		}
+	}
+	svr.Close()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("api_cassette_test.go: Expected cassette_file to be written: %s", err)
+	}
+	if strings.Contains(string(b), "key-secret") || !strings.Contains(string(b), "uri: /api/objects/1?full=true") {
+		t.Fatalf("api_cassette_test.go: Expected the interactions to be recorded with sensitive headers redacted:\n%s", b)
+	}
+
+	/* The server is gone, so every response must come from the cassette */
+	client, err := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:1", timeout: 2, cassetteFile: file})
+	if err != nil {
+		t.Fatalf("api_cassette_test.go: %s", err)
+	}
+	expected := []string{`{"id":"1","version":2}`, `{"id":"1","version":3}`}
+	for _, want := range expected {
+		body, err := client.sendRequest("GET", "/api/objects/1?full=true", "")
+		if err != nil || body != want {
+			t.Fatalf("api_cassette_test.go: Expected the recorded response %s in order but got %s (%v)", want, body, err)
+		}
+	}
+	if body, err := client.sendRequest("POST", "/api/objects/1?full=true", `{"id":"1"}`); err != nil || body != `{"id":"1","version":1}` {
+		t.Fatalf("api_cassette_test.go: Expected the POST to be matched by its body but got %s (%v)", body, err)
+	}
+	if _, err := client.sendRequest("GET", "/api/objects/1?full=true", ""); err == nil || !strings.Contains(err.Error(), "no recorded response left") {
+		t.Fatalf("api_cassette_test.go: Expected an error once the recorded responses are used up but got %v", err)
+	}
+
+	/* Bodies that changed since recording still get the response recorded for the path */
+	client, _ = NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:1", timeout: 2, cassetteFile: file})
+	if body, err := client.sendRequest("POST", "/api/objects/1?full=true", `{"id":"1","at":"now"}`); err != nil || body != `{"id":"1","version":1}` {
+		t.Fatalf("api_cassette_test.go: Expected a changed body to fall back to the recording for its path but got %s (%v)", body, err)
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, cassetteFile: file, cassetteMode: "rewind"}); err == nil {
+		t.Fatalf("api_cassette_test.go: Expected an invalid cassette_mode to be refused")
+	}
+}
@@ -3,86 +3,180 @@ package restapi
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/clientcredentials"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/websocket"
 	"golang.org/x/time/rate"
 )
 
 type apiClientOpt struct {
-	uri                 string
-	insecure            bool
-	username            string
-	password            string
-	headers             map[string]string
-	timeout             int
-	idAttribute         string
-	createMethod        string
-	readMethod          string
-	updateMethod        string
-	updateData          string
-	destroyMethod       string
-	destroyData         string
-	copyKeys            []string
-	writeReturnsObject  bool
-	createReturnsObject bool
-	xssiPrefix          string
-	useCookies          bool
-	rateLimit           float64
-	oauthClientID       string
-	oauthClientSecret   string
-	oauthScopes         []string
-	oauthTokenURL       string
-	oauthEndpointParams url.Values
-	certFile            string
-	keyFile             string
-	certString          string
-	keyString           string
-	debug               bool
-	GCPOauthConfig      *GCPOauthConfig
+	uri                   string
+	insecure              bool
+	username              string
+	password              string
+	headers               map[string]string
+	hostHeader            string
+	timeout               int
+	idAttribute           string
+	idAttributes          []string
+	createMethod          string
+	readMethod            string
+	updateMethod          string
+	updateData            string
+	updateMode            string
+	payloadFormat         string
+	destroyMethod         string
+	destroyData           string
+	copyKeys              []string
+	copyKeyPaths          map[string]string
+	writeReturnsObject    bool
+	createReturnsObject   bool
+	xssiPrefix            string
+	errorMessageKey       string
+	requestIDHeader       string
+	curlOnError           bool
+	useCookies            bool
+	forceHTTP2            bool
+	disableHTTP2          bool
+	useHTTP3              bool
+	unixSocket            string
+	hostOverrides         map[string]string
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	maxConnsPerHost       int
+	idleConnTimeout       int
+	connectTimeout        int
+	tlsHandshakeTimeout   int
+	responseHeaderTimeout int
+	rateLimit             float64
+	rateLimitBuckets      []rateLimitBucket
+	maxResponseSize       int64
+	sensitiveHeaders      []string
+	sensitiveKeys         []string
+	maxLoggedBodySize     int
+	maxLoggedHeaderSize   int
+	metricsFile           string
+	debugLogFile          string
+	debugLogMaxSize       int64
+	harFile               string
+	cassetteFile          string
+	cassetteMode          string
+	auditLog              string
+	auditMetadata         map[string]string
+	metricsStatsdAddress  string
+	maxParallelRequests   int
+	serialize             bool
+	maxRetries            int
+	retryWaitMin          float64
+	retryWaitMax          float64
+	retryMultiplier       float64
+	retryJitter           float64
+	retryBudget           float64
+	retryStatusCodes      []int
+	retryNetworkErrors    []string
+	maxThrottleRetries    int
+	failoverURIs          []string
+	healthCheckPath       string
+	oauthClientID         string
+	oauthClientSecret     string
+	oauthScopes           []string
+	oauthTokenURL         string
+	oauthEndpointParams   url.Values
+	certFile              string
+	keyFile               string
+	certString            string
+	keyString             string
+	caCertsFile           string
+	caCertsString         string
+	tlsMinVersion         string
+	tlsMaxVersion         string
+	tlsCipherSuites       []string
+	pinnedCertSHA256      []string
+	tlsServerName         string
+	openAPISpecFile       string
+	openAPIValidation     string
+	dryRun                bool
+	debug                 bool
+	logCtx                context.Context
+	GCPOauthConfig        *GCPOauthConfig
+	transport             http.RoundTripper
+	authenticator         Authenticator
 }
 
 /*APIClient is a HTTP client with additional controlling fields*/
 type APIClient struct {
 	httpClient          *http.Client
+	authenticator       Authenticator
+	requests            http.RoundTripper
+	tlsConfig           *tls.Config
+	dialer              *net.Dialer
 	uri                 string
 	insecure            bool
 	username            string
 	password            string
 	headers             map[string]string
+	hostHeader          string
 	idAttribute         string
+	idAttributes        []string
 	createMethod        string
 	readMethod          string
 	updateMethod        string
 	updateData          string
+	updateMode          string
+	payloadFormat       string
 	destroyMethod       string
 	destroyData         string
 	copyKeys            []string
+	copyKeyPaths        map[string]string
 	writeReturnsObject  bool
 	createReturnsObject bool
 	xssiPrefix          string
+	errorMessageKey     string
+	requestIDHeader     string
+	curlOnError         bool
 	rateLimiter         *rate.Limiter
+	rateLimitBuckets    []rateLimitBucket
+	maxResponseSize     int64
+	metrics             *requestMetrics
+	debugFile           *rotatingFile
+	har                 *harRecorder
+	audit               *auditLog
+	openAPI             *openAPISpec
+	requestSlots        chan struct{}
+	operationLock       *sync.Mutex
+	retryPolicy         *retryPolicy
+	throttle            *throttle
+	endpoints           *endpointPool
+	sensitiveKeys       *sensitiveKeys
+	sensitiveHeaders    []string
+	maxLoggedBodySize   int
+	maxLoggedHeaderSize int
+	dryRun              bool
 	debug               bool
+	logCtx              context.Context
 }
 
 // NewAPIClient makes a new api client for RESTful calls
 func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
-	if opt.debug {
-		log.Printf("api_client.go: Constructing debug api_client\n")
-	}
-
 	if opt.uri == "" {
 		return nil, errors.New("uri must be set to construct an API client")
 	}
@@ -97,6 +191,14 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 	if strings.HasSuffix(opt.uri, "/") {
 		opt.uri = opt.uri[:len(opt.uri)-1]
 	}
+	endpoints := &endpointPool{uris: []string{opt.uri}, healthCheckPath: opt.healthCheckPath}
+	for _, uri := range opt.failoverURIs {
+		endpoints.uris = append(endpoints.uris, strings.TrimSuffix(uri, "/"))
+	}
+	if len(endpoints.uris) == 1 {
+		/* No point in checking an endpoint there is no alternative to */
+		endpoints.selected = true
+	}
 
 	if opt.createMethod == "" {
 		opt.createMethod = "POST"
@@ -107,6 +209,15 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 	if opt.updateMethod == "" {
 		opt.updateMethod = "PUT"
 	}
+	if opt.updateMode == "" {
+		opt.updateMode = "put"
+	}
+	if opt.payloadFormat == "" {
+		opt.payloadFormat = "json"
+	}
+	if _, ok := codecs[opt.payloadFormat]; !ok {
+		return nil, fmt.Errorf("payload_format '%s' is invalid - must be one of %v", opt.payloadFormat, payloadFormats())
+	}
 	if opt.destroyMethod == "" {
 		opt.destroyMethod = "DELETE"
 	}
@@ -132,35 +243,149 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	var httpClientTransport http.RoundTripper
-	httpClientTransport = &http.Transport{
-		TLSClientConfig: tlsConfig,
-		Proxy:           http.ProxyFromEnvironment,
+	/* Present a different name via SNI and verify the certificate against it */
+	if opt.tlsServerName != "" {
+		tlsConfig.ServerName = opt.tlsServerName
 	}
 
-	if opt.GCPOauthConfig != nil && opt.GCPOauthConfig.serviceAccountKey != "" {
-		reuseTokenSource, err := GetGCPOauthReuseTokenSource(opt.GCPOauthConfig)
+	if opt.tlsMinVersion != "" {
+		version, err := parseTLSVersion(opt.tlsMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = version
+	}
+	if opt.tlsMaxVersion != "" {
+		version, err := parseTLSVersion(opt.tlsMaxVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MaxVersion = version
+	}
+	if tlsConfig.MinVersion != 0 && tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return nil, fmt.Errorf("tls_min_version '%s' is greater than tls_max_version '%s'", opt.tlsMinVersion, opt.tlsMaxVersion)
+	}
+	if len(opt.tlsCipherSuites) > 0 {
+		cipherSuites, err := parseCipherSuites(opt.tlsCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = cipherSuites
+	}
 
+	/* Pinning replaces chain verification entirely, which lets
+	   self-signed certificates be trusted without insecure=true */
+	if len(opt.pinnedCertSHA256) > 0 {
+		pins, err := parsePins(opt.pinnedCertSHA256)
 		if err != nil {
 			return nil, err
 		}
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPinnedCert(rawCerts, pins)
+		}
+	}
 
-		httpClientTransport = &oauth2.Transport{
-			Source: *reuseTokenSource,
-			Base:   httpClientTransport,
+	/* Trust only the CAs provided instead of the system trust store */
+	if opt.caCertsFile != "" || opt.caCertsString != "" {
+		caCertPool := x509.NewCertPool()
+		if opt.caCertsFile != "" {
+			caCerts, err := os.ReadFile(opt.caCertsFile)
+			if err != nil {
+				return nil, err
+			}
+			if !caCertPool.AppendCertsFromPEM(caCerts) {
+				return nil, fmt.Errorf("no PEM encoded certificates could be loaded from cacerts_file '%s'", opt.caCertsFile)
+			}
 		}
-	} else if opt.oauthClientID != "" && opt.oauthClientSecret != "" && opt.oauthTokenURL != "" {
-		clientCredentialsConfig := clientcredentials.Config{
-			ClientID:       opt.oauthClientID,
-			ClientSecret:   opt.oauthClientSecret,
-			TokenURL:       opt.oauthTokenURL,
-			Scopes:         opt.oauthScopes,
-			EndpointParams: opt.oauthEndpointParams,
+		if opt.caCertsString != "" {
+			if !caCertPool.AppendCertsFromPEM([]byte(opt.caCertsString)) {
+				return nil, errors.New("no PEM encoded certificates could be loaded from cacerts_string")
+			}
 		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if opt.forceHTTP2 && opt.disableHTTP2 {
+		return nil, errors.New("force_http2 and disable_http2 cannot both be set")
+	}
+
+	/* These only bound their phase of the request, unlike timeout
+	   which covers everything up to reading the last byte */
+	dialer := &net.Dialer{Timeout: time.Second * time.Duration(opt.connectTimeout)}
+	transport := &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   time.Second * time.Duration(opt.tlsHandshakeTimeout),
+		ResponseHeaderTimeout: time.Second * time.Duration(opt.responseHeaderTimeout),
+	}
+
+	/* Connection pool tuning. Zero values keep golang's defaults */
+	transport.MaxIdleConns = opt.maxIdleConns
+	transport.MaxIdleConnsPerHost = opt.maxIdleConnsPerHost
+	transport.MaxConnsPerHost = opt.maxConnsPerHost
+	transport.IdleConnTimeout = time.Second * time.Duration(opt.idleConnTimeout)
+
+	/* Go will only negotiate HTTP/2 on its own if the TLS config is
+	   left untouched, so it must be requested explicitly here */
+	if opt.forceHTTP2 {
+		transport.ForceAttemptHTTP2 = true
+	}
+	if opt.disableHTTP2 {
+		/* A non-nil, empty map is the documented way to turn HTTP/2 off */
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
 
-		httpClientTransport = &oauth2.Transport{
-			Source: clientCredentialsConfig.TokenSource(context.Background()),
-			Base:   httpClientTransport,
+	/* Every connection goes to the socket, so the host portion of
+	   the uri is only used for the Host header */
+	if opt.unixSocket != "" {
+		if opt.useHTTP3 {
+			return nil, errors.New("use_http3 cannot be used with unix_socket")
+		}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", opt.unixSocket)
+		}
+	}
+
+	/* Connect to a fixed address for some hosts, similar to /etc/hosts.
+	   TLS verification and the Host header still use the original name */
+	if len(opt.hostOverrides) > 0 {
+		if opt.unixSocket != "" || opt.useHTTP3 {
+			return nil, errors.New("host_overrides cannot be used with unix_socket or use_http3")
+		}
+		for host, override := range opt.hostOverrides {
+			if override == "" {
+				return nil, fmt.Errorf("host_overrides entry for '%s' must not be empty", host)
+			}
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, overrideHost(addr, opt.hostOverrides))
+		}
+	}
+
+	var httpClientTransport http.RoundTripper
+	httpClientTransport = transport
+
+	if opt.useHTTP3 {
+		httpClientTransport = &http3.RoundTripper{
+			TLSClientConfig: tlsConfig,
+		}
+	}
+
+	/* Such as a mock in tests. Cassettes still wrap it */
+	if opt.transport != nil {
+		httpClientTransport = opt.transport
+	}
+
+	/* Tokens are fetched through the same requests chain (set below) as other requests */
+	tokenClient := &http.Client{}
+	authenticator, scheme := opt.authenticator, &authScheme{name: "a custom Authenticator"}
+	if authenticator == nil {
+		var err error
+		if authenticator, scheme, err = newAuthenticator(opt, tokenClient); err != nil {
+			return nil, err
 		}
 	}
 
@@ -170,10 +395,50 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 		cookieJar, _ = cookiejar.New(nil)
 	}
 
-	rateLimit := rate.Limit(opt.rateLimit)
-	bucketSize := int(math.Max(math.Round(opt.rateLimit), 1))
-	log.Printf("limit: %f bucket: %d", opt.rateLimit, bucketSize)
-	rateLimiter := rate.NewLimiter(rateLimit, bucketSize)
+	rateLimiter := newRateLimiter(opt.rateLimit)
+
+	rateLimitBuckets := make([]rateLimitBucket, 0, len(opt.rateLimitBuckets))
+	for _, bucket := range opt.rateLimitBuckets {
+		if bucket.rateLimit <= 0 {
+			return nil, fmt.Errorf("rate_limits entry for path_prefix '%s' must have a rate_limit greater than zero", bucket.pathPrefix)
+		}
+		bucket.limiter = newRateLimiter(bucket.rateLimit)
+		rateLimitBuckets = append(rateLimitBuckets, bucket)
+	}
+
+	if opt.maxParallelRequests < 0 {
+		return nil, errors.New("max_parallel_requests must not be negative")
+	}
+
+	var requestSlots chan struct{}
+	if opt.maxParallelRequests > 0 {
+		requestSlots = make(chan struct{}, opt.maxParallelRequests)
+	}
+
+	retryPolicy, err := newRetryPolicy(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := newRequestMetrics(opt.metricsFile, opt.metricsStatsdAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	debugFile, err := openRotatingFile(opt.debugLogFile, opt.debugLogMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	openAPI, err := newOpenAPISpec(opt.openAPISpecFile, opt.openAPIValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	var operationLock *sync.Mutex
+	if opt.serialize {
+		operationLock = &sync.Mutex{}
+	}
 
 	client := APIClient{
 		httpClient: &http.Client{
@@ -181,46 +446,274 @@ func NewAPIClient(opt *apiClientOpt) (*APIClient, error) {
 			Transport: httpClientTransport,
 			Jar:       cookieJar,
 		},
+		authenticator:       authenticator,
+		tlsConfig:           tlsConfig,
+		dialer:              dialer,
 		rateLimiter:         rateLimiter,
+		rateLimitBuckets:    rateLimitBuckets,
+		maxResponseSize:     opt.maxResponseSize,
+		metrics:             metrics,
+		debugFile:           debugFile,
+		har:                 newHARRecorder(opt.harFile),
+		audit:               newAuditLog(opt.auditLog, opt.auditMetadata),
+		openAPI:             openAPI,
+		requestSlots:        requestSlots,
+		operationLock:       operationLock,
+		retryPolicy:         retryPolicy,
+		throttle:            &throttle{},
+		sensitiveKeys:       &sensitiveKeys{},
+		sensitiveHeaders:    opt.sensitiveHeaders,
+		maxLoggedBodySize:   opt.maxLoggedBodySize,
+		maxLoggedHeaderSize: opt.maxLoggedHeaderSize,
+		endpoints:           endpoints,
 		uri:                 opt.uri,
 		insecure:            opt.insecure,
 		username:            opt.username,
 		password:            opt.password,
 		headers:             opt.headers,
+		hostHeader:          opt.hostHeader,
 		idAttribute:         opt.idAttribute,
+		idAttributes:        opt.idAttributes,
 		createMethod:        opt.createMethod,
 		readMethod:          opt.readMethod,
 		updateMethod:        opt.updateMethod,
 		updateData:          opt.updateData,
+		updateMode:          opt.updateMode,
+		payloadFormat:       opt.payloadFormat,
 		destroyMethod:       opt.destroyMethod,
 		destroyData:         opt.destroyData,
 		copyKeys:            opt.copyKeys,
+		copyKeyPaths:        opt.copyKeyPaths,
 		writeReturnsObject:  opt.writeReturnsObject,
 		createReturnsObject: opt.createReturnsObject,
 		xssiPrefix:          opt.xssiPrefix,
+		errorMessageKey:     opt.errorMessageKey,
+		requestIDHeader:     opt.requestIDHeader,
+		curlOnError:         opt.curlOnError,
+		dryRun:              opt.dryRun,
 		debug:               opt.debug,
+		logCtx:              opt.logCtx,
 	}
 
+	client.sensitiveKeys.add(opt.sensitiveKeys)
+	if openAPI != nil && len(openAPI.unsupported) > 0 {
+		client.log(logTransport, "WARN", "openapi_spec_file uses keywords that requests and responses are not checked against", map[string]interface{}{"file": openAPI.file, "keywords": strings.Join(openAPI.unsupported, ", ")})
+	}
+
+	/* Outermost, so replaying sends nothing (not even for OAuth tokens) */
+	cassette, err := newCassette(opt.cassetteFile, opt.cassetteMode, client.httpClient.Transport, client.isSensitiveHeader, client.maskCassetteBody)
+	if err != nil {
+		return nil, err
+	}
+	if cassette != nil {
+		client.httpClient.Transport = cassette
+	}
+	client.requests = client.requestChain()
+	tokenClient.Transport = client.requests
+
+	if opt.useHTTP3 {
+		client.log(logTransport, "INFO", "Using EXPERIMENTAL HTTP/3 transport", nil)
+	}
+	if scheme != nil {
+		var fields map[string]interface{}
+		if scheme.logFields != nil {
+			fields = scheme.logFields(opt)
+		}
+		client.log(logAuth, "DEBUG", "Authenticating with "+scheme.name, fields)
+	}
 	if opt.debug {
-		log.Printf("api_client.go: Constructed client:\n%s", client.toString())
+		client.log(logTransport, "DEBUG", "Constructed client", map[string]interface{}{"client": client.toString()})
 	}
 
 	return &client, nil
 }
 
+/*
+When serialize is set, only one resource or data source operation (which
+
+	may consist of several requests) runs against the API at any time.
+	These are no-ops otherwise.
+*/
+func (client *APIClient) lockOperation() {
+	if client.operationLock != nil {
+		client.operationLock.Lock()
+	}
+}
+
+func (client *APIClient) unlockOperation() {
+	if client.operationLock != nil {
+		client.operationLock.Unlock()
+	}
+}
+
+/*
+A rateLimitBucket applies its own rate limit to requests matching
+
+	a path prefix and/or a set of HTTP methods instead of the global rate_limit
+*/
+type rateLimitBucket struct {
+	pathPrefix string
+	methods    []string
+	rateLimit  float64
+	limiter    *rate.Limiter
+}
+
+func (bucket *rateLimitBucket) matches(method string, path string) bool {
+	if !strings.HasPrefix(path, bucket.pathPrefix) {
+		return false
+	}
+	if len(bucket.methods) == 0 {
+		return true
+	}
+	for _, m := range bucket.methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func newRateLimiter(limit float64) *rate.Limiter {
+	bucketSize := int(math.Max(math.Round(limit), 1))
+	return rate.NewLimiter(rate.Limit(limit), bucketSize)
+}
+
+/* The first matching bucket wins. Requests matching no bucket use the global limiter */
+func (client *APIClient) rateLimiterFor(method string, path string) *rate.Limiter {
+	for i := range client.rateLimitBuckets {
+		if client.rateLimitBuckets[i].matches(method, path) {
+			return client.rateLimitBuckets[i].limiter
+		}
+	}
+	return client.rateLimiter
+}
+
+/*
+Rewrite a host:port address using the host_overrides map. Overrides may
+
+	be just an IP address (keeping the original port) or an ip:port pair
+*/
+func overrideHost(addr string, overrides map[string]string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	override, ok := overrides[host]
+	if !ok {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override
+	}
+	return net.JoinHostPort(override, port)
+}
+
+/* Map the TLS versions users may configure to their crypto/tls constants */
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version '%s' - must be one of 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+/*
+Look up cipher suites by their IANA names (such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256).
+
+	Insecure suites are permitted since old appliances may require them.
+	Note that TLS 1.3 suites are not configurable and are ignored by crypto/tls.
+*/
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite '%s'", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+/*
+Pins are the SHA-256 hash of a certificate's SubjectPublicKeyInfo and
+
+	may be given either base64 encoded (as produced by openssl) or hex encoded
+*/
+func parsePins(pins []string) ([][]byte, error) {
+	parsed := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		var hash []byte
+		var err error
+		if len(pin) == hex.EncodedLen(sha256.Size) {
+			hash, err = hex.DecodeString(pin)
+		} else {
+			hash, err = base64.StdEncoding.DecodeString(pin)
+		}
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pinned_cert_sha256 value '%s' is not a base64 or hex encoded SHA-256 hash", pin)
+		}
+		parsed = append(parsed, hash)
+	}
+	return parsed, nil
+}
+
+/*
+Only the leaf is matched against the pins, since it is the only
+
+	certificate the handshake proves the server has the key for. With
+	the chain unverified, anyone could append a pinned certificate to
+	their own
+*/
+func verifyPinnedCert(rawCerts [][]byte, pins [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("the server presented no certificate to check against pinned_cert_sha256")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(hash[:], pin) {
+			return nil
+		}
+	}
+	return errors.New("the certificate presented by the server does not match any pinned_cert_sha256 value")
+}
+
 // Convert the important bits about this object to string representation
 // This is useful for debugging.
 func (client *APIClient) toString() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(fmt.Sprintf("uri: %s\n", client.uri))
+	buffer.WriteString(fmt.Sprintf("failover_uris: %v\n", client.endpoints.uris[1:]))
 	buffer.WriteString(fmt.Sprintf("insecure: %t\n", client.insecure))
 	buffer.WriteString(fmt.Sprintf("username: %s\n", client.username))
-	buffer.WriteString(fmt.Sprintf("password: %s\n", client.password))
+	if client.password != "" {
+		buffer.WriteString(fmt.Sprintf("password: %s\n", sensitiveMask))
+	}
 	buffer.WriteString(fmt.Sprintf("id_attribute: %s\n", client.idAttribute))
+	buffer.WriteString(fmt.Sprintf("id_attributes: %v\n", client.idAttributes))
 	buffer.WriteString(fmt.Sprintf("write_returns_object: %t\n", client.writeReturnsObject))
 	buffer.WriteString(fmt.Sprintf("create_returns_object: %t\n", client.createReturnsObject))
 	buffer.WriteString("headers:\n")
 	for k, v := range client.headers {
+		if client.isSensitiveHeader(k) {
+			v = sensitiveMask
+		}
 		buffer.WriteString(fmt.Sprintf("  %s: %s\n", k, v))
 	}
 	for _, n := range client.copyKeys {
@@ -232,37 +725,139 @@ func (client *APIClient) toString() string {
 /*
 Helper function that handles sending/receiving and handling
 
-	of HTTP data in and out.
+	of HTTP data in and out. Requests are retried according to
+	the client's retry policy.
 */
 func (client *APIClient) sendRequest(method string, path string, data string) (string, error) {
-	fullURI := client.uri + path
-	var req *http.Request
-	var err error
+	return client.sendRequestWithHeaders(method, path, data, nil)
+}
 
-	if client.debug {
-		log.Printf("api_client.go: method='%s', path='%s', full uri (derived)='%s', data='%s'\n", method, path, fullURI, data)
+/* Like sendRequest, but headers are set on this request after (and so override) the provider's headers */
+func (client *APIClient) sendRequestWithHeaders(method string, path string, data string, headers map[string]string) (string, error) {
+	body, _, err := client.sendRequestWithResponse(method, path, data, headers)
+	return body, err
+}
+
+/*
+Like sendRequestWithHeaders, but also returns the final response so
+
+	callers can look at its status code and headers. The response body
+	has already been read and closed.
+*/
+func (client *APIClient) sendRequestWithResponse(method string, path string, data string, headers map[string]string) (string, *http.Response, error) {
+	return client.sendRequestContext(context.Background(), method, path, data, headers)
+}
+
+/* Like sendRequestWithResponse, but gives up (with ctx's error) once ctx is done */
+func (client *APIClient) sendRequestContext(ctx context.Context, method string, path string, data string, headers map[string]string) (string, *http.Response, error) {
+	if client.dryRun && !isSafeMethod(method) {
+		return "", nil, client.dryRunError(ctx, method, path, data, headers)
+	}
+	if client.openAPI != nil {
+		if err := client.checkOpenAPI("request", method, path, client.openAPI.validateRequest(method, path, data)); err != nil {
+			return "", nil, err
+		}
 	}
 
-	buffer := bytes.NewBuffer([]byte(data))
+	retries, throttleRetries, failovers := 0, 0, 0
+	for {
+		uri := client.activeURI()
+		body, resp, err := client.sendRequestOnce(ctx, uri, method, path, data, headers)
+		if err == nil || ctx.Err() != nil {
+			return body, resp, err
+		}
 
-	if data == "" || data == "{}" {
-		req, err = http.NewRequest(method, fullURI, nil)
-	} else {
-		req, err = http.NewRequest(method, fullURI, buffer)
+		/* The endpoint could not be reached at all - give each failover endpoint one try */
+		if resp == nil && canFailOver(method, err) && failovers < len(client.endpoints.uris)-1 && client.failover(uri) {
+			failovers++
+			continue
+		}
 
-		/* Default of application/json, but allow headers array to overwrite later */
-		if err == nil {
-			req.Header.Set("Content-Type", "application/json")
+		var wait time.Duration
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			/* Throttling pauses every request made by this client, not just this one */
+			retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if !ok {
+				retryAfter = client.retryPolicy.backoff(throttleRetries)
+			} else if retryAfter > client.retryPolicy.waitMax {
+				client.log(logTransport, "WARN", "Retry-After is longer than retry_wait_max. Waiting retry_wait_max instead", map[string]interface{}{"retry_after": retryAfter.String(), "retry_wait_max": client.retryPolicy.waitMax.String()})
+				retryAfter = client.retryPolicy.waitMax
+			}
+			client.throttle.pause(retryAfter)
+			if throttleRetries >= client.retryPolicy.maxThrottleRetries {
+				return body, resp, err
+			}
+			throttleRetries++
+			wait = retryAfter
+		} else if client.retryPolicy.shouldRetry(method, retries, resp, err) {
+			wait = client.retryPolicy.backoff(retries)
+			retries++
+		} else {
+			return body, resp, err
+		}
+
+		if !client.retryPolicy.spendBudget(wait) {
+			client.log(logTransport, "WARN", "Not retrying - retry_budget would be exceeded", map[string]interface{}{"method": method, "path": path, "retry_budget": client.retryPolicy.budget.String()})
+			return body, resp, err
+		}
+
+		client.log(logTransport, "WARN", "Retrying request", map[string]interface{}{
+			"method": method, "path": path, "wait": wait.String(), "error": err.Error(),
+			"retry": retries, "max_retries": client.retryPolicy.maxRetries,
+			"throttled_retry": throttleRetries, "max_throttle_retries": client.retryPolicy.maxThrottleRetries,
+		})
+		client.metrics.retry()
+		if err := sleepContext(ctx, wait); err != nil {
+			return body, resp, err
 		}
 	}
+}
+
+/* Whether requests with method only read, and so are sent with dry_run */
+func isSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
 
+/* Log a request dry_run keeps from being sent, and the error it fails with */
+func (client *APIClient) dryRunError(ctx context.Context, method string, path string, data string, headers map[string]string) error {
+	req, err := client.newRequest(ctx, client.activeURI()+path, method, data, headers)
 	if err != nil {
-		log.Fatal(err)
-		return "", err
+		return err
+	}
+	dump, _ := httputil.DumpRequestOut(req, false)
+	client.log(logTransport, "INFO", "dry_run: Not sending request", map[string]interface{}{"request": client.redactHeaders(string(dump)), "body": client.logBody(data)})
+
+	if data == "" {
+		return fmt.Errorf("dry_run: not sending %s %s", method, req.URL)
+	}
+	return fmt.Errorf("dry_run: not sending %s %s with body %s", method, req.URL, client.sensitiveKeys.mask(data))
+}
+
+/* Build a request with the provider's headers and credentials, and then headers */
+func (client *APIClient) newRequest(ctx context.Context, fullURI string, method string, data string, headers map[string]string) (*http.Request, error) {
+	/* Read straight from data rather than a copy of it */
+	var body io.Reader
+	if data != "" && data != "{}" {
+		body = strings.NewReader(data)
+	}
+	return client.newStreamingRequest(ctx, fullURI, method, body, headers)
+}
+
+/* Like newRequest, but with a body (nil for none) that is read as it is sent */
+func (client *APIClient) newStreamingRequest(ctx context.Context, fullURI string, method string, body io.Reader, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, fullURI, body)
+
+	/* Default of application/json, but allow headers array to overwrite later */
+	if err == nil && body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
 	if client.debug {
-		log.Printf("api_client.go: Sending HTTP request to %s...\n", req.URL)
+		client.debugLog("Sending HTTP request", map[string]interface{}{"url": req.URL.String()})
 	}
 
 	/* Allow for tokens or other pre-created secrets */
@@ -271,61 +866,286 @@ func (client *APIClient) sendRequest(method string, path string, data string) (s
 			req.Header.Set(n, v)
 		}
 	}
+	for n, v := range headers {
+		req.Header.Set(n, v)
+	}
 
-	if client.username != "" && client.password != "" {
-		/* ... and fall back to basic auth if configured */
-		req.SetBasicAuth(client.username, client.password)
+	/* A fresh id for each request, unless the resource set its own */
+	if client.requestIDHeader != "" && req.Header.Get(client.requestIDHeader) == "" {
+		req.Header.Set(client.requestIDHeader, newRequestID())
 	}
 
-	if client.debug {
-		body, err := httputil.DumpRequestOut(req, true)
+	/* Go ignores a Host entry in the header map, so it must be set here */
+	if client.hostHeader != "" {
+		req.Host = client.hostHeader
+	}
 
-		if err != nil {
-			return "", err
+	/* Credentials take precedence over an Authorization header set above */
+	if client.authenticator != nil {
+		if err := client.authenticator.Apply(req); err != nil {
+			return nil, err
 		}
+	}
 
-		log.Print(string(body))
+	return req, nil
+}
+
+/* A random (version 4) UUID to identify a request by in the API's logs */
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+/*
+The error for a non-2xx response to req (sent with data), with the
 
-	if client.rateLimiter != nil {
-		// Rate limiting
-		if client.debug {
-			log.Printf("Waiting for rate limit availability\n")
+	request id the API returned in request_id_header (or the one that
+	was sent, if it returned none) and with curl_on_error, a curl
+	command to reproduce it
+*/
+func (client *APIClient) responseError(req *http.Request, data string, resp *http.Response, body string) *APIError {
+	apiErr := &APIError{statusCode: resp.StatusCode, body: body, message: client.errorMessage(resp, body)}
+	if client.curlOnError {
+		apiErr.curl = client.curlCommand(req, data)
+	}
+	if client.requestIDHeader != "" {
+		apiErr.requestID = resp.Header.Get(client.requestIDHeader)
+		if apiErr.requestID == "" {
+			apiErr.requestID = req.Header.Get(client.requestIDHeader)
 		}
-		_ = client.rateLimiter.Wait(context.Background())
 	}
+	return apiErr
+}
 
-	resp, err := client.httpClient.Do(req)
+/* The most memory set aside for a response body because of its Content-Length */
+const maxResponsePrealloc int64 = 1 << 20
 
-	if err != nil {
-		//log.Printf("api_client.go: Error detected: %s\n", err)
-		return "", err
+/* Send a single request without any retries */
+func (client *APIClient) sendRequestOnce(ctx context.Context, uri string, method string, path string, data string, headers map[string]string) (string, *http.Response, error) {
+	fullURI := uri + path
+
+	if client.debug {
+		client.debugLog("Building request", map[string]interface{}{"method": method, "path": path, "uri": fullURI, "data": client.logBody(data)})
 	}
 
+	req, err := client.newRequest(withRequestPath(ctx, path), fullURI, method, data, headers)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to build the %s request to %s: %w", method, fullURI, err)
+	}
 	if client.debug {
-		body, err := httputil.DumpResponse(resp, true)
+		client.debugLog("Request as a curl command", map[string]interface{}{"curl": client.curlCommand(req, data)})
+	}
+	if client.requestIDHeader != "" {
+		client.log(logTransport, "DEBUG", "Request id", map[string]interface{}{"method": method, "path": path, "request_id": req.Header.Get(client.requestIDHeader)})
+	}
 
-		if err != nil {
-			return "", err
+	resp, err := client.requests.RoundTrip(req)
+	if err != nil {
+		/* Already names the method and URL (as a *url.Error) */
+		if client.curlOnError {
+			err = fmt.Errorf("%w\nReproduce with: %s", err, client.curlCommand(req, data))
 		}
+		return "", nil, err
+	}
 
-		log.Print(string(body))
+	/* Never read more than one byte past the limit so a huge
+	   response cannot exhaust memory */
+	if client.maxResponseSize > 0 {
+		if resp.ContentLength > client.maxResponseSize {
+			resp.Body.Close()
+			return "", resp, fmt.Errorf("response from %s is %d bytes which exceeds max_response_size of %d bytes", req.URL, resp.ContentLength, client.maxResponseSize)
+		}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, client.maxResponseSize+1), resp.Body}
 	}
 
-	bodyBytes, err2 := io.ReadAll(resp.Body)
+	/* Read into the string that is returned, rather than into bytes that are then copied.
+	   Content-Length comes from the server, so only so much is allocated up front on its word */
+	var builder strings.Builder
+	if resp.ContentLength > 0 {
+		builder.Grow(int(min(resp.ContentLength, maxResponsePrealloc)))
+	}
+	read, err2 := io.Copy(&builder, resp.Body)
 	resp.Body.Close()
 
 	if err2 != nil {
-		return "", err2
+		return "", resp, fmt.Errorf("failed to read the response to %s %s: %w", method, req.URL, err2)
 	}
-	body := strings.TrimPrefix(string(bodyBytes), client.xssiPrefix)
+	if client.maxResponseSize > 0 && read > client.maxResponseSize {
+		return "", resp, fmt.Errorf("response from %s exceeds max_response_size of %d bytes", req.URL, client.maxResponseSize)
+	}
+	body := strings.TrimPrefix(builder.String(), client.xssiPrefix)
 	if client.debug {
-		log.Printf("api_client.go: BODY:\n%s\n", body)
+		client.debugLog("Response body", map[string]interface{}{"body": client.logBody(body)})
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && client.authenticator != nil {
+		client.authenticator.Invalidate()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return body, resp, client.responseError(req, data, resp, body)
+	}
+	if client.openAPI != nil {
+		if err := client.checkOpenAPI("response", method, path, client.openAPI.validateResponse(method, path, resp.StatusCode, body)); err != nil {
+			return body, resp, err
+		}
+	}
+
+	return body, resp, nil
+}
+
+/*
+Open a streaming response, such as Server-Sent Events, for the caller
+
+	to read and close
+*/
+func (client *APIClient) openStream(ctx context.Context, path string, headers map[string]string) (*http.Response, error) {
+	return client.sendRequestStream(ctx, "GET", path, nil, headers)
+}
+
+/*
+Send a request with body (nil for none) read as it is sent, returning
+
+	the response with its body unread for the caller to read and close.
+	Neither has to fit in memory. The request is not retried, since its
+	body cannot be read twice, and is only limited by ctx since the
+	client's timeout would cover reading the whole body. Streams stay
+	open, so they do not take a max_parallel_requests slot, and are not
+	recorded in har_file
+*/
+func (client *APIClient) sendRequestStream(ctx context.Context, method string, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	if client.dryRun && !isSafeMethod(method) {
+		return nil, client.dryRunError(ctx, method, path, "", headers)
+	}
+
+	req, err := client.newStreamingRequest(withRequestPath(ctx, path), client.activeURI()+path, method, body, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the %s request to %s%s: %w", method, client.activeURI(), path, err)
+	}
+
+	streamClient := *client.httpClient
+	streamClient.Timeout = 0
+	resp, err := chain(roundTripperFunc(streamClient.Do), client.throttleMiddleware, client.debugMiddleware).RoundTrip(req)
+	if err != nil {
+		return nil, err
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized && client.authenticator != nil {
+		client.authenticator.Invalidate()
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return body, fmt.Errorf("unexpected response code '%d': %s", resp.StatusCode, body)
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+		body := string(bodyBytes)
+		return nil, client.responseError(req, "", resp, body)
+	}
+	if client.maxResponseSize > 0 {
+		resp.Body = &maxSizeBody{ReadCloser: resp.Body, remaining: client.maxResponseSize, url: req.URL.String(), max: client.maxResponseSize}
+	}
+	return resp, nil
+}
+
+/* A streamed response body that fails once more than max_response_size bytes are read from it */
+type maxSizeBody struct {
+	io.ReadCloser
+	remaining int64
+	url       string
+	max       int64
+}
+
+func (b *maxSizeBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, fmt.Errorf("response from %s exceeds max_response_size of %d bytes", b.url, b.max)
 	}
+	/* One byte past the limit says whether there is more */
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), fmt.Errorf("response from %s exceeds max_response_size of %d bytes", b.url, b.max)
+	}
+	return n, err
+}
+
+/*
+Open a WebSocket to path on the API server (or a full ws:// or wss://
 
-	return body, nil
+	URL to the host of uri or one of failover_uris) with the provider's
+	headers, credentials and TLS settings. The connection is closed at
+	deadline, or when ctx is done. stop must be called once the
+	connection is no longer used
+*/
+func (client *APIClient) openWebSocket(ctx context.Context, path string, headers map[string]string, deadline time.Time) (conn *websocket.Conn, stop func(), err error) {
+	origin := client.activeURI()
+	location := path
+	if !strings.HasPrefix(path, "ws://") && !strings.HasPrefix(path, "wss://") {
+		location = strings.Replace(origin+path, "http", "ws", 1)
+	}
+
+	config, err := websocket.NewConfig(location, origin)
+	if err != nil {
+		return nil, nil, err
+	}
+	/* The provider's credentials are only sent to the API */
+	if !client.isAPIHost(config.Location) {
+		return nil, nil, fmt.Errorf("'%s' is not on the host of uri or failover_uris", location)
+	}
+	req, err := client.newRequest(ctx, location, "GET", "", headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Header = req.Header
+	config.TlsConfig = client.tlsConfig
+	config.Dialer = &net.Dialer{Timeout: client.dialer.Timeout, Deadline: deadline}
+
+	dialCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	if err := client.waitForThrottle(dialCtx); err != nil {
+		return nil, nil, err
+	}
+	conn, err = websocket.DialConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	/* Unblock a Receive once ctx is done */
+	stopAfter := context.AfterFunc(ctx, func() { conn.Close() })
+	return conn, func() {
+		stopAfter()
+		conn.Close()
+	}, nil
+}
+
+/* Whether u is on the host (and port) of uri or one of failover_uris */
+func (client *APIClient) isAPIHost(u *url.URL) bool {
+	for _, uri := range client.endpoints.uris {
+		if api, err := url.Parse(uri); err == nil && hostPort(api) == hostPort(u) {
+			return true
+		}
+	}
+	return false
+}
+
+/* The host and port of u, with the port defaulting to its scheme's */
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" || u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
 }
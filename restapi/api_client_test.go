@@ -1,11 +1,30 @@
 package restapi
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/time/rate"
 )
 
 type FollowResponse struct {
@@ -129,3 +148,470 @@ func setupAPIClientServer() {
 func shutdownAPIClientServer() {
 	apiClientServer.Close()
 }
+
+func TestAPIClientHTTP2Options(t *testing.T) {
+	opt := &apiClientOpt{
+		uri:          "https://127.0.0.1:8083/",
+		forceHTTP2:   true,
+		disableHTTP2: true,
+	}
+	if _, err := NewAPIClient(opt); err == nil {
+		t.Fatalf("client_test.go: Expected an error when both force_http2 and disable_http2 are set")
+	}
+
+	opt.forceHTTP2 = false
+	client, err := NewAPIClient(opt)
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	transport, ok := client.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("client_test.go: Expected an *http.Transport but got %T", client.httpClient.Transport)
+	}
+	if transport.TLSNextProto == nil || len(transport.TLSNextProto) != 0 {
+		t.Fatalf("client_test.go: Expected disable_http2 to set an empty TLSNextProto map")
+	}
+
+	opt.disableHTTP2 = false
+	opt.forceHTTP2 = true
+	client, _ = NewAPIClient(opt)
+	if !client.httpClient.Transport.(*http.Transport).ForceAttemptHTTP2 {
+		t.Fatalf("client_test.go: Expected force_http2 to set ForceAttemptHTTP2")
+	}
+
+	opt.forceHTTP2 = false
+	opt.useHTTP3 = true
+	client, _ = NewAPIClient(opt)
+	if _, ok := client.httpClient.Transport.(*http3.RoundTripper); !ok {
+		t.Fatalf("client_test.go: Expected use_http3 to configure an HTTP/3 transport but got %T", client.httpClient.Transport)
+	}
+}
+
+func TestAPIClientUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("client_test.go: Failed to listen on unix socket: %s", err)
+	}
+
+	serverMux := http.NewServeMux()
+	serverMux.HandleFunc("/api/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("It works!"))
+	})
+	svr := &http.Server{Handler: serverMux}
+	go svr.Serve(listener)
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:        "http://localhost/api",
+		unixSocket: socket,
+		timeout:    2,
+	})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if res != "It works!" {
+		t.Fatalf("client_test.go: Got back '%s' but expected 'It works!'\n", res)
+	}
+}
+
+func TestAPIClientCACerts(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	caCerts := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw}))
+	caCertsFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCertsFile, []byte(caCerts), 0600); err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	/* The test server's certificate is not trusted by default */
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("client_test.go: Expected a TLS verification error without cacerts")
+	}
+
+	for _, opt := range []*apiClientOpt{
+		{uri: svr.URL, timeout: 2, caCertsFile: caCertsFile},
+		{uri: svr.URL, timeout: 2, caCertsString: caCerts},
+	} {
+		client, err := NewAPIClient(opt)
+		if err != nil {
+			t.Fatalf("client_test.go: %s", err)
+		}
+		if _, err := client.sendRequest("GET", "/ok", ""); err != nil {
+			t.Fatalf("client_test.go: %s", err)
+		}
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, caCertsString: "not a certificate"}); err == nil {
+		t.Fatalf("client_test.go: Expected an error when cacerts_string has no certificates")
+	}
+}
+
+func TestAPIClientTLSVersions(t *testing.T) {
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("It works!"))
+	}))
+	svr.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	svr.StartTLS()
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, insecure: true, tlsMinVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("client_test.go: Expected the handshake to fail with tls_min_version 1.3 against a TLS 1.2 server")
+	}
+
+	client, err = NewAPIClient(&apiClientOpt{
+		uri:             svr.URL,
+		timeout:         2,
+		insecure:        true,
+		tlsMinVersion:   "1.2",
+		tlsCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("GET", "/ok", ""); err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, tlsMinVersion: "1.3", tlsMaxVersion: "1.2"}); err == nil {
+		t.Fatalf("client_test.go: Expected an error when tls_min_version is greater than tls_max_version")
+	}
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, tlsCipherSuites: []string{"TLS_NOT_A_REAL_SUITE"}}); err == nil {
+		t.Fatalf("client_test.go: Expected an error for an unknown cipher suite")
+	}
+}
+
+func TestAPIClientPinnedCert(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	hash := sha256.Sum256(svr.Certificate().RawSubjectPublicKeyInfo)
+
+	for _, pin := range []string{base64.StdEncoding.EncodeToString(hash[:]), hex.EncodeToString(hash[:])} {
+		client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, pinnedCertSHA256: []string{pin}})
+		if err != nil {
+			t.Fatalf("client_test.go: %s", err)
+		}
+		if _, err := client.sendRequest("GET", "/ok", ""); err != nil {
+			t.Fatalf("client_test.go: Expected pinned certificate '%s' to be accepted: %s", pin, err)
+		}
+	}
+
+	wrong := sha256.Sum256([]byte("not the key"))
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, pinnedCertSHA256: []string{hex.EncodeToString(wrong[:])}})
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("client_test.go: Expected a certificate not matching the pin to be rejected")
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, pinnedCertSHA256: []string{"junk"}}); err == nil {
+		t.Fatalf("client_test.go: Expected an error for an invalid pin")
+	}
+
+	/* A leaf the server has the key for, followed by the (public) pinned certificate */
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	attacker, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if err := verifyPinnedCert([][]byte{attacker, svr.Certificate().Raw}, [][]byte{hash[:]}); err == nil {
+		t.Fatalf("client_test.go: Expected a chain with the pinned certificate after another leaf to be rejected")
+	}
+	if err := verifyPinnedCert([][]byte{svr.Certificate().Raw}, [][]byte{hash[:]}); err != nil {
+		t.Fatalf("client_test.go: Expected the pinned leaf to be accepted: %s", err)
+	}
+}
+
+func TestAPIClientServerNameAndHostHeader(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer svr.Close()
+
+	caCerts := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw}))
+
+	/* The test certificate is valid for example.com */
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:           svr.URL,
+		timeout:       2,
+		caCertsString: caCerts,
+		tlsServerName: "example.com",
+		hostHeader:    "api.example.com",
+	})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if res != "api.example.com" {
+		t.Fatalf("client_test.go: Got back Host '%s' but expected 'api.example.com'\n", res)
+	}
+
+	client, _ = NewAPIClient(&apiClientOpt{
+		uri:           svr.URL,
+		timeout:       2,
+		caCertsString: caCerts,
+		tlsServerName: "not.example.org",
+	})
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("client_test.go: Expected certificate verification to fail for a server name not on the certificate")
+	}
+}
+
+func TestAPIClientConnectionPool(t *testing.T) {
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:                 "http://127.0.0.1:8083/",
+		maxIdleConns:        50,
+		maxIdleConnsPerHost: 10,
+		maxConnsPerHost:     20,
+		idleConnTimeout:     30,
+	})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	transport := client.httpClient.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 50 || transport.MaxIdleConnsPerHost != 10 || transport.MaxConnsPerHost != 20 {
+		t.Fatalf("client_test.go: Connection pool limits were not applied to the transport: %d/%d/%d",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport.IdleConnTimeout != 30*time.Second {
+		t.Fatalf("client_test.go: Expected an idle connection timeout of 30s but got %s", transport.IdleConnTimeout)
+	}
+}
+
+func TestAPIClientPhaseTimeouts(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow_headers" {
+			time.Sleep(1500 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		/* A slow body is not limited by the response header timeout */
+		time.Sleep(1500 * time.Millisecond)
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, connectTimeout: 1, tlsHandshakeTimeout: 1, responseHeaderTimeout: 1})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	transport := client.httpClient.Transport.(*http.Transport)
+	if transport.TLSHandshakeTimeout != time.Second || transport.ResponseHeaderTimeout != time.Second {
+		t.Fatalf("client_test.go: Timeouts were not applied to the transport: %s/%s", transport.TLSHandshakeTimeout, transport.ResponseHeaderTimeout)
+	}
+
+	if _, err := client.sendRequest("GET", "/slow_headers", ""); err == nil {
+		t.Fatalf("client_test.go: Expected slow response headers to time out")
+	}
+	res, err := client.sendRequest("GET", "/slow_body", "")
+	if err != nil {
+		t.Fatalf("client_test.go: Expected a slow body not to time out: %s", err)
+	}
+	if res != "It works!" {
+		t.Fatalf("client_test.go: Expected 'It works!' but got '%s'", res)
+	}
+}
+
+func TestAPIClientHostOverrides(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer svr.Close()
+
+	_, port, _ := net.SplitHostPort(svr.Listener.Addr().String())
+
+	for _, override := range []string{"127.0.0.1", svr.Listener.Addr().String()} {
+		client, err := NewAPIClient(&apiClientOpt{
+			uri:           "http://api.example.invalid:" + port,
+			timeout:       2,
+			hostOverrides: map[string]string{"api.example.invalid": override},
+		})
+		if err != nil {
+			t.Fatalf("client_test.go: %s", err)
+		}
+		res, err := client.sendRequest("GET", "/ok", "")
+		if err != nil {
+			t.Fatalf("client_test.go: %s", err)
+		}
+		if res != "api.example.invalid:"+port {
+			t.Fatalf("client_test.go: Got back Host '%s' but expected the original host to be sent\n", res)
+		}
+	}
+
+	if res := overrideHost("other.example.invalid:443", map[string]string{"api.example.invalid": "10.0.0.1"}); res != "other.example.invalid:443" {
+		t.Fatalf("client_test.go: Expected hosts without an override to be left alone but got '%s'", res)
+	}
+}
+
+func TestAPIClientMaxResponseSize(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		/* Flushing first forces a chunked response with no Content-Length */
+		if r.URL.Path == "/chunked" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	for _, debug := range []bool{false, true} {
+		client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxResponseSize: 9, debug: debug})
+		if _, err := client.sendRequest("GET", "/ok", ""); err != nil {
+			t.Fatalf("client_test.go: Expected a response of exactly max_response_size to be accepted: %s", err)
+		}
+
+		client, _ = NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxResponseSize: 8, debug: debug})
+		for _, path := range []string{"/ok", "/chunked"} {
+			if _, err := client.sendRequest("GET", path, ""); err == nil {
+				t.Fatalf("client_test.go: Expected a response larger than max_response_size to be rejected (path=%s, debug=%t)", path, debug)
+			}
+		}
+	}
+}
+
+func TestAPIClientRateLimitBuckets(t *testing.T) {
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:       "http://127.0.0.1:8083/",
+		rateLimit: 100,
+		rateLimitBuckets: []rateLimitBucket{
+			{pathPrefix: "/api/objects", methods: []string{"POST", "PUT", "DELETE"}, rateLimit: 1},
+			{pathPrefix: "/api/objects", rateLimit: 10},
+		},
+	})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	cases := []struct {
+		method   string
+		path     string
+		expected *rate.Limiter
+	}{
+		{"post", "/api/objects", client.rateLimitBuckets[0].limiter},
+		{"DELETE", "/api/objects/1", client.rateLimitBuckets[0].limiter},
+		{"GET", "/api/objects/1", client.rateLimitBuckets[1].limiter},
+		{"PUT", "/api/other", client.rateLimiter},
+	}
+	for _, c := range cases {
+		if client.rateLimiterFor(c.method, c.path) != c.expected {
+			t.Errorf("client_test.go: %s %s was not matched to the expected rate limiter", c.method, c.path)
+		}
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:8083/", rateLimitBuckets: []rateLimitBucket{{pathPrefix: "/"}}}); err == nil {
+		t.Fatalf("client_test.go: Expected an error for a rate_limits entry without a rate")
+	}
+}
+
+func TestAPIClientMaxParallelRequests(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxParallelRequests: 2})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.sendRequest("GET", "/ok", "")
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 2 {
+		t.Fatalf("client_test.go: Expected at most 2 requests in flight but saw %d", maxInFlight)
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, maxParallelRequests: -1}); err == nil {
+		t.Fatalf("client_test.go: Expected an error for a negative max_parallel_requests")
+	}
+}
+
+func TestAPIClientDryRun(t *testing.T) {
+	var sent []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Method)
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, dryRun: true, maxRetries: 2, sensitiveKeys: []string{"password"}})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("client_test.go: Expected reads to be sent with dry_run but got %s", err)
+	}
+	_, err = client.sendRequest("POST", "/api/objects", `{"id":"1","password":"hunter2"}`)
+	expected := `dry_run: not sending POST ` + svr.URL + `/api/objects with body {"id":"1","password":"(sensitive value)"}`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("client_test.go: Expected error %q but got %v", expected, err)
+	}
+	if _, err := client.sendRequest("DELETE", "/api/objects/1", ""); err == nil {
+		t.Fatalf("client_test.go: Expected the DELETE not to be sent")
+	}
+	if len(sent) != 1 || sent[0] != "GET" {
+		t.Fatalf("client_test.go: Expected only the GET to be sent but got %v", sent)
+	}
+}
+
+func TestAPIClientRequestErrors(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(`{"id":`))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	/* Errors say which request failed, rather than stopping the provider */
+	if _, err := client.sendRequest("GET", "/api/objects/\x7f", ""); err == nil || !strings.Contains(err.Error(), "failed to build the GET request to "+svr.URL+"/api/objects/") {
+		t.Fatalf("client_test.go: Expected an error building the request but got %v", err)
+	}
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err == nil || !strings.Contains(err.Error(), "failed to read the response to GET "+svr.URL+"/api/objects/1") {
+		t.Fatalf("client_test.go: Expected an error reading the truncated response but got %v", err)
+	}
+}
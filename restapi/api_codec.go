@@ -0,0 +1,256 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+A codec turns objects into request bodies and response bodies back into
+
+	objects, for payload_format. Objects are what decodeJSON makes of
+	data, so everything else (ids, search, drift and state) works the
+	same whatever the API speaks. Add a format by adding it to codecs
+*/
+type codec interface {
+	/* The Content-Type of requests */
+	contentType() string
+	/* The Accept header of requests */
+	accept() string
+	/* Whether a response's media type (without parameters) is in this format */
+	decodes(mediaType string) bool
+	marshal(v interface{}) ([]byte, error)
+	unmarshal(b []byte) (interface{}, error)
+}
+
+/* The codecs for each payload_format */
+var codecs = map[string]codec{
+	"json": jsonCodec{},
+	"yaml": yamlCodec{},
+	"form": formCodec{},
+}
+
+/* Valid values for payload_format */
+func payloadFormats() []string {
+	formats := make([]string, 0, len(codecs))
+	for format := range codecs {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) contentType() string { return "application/json" }
+
+func (jsonCodec) accept() string { return "application/json" }
+
+func (jsonCodec) decodes(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func (jsonCodec) marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) unmarshal(b []byte) (interface{}, error) {
+	var v interface{}
+	err := decodeJSON(b, &v)
+	return v, err
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) contentType() string { return "application/yaml" }
+
+func (yamlCodec) accept() string { return "application/yaml" }
+
+func (yamlCodec) decodes(mediaType string) bool {
+	return mediaType == "application/yaml" || mediaType == "application/x-yaml" || mediaType == "text/yaml" || strings.HasSuffix(mediaType, "+yaml")
+}
+
+func (yamlCodec) marshal(v interface{}) ([]byte, error) { return yaml.Marshal(plainNumbers(v)) }
+
+func (yamlCodec) unmarshal(b []byte) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return stringKeys(v), nil
+}
+
+/*
+application/x-www-form-urlencoded, for flat objects. Lists become
+
+	repeated keys and nested objects are sent as JSON. Values read back
+	are strings, or lists of strings for repeated keys. Form APIs
+	almost always answer in JSON, so that is what is asked for
+*/
+type formCodec struct{}
+
+func (formCodec) contentType() string { return "application/x-www-form-urlencoded" }
+
+func (formCodec) accept() string { return "application/json" }
+
+func (formCodec) decodes(mediaType string) bool {
+	return mediaType == "application/x-www-form-urlencoded"
+}
+
+func (formCodec) marshal(v interface{}) ([]byte, error) {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("only objects can be sent as form data, not %T", v)
+	}
+	values := url.Values{}
+	for key, value := range object {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			switch item := item.(type) {
+			case nil:
+				values.Add(key, "")
+			case map[string]interface{}, []interface{}:
+				b, _ := json.Marshal(item)
+				values.Add(key, string(b))
+			default:
+				values.Add(key, fmt.Sprint(item))
+			}
+		}
+	}
+	return []byte(values.Encode()), nil
+}
+
+func (formCodec) unmarshal(b []byte) (interface{}, error) {
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, err
+	}
+	object := make(map[string]interface{}, len(values))
+	for key, list := range values {
+		if len(list) == 1 {
+			object[key] = list[0]
+			continue
+		}
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			items[i] = item
+		}
+		object[key] = items
+	}
+	return object, nil
+}
+
+/* Turn the json.Numbers decodeJSON makes into numbers other encoders know */
+func plainNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = plainNumbers(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			l[i] = plainNumbers(val)
+		}
+		return l
+	}
+	return v
+}
+
+/*
+Encode data (JSON, as the rest of the provider builds it) for the API,
+
+	setting Content-Type and Accept unless headers or the provider's
+	headers already do
+*/
+func encodePayload(c codec, data string, headers map[string]string, providerHeaders map[string]string) (string, map[string]string, error) {
+	if _, ok := c.(jsonCodec); ok {
+		return data, headers, nil
+	}
+
+	withTypes := make(map[string]string, len(headers)+2)
+	for name, value := range map[string]string{"Content-Type": c.contentType(), "Accept": c.accept()} {
+		if !hasHeader(name, headers) && !hasHeader(name, providerHeaders) {
+			withTypes[name] = value
+		}
+	}
+	for k, v := range headers {
+		withTypes[k] = v
+	}
+	if data == "" || data == "{}" {
+		return data, withTypes, nil
+	}
+
+	v, err := jsonCodec{}.unmarshal([]byte(data))
+	if err != nil {
+		return "", nil, err
+	}
+	b, err := c.marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(b), withTypes, nil
+}
+
+func hasHeader(name string, headers map[string]string) bool {
+	for k := range headers {
+		if http.CanonicalHeaderKey(k) == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Decode a response body from the API into JSON, for the rest of the
+
+	provider. The codec is picked by the response's Content-Type, since
+	APIs often answer in another format than they are sent (form APIs
+	in JSON). Without a known Content-Type, JSON is kept as it is and
+	anything else is decoded with c, the payload_format's codec
+*/
+func decodeResponse(c codec, contentType string, body string) (string, error) {
+	if body == "" {
+		return body, nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		for _, format := range payloadFormats() {
+			if codecs[format].decodes(mediaType) {
+				return decodePayload(codecs[format], body)
+			}
+		}
+	}
+	if json.Valid([]byte(body)) {
+		return body, nil
+	}
+	return decodePayload(c, body)
+}
+
+/* Decode a response body in c's format into JSON */
+func decodePayload(c codec, body string) (string, error) {
+	if _, ok := c.(jsonCodec); ok || body == "" {
+		return body, nil
+	}
+	v, err := c.unmarshal([]byte(body))
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,136 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	data := `{"id":"1","size":3,"ratio":0.5,"tags":["a","b"],"owner":{"name":"x"}}`
+
+	b, headers, err := encodePayload(codecs["yaml"], data, map[string]string{"accept": "text/plain"}, nil)
+	if err != nil {
+		t.Fatalf("api_codec_test.go: %s", err)
+	}
+	if !strings.Contains(b, "size: 3\n") || !strings.Contains(b, "ratio: 0.5\n") || !strings.Contains(b, "name: x\n") {
+		t.Fatalf("api_codec_test.go: Unexpected YAML %s", b)
+	}
+	if headers["Content-Type"] != "application/yaml" || headers["Accept"] != "" || headers["accept"] != "text/plain" {
+		t.Fatalf("api_codec_test.go: Expected only the Content-Type to be set but got %v", headers)
+	}
+	decoded, err := decodePayload(codecs["yaml"], b)
+	if err != nil || decoded != `{"id":"1","owner":{"name":"x"},"ratio":0.5,"size":3,"tags":["a","b"]}` {
+		t.Fatalf("api_codec_test.go: Expected the YAML to decode back to the data but got %s (%v)", decoded, err)
+	}
+
+	b, _, err = encodePayload(codecs["form"], data, nil, map[string]string{"Content-Type": "text/plain"})
+	if err != nil || b != "id=1&owner=%7B%22name%22%3A%22x%22%7D&ratio=0.5&size=3&tags=a&tags=b" {
+		t.Fatalf("api_codec_test.go: Unexpected form data %s (%v)", b, err)
+	}
+	decoded, err = decodePayload(codecs["form"], "id=1&tags=a&tags=b")
+	if err != nil || decoded != `{"id":"1","tags":["a","b"]}` {
+		t.Fatalf("api_codec_test.go: Unexpected decoded form %s (%v)", decoded, err)
+	}
+	if _, _, err := encodePayload(codecs["form"], `[1,2]`, nil, nil); err == nil {
+		t.Fatalf("api_codec_test.go: Expected an error encoding a list as form data")
+	}
+
+	/* JSON is sent as it is */
+	b, headers, _ = encodePayload(codecs["json"], data, nil, nil)
+	if b != data || headers != nil {
+		t.Fatalf("api_codec_test.go: Expected JSON to be left alone but got %s and %v", b, headers)
+	}
+}
+
+func TestAPIObjectPayloadFormat(t *testing.T) {
+	var contentType, body string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		contentType, body = r.Header.Get("Content-Type"), string(b)
+		w.Write([]byte("id: \"7\"\nname: test\nsize: 3\n"))
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true, payloadFormat: "yaml"})
+	if err != nil {
+		t.Fatalf("api_codec_test.go: %s", err)
+	}
+	object, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"name":"test","size":3}`})
+	if err != nil {
+		t.Fatalf("api_codec_test.go: %s", err)
+	}
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_codec_test.go: %s", err)
+	}
+	if contentType != "application/yaml" || body != "name: test\nsize: 3\n" {
+		t.Fatalf("api_codec_test.go: Expected the data to be sent as YAML but got '%s' with %s", body, contentType)
+	}
+	if object.id != "7" || object.apiData["name"] != "test" {
+		t.Fatalf("api_codec_test.go: Expected the id and data to be read from the YAML response but got '%s' and %v", object.id, object.apiData)
+	}
+
+	if _, err := NewAPIClient(&apiClientOpt{uri: svr.URL, payloadFormat: "xml"}); err == nil {
+		t.Fatalf("api_codec_test.go: Expected an error for an unknown payload_format")
+	}
+}
+
+func TestAPIObjectFormatWithJSONResponse(t *testing.T) {
+	var contentType, accept, body string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		contentType, accept, body = r.Header.Get("Content-Type"), r.Header.Get("Accept"), string(b)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(`{"id":"7","name":"test","size":3}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true, payloadFormat: "form"})
+	object, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"name":"test","size":3}`})
+	if err != nil {
+		t.Fatalf("api_codec_test.go: %s", err)
+	}
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_codec_test.go: %s", err)
+	}
+	if contentType != "application/x-www-form-urlencoded" || accept != "application/json" || body != "name=test&size=3" {
+		t.Fatalf("api_codec_test.go: Expected the data to be sent as a form asking for JSON but got '%s' with %s (Accept %s)", body, contentType, accept)
+	}
+	if size, ok := object.apiData["size"].(json.Number); object.id != "7" || !ok || size != "3" {
+		t.Fatalf("api_codec_test.go: Expected the JSON response to be read as JSON but got '%s' and %v", object.id, object.apiData)
+	}
+}
+
+func TestAPIObjectPayloadFormatSideRequests(t *testing.T) {
+	var hookBody string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/drain/1":
+			b, _ := io.ReadAll(r.Body)
+			hookBody = string(b)
+		case r.Method == "DELETE":
+			w.Header().Set("Location", "/operations/1")
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/operations/1":
+			w.Write([]byte("status: SUCCEEDED\n"))
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, payloadFormat: "yaml"})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:          "/api/objects",
+		id:            "1",
+		hooks:         []apiHook{{when: "pre_destroy", method: "POST", path: "/api/drain/{id}", data: `{"force":true}`}},
+		asyncSettings: map[string]*AsyncSettings{"destroy": {SearchKey: "status", SearchValue: "SUCCEEDED", PollInterval: 1, MaximumPollingDuration: 5}},
+	})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_codec_test.go: Expected the YAML operation status to be read but got %s", err)
+	}
+	if hookBody != "force: true\n" {
+		t.Fatalf("api_codec_test.go: Expected the hook's data to be sent as YAML but got '%s'", hookBody)
+	}
+}
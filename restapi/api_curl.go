@@ -0,0 +1,51 @@
+package restapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+A curl command that sends req (with body data) again, to reproduce a
+
+	request outside of Terraform. The values of sensitive headers are
+	left to environment variables named after them (such as
+	$AUTHORIZATION) and sensitive keys are masked in the body, as are
+	sensitive query parameters in the URL
+*/
+func (client *APIClient) curlCommand(req *http.Request, data string) string {
+	parts := []string{"curl", "-X", req.Method, shellQuote(client.redactURL(req.URL))}
+	if client.insecure {
+		parts = append(parts, "-k")
+	}
+	if req.Host != "" && req.Host != req.URL.Host {
+		parts = append(parts, "-H", shellQuote("Host: "+req.Host))
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if client.isSensitiveHeader(name) {
+				variable := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+				parts = append(parts, "-H", `"`+name+`: $`+variable+`"`)
+				continue
+			}
+			parts = append(parts, "-H", shellQuote(name+": "+value))
+		}
+	}
+
+	if data != "" {
+		parts = append(parts, "--data-raw", shellQuote(client.sensitiveKeys.mask(data)))
+	}
+	return strings.Join(parts, " ")
+}
+
+/* Quote s for a POSIX shell */
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
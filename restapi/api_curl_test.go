@@ -0,0 +1,56 @@
+package restapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCurlCommand(t *testing.T) {
+	client, _ := NewAPIClient(&apiClientOpt{
+		uri:           "https://api.example.com",
+		timeout:       2,
+		insecure:      true,
+		username:      "admin",
+		password:      "hunter2",
+		headers:       map[string]string{"X-Team": "it's ops"},
+		sensitiveKeys: []string{"secret"},
+	})
+	req, err := client.newRequest(context.Background(), "https://api.example.com/api/objects?x=1&api_key=k3y", "POST", `{"id":"1","secret":"s3cret"}`, nil)
+	if err != nil {
+		t.Fatalf("api_curl_test.go: %s", err)
+	}
+
+	expected := `curl -X POST 'https://api.example.com/api/objects?x=1&api_key=%28sensitive+value%29' -k -H "Authorization: $AUTHORIZATION" -H 'Content-Type: application/json' -H 'X-Team: it'\''s ops' --data-raw '{"id":"1","secret":"(sensitive value)"}'`
+	if curl := client.curlCommand(req, `{"id":"1","secret":"s3cret"}`); curl != expected {
+		t.Errorf("api_curl_test.go: Expected\n%s\nbut got\n%s", expected, curl)
+	}
+
+	/* An empty object is still a body that was sent */
+	if curl := client.curlCommand(req, "{}"); !strings.HasSuffix(curl, " --data-raw '{}'") {
+		t.Errorf("api_curl_test.go: Expected the {} body to be kept but got\n%s", curl)
+	}
+}
+
+func TestCurlOnError(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad name", http.StatusBadRequest)
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, curlOnError: true})
+	_, err := client.sendRequest("PUT", "/api/objects/1", `{"name":"x"}`)
+	if err == nil || !strings.Contains(err.Error(), "\nReproduce with: curl -X PUT '"+svr.URL+"/api/objects/1'") {
+		t.Errorf("api_curl_test.go: Expected the error to include a curl command but got %v", err)
+	}
+	if responseCode(err) != http.StatusBadRequest {
+		t.Errorf("api_curl_test.go: Expected the status code to be kept but got %d", responseCode(err))
+	}
+
+	client, _ = NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	if _, err := client.sendRequest("PUT", "/api/objects/1", `{"name":"x"}`); err == nil || strings.Contains(err.Error(), "curl") {
+		t.Errorf("api_curl_test.go: Expected no curl command without curl_on_error but got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+/*
+APIError is returned when the API responds with a non-2xx status code.
+
+	Use errors.As to get it from errors returned by APIClient
+*/
+type APIError struct {
+	statusCode int
+	body       string
+	/* The error message found in the body, if any (see errorMessage) */
+	message string
+	/* The id of the request, with request_id_header */
+	requestID string
+	/* A curl command to reproduce the request, with curl_on_error */
+	curl string
+}
+
+func (e *APIError) Error() string {
+	msg := e.body
+	if e.message != "" {
+		msg = e.message
+	}
+	if e.curl != "" {
+		msg += "\nReproduce with: " + e.curl
+	}
+	if e.requestID != "" {
+		return fmt.Sprintf("unexpected response code '%d' (request id %s): %s", e.statusCode, e.requestID, msg)
+	}
+	return fmt.Sprintf("unexpected response code '%d': %s", e.statusCode, msg)
+}
+
+/*StatusCode returns the status code the API responded with*/
+func (e *APIError) StatusCode() int {
+	return e.statusCode
+}
+
+/*Body returns the body of the response*/
+func (e *APIError) Body() string {
+	return e.body
+}
+
+/*Message returns the error message found in the body (see error_message_key), or "" if there is none*/
+func (e *APIError) Message() string {
+	return e.message
+}
+
+/*RequestID returns the id of the request (see request_id_header), or "" if it has none*/
+func (e *APIError) RequestID() string {
+	return e.requestID
+}
+
+/* The status code the API responded with, or 0 if err did not come from a response */
+func responseCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.statusCode
+	}
+	return 0
+}
+
+/*
+Find a readable error message in the body of an error response.
+
+	application/problem+json (RFC 7807) bodies give their title and
+	detail. Otherwise, the value at the client's error_message_key is
+	used. Returns "" if there is no message, so the whole body is shown
+*/
+func (client *APIClient) errorMessage(resp *http.Response, body string) string {
+	var data map[string]interface{}
+	if err := decodeJSON([]byte(body), &data); err != nil {
+		return ""
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" {
+		parts := []string{}
+		for _, key := range []string{"title", "detail"} {
+			if value, ok := data[key].(string); ok && value != "" {
+				parts = append(parts, value)
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, ": ")
+		}
+	}
+
+	if client.errorMessageKey == "" {
+		return ""
+	}
+	value, err := GetObjectAtKey(data, client.errorMessageKey, client.debug)
+	if err != nil || value == nil {
+		return ""
+	}
+	if message, ok := value.(string); ok {
+		return message
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
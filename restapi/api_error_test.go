@@ -0,0 +1,76 @@
+package restapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		key         string
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"", "application/problem+json", `{"type":"about:blank","title":"Conflict","detail":"name is taken","status":409}`, "unexpected response code '409': Conflict: name is taken"},
+		{"", "application/problem+json; charset=utf-8", `{"title":"Conflict"}`, "unexpected response code '409': Conflict"},
+		{"", "application/json", `{"title":"Conflict","detail":"name is taken"}`, `unexpected response code '409': {"title":"Conflict","detail":"name is taken"}`},
+		{"error/message", "application/json", `{"error":{"code":17,"message":"name is taken"}}`, "unexpected response code '409': name is taken"},
+		{"$.errors[0]", "application/json", `{"errors":[{"field":"name"}]}`, `unexpected response code '409': {"field":"name"}`},
+		{"error/message", "application/json", `{"message":"name is taken"}`, `unexpected response code '409': {"message":"name is taken"}`},
+		{"error/message", "text/plain", `name is taken`, "unexpected response code '409': name is taken"},
+	}
+
+	for _, test := range tests {
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", test.contentType)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(test.body))
+		}))
+
+		client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, errorMessageKey: test.key})
+		_, err := client.sendRequest("POST", "/api/objects", "{}")
+		svr.Close()
+
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("api_error_test.go: Expected error %q for %s but got %v", test.expected, test.body, err)
+		}
+		if responseCode(err) != http.StatusConflict {
+			t.Errorf("api_error_test.go: Expected the status code to be kept but got %d", responseCode(err))
+		}
+	}
+}
+
+func TestAPIErrorRequestID(t *testing.T) {
+	var sent []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("X-Request-Id"))
+		if r.URL.Path == "/echo" {
+			w.Header().Set("X-Request-Id", "server-1")
+		}
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("name is taken"))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, requestIDHeader: "X-Request-Id"})
+	_, err := client.sendRequest("POST", "/echo", "{}")
+	if err == nil || err.Error() != "unexpected response code '409' (request id server-1): name is taken" {
+		t.Errorf("api_error_test.go: Expected the error to show the id the API returned but got %v", err)
+	}
+
+	_, err = client.sendRequest("POST", "/api/objects", "{}")
+	if len(sent) != 2 || len(sent[1]) != 36 || sent[0] == sent[1] {
+		t.Fatalf("api_error_test.go: Expected a new request id for each request but got %v", sent)
+	}
+	if err == nil || !strings.Contains(err.Error(), "(request id "+sent[1]+")") {
+		t.Errorf("api_error_test.go: Expected the error to show the id that was sent but got %v", err)
+	}
+
+	_, _ = client.sendRequestWithHeaders("GET", "/api/objects", "", map[string]string{"X-Request-Id": "mine"})
+	if sent[2] != "mine" {
+		t.Errorf("api_error_test.go: Expected the request id from headers to be kept but got %s", sent[2])
+	}
+}
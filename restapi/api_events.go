@@ -0,0 +1,202 @@
+package restapi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+/*
+Wait for an operation by watching the Server-Sent Events stream at
+
+	EventsPath, rather than polling, until an event says it is done.
+	Only events of EventType (if set) with a JSON object as their data
+	are looked at, and with a CallbackKey only those for this operation.
+	Returns the event that said so, or the status if the operation was
+	already done once the stream was open (see statusOnceWatched)
+*/
+func (obj *APIObject) watchOperation(operation string, settings *AsyncSettings, started *http.Response, body string, callbackID string) (map[string]interface{}, error) {
+	eventsPath, err := obj.fillFromResponse(settings.EventsPath, body)
+	if err != nil {
+		return nil, fmt.Errorf("the %s of '%s' started, but %v", operation, obj.id, err)
+	}
+
+	timeout := time.Duration(settings.MaximumPollingDuration) * time.Second
+	ctx, cancel := context.WithTimeout(obj.context(), timeout)
+	defer cancel()
+
+	resp, err := obj.apiClient.openStream(ctx, eventsPath, obj.requestHeaders("read", map[string]string{"Accept": "text/event-stream"}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch the %s of '%s' at %s: %v", operation, obj.id, eventsPath, err)
+	}
+	defer resp.Body.Close()
+
+	status, err := obj.statusOnceWatched(operation, settings, started, callbackID)
+	if status != nil || err != nil {
+		return status, err
+	}
+	err = readEvents(resp.Body, func(eventType string, data string) (bool, error) {
+		if settings.EventType != "" && eventType != settings.EventType {
+			return false, nil
+		}
+		status, err = obj.handleEvent(operation, settings, callbackID, data)
+		return status != nil, err
+	})
+
+	if obj.context().Err() != nil {
+		return nil, obj.context().Err()
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %d seconds waiting for an event at %s saying the %s of '%s' finished", settings.MaximumPollingDuration, eventsPath, operation, obj.id)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("the event stream at %s ended before the %s of '%s' finished", eventsPath, operation, obj.id)
+	}
+	return status, err
+}
+
+/*
+Wait for an operation by watching the messages on the WebSocket at
+
+	WebsocketPath until one says it is done. Messages are looked at like
+	Server-Sent Events' data. Returns the message that said so, or the
+	status if the operation was already done once the socket was open
+*/
+func (obj *APIObject) watchWebSocket(operation string, settings *AsyncSettings, started *http.Response, body string, callbackID string) (map[string]interface{}, error) {
+	websocketPath, err := obj.fillFromResponse(settings.WebsocketPath, body)
+	if err != nil {
+		return nil, fmt.Errorf("the %s of '%s' started, but %v", operation, obj.id, err)
+	}
+
+	deadline := time.Now().Add(time.Duration(settings.MaximumPollingDuration) * time.Second)
+	conn, stop, err := obj.apiClient.openWebSocket(obj.context(), websocketPath, obj.requestHeaders("read", nil), deadline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch the %s of '%s' at %s: %v", operation, obj.id, websocketPath, err)
+	}
+	defer stop()
+
+	if status, err := obj.statusOnceWatched(operation, settings, started, callbackID); status != nil || err != nil {
+		return status, err
+	}
+
+	for {
+		var message string
+		if err := websocket.Message.Receive(conn, &message); err != nil {
+			if obj.context().Err() != nil {
+				return nil, obj.context().Err()
+			}
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("timed out after %d seconds waiting for a message at %s saying the %s of '%s' finished", settings.MaximumPollingDuration, websocketPath, operation, obj.id)
+			}
+			return nil, fmt.Errorf("the WebSocket at %s closed before the %s of '%s' finished: %v", websocketPath, operation, obj.id, err)
+		}
+		if status, err := obj.handleEvent(operation, settings, callbackID, message); status != nil || err != nil {
+			return status, err
+		}
+	}
+}
+
+/*
+The operation's status at the Location of started (the response that
+
+	started it), if it has one and the status says the operation is
+	done. Events and messages sent before the stream was open are lost,
+	so this is checked once it is, in case the operation finished first.
+	A status that cannot be read is left to the stream
+*/
+func (obj *APIObject) statusOnceWatched(operation string, settings *AsyncSettings, started *http.Response, callbackID string) (map[string]interface{}, error) {
+	if started == nil || started.Header.Get("Location") == "" || settings.SearchKey == "" || settings.CallbackKey != "" {
+		return nil, nil
+	}
+	statusPath, err := apiPath(started.Header.Get("Location"), obj.apiClient.currentURI())
+	if err != nil {
+		return nil, nil
+	}
+	statusBody, _, err := obj.exchange("GET", statusPath, "", obj.requestHeaders("read", nil))
+	if err != nil {
+		if obj.debug {
+			obj.apiClient.log(logAsync, "DEBUG", "Could not check the operation's status", map[string]interface{}{"operation": operation, "id": obj.id, "status_path": statusPath, "error": err.Error()})
+		}
+		return nil, nil
+	}
+	return obj.handleEvent(operation, settings, callbackID, statusBody)
+}
+
+/*
+The event or message (a JSON object, otherwise it is ignored) if it
+
+	says the operation is done, or nil. With a CallbackKey, only those
+	for the operation with callbackID count
+*/
+func (obj *APIObject) handleEvent(operation string, settings *AsyncSettings, callbackID string, data string) (map[string]interface{}, error) {
+	var status map[string]interface{}
+	if decodeJSON([]byte(data), &status) != nil {
+		return nil, nil
+	}
+	if settings.CallbackKey != "" {
+		if value, err := GetStringAtKey(status, settings.CallbackKey, false); err != nil || value != callbackID {
+			return nil, nil
+		}
+	}
+	done, value, err := obj.operationStatus(operation, settings, status, data)
+	if obj.debug && !done && err == nil {
+		obj.apiClient.log(logAsync, "DEBUG", "Operation is still running", map[string]interface{}{"operation": operation, "id": obj.id, "status_key": settings.SearchKey, "status": value})
+	}
+	if !done {
+		return nil, err
+	}
+	return status, nil
+}
+
+/*
+Read Server-Sent Events from r, calling handle with the type (message
+
+	if not set) and data of each until it returns true or an error.
+	Returns io.EOF if the stream ends first
+*/
+func readEvents(r io.Reader, handle func(eventType string, data string) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	eventType := ""
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			/* A blank line dispatches the event */
+			if len(data) > 0 {
+				if eventType == "" {
+					eventType = "message"
+				}
+				if done, err := handle(eventType, strings.Join(data, "\n")); done || err != nil {
+					return err
+				}
+			}
+			eventType, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
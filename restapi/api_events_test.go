@@ -0,0 +1,129 @@
+package restapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\n\nevent: progress\ndata: {\"pct\":50}\n\ndata: line one\ndata: line two\n\nevent: done\ndata: {}\n\n"
+	var seen []string
+	err := readEvents(strings.NewReader(stream), func(eventType string, data string) (bool, error) {
+		seen = append(seen, eventType+"="+data)
+		return eventType == "done", nil
+	})
+	if err != nil {
+		t.Fatalf("api_events_test.go: %s", err)
+	}
+	expected := []string{`progress={"pct":50}`, "message=line one\nline two", "done={}"}
+	if fmt.Sprint(seen) != fmt.Sprint(expected) {
+		t.Fatalf("api_events_test.go: Expected events %q but got %q", expected, seen)
+	}
+
+	if err := readEvents(strings.NewReader("data: x\n\n"), func(string, string) (bool, error) { return false, nil }); err != io.EOF {
+		t.Fatalf("api_events_test.go: Expected io.EOF when the stream ends but got %v", err)
+	}
+}
+
+func TestAPIObjectAsyncEvents(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE":
+			w.Header().Set("Location", "/operations/op-2")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"operation_id":"op-2"}`))
+		case r.URL.Path == "/operations/op-2":
+			w.Write([]byte(`{"operation_id":"op-2","state":"done"}`))
+		case r.URL.Path == "/events":
+			if r.Header.Get("Accept") != "text/event-stream" {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("event: operation\ndata: {\"operation_id\":\"op-1\",\"state\":\"done\"}\n\n"))
+			w.Write([]byte("event: heartbeat\ndata: {\"operation_id\":\"op-2\",\"state\":\"done\"}\n\n"))
+			w.Write([]byte("event: operation\ndata: {\"operation_id\":\"op-2\",\"state\":\"running\"}\n\n"))
+			w.(http.Flusher).Flush()
+			if r.URL.Query().Get("hang") != "" {
+				<-r.Context().Done()
+				return
+			}
+			w.Write([]byte("event: operation\ndata: {\"operation_id\":\"op-2\",\"state\":\"done\"}\n\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	settings := &AsyncSettings{EventsPath: "/events", EventType: "operation", CallbackKey: "operation_id", SearchKey: "state", SearchValue: "done", MaximumPollingDuration: 10}
+
+	/* Events for other operations, or of other types, are ignored */
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_events_test.go: %s", err)
+	}
+
+	/* An operation done before the stream was open is found at its Location */
+	done := AsyncSettings{EventsPath: "/events?hang=1", SearchKey: "state", SearchValue: "done", MaximumPollingDuration: 10}
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": &done}})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_events_test.go: Expected the status at the Location to say the operation was done but got %v", err)
+	}
+
+	/* The stream is watched until the timeout, not the client's */
+	hanging := *settings
+	hanging.EventsPath, hanging.MaximumPollingDuration = "/events?hang=1", 3
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": &hanging}})
+	start := time.Now()
+	if err := object.deleteObject(); err == nil || !strings.Contains(err.Error(), "timed out after 3 seconds") || time.Since(start) < 3*time.Second {
+		t.Fatalf("api_events_test.go: Expected the watch to time out after 3 seconds but got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestAPIObjectAsyncWebSocket(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/objects/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"task":"t-1"}`))
+	})
+	mux.Handle("/tasks/t-1/progress", websocket.Handler(func(conn *websocket.Conn) {
+		if conn.Request().Header.Get("X-Token") != "secret" {
+			return
+		}
+		websocket.Message.Send(conn, "hello")
+		websocket.Message.Send(conn, `{"progress":50,"phase":"deleting"}`)
+		websocket.Message.Send(conn, `{"progress":100,"phase":"gone"}`)
+		conn.Read(make([]byte, 1))
+	}))
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, headers: map[string]string{"X-Token": "secret"}})
+	settings := &AsyncSettings{WebsocketPath: "/tasks/{task}/progress", SearchKey: "phase", SearchValue: "gone", MaximumPollingDuration: 10}
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": settings}})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_events_test.go: %s", err)
+	}
+
+	/* Without the token, the socket closes before the operation is done */
+	client.headers = nil
+	if err := object.deleteObject(); err == nil || !strings.Contains(err.Error(), "closed before the destroy") {
+		t.Fatalf("api_events_test.go: Expected the closed WebSocket to fail the destroy but got %v", err)
+	}
+
+	/* The provider's credentials are not sent to other hosts */
+	elsewhere := *settings
+	elsewhere.WebsocketPath = "ws://elsewhere.example/tasks/{task}/progress"
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", asyncSettings: map[string]*AsyncSettings{"destroy": &elsewhere}})
+	if err := object.deleteObject(); err == nil || !strings.Contains(err.Error(), "is not on the host of uri") {
+		t.Fatalf("api_events_test.go: Expected a WebSocket on another host to be refused but got %v", err)
+	}
+}
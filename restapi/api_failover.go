@@ -0,0 +1,161 @@
+package restapi
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+/*
+endpointPool holds the primary uri followed by any failover_uris.
+
+	All requests go to the active endpoint. When it cannot be reached,
+	the client moves on to the next endpoint that passes the health check
+	and stays there until that one fails in turn.
+*/
+type endpointPool struct {
+	mutex           sync.Mutex
+	uris            []string
+	active          int
+	selected        bool
+	healthCheckPath string
+}
+
+/* The base URI requests should currently be sent to */
+func (client *APIClient) activeURI() string {
+	pool := client.endpoints
+	pool.mutex.Lock()
+	if pool.selected {
+		defer pool.mutex.Unlock()
+		return pool.uris[pool.active]
+	}
+	pool.mutex.Unlock()
+
+	/* The first request picks the first healthy endpoint, starting with the primary.
+	   Health checks run without the lock, so they hold up no other requests */
+	next, ok := client.nextHealthyEndpoint(len(pool.uris) - 1)
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if !pool.selected {
+		pool.selected = true
+		if ok {
+			pool.active = next
+		}
+	}
+	return pool.uris[pool.active]
+}
+
+/* The base URI requests are currently sent to, without checking any endpoint's health */
+func (client *APIClient) currentURI() string {
+	pool := client.endpoints
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.uris[pool.active]
+}
+
+/*
+Mark failed as unreachable and switch to the next healthy endpoint.
+
+	Returns false if there is no other endpoint to switch to.
+*/
+func (client *APIClient) failover(failed string) bool {
+	pool := client.endpoints
+	if len(pool.uris) < 2 {
+		return false
+	}
+
+	pool.mutex.Lock()
+	active := pool.active
+	pool.mutex.Unlock()
+	/* Another request already failed over */
+	if pool.uris[active] != failed {
+		return true
+	}
+
+	next, ok := client.nextHealthyEndpoint(active)
+	if !ok {
+		/* Nothing passed the health check - try the next one anyway */
+		next = (active + 1) % len(pool.uris)
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.active != active {
+		return true
+	}
+	client.log(logTransport, "WARN", "Endpoint is unreachable. Failing over", map[string]interface{}{"failed": failed, "next": pool.uris[next]})
+	pool.active = next
+	return true
+}
+
+/*
+Whether a request that failed with err can be sent to another endpoint.
+
+	That is only safe if the request never reached the endpoint (its
+	name could not be resolved or it could not be connected to), or if
+	sending it twice does no harm. Otherwise a timeout or a connection
+	reset after a POST was sent could create the object on both
+*/
+func canFailOver(method string, err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return true
+	}
+	return contains(idempotentMethods, method)
+}
+
+/* Methods that can be sent more than once with the same effect, per RFC 9110 */
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+
+/* Find the first endpoint after index (wrapping around) that passes the health check */
+func (client *APIClient) nextHealthyEndpoint(index int) (int, bool) {
+	pool := client.endpoints
+	if pool.healthCheckPath == "" {
+		return (index + 1) % len(pool.uris), true
+	}
+	for i := 1; i <= len(pool.uris); i++ {
+		next := (index + i) % len(pool.uris)
+		if client.healthy(pool.uris[next]) {
+			return next, true
+		}
+	}
+	return 0, false
+}
+
+/* Whether a GET of health_check_path on uri returns a 2xx response */
+func (client *APIClient) healthy(uri string) bool {
+	req, err := http.NewRequest("GET", uri+client.endpoints.healthCheckPath, nil)
+	if err != nil {
+		return false
+	}
+	for n, v := range client.headers {
+		req.Header.Set(n, v)
+	}
+	if client.hostHeader != "" {
+		req.Host = client.hostHeader
+	}
+	if client.authenticator != nil {
+		if err := client.authenticator.Apply(req); err != nil {
+			client.log(logTransport, "WARN", "Health check failed", map[string]interface{}{"uri": uri, "error": err.Error()})
+			return false
+		}
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		client.log(logTransport, "WARN", "Health check failed", map[string]interface{}{"uri": uri, "error": err.Error()})
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		client.log(logTransport, "WARN", "Health check failed", map[string]interface{}{"uri": uri, "status_code": resp.StatusCode})
+		return false
+	}
+	return true
+}
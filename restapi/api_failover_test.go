@@ -0,0 +1,97 @@
+package restapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIClientFailover(t *testing.T) {
+	/* A closed server stands in for a primary that is down */
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("standby"))
+	}))
+	defer standby.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: down.URL, failoverURIs: []string{standby.URL + "/"}, timeout: 2})
+	if err != nil {
+		t.Fatalf("api_failover_test.go: %s", err)
+	}
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("api_failover_test.go: Expected the request to fail over to the standby: %s", err)
+	}
+	if res != "standby" {
+		t.Fatalf("api_failover_test.go: Expected 'standby' but got '%s'", res)
+	}
+	if uri := client.activeURI(); uri != standby.URL {
+		t.Fatalf("api_failover_test.go: Expected the standby to stay active but got '%s'", uri)
+	}
+
+	/* Without failover_uris the error is returned */
+	client, _ = NewAPIClient(&apiClientOpt{uri: down.URL, timeout: 2})
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("api_failover_test.go: Expected an error when the only endpoint is down")
+	}
+}
+
+func TestAPIClientHealthCheck(t *testing.T) {
+	/* The passive member of an HA pair answers, but reports itself unhealthy */
+	passive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			http.Error(w, "passive", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("passive"))
+	}))
+	defer passive.Close()
+
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("active"))
+	}))
+	defer active.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: passive.URL, failoverURIs: []string{active.URL}, healthCheckPath: "/health", timeout: 2})
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("api_failover_test.go: %s", err)
+	}
+	if res != "active" {
+		t.Fatalf("api_failover_test.go: Expected the healthy endpoint to be used but got '%s'", res)
+	}
+}
+
+func TestAPIClientFailoverNotIdempotent(t *testing.T) {
+	/* The primary takes the request and then drops the connection, so it may have made the change */
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer primary.Close()
+
+	var standbyRequests []string
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standbyRequests = append(standbyRequests, r.Method)
+		w.Write([]byte("standby"))
+	}))
+	defer standby.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: primary.URL, failoverURIs: []string{standby.URL}, timeout: 2, retryNetworkErrors: []string{"none"}})
+	if _, err := client.sendRequest("POST", "/api/objects", `{"id":"1"}`); err == nil || len(standbyRequests) != 0 {
+		t.Fatalf("api_failover_test.go: Expected the POST not to be sent again to the standby but got %v (%v)", standbyRequests, err)
+	}
+	if res, err := client.sendRequest("PUT", "/api/objects/1", `{"id":"1"}`); err != nil || res != "standby" {
+		t.Fatalf("api_failover_test.go: Expected the PUT to fail over to the standby but got '%s' (%v)", res, err)
+	}
+
+	/* A POST to an endpoint that cannot be connected to was never sent, so it fails over */
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	client, _ = NewAPIClient(&apiClientOpt{uri: down.URL, failoverURIs: []string{standby.URL}, timeout: 2})
+	if res, err := client.sendRequest("POST", "/api/objects", `{"id":"1"}`); err != nil || res != "standby" {
+		t.Fatalf("api_failover_test.go: Expected the POST to fail over from an endpoint that is down but got '%s' (%v)", res, err)
+	}
+}
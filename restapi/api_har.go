@@ -0,0 +1,205 @@
+package restapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+A HAR (HTTP Archive 1.2) recording of the requests a client made, for
+
+	har_file. Providers are not told when the run ends, so the file is
+	kept a complete HAR after every request: each entry is written over
+	the closing brackets, which are then written again after it
+*/
+type harRecorder struct {
+	mutex sync.Mutex
+	file  string
+	/* The length of the file so far, or 0 before the first entry */
+	size int64
+}
+
+/* What a HAR starts and ends with, around its entries */
+const (
+	harHeader = `{"log":{"version":"1.2","creator":{"name":"terraform-provider-restapi","version":"1.0"},"entries":[`
+	harFooter = "]}}\n"
+)
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+}
+
+/* A recorder for har_file, or nil if it is not set */
+func newHARRecorder(file string) *harRecorder {
+	if file == "" {
+		return nil
+	}
+	return &harRecorder{file: file}
+}
+
+/*
+Record a request and its response (nil if there was none) in har_file,
+
+	with sensitive headers and keys redacted as in the debug log
+*/
+func (client *APIClient) recordHAR(req *http.Request, data string, resp *http.Response, body string, requestErr error, start time.Time) {
+	if client.har == nil {
+		return
+	}
+	elapsed := time.Since(start).Milliseconds()
+
+	httpVersion := req.Proto
+	if resp != nil {
+		httpVersion = resp.Proto
+	}
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time:            elapsed,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         client.redactURL(req.URL),
+			HTTPVersion: httpVersion,
+			Cookies:     []harNameValue{},
+			Headers:     client.harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(data),
+		},
+		Response: harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: harTimings{Send: 0, Wait: elapsed, Receive: 0},
+	}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			if client.isSensitiveQueryParam(name) {
+				value = sensitiveMask
+			}
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: value})
+		}
+	}
+	if data != "" {
+		entry.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: client.sensitiveKeys.mask(data)}
+	}
+
+	if resp != nil {
+		entry.Response.Status = resp.StatusCode
+		entry.Response.StatusText = http.StatusText(resp.StatusCode)
+		entry.Response.HTTPVersion = resp.Proto
+		entry.Response.Headers = client.harHeaders(resp.Header)
+		entry.Response.RedirectURL = resp.Header.Get("Location")
+		entry.Response.BodySize = len(body)
+		entry.Response.Content = harContent{Size: len(body), MimeType: resp.Header.Get("Content-Type"), Text: client.sensitiveKeys.mask(body)}
+	}
+	if requestErr != nil {
+		entry.Comment = requestErr.Error()
+	}
+
+	client.har.add(entry)
+}
+
+/* Headers for a HAR entry, with the values of sensitive headers redacted */
+func (client *APIClient) harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			if client.isSensitiveHeader(name) {
+				value = sensitiveMask
+			}
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+/* Add an entry to har_file, starting it with the first */
+func (h *harRecorder) add(entry harEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	b, err := json.Marshal(entry)
+	if err == nil {
+		err = h.write(b)
+	}
+	if err != nil {
+		log.Print(formatLog(logTransport, "WARN", "Failed to write har_file", map[string]interface{}{"file": h.file, "error": err.Error()}))
+	}
+}
+
+func (h *harRecorder) write(entry []byte) error {
+	flags, offset, prefix := os.O_WRONLY, h.size-int64(len(harFooter)), ","
+	if h.size == 0 {
+		flags, offset, prefix = os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0, harHeader
+	}
+	f, err := os.OpenFile(h.file, flags, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := append(append([]byte(prefix), entry...), harFooter...)
+	if _, err := f.WriteAt(b, offset); err != nil {
+		return err
+	}
+	h.size = offset + int64(len(b))
+	return nil
+}
@@ -0,0 +1,89 @@
+package restapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHARRecording(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc123")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"1","password":"hunter2"}`))
+	}))
+	defer svr.Close()
+
+	file := filepath.Join(t.TempDir(), "run.har")
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:           svr.URL,
+		timeout:       2,
+		headers:       map[string]string{"Authorization": "Bearer secret-token", "X-Trace": "abc"},
+		sensitiveKeys: []string{"password"},
+		harFile:       file,
+	})
+	if err != nil {
+		t.Fatalf("api_har_test.go: %s", err)
+	}
+
+	if _, err := client.sendRequest("POST", "/api/objects?dry_run=false", `{"id":"1","password":"hunter2"}`); err != nil {
+		t.Fatalf("api_har_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("GET", "/api/objects/1?api_key=key-secret&password=hunter2", ""); err != nil {
+		t.Fatalf("api_har_test.go: %s", err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("api_har_test.go: Expected har_file to be written: %s", err)
+	}
+	for _, secret := range []string{"secret-token", "hunter2", "abc123", "key-secret"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("api_har_test.go: Expected '%s' to be redacted from har_file: %s", secret, b)
+		}
+	}
+
+	var har struct {
+		Log struct {
+			Version string     `json:"version"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(b, &har); err != nil {
+		t.Fatalf("api_har_test.go: Expected har_file to be JSON: %s", err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("api_har_test.go: Expected a HAR 1.2 log with two entries but got %s", b)
+	}
+	entry := har.Log.Entries[0]
+	if entry.Request.HTTPVersion != "HTTP/1.1" || entry.Response.HTTPVersion != "HTTP/1.1" {
+		t.Errorf("api_har_test.go: Expected the negotiated protocol to be recorded but got %s and %s", entry.Request.HTTPVersion, entry.Response.HTTPVersion)
+	}
+	if url := har.Log.Entries[1].Request.URL; !strings.HasSuffix(url, "?api_key=%28sensitive+value%29&password=%28sensitive+value%29") {
+		t.Errorf("api_har_test.go: Expected sensitive query parameters to be redacted in the URL but got %s", url)
+	}
+	if entry.Request.Method != "POST" || !strings.HasSuffix(entry.Request.URL, "/api/objects?dry_run=false") {
+		t.Errorf("api_har_test.go: Expected the POST to be recorded but got %s %s", entry.Request.Method, entry.Request.URL)
+	}
+	if len(entry.Request.QueryString) != 1 || entry.Request.QueryString[0].Value != "false" {
+		t.Errorf("api_har_test.go: Expected the query string to be recorded but got %v", entry.Request.QueryString)
+	}
+	if entry.Request.PostData == nil || !strings.Contains(entry.Request.PostData.Text, `"id":"1"`) {
+		t.Errorf("api_har_test.go: Expected the request body to be recorded but got %v", entry.Request.PostData)
+	}
+	if entry.Response.Status != 201 || !strings.Contains(entry.Response.Content.Text, `"id":"1"`) {
+		t.Errorf("api_har_test.go: Expected the 201 response to be recorded but got %d %s", entry.Response.Status, entry.Response.Content.Text)
+	}
+	traced := false
+	for _, header := range entry.Request.Headers {
+		traced = traced || (header.Name == "X-Trace" && header.Value == "abc")
+	}
+	if !traced {
+		t.Errorf("api_har_test.go: Expected headers that are not sensitive to be kept but got %v", entry.Request.Headers)
+	}
+}
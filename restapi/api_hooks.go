@@ -0,0 +1,48 @@
+package restapi
+
+import (
+	"fmt"
+)
+
+/* Valid values for the when attribute of hooks */
+var hookTimes = []string{"pre_create", "post_create", "pre_update", "post_update", "pre_destroy", "post_destroy"}
+
+/*
+apiHook is an extra request sent around one of the object's
+
+	operations, such as triggering a deployment after each update
+*/
+type apiHook struct {
+	when         string
+	method       string
+	path         string
+	data         string
+	ignoreErrors bool
+}
+
+/* Send every hook configured to run at when, in order */
+func (obj *APIObject) runHooks(when string) error {
+	for _, hook := range obj.hooks {
+		if hook.when != when {
+			continue
+		}
+
+		method := hook.method
+		if method == "" {
+			method = "POST"
+		}
+		path := obj.expandPath(hook.path)
+		data := obj.expandPlaceholders(hook.data)
+
+		obj.log("INFO", "Running hook", map[string]interface{}{"when": when, "method": method, "path": path})
+		_, _, err := obj.exchange(method, path, data, obj.requestHeaders("", nil))
+		if err != nil {
+			if hook.ignoreErrors {
+				obj.log("WARN", "Ignoring failed hook", map[string]interface{}{"when": when, "method": method, "path": path, "error": err.Error()})
+				continue
+			}
+			return fmt.Errorf("%s hook %s %s failed: %v", when, method, path, err)
+		}
+	}
+	return nil
+}
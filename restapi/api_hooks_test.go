@@ -0,0 +1,110 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIObjectHooks(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests = append(requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, b))
+		if r.URL.Path == "/api/drain/1" {
+			http.Error(w, "not draining", http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"id":"1","name":"web","revision":"7"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, err := NewAPIObject(client, &apiObjectOpts{
+		path: "/api/objects",
+		data: `{"id":"1","name":"web"}`,
+		hooks: []apiHook{
+			{when: "post_update", method: "POST", path: "/api/deployments", data: `{"service":"{data.name}","revision":"{api_data.revision}"}`},
+			{when: "pre_destroy", method: "POST", path: "/api/drain/{id}", ignoreErrors: true},
+			{when: "post_destroy", method: "DELETE", path: "/api/dns/{data.name}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("api_hooks_test.go: %s", err)
+	}
+
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_hooks_test.go: %s", err)
+	}
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_hooks_test.go: %s", err)
+	}
+
+	expected := []string{
+		`PUT /api/objects/1 {"id":"1","name":"web"}`,
+		`POST /api/deployments {"service":"web","revision":"7"}`,
+		`POST /api/drain/1 `,
+		`DELETE /api/objects/1 `,
+		`DELETE /api/dns/web `,
+	}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		t.Fatalf("api_hooks_test.go: Expected requests %v but got %v", expected, requests)
+	}
+
+	/* Failing hooks fail the operation */
+	object.hooks = []apiHook{{when: "pre_update", method: "POST", path: "/api/drain/{id}"}}
+	if err := object.updateObject(); err == nil {
+		t.Fatalf("api_hooks_test.go: Expected a failed hook to fail the update")
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	object := &APIObject{
+		id:      "42",
+		data:    map[string]interface{}{"name": "web", "meta": map[string]interface{}{"team": "ops"}},
+		apiData: map[string]interface{}{"revision": float64(7)},
+	}
+
+	expanded := object.expandPlaceholders("/teams/{data.meta/team}/services/{data.name}/{id}?rev={api_data.revision}&x={data.missing}")
+	if expanded != "/teams/ops/services/web/42?rev=7&x={data.missing}" {
+		t.Fatalf("api_hooks_test.go: Unexpected expansion '%s'", expanded)
+	}
+
+	/* In paths, values stay one segment */
+	object.data["name"] = "web 1/a"
+	expanded = object.expandPath("/services/{data.name}/{id}")
+	if expanded != "/services/web%201%2Fa/42" {
+		t.Fatalf("api_hooks_test.go: Unexpected path expansion '%s'", expanded)
+	}
+
+	/* Bare keys are id_template fields, then keys of data and the API response */
+	object.id, object.idTemplate = "o1:web", "{org}:{name}"
+	expanded = object.expandPath("/orgs/{org}/services/{meta/team}/{revision}/{missing}")
+	if expanded != "/orgs/o1/services/ops/7/{missing}" {
+		t.Fatalf("api_hooks_test.go: Unexpected expansion of bare keys '%s'", expanded)
+	}
+}
+
+func TestExpandDataPlaceholders(t *testing.T) {
+	object := &APIObject{
+		id:           "42",
+		data:         map[string]interface{}{"name": "web"},
+		priorData:    map[string]interface{}{"name": "old-web"},
+		priorAPIData: map[string]interface{}{"revision": json.Number("7"), "labels": map[string]interface{}{"env": "prod"}},
+	}
+
+	expanded, _ := json.Marshal(object.expandDataPlaceholders(map[string]interface{}{
+		"revision": "{api_data.revision}",
+		"labels":   "{api_data.labels}",
+		"names":    []interface{}{"{prior_data.name}", "{data.name}-{id}"},
+		"missing":  "{api_data.missing}",
+		"force":    true,
+	}))
+	expected := `{"force":true,"labels":{"env":"prod"},"missing":"{api_data.missing}","names":["old-web","web-42"],"revision":7}`
+	if string(expanded) != expected {
+		t.Fatalf("api_hooks_test.go: Expected %s but got %s", expected, expanded)
+	}
+}
@@ -0,0 +1,145 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Upper bounds of the request duration histogram, in milliseconds */
+var durationBuckets = []int64{100, 250, 500, 1000, 2500, 5000, 10000}
+
+/*
+Totals of the requests a client made, written to metrics_file after
+
+	every request and sent to metrics_statsd_address as they happen.
+	The totals are this client's alone: each provider configuration has
+	its own client, and Terraform runs the provider in a new process
+	for each command, so the file only covers the last of them
+*/
+type requestMetrics struct {
+	mutex  sync.Mutex
+	file   string
+	statsd net.Conn
+
+	Requests          map[string]int   `json:"requests"`
+	Retries           int              `json:"retries"`
+	RateLimitWaitMs   int64            `json:"rate_limit_wait_ms"`
+	APITimeMs         int64            `json:"api_time_ms"`
+	DurationHistogram []durationBucket `json:"duration_histogram"`
+	Started           time.Time        `json:"started"`
+	Updated           time.Time        `json:"updated"`
+}
+
+type durationBucket struct {
+	LessOrEqual string `json:"le"`
+	Count       int    `json:"count"`
+}
+
+/* Metrics for metrics_file and metrics_statsd_address, or nil if neither is set */
+func newRequestMetrics(file string, statsdAddress string) (*requestMetrics, error) {
+	if file == "" && statsdAddress == "" {
+		return nil, nil
+	}
+
+	metrics := &requestMetrics{
+		file:     file,
+		Requests: make(map[string]int),
+		Started:  time.Now(),
+	}
+	for _, bucket := range durationBuckets {
+		metrics.DurationHistogram = append(metrics.DurationHistogram, durationBucket{LessOrEqual: fmt.Sprintf("%dms", bucket)})
+	}
+	metrics.DurationHistogram = append(metrics.DurationHistogram, durationBucket{LessOrEqual: "+Inf"})
+
+	if statsdAddress != "" {
+		conn, err := net.Dial("udp", statsdAddress)
+		if err != nil {
+			return nil, fmt.Errorf("metrics_statsd_address '%s' is invalid: %v", statsdAddress, err)
+		}
+		metrics.statsd = conn
+	}
+	return metrics, nil
+}
+
+/* Count a request, by method and status code ("error" if there was no response) */
+func (m *requestMetrics) request(method string, resp *http.Response, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	status := "error"
+	if resp != nil {
+		status = fmt.Sprintf("%d", resp.StatusCode)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Requests[method+" "+status]++
+	m.APITimeMs += duration.Milliseconds()
+	bucket := len(durationBuckets)
+	for i, le := range durationBuckets {
+		if duration.Milliseconds() <= le {
+			bucket = i
+			break
+		}
+	}
+	m.DurationHistogram[bucket].Count++
+
+	m.send(fmt.Sprintf("restapi.requests.%s.%s:1|c", strings.ToLower(method), status),
+		fmt.Sprintf("restapi.request_time:%d|ms", duration.Milliseconds()))
+	m.write()
+}
+
+/* Count a retried request */
+func (m *requestMetrics) retry() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Retries++
+	m.send("restapi.retries:1|c")
+	m.write()
+}
+
+/* Count time spent waiting for rate_limit, rate_limits or throttling */
+func (m *requestMetrics) waited(wait time.Duration) {
+	if m == nil || wait < time.Millisecond {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.RateLimitWaitMs += wait.Milliseconds()
+	m.send(fmt.Sprintf("restapi.rate_limit_wait:%d|ms", wait.Milliseconds()))
+	m.write()
+}
+
+/* Send statsd lines. Metrics are best effort, so failures are only logged */
+func (m *requestMetrics) send(lines ...string) {
+	if m.statsd == nil {
+		return
+	}
+	if _, err := m.statsd.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		log.Print(formatLog(logTransport, "WARN", "Failed to send metrics", map[string]interface{}{"error": err.Error()}))
+	}
+}
+
+/* Replace metrics_file with the totals so far. Must hold the mutex */
+func (m *requestMetrics) write() {
+	if m.file == "" {
+		return
+	}
+	m.Updated = time.Now()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(m.file, b, 0o644)
+	}
+	if err != nil {
+		log.Print(formatLog(logTransport, "WARN", "Failed to write metrics_file", map[string]interface{}{"file": m.file, "error": err.Error()}))
+	}
+}
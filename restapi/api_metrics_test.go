@@ -0,0 +1,87 @@
+package restapi
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	var calls int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer svr.Close()
+
+	statsd, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("api_metrics_test.go: %s", err)
+	}
+	defer statsd.Close()
+
+	file := filepath.Join(t.TempDir(), "metrics.json")
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:                  svr.URL,
+		timeout:              2,
+		maxRetries:           1,
+		retryStatusCodes:     []int{503},
+		metricsFile:          file,
+		metricsStatsdAddress: statsd.LocalAddr().String(),
+	})
+	if err != nil {
+		t.Fatalf("api_metrics_test.go: %s", err)
+	}
+
+	if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+		t.Fatalf("api_metrics_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("PUT", "/api/objects/1", `{"id":"1"}`); err != nil {
+		t.Fatalf("api_metrics_test.go: %s", err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("api_metrics_test.go: Expected metrics_file to be written: %s", err)
+	}
+	var summary struct {
+		Requests          map[string]int `json:"requests"`
+		Retries           int            `json:"retries"`
+		DurationHistogram []struct {
+			Count int `json:"count"`
+		} `json:"duration_histogram"`
+	}
+	if err := json.Unmarshal(b, &summary); err != nil {
+		t.Fatalf("api_metrics_test.go: %s", err)
+	}
+	if summary.Requests["GET 503"] != 1 || summary.Requests["GET 200"] != 1 || summary.Requests["PUT 200"] != 1 || summary.Retries != 1 {
+		t.Fatalf("api_metrics_test.go: Unexpected metrics summary: %s", b)
+	}
+	total := 0
+	for _, bucket := range summary.DurationHistogram {
+		total += bucket.Count
+	}
+	if total != 3 {
+		t.Fatalf("api_metrics_test.go: Expected 3 requests in the duration histogram but got %d", total)
+	}
+
+	statsd.SetReadDeadline(time.Now().Add(2 * time.Second))
+	packet := make([]byte, 1024)
+	n, _, err := statsd.ReadFrom(packet)
+	if err != nil {
+		t.Fatalf("api_metrics_test.go: Expected metrics to be sent to statsd: %s", err)
+	}
+	if !strings.HasPrefix(string(packet[:n]), "restapi.requests.get.503:1|c\nrestapi.request_time:") {
+		t.Fatalf("api_metrics_test.go: Unexpected statsd metrics: %s", packet[:n])
+	}
+}
@@ -0,0 +1,211 @@
+package restapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+/*
+A layer of request handling around next, such as rate limiting. Each
+
+	one can be tested on its own, around a stub in place of next
+*/
+type middleware func(next http.RoundTripper) http.RoundTripper
+
+/*roundTripperFunc lets a function be used as an http.RoundTripper*/
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+/* Wrap core in middlewares, the first of which sees each request first */
+func chain(core http.RoundTripper, middlewares ...middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		core = middlewares[i](core)
+	}
+	return core
+}
+
+/*
+What a request passes through once built, outermost first. The core is
+
+	the HTTP client, so its timeout only starts once the request stops
+	waiting. Retries and failover sit above this in sendRequestContext,
+	since they run the whole chain again (on another endpoint for
+	failover) and decide based on the read response
+*/
+func (client *APIClient) requestChain() http.RoundTripper {
+	return chain(roundTripperFunc(client.httpClient.Do),
+		client.throttleMiddleware,
+		client.requestSlotMiddleware,
+		client.recordMiddleware,
+		client.debugMiddleware,
+	)
+}
+
+/* The key a request's path (relative to the API's URI) is kept under in its context */
+type requestPathKey struct{}
+
+/* The path a request was made for, which rate_limits buckets are matched against */
+func requestPath(req *http.Request) string {
+	if path, ok := req.Context().Value(requestPathKey{}).(string); ok {
+		return path
+	}
+	return req.URL.RequestURI()
+}
+
+/* Wait while the API is throttling requests, and then for rate_limit or a rate_limits bucket */
+func (client *APIClient) throttleMiddleware(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		ctx := req.Context()
+		waitStart := time.Now()
+		if err := client.waitForThrottle(ctx); err != nil {
+			return nil, err
+		}
+
+		if rateLimiter := client.rateLimiterFor(req.Method, requestPath(req)); rateLimiter != nil {
+			if client.debug {
+				client.debugLog("Waiting for rate limit availability", map[string]interface{}{"method": req.Method, "path": requestPath(req)})
+			}
+			/* Wait fails early when ctx's deadline would pass first, which is only fatal once ctx is done */
+			if err := rateLimiter.Wait(ctx); err != nil && ctx.Err() != nil {
+				return nil, err
+			}
+		}
+		client.metrics.waited(time.Since(waitStart))
+		return next.RoundTrip(req)
+	})
+}
+
+/* Hold one of max_parallel_requests slots until the response body is closed, so the limit covers the whole exchange */
+func (client *APIClient) requestSlotMiddleware(next http.RoundTripper) http.RoundTripper {
+	if client.requestSlots == nil {
+		return next
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		select {
+		case client.requestSlots <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		var once sync.Once
+		release := func() { once.Do(func() { <-client.requestSlots }) }
+
+		resp, err := next.RoundTrip(req)
+		if err != nil || resp == nil {
+			release()
+			return resp, err
+		}
+		resp.Body = &closeNotifyingBody{ReadCloser: resp.Body, onClose: func([]byte) { release() }}
+		return resp, err
+	})
+}
+
+/* Count the request in the metrics and add it to har_file once its response has been read */
+func (client *APIClient) recordMiddleware(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		data := requestBody(req)
+		resp, err := next.RoundTrip(req)
+		if err != nil || resp == nil {
+			client.metrics.request(req.Method, nil, time.Since(start))
+			client.recordHAR(req, data, nil, "", err, start)
+			return resp, err
+		}
+
+		/* Only what the caller reads is recorded, so max_response_size still bounds it */
+		var body *bytes.Buffer
+		if client.har != nil {
+			body = &bytes.Buffer{}
+		}
+		resp.Body = &closeNotifyingBody{ReadCloser: resp.Body, copy: body, onClose: func(b []byte) {
+			client.metrics.request(req.Method, resp, time.Since(start))
+			client.recordHAR(req, data, resp, string(b), nil, start)
+		}}
+		return resp, nil
+	})
+}
+
+/* Dump the request and response headers to the debug log. Bodies are logged apart from these, with sensitive keys masked */
+func (client *APIClient) debugMiddleware(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !client.debug {
+			return next.RoundTrip(req)
+		}
+		dump, err := httputil.DumpRequestOut(req, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump the %s request to %s for the debug log: %w", req.Method, req.URL, err)
+		}
+		client.debugLog("Request", map[string]interface{}{"request": client.redactHeaders(string(dump))})
+
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			return resp, err
+		}
+		dump, err = httputil.DumpResponse(resp, false)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to dump the response to %s %s for the debug log: %w", req.Method, req.URL, err)
+		}
+		client.debugLog("Response", map[string]interface{}{"response": client.redactHeaders(string(dump))})
+		return resp, nil
+	})
+}
+
+/* The body req was built with, which newRequest keeps available through GetBody */
+func requestBody(req *http.Request) string {
+	if req.GetBody == nil {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	b, _ := io.ReadAll(body)
+	return string(b)
+}
+
+/*
+A response body that calls onClose (once) with what was read from it
+
+	when closed. What was read is only kept if copy is set
+*/
+type closeNotifyingBody struct {
+	io.ReadCloser
+	copy    *bytes.Buffer
+	onClose func([]byte)
+	once    sync.Once
+}
+
+func (b *closeNotifyingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.copy != nil && n > 0 {
+		b.copy.Write(p[:n])
+	}
+	return n, err
+}
+
+func (b *closeNotifyingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		var read []byte
+		if b.copy != nil {
+			read = b.copy.Bytes()
+		}
+		b.onClose(read)
+	})
+	return err
+}
+
+/* A context for requests about path, for rate_limits to match on */
+func withRequestPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, requestPathKey{}, path)
+}
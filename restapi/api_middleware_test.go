@@ -0,0 +1,73 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	layer := func(name string) middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	core := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "core")
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	})
+
+	req, _ := http.NewRequest("GET", "https://api.example.com/api/objects", nil)
+	if _, err := chain(core, layer("first"), layer("second")).RoundTrip(req); err != nil {
+		t.Fatalf("api_middleware_test.go: %s", err)
+	}
+	if strings.Join(order, ",") != "first,second,core" {
+		t.Fatalf("api_middleware_test.go: Expected the middlewares to run in order but got %v", order)
+	}
+}
+
+func TestRequestSlotAndRecordMiddleware(t *testing.T) {
+	client, err := NewAPIClient(&apiClientOpt{uri: "https://api.example.com", maxParallelRequests: 1, harFile: filepath.Join(t.TempDir(), "requests.har")})
+	if err != nil {
+		t.Fatalf("api_middleware_test.go: %s", err)
+	}
+	core := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 201, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"id":"1"}`)), Request: req}, nil
+	})
+	send := chain(core, client.requestSlotMiddleware, client.recordMiddleware)
+
+	req, _ := http.NewRequest("POST", "https://api.example.com/api/objects", strings.NewReader(`{"name":"foo"}`))
+	resp, err := send.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("api_middleware_test.go: %s", err)
+	}
+	if len(client.requestSlots) != 1 {
+		t.Fatalf("api_middleware_test.go: Expected the slot to be held until the body is closed")
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body.Close()
+	if len(client.requestSlots) != 0 {
+		t.Fatalf("api_middleware_test.go: Expected closing the body to release the slot")
+	}
+
+	var har struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	b, _ := os.ReadFile(client.har.file)
+	json.Unmarshal(b, &har)
+	entries := har.Log.Entries
+	if len(entries) != 1 || entries[0].Request.PostData.Text != `{"name":"foo"}` || entries[0].Response.Content.Text != `{"id":"1"}` {
+		t.Fatalf("api_middleware_test.go: Expected one HAR entry with both bodies but got %+v", entries)
+	}
+}
@@ -2,67 +2,166 @@ package restapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
 
 type apiObjectOpts struct {
-	path          string
-	getPath       string
-	postPath      string
-	putPath       string
-	createMethod  string
-	readMethod    string
-	updateMethod  string
-	updateData    string
-	destroyMethod string
-	destroyData   string
-	deletePath    string
-	searchPath    string
-	queryString   string
-	debug         bool
-	readSearch    map[string]string
-	id            string
-	idAttribute   string
-	data          string
+	ctx                    context.Context
+	path                   string
+	getPath                string
+	postPath               string
+	putPath                string
+	createMethod           string
+	readMethod             string
+	readData               string
+	updateMethod           string
+	updateData             string
+	updateMode             string
+	payloadFormat          string
+	priorData              string
+	priorResponse          string
+	destroyMethod          string
+	destroyData            string
+	createConflictBehavior string
+	goneStatusCodes        []int
+	deletedKey             string
+	deletedValues          []string
+	destroyWaitTimeout     int
+	destroyPollInterval    int
+	disableProtection      map[string]string
+	existsCheck            map[string]string
+	versionKey             string
+	captureResponseHeaders []string
+	useETag                bool
+	etag                   string
+	hooks                  []apiHook
+	bodyTemplates          map[string]string
+	headers                map[string]string
+	operationHeaders       map[string]map[string]string
+	extract                map[string]string
+	auditMetadata          map[string]string
+	sensitiveKeys          []string
+	mergeServerDefaults    bool
+	omitNullKeys           bool
+	nullValue              string
+	readEmptyResponse      string
+	emptyResponseBodies    []string
+	asyncSettings          map[string]*AsyncSettings
+	followAccepted         bool
+	successCodes           map[string][]int
+	deletePath             string
+	searchPath             string
+	queryString            string
+	debug                  bool
+	readSearch             map[string]string
+	adoptSearch            map[string]string
+	id                     string
+	idAttribute            string
+	idAttributes           []string
+	idTemplate             string
+	idFromHeader           string
+	idFromHeaderRegex      string
+	retryCreatePatterns    []string
+	retryCreateKey         string
+	retryCreateTimeout     int
+	createOnlyKeys         []string
+	updateOnlyKeys         []string
+	data                   string
 }
 
 /*APIObject is the state holding struct for a restapi_object resource*/
 type APIObject struct {
-	apiClient     *APIClient
-	getPath       string
-	postPath      string
-	putPath       string
-	createMethod  string
-	readMethod    string
-	updateMethod  string
-	destroyMethod string
-	deletePath    string
-	searchPath    string
-	queryString   string
-	debug         bool
-	readSearch    map[string]string
-	id            string
-	idAttribute   string
+	apiClient              *APIClient
+	ctx                    context.Context
+	getPath                string
+	postPath               string
+	putPath                string
+	createMethod           string
+	readMethod             string
+	readData               string
+	updateMethod           string
+	updateMode             string
+	payloadFormat          string
+	destroyMethod          string
+	createConflictBehavior string
+	goneStatusCodes        []int
+	deletedKey             string
+	deletedValues          []string
+	destroyWaitTimeout     int
+	destroyPollInterval    int
+	disableProtection      map[string]string
+	existsCheck            map[string]string
+	versionKey             string
+	captureResponseHeaders []string
+	useETag                bool
+	etag                   string
+	hooks                  []apiHook
+	bodyTemplates          map[string]*template.Template
+	headers                map[string]string
+	operationHeaders       map[string]map[string]string
+	extract                map[string]string
+	auditMetadata          map[string]string
+	sensitiveKeys          []string
+	mergeServerDefaults    bool
+	omitNullKeys           bool
+	nullValue              string
+	readEmptyResponse      string
+	emptyResponseBodies    []string
+	asyncSettings          map[string]*AsyncSettings
+	followAccepted         bool
+	successCodes           map[string][]int
+	deletePath             string
+	searchPath             string
+	queryString            string
+	debug                  bool
+	readSearch             map[string]string
+	adoptSearch            map[string]string
+	id                     string
+	idAttribute            string
+	idAttributes           []string
+	idTemplate             string
+	idFromHeader           string
+	idFromHeaderRegex      *regexp.Regexp
+	retryCreatePatterns    []*regexp.Regexp
+	retryCreateKey         string
+	retryCreateTimeout     int
+	createOnlyKeys         []string
+	updateOnlyKeys         []string
 
 	/* Set internally */
-	data        map[string]interface{} /* Data as managed by the user */
-	updateData  map[string]interface{} /* Update data as managed by the user */
-	destroyData map[string]interface{} /* Destroy data as managed by the user */
-	apiData     map[string]interface{} /* Data as available from the API */
-	apiResponse string
+	data          map[string]interface{} /* Data as managed by the user */
+	priorData     map[string]interface{} /* Data as of the last apply, used to compute merge patches */
+	updateData    map[string]interface{} /* Update data as managed by the user */
+	destroyData   map[string]interface{} /* Destroy data as managed by the user */
+	apiData       map[string]interface{} /* Data as available from the API */
+	priorAPIData  map[string]interface{} /* API data as of the last apply or refresh, used to fill in path placeholders */
+	apiResponse   string
+	priorResponse string
+
+	/* The last response about this object, for the last_* attributes */
+	lastStatusCode      int
+	created             bool /* The create request succeeded, so the object exists even if a later step of creating it failed */
+	lastResponseHeaders http.Header
+	lastDuration        time.Duration
 }
 
 // NewAPIObject makes an APIobject to manage a RESTful object in an API
 func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 	if opts.debug {
-		log.Printf("api_object.go: Constructing debug api_object\n")
-		log.Printf(" id: %s\n", opts.id)
+		iClient.log(logObject, "DEBUG", "Constructing debug api_object", map[string]interface{}{"id": opts.id})
 	}
 
 	/* id_attribute can be set either on the client (to apply for all calls with the server)
@@ -70,6 +169,9 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 	   Permit overridding from the API client here by using the client-wide value only
 	   if a per-object value is not set */
 	if opts.idAttribute == "" {
+		if len(opts.idAttributes) == 0 {
+			opts.idAttributes = iClient.idAttributes
+		}
 		opts.idAttribute = iClient.idAttribute
 	}
 
@@ -85,6 +187,12 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 	if opts.updateData == "" {
 		opts.updateData = iClient.updateData
 	}
+	if opts.updateMode == "" {
+		opts.updateMode = iClient.updateMode
+	}
+	if opts.payloadFormat == "" {
+		opts.payloadFormat = iClient.payloadFormat
+	}
 	if opts.destroyMethod == "" {
 		opts.destroyMethod = iClient.destroyMethod
 	}
@@ -108,33 +216,98 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 	}
 
 	obj := APIObject{
-		apiClient:     iClient,
-		getPath:       opts.getPath,
-		postPath:      opts.postPath,
-		putPath:       opts.putPath,
-		createMethod:  opts.createMethod,
-		readMethod:    opts.readMethod,
-		updateMethod:  opts.updateMethod,
-		destroyMethod: opts.destroyMethod,
-		deletePath:    opts.deletePath,
-		searchPath:    opts.searchPath,
-		queryString:   opts.queryString,
-		debug:         opts.debug,
-		readSearch:    opts.readSearch,
-		id:            opts.id,
-		idAttribute:   opts.idAttribute,
-		data:          make(map[string]interface{}),
-		updateData:    make(map[string]interface{}),
-		destroyData:   make(map[string]interface{}),
-		apiData:       make(map[string]interface{}),
+		apiClient:              iClient,
+		ctx:                    opts.ctx,
+		getPath:                opts.getPath,
+		postPath:               opts.postPath,
+		putPath:                opts.putPath,
+		createMethod:           opts.createMethod,
+		readMethod:             opts.readMethod,
+		readData:               opts.readData,
+		updateMethod:           opts.updateMethod,
+		updateMode:             opts.updateMode,
+		payloadFormat:          opts.payloadFormat,
+		destroyMethod:          opts.destroyMethod,
+		createConflictBehavior: opts.createConflictBehavior,
+		goneStatusCodes:        opts.goneStatusCodes,
+		deletedKey:             opts.deletedKey,
+		deletedValues:          opts.deletedValues,
+		destroyWaitTimeout:     opts.destroyWaitTimeout,
+		destroyPollInterval:    opts.destroyPollInterval,
+		retryCreateKey:         opts.retryCreateKey,
+		retryCreateTimeout:     opts.retryCreateTimeout,
+		createOnlyKeys:         opts.createOnlyKeys,
+		updateOnlyKeys:         opts.updateOnlyKeys,
+		disableProtection:      opts.disableProtection,
+		existsCheck:            opts.existsCheck,
+		versionKey:             opts.versionKey,
+		captureResponseHeaders: opts.captureResponseHeaders,
+		useETag:                opts.useETag,
+		etag:                   opts.etag,
+		hooks:                  opts.hooks,
+		headers:                opts.headers,
+		operationHeaders:       opts.operationHeaders,
+		extract:                opts.extract,
+		auditMetadata:          opts.auditMetadata,
+		sensitiveKeys:          opts.sensitiveKeys,
+		mergeServerDefaults:    opts.mergeServerDefaults,
+		omitNullKeys:           opts.omitNullKeys,
+		nullValue:              opts.nullValue,
+		readEmptyResponse:      opts.readEmptyResponse,
+		emptyResponseBodies:    opts.emptyResponseBodies,
+		asyncSettings:          opts.asyncSettings,
+		followAccepted:         opts.followAccepted,
+		successCodes:           opts.successCodes,
+		deletePath:             opts.deletePath,
+		searchPath:             opts.searchPath,
+		queryString:            opts.queryString,
+		debug:                  opts.debug,
+		readSearch:             opts.readSearch,
+		adoptSearch:            opts.adoptSearch,
+		id:                     opts.id,
+		idAttribute:            opts.idAttribute,
+		idAttributes:           opts.idAttributes,
+		idTemplate:             opts.idTemplate,
+		idFromHeader:           opts.idFromHeader,
+		data:                   make(map[string]interface{}),
+		priorData:              make(map[string]interface{}),
+		updateData:             make(map[string]interface{}),
+		destroyData:            make(map[string]interface{}),
+		apiData:                make(map[string]interface{}),
+		priorAPIData:           make(map[string]interface{}),
+	}
+
+	/* Keep them out of the client's debug logs too */
+	iClient.sensitiveKeys.add(obj.sensitiveKeys)
+
+	bodyTemplates, err := parseBodyTemplates(opts.bodyTemplates)
+	if err != nil {
+		return &obj, err
+	}
+	obj.bodyTemplates = bodyTemplates
+
+	if opts.idFromHeaderRegex != "" {
+		re, err := regexp.Compile(opts.idFromHeaderRegex)
+		if err != nil {
+			return &obj, fmt.Errorf("api_object.go: error parsing id_from_header_regex: %v", err)
+		}
+		obj.idFromHeaderRegex = re
+	}
+
+	for _, pattern := range opts.retryCreatePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return &obj, fmt.Errorf("api_object.go: error parsing retry_create_on pattern '%s': %v", pattern, err)
+		}
+		obj.retryCreatePatterns = append(obj.retryCreatePatterns, re)
 	}
 
 	if opts.data != "" {
 		if opts.debug {
-			log.Printf("api_object.go: Parsing data: '%s'", opts.data)
+			obj.log("DEBUG", "Parsing data", map[string]interface{}{"data": obj.apiClient.logBody(opts.data)})
 		}
 
-		err := json.Unmarshal([]byte(opts.data), &obj.data)
+		err := decodeJSON([]byte(opts.data), &obj.data)
 		if err != nil {
 			return &obj, fmt.Errorf("api_object.go: error parsing data provided: %v", err.Error())
 		}
@@ -143,26 +316,39 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 		   If it is not set, we will get it later in synchronize_state */
 		if obj.id == "" {
 			var tmp string
-			tmp, err := GetStringAtKey(obj.data, obj.idAttribute, obj.debug)
+			tmp, err := obj.idFrom(obj.data)
 			if err == nil {
 				if opts.debug {
-					log.Printf("api_object.go: opportunisticly set id from data provided.")
+					obj.log("DEBUG", "Opportunistically set id from data provided", map[string]interface{}{"id": obj.id})
 				}
 				obj.id = tmp
-			} else if !obj.apiClient.writeReturnsObject && !obj.apiClient.createReturnsObject && obj.searchPath == "" {
+			} else if !obj.apiClient.writeReturnsObject && !obj.apiClient.createReturnsObject && obj.idFromHeader == "" && obj.searchPath == "" && obj.asyncSettings["create"] == nil {
 				/* If the id is not set and we cannot obtain it
 				   later, error out to be safe */
-				return &obj, fmt.Errorf("provided data does not have %s attribute for the object's id and the client is not configured to read the object from a POST response; without an id, the object cannot be managed", obj.idAttribute)
+				return &obj, fmt.Errorf("provided data does not have %s attribute for the object's id and the client is not configured to read the object from a POST response; without an id, the object cannot be managed", obj.idDescription())
 			}
 		}
 	}
 
+	if opts.priorData != "" {
+		err := decodeJSON([]byte(opts.priorData), &obj.priorData)
+		if err != nil {
+			return &obj, fmt.Errorf("api_object.go: error parsing prior data: %v", err.Error())
+		}
+	}
+
+	if opts.priorResponse != "" {
+		/* Only used for placeholders, so a response that is not a JSON object is not an error */
+		decodeJSON([]byte(opts.priorResponse), &obj.priorAPIData)
+		obj.priorResponse = opts.priorResponse
+	}
+
 	if opts.updateData != "" {
 		if opts.debug {
-			log.Printf("api_object.go: Parsing update data: '%s'", opts.updateData)
+			obj.log("DEBUG", "Parsing update data", map[string]interface{}{"update_data": obj.apiClient.logBody(opts.updateData)})
 		}
 
-		err := json.Unmarshal([]byte(opts.updateData), &obj.updateData)
+		err := decodeJSON([]byte(opts.updateData), &obj.updateData)
 		if err != nil {
 			return &obj, fmt.Errorf("api_object.go: error parsing update data provided: %v", err.Error())
 		}
@@ -170,21 +356,466 @@ func NewAPIObject(iClient *APIClient, opts *apiObjectOpts) (*APIObject, error) {
 
 	if opts.destroyData != "" {
 		if opts.debug {
-			log.Printf("api_object.go: Parsing destroy data: '%s'", opts.destroyData)
+			obj.log("DEBUG", "Parsing destroy data", map[string]interface{}{"destroy_data": obj.apiClient.logBody(opts.destroyData)})
 		}
 
-		err := json.Unmarshal([]byte(opts.destroyData), &obj.destroyData)
+		err := decodeJSON([]byte(opts.destroyData), &obj.destroyData)
 		if err != nil {
 			return &obj, fmt.Errorf("api_object.go: error parsing destroy data provided: %v", err.Error())
 		}
 	}
 
 	if opts.debug {
-		log.Printf("api_object.go: Constructed object: %s", obj.toString())
+		obj.log("DEBUG", "Constructed object", map[string]interface{}{"object": obj.toString()})
 	}
 	return &obj, nil
 }
 
+/*
+The context of the Terraform operation the object is for, so requests
+
+	and polling stop when it is cancelled (such as with Ctrl-C)
+*/
+func (obj *APIObject) context() context.Context {
+	if obj.ctx == nil {
+		return context.Background()
+	}
+	return obj.ctx
+}
+
+/*
+Send a request about this object, recording its response for the
+
+	last_* attributes. data and the body returned are JSON, which the
+	payload_format's codec converts to and from what the API speaks
+*/
+func (obj *APIObject) send(method string, path string, data string, headers map[string]string) (string, *http.Response, error) {
+	start := time.Now()
+	body, resp, err := obj.exchange(method, path, data, headers)
+	obj.lastDuration = time.Since(start)
+	obj.lastStatusCode = responseCode(err)
+	obj.lastResponseHeaders = nil
+	if resp != nil {
+		obj.lastStatusCode = resp.StatusCode
+		obj.lastResponseHeaders = resp.Header
+	}
+	if err == nil && resp != nil && resp.Header.Get("ETag") != "" {
+		obj.etag = resp.Header.Get("ETag")
+	}
+	return body, resp, err
+}
+
+/*
+Send a request in the object's payload_format without recording it,
+
+	for requests that are not about the object itself, such as hooks
+	and status polls. data and the body returned are JSON, as for send
+*/
+func (obj *APIObject) exchange(method string, path string, data string, headers map[string]string) (string, *http.Response, error) {
+	c := obj.codec()
+	/* Merge patches are JSON by definition */
+	if headers["Content-Type"] != "application/merge-patch+json" {
+		var err error
+		data, headers, err = encodePayload(c, data, headers, obj.apiClient.headers)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to encode the %s request to %s as %s: %w", method, path, obj.payloadFormat, err)
+		}
+	}
+
+	body, resp, err := obj.apiClient.sendRequestContext(obj.context(), method, path, data, headers)
+	if err == nil {
+		if body, err = decodeResponse(c, resp.Header.Get("Content-Type"), body); err != nil {
+			err = fmt.Errorf("the response to %s %s could not be decoded: %w", method, path, err)
+		}
+	}
+	return body, resp, err
+}
+
+/* The codec for payload_format */
+func (obj *APIObject) codec() codec {
+	if c, ok := codecs[obj.payloadFormat]; ok {
+		return c
+	}
+	return jsonCodec{}
+}
+
+/* The response headers listed in capture_response_headers, for last_response_headers */
+func (obj *APIObject) capturedResponseHeaders() map[string]string {
+	captured := make(map[string]string)
+	for _, name := range obj.captureResponseHeaders {
+		if value := obj.lastResponseHeaders.Get(name); value != "" {
+			captured[name] = value
+		}
+	}
+	return captured
+}
+
+/*
+Headers for a request about this object, which the client sets over
+
+	the provider's headers. operation is create, read, update or destroy,
+	or "" for other requests such as hooks. extra headers needed by the
+	request itself take precedence
+*/
+func (obj *APIObject) requestHeaders(operation string, extra map[string]string) map[string]string {
+	headers := make(map[string]string)
+	if obj.useETag && obj.etag != "" && (operation == "update" || operation == "destroy") {
+		headers["If-Match"] = obj.etag
+	}
+	for _, source := range []map[string]string{obj.headers, obj.operationHeaders[operation], extra} {
+		for k, v := range source {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+/* The API data to store in state, with sensitive_keys masked */
+func (obj *APIObject) stateAPIData() map[string]interface{} {
+	return maskSensitiveKeys(obj.apiData, obj.sensitiveKeys)
+}
+
+/* The API response to store in state, with sensitive_keys masked */
+func (obj *APIObject) stateAPIResponse() string {
+	return maskSensitiveJSON(obj.apiResponse, obj.sensitiveKeys)
+}
+
+/*
+Values from the API response for each path in extract. Strings are
+
+	used as they are and anything else is rendered as JSON. Paths that
+	are not in the response are left out
+*/
+func (obj *APIObject) extractOutputs() map[string]string {
+	outputs := make(map[string]string)
+	for name, path := range obj.extract {
+		value, err := GetObjectAtKey(obj.stateAPIData(), path, obj.debug)
+		if err != nil {
+			obj.log("WARN", "Cannot extract output", map[string]interface{}{"output": name, "error": err.Error()})
+			continue
+		}
+		if str, ok := value.(string); ok {
+			outputs[name] = str
+			continue
+		}
+		b, _ := json.Marshal(value)
+		outputs[name] = string(b)
+	}
+	return outputs
+}
+
+/*
+Check the outcome of a create, update or destroy request against the
+
+	operation's *_success_codes (by default, any 2xx is a success).
+	Returns whether the response body is the object, which it is not
+	for accepted error codes such as 404 on destroy or 409 on create
+*/
+func (obj *APIObject) checkStatus(operation string, resp *http.Response, err error) (bool, error) {
+	codes := obj.successCodes[operation]
+	if len(codes) == 0 {
+		return err == nil, err
+	}
+
+	code := responseCode(err)
+	if err == nil && resp != nil {
+		code = resp.StatusCode
+	}
+	if code == 0 {
+		/* The request failed before there was a response */
+		return false, err
+	}
+
+	for _, successCode := range codes {
+		if code == successCode {
+			if err != nil {
+				obj.log("INFO", "Accepting response (*_success_codes)", map[string]interface{}{"status_code": code, "operation": operation, "id": obj.id})
+			}
+			return err == nil, nil
+		}
+	}
+	if err == nil {
+		return false, fmt.Errorf("unexpected response code '%d' to %s request; %s_success_codes is %v", code, operation, operation, codes)
+	}
+	return false, err
+}
+
+/* Whether a response has no body to parse, as with 204 No Content */
+func isEmptyResponse(body string) bool {
+	return strings.TrimSpace(body) == ""
+}
+
+/* Whether a read response is empty, or one of empty_response_bodies such as {} */
+func (obj *APIObject) isEmptyReadResponse(body string) bool {
+	if isEmptyResponse(body) {
+		return true
+	}
+	for _, empty := range obj.emptyResponseBodies {
+		if jsonEquivalent(body, empty) {
+			return true
+		}
+	}
+	return false
+}
+
+/* Add a query string to a path, which may already have one (such as /objects/{id}?action=delete) */
+func appendQueryString(path string, queryString string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + queryString
+	}
+	return path + "?" + queryString
+}
+
+/* Matches the {KEY} fields of an id_template */
+var idTemplateRegexp = regexp.MustCompile(`\{([^{}]+)\}`)
+
+/*
+Get the object's id from source, which is the object's data, an API
+
+	response or a search result. With id_template, the id is built from
+	several keys such as {org_id}:{name}
+*/
+func (obj *APIObject) idFrom(source map[string]interface{}) (string, error) {
+	if obj.idTemplate == "" && len(obj.idAttributes) > 0 {
+		/* The first of id_attributes that is found */
+		var err error
+		for _, idAttribute := range obj.idAttributes {
+			var id string
+			if id, err = GetStringAtKey(source, idAttribute, obj.debug); err == nil {
+				return id, nil
+			}
+		}
+		return "", err
+	}
+	if obj.idTemplate == "" {
+		return GetStringAtKey(source, obj.idAttribute, obj.debug)
+	}
+
+	var missing error
+	id := idTemplateRegexp.ReplaceAllStringFunc(obj.idTemplate, func(field string) string {
+		value, err := GetStringAtKey(source, field[1:len(field)-1], obj.debug)
+		if err != nil && missing == nil {
+			missing = err
+		}
+		return value
+	})
+	return id, missing
+}
+
+/* For error messages */
+func (obj *APIObject) idDescription() string {
+	if obj.idTemplate != "" {
+		return fmt.Sprintf("the keys of id_template '%s'", obj.idTemplate)
+	}
+	if len(obj.idAttributes) > 0 {
+		return fmt.Sprintf("id_attributes %v", obj.idAttributes)
+	}
+	return fmt.Sprintf("id_attribute '%s'", obj.idAttribute)
+}
+
+/*
+Split the object's id back into the fields of its id_template, so
+
+	paths can use them even when the id was imported rather than built
+	from data. Returns nil if there is no id_template or the id does not
+	match it
+*/
+func (obj *APIObject) idFields() map[string]string {
+	if obj.idTemplate == "" || obj.id == "" {
+		return nil
+	}
+
+	names := []string{}
+	pattern := "^"
+	last := 0
+	for _, loc := range idTemplateRegexp.FindAllStringSubmatchIndex(obj.idTemplate, -1) {
+		pattern += regexp.QuoteMeta(obj.idTemplate[last:loc[0]]) + "(.+?)"
+		names = append(names, obj.idTemplate[loc[2]:loc[3]])
+		last = loc[1]
+	}
+	pattern += regexp.QuoteMeta(obj.idTemplate[last:]) + "$"
+
+	match := regexp.MustCompile(pattern).FindStringSubmatch(obj.id)
+	if match == nil {
+		obj.log("WARN", "id does not match id_template", map[string]interface{}{"id": obj.id, "id_template": obj.idTemplate})
+		return nil
+	}
+	fields := map[string]string{}
+	for i, name := range names {
+		fields[name] = match[i+1]
+	}
+	return fields
+}
+
+/* Matches {id}, {data.KEY}, {prior_data.KEY}, {api_data.KEY} and {KEY} placeholders */
+var placeholderRegexp = regexp.MustCompile(`\{([^{}"\s]+)\}`)
+
+/*
+Replace placeholders in s with values from the object. This is the one
+
+	placeholder syntax, used alike in the *_path attributes, hooks,
+	read_data, update_data, destroy_data and the paths of *_async
+	blocks. {id} is the object's id, {data.KEY} a value from data,
+	{prior_data.KEY} a value from data as of the last apply and
+	{api_data.KEY} a value from the last API response, where KEY may be
+	a '/'-delimited path such as meta/name. A bare {KEY} is (in order of
+	preference) its part of the id when id_template is set, its value in
+	data, or its value in the API response. Placeholders that cannot be
+	resolved are left as they are.
+*/
+func (obj *APIObject) expandPlaceholders(s string) string {
+	expanded, _ := obj.replacePlaceholders(s, nil, func(value string) string { return value })
+	return expanded
+}
+
+/*
+Replace placeholders in a path like expandPlaceholders, escaping the
+
+	values from data and the API so each stays one path segment. The id
+	and its id_template fields are inserted as they are
+*/
+func (obj *APIObject) expandPath(path string) string {
+	expanded, _ := obj.replacePlaceholders(path, nil, url.PathEscape)
+	return expanded
+}
+
+/*
+Replace placeholders in the path of an operation like expandPath. A bare
+
+	{KEY}, {id} included, is first looked for in body, the response that
+	started the operation. Fails if a placeholder cannot be resolved
+*/
+func (obj *APIObject) fillFromResponse(path string, body string) (string, error) {
+	var response map[string]interface{}
+	if err := decodeJSON([]byte(body), &response); err != nil {
+		return "", fmt.Errorf("the response is not a JSON object to fill '%s' from: %v", path, err)
+	}
+	return obj.replacePlaceholders(path, response, url.PathEscape)
+}
+
+func (obj *APIObject) replacePlaceholders(s string, response map[string]interface{}, escape func(string) string) (string, error) {
+	var missing error
+	expanded := placeholderRegexp.ReplaceAllStringFunc(s, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, fromID, err := obj.placeholderValue(name, response)
+		var str string
+		if err == nil {
+			if str, err = jsonScalarString(value); err == nil && !fromID {
+				str = escape(str)
+			}
+		}
+		if err != nil {
+			if missing == nil {
+				missing = fmt.Errorf("there is no '%s' to fill '%s'", name, s)
+			}
+			if response == nil {
+				obj.log("WARN", "Cannot replace placeholder", map[string]interface{}{"placeholder": placeholder, "in": s, "error": err.Error()})
+			}
+			return placeholder
+		}
+		return str
+	})
+	return expanded, missing
+}
+
+/*
+The value a placeholder name (what is between its braces) refers to,
+
+	and whether it is the id or one of its id_template fields. response
+	is looked in first for a bare KEY, if it is set
+*/
+func (obj *APIObject) placeholderValue(name string, response map[string]interface{}) (interface{}, bool, error) {
+	for _, prefix := range []string{"data.", "prior_data.", "api_data."} {
+		if strings.HasPrefix(name, prefix) {
+			source, key := obj.placeholderSource(name)
+			value, err := GetObjectAtKey(source, key, obj.debug)
+			return value, false, err
+		}
+	}
+
+	if response != nil {
+		if value, err := GetObjectAtKey(response, name, false); err == nil {
+			return value, false, nil
+		}
+	}
+	if name == "id" && (obj.id != "" || response == nil) {
+		return obj.id, true, nil
+	}
+	if value, ok := obj.idFields()[name]; ok {
+		return value, true, nil
+	}
+	for _, source := range []map[string]interface{}{obj.data, obj.apiData, obj.priorAPIData} {
+		if value, err := GetObjectAtKey(source, name, false); err == nil {
+			return value, false, nil
+		}
+	}
+	return nil, false, fmt.Errorf("'%s' is not in data or the API response", name)
+}
+
+/* The data a placeholder name such as api_data.meta/name refers to, and the key in it */
+func (obj *APIObject) placeholderSource(name string) (map[string]interface{}, string) {
+	switch {
+	case strings.HasPrefix(name, "api_data."):
+		/* Before the object is read, the last API response is in state */
+		if len(obj.apiData) == 0 {
+			return obj.priorAPIData, strings.TrimPrefix(name, "api_data.")
+		}
+		return obj.apiData, strings.TrimPrefix(name, "api_data.")
+	case strings.HasPrefix(name, "prior_data."):
+		return obj.priorData, strings.TrimPrefix(name, "prior_data.")
+	}
+	return obj.data, strings.TrimPrefix(name, "data.")
+}
+
+/*
+Replace placeholders in the string values of update_data or
+
+	destroy_data. A value that is a single placeholder, such as
+	"{api_data.revision}", takes the type of what it refers to, so
+	numbers and objects are not turned into strings
+*/
+func (obj *APIObject) expandDataPlaceholders(data map[string]interface{}) map[string]interface{} {
+	return obj._expandDataPlaceholders(data).(map[string]interface{})
+}
+
+func (obj *APIObject) _expandDataPlaceholders(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(value))
+		for key, val := range value {
+			expanded[key] = obj._expandDataPlaceholders(val)
+		}
+		return expanded
+	case []interface{}:
+		expanded := make([]interface{}, len(value))
+		for i, val := range value {
+			expanded[i] = obj._expandDataPlaceholders(val)
+		}
+		return expanded
+	case string:
+		if match := placeholderRegexp.FindStringSubmatch(value); match != nil && match[0] == value {
+			if found, _, err := obj.placeholderValue(match[1], nil); err == nil {
+				return found
+			}
+		}
+		return obj.expandPlaceholders(value)
+	}
+	return data
+}
+
+/*
+Log msg about the object to the object subsystem. Objects built without
+
+	a client (such as in tests) log to the standard logger
+*/
+func (obj *APIObject) log(level string, msg string, fields map[string]interface{}) {
+	if obj.apiClient == nil {
+		log.Print(formatLog(logObject, level, msg, fields))
+		return
+	}
+	obj.apiClient.log(logObject, level, msg, fields)
+}
+
 // Convert the important bits about this object to string representation
 // This is useful for debugging.
 func (obj *APIObject) toString() string {
@@ -197,14 +828,16 @@ func (obj *APIObject) toString() string {
 	buffer.WriteString(fmt.Sprintf("query_string: %s\n", obj.queryString))
 	buffer.WriteString(fmt.Sprintf("create_method: %s\n", obj.createMethod))
 	buffer.WriteString(fmt.Sprintf("read_method: %s\n", obj.readMethod))
+	buffer.WriteString(fmt.Sprintf("read_data: %s\n", maskSensitiveJSON(obj.readData, obj.sensitiveKeys)))
 	buffer.WriteString(fmt.Sprintf("update_method: %s\n", obj.updateMethod))
 	buffer.WriteString(fmt.Sprintf("destroy_method: %s\n", obj.destroyMethod))
 	buffer.WriteString(fmt.Sprintf("debug: %t\n", obj.debug))
 	buffer.WriteString(fmt.Sprintf("read_search: %s\n", spew.Sdump(obj.readSearch)))
-	buffer.WriteString(fmt.Sprintf("data: %s\n", spew.Sdump(obj.data)))
-	buffer.WriteString(fmt.Sprintf("update_data: %s\n", spew.Sdump(obj.updateData)))
-	buffer.WriteString(fmt.Sprintf("destroy_data: %s\n", spew.Sdump(obj.destroyData)))
-	buffer.WriteString(fmt.Sprintf("api_data: %s\n", spew.Sdump(obj.apiData)))
+	buffer.WriteString(fmt.Sprintf("adopt_search: %s\n", spew.Sdump(obj.adoptSearch)))
+	buffer.WriteString(fmt.Sprintf("data: %s\n", spew.Sdump(maskSensitiveKeys(obj.data, obj.sensitiveKeys))))
+	buffer.WriteString(fmt.Sprintf("update_data: %s\n", spew.Sdump(maskSensitiveKeys(obj.updateData, obj.sensitiveKeys))))
+	buffer.WriteString(fmt.Sprintf("destroy_data: %s\n", spew.Sdump(maskSensitiveKeys(obj.destroyData, obj.sensitiveKeys))))
+	buffer.WriteString(fmt.Sprintf("api_data: %s\n", spew.Sdump(obj.stateAPIData())))
 	return buffer.String()
 }
 
@@ -216,15 +849,10 @@ Centralized function to ensure that our data as managed by
 */
 func (obj *APIObject) updateState(state string) error {
 	if obj.debug {
-		log.Printf("api_object.go: Updating API object state to '%s'\n", state)
+		obj.log("DEBUG", "Updating API object state", map[string]interface{}{"state": obj.apiClient.logBody(state)})
 	}
 
-	/* Other option - Decode as JSON Numbers instead of golang datatypes
-	d := json.NewDecoder(strings.NewReader(res_str))
-	d.UseNumber()
-	err = d.Decode(&obj.api_data)
-	*/
-	err := json.Unmarshal([]byte(state), &obj.apiData)
+	err := decodeJSON([]byte(state), &obj.apiData)
 	if err != nil {
 		return err
 	}
@@ -235,62 +863,161 @@ func (obj *APIObject) updateState(state string) error {
 	/* A usable ID was not passed (in constructor or here),
 	   so we have to guess what it is from the data structure */
 	if obj.id == "" {
-		val, err := GetStringAtKey(obj.apiData, obj.idAttribute, obj.debug)
+		val, err := obj.idFrom(obj.apiData)
 		if err != nil {
 			return fmt.Errorf("api_object.go: Error extracting ID from data element: %s", err)
 		}
 		obj.id = val
 	} else if obj.debug {
-		log.Printf("api_object.go: Not updating id. It is already set to '%s'\n", obj.id)
+		obj.log("DEBUG", "Not updating id. It is already set", map[string]interface{}{"id": obj.id})
 	}
 
 	/* Any keys that come from the data we want to copy are done here */
 	if len(obj.apiClient.copyKeys) > 0 {
 		for _, key := range obj.apiClient.copyKeys {
 			if obj.debug {
-				log.Printf("api_object.go: Copying key '%s' from api_data (%v) to data (%v)\n", key, obj.apiData[key], obj.data[key])
+				obj.log("DEBUG", "Copying key from api_data to data", map[string]interface{}{"key": key})
 			}
 			obj.data[key] = obj.apiData[key]
 		}
 	} else if obj.debug {
-		log.Printf("api_object.go: copy_keys is empty - not attempting to copy data")
+		obj.log("DEBUG", "copy_keys is empty - not attempting to copy data", nil)
+	}
+	for key, path := range obj.apiClient.copyKeyPaths {
+		value, err := GetObjectAtKey(obj.apiData, path, obj.debug)
+		if err != nil {
+			obj.log("WARN", "Not copying a copy_key_paths path", map[string]interface{}{"path": path, "key": key, "error": err.Error()})
+			continue
+		}
+		if obj.debug {
+			obj.log("DEBUG", "Copying a path from api_data to data", map[string]interface{}{"path": path, "key": key})
+		}
+		obj.data = setAtKey(obj.data, key, value)
 	}
 
 	if obj.debug {
-		log.Printf("api_object.go: final object after synchronization of state:\n%+v\n", obj.toString())
+		obj.log("DEBUG", "Final object after synchronization of state", map[string]interface{}{"object": obj.toString()})
 	}
 	return err
 }
 
-func (obj *APIObject) createObject() error {
+/* The path (before placeholders are filled) and body of the request to create the object */
+func (obj *APIObject) createRequest() (string, []byte, error) {
+	/* update_only_keys are set by an update right after the create */
+	createData := omitKeys(obj.data, obj.updateOnlyKeys)
+	b, _ := json.Marshal(obj.resolveNulls(createData))
+	if body, ok, err := obj.renderBodyTemplate("create"); ok {
+		if err != nil {
+			return "", nil, err
+		}
+		b = []byte(body)
+	}
+
+	postPath := obj.postPath
+	if obj.queryString != "" {
+		if obj.debug {
+			obj.log("DEBUG", "Adding query string", map[string]interface{}{"query_string": obj.queryString})
+		}
+		postPath = appendQueryString(obj.postPath, obj.queryString)
+	}
+	return postPath, b, nil
+}
+
+func (obj *APIObject) createObject() (err error) {
+	/* Recorded in audit_log last, so failed post_create hooks count */
+	auditPath, auditCode := "", 0
+	defer func() { obj.audit("create", obj.id, obj.createMethod, auditPath, auditCode, err) }()
+
+	if err = obj.runHooks("pre_create"); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = obj.runHooks("post_create")
+		}
+	}()
+
 	/* Failsafe: The constructor should prevent this situation, but
 	   protect here also. If no id is set, and the API does not respond
 	   with the id of whatever gets created, we have no way to know what
 	   the object's id will be. Abandon this attempt */
-	if obj.id == "" && !obj.apiClient.writeReturnsObject && !obj.apiClient.createReturnsObject {
-		return fmt.Errorf("provided object does not have an id set and the client is not configured to read the object from a POST or PUT response; please set write_returns_object to true, or include an id in the object's data")
+	if obj.id == "" && !obj.apiClient.writeReturnsObject && !obj.apiClient.createReturnsObject && obj.idFromHeader == "" && obj.asyncSettings["create"] == nil {
+		return fmt.Errorf("provided object does not have an id set and the client is not configured to read the object from a POST or PUT response; please set write_returns_object to true, set id_from_header, or include an id in the object's data")
 	}
 
-	b, _ := json.Marshal(obj.data)
-
-	postPath := obj.postPath
-	if obj.queryString != "" {
-		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+	if obj.existsCheck != nil {
+		exists, err := obj.checkExists()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return obj.createExistingObject()
 		}
-		postPath = fmt.Sprintf("%s?%s", obj.postPath, obj.queryString)
 	}
 
-	resultString, err := obj.apiClient.sendRequest(obj.createMethod, strings.Replace(postPath, "{id}", obj.id, -1), string(b))
+	postPath, b, err := obj.createRequest()
 	if err != nil {
 		return err
 	}
+	/* update_only_keys are left out of the create, and set by an update after it */
+	setUpdateOnlyKeys := !jsonValuesEqual(omitKeys(obj.data, obj.updateOnlyKeys), obj.data)
+
+	var resultString string
+	var resp *http.Response
+	var isObject bool
+	deadline := time.Now().Add(time.Duration(obj.retryCreateTimeout) * time.Second)
+	for attempt := 0; ; attempt++ {
+		resultString, resp, err = obj.send(obj.createMethod, obj.expandPath(postPath), string(b), obj.requestHeaders("create", nil))
+		auditPath, auditCode = obj.expandPath(postPath), obj.lastStatusCode
+		isObject, err = obj.checkStatus("create", resp, err)
+		if err == nil || !obj.retryCreate(err, attempt, deadline) {
+			break
+		}
+	}
+	if err != nil {
+		code := responseCode(err)
+		if code == http.StatusConflict && obj.createConflictBehavior == "update" {
+			return obj.updateExistingObject(err)
+		}
+		if (code == http.StatusConflict || code == http.StatusUnprocessableEntity) && obj.createConflictBehavior == "adopt" {
+			return obj.adoptExistingObject(err)
+		}
+		return err
+	}
+	obj.created = true
+	if !isObject {
+		resultString = ""
+	}
+
+	if obj.id == "" && obj.idFromHeader != "" {
+		obj.id, err = obj.idFromResponseHeader(resp)
+		if err != nil {
+			return err
+		}
+	}
+
+	/* An async create responds with the operation rather than the object,
+	   which is read once the operation is done (unless it has a result).
+	   The operation's id is not the object's, so the id must come from
+	   the result, or be known already */
+	returnsObject := obj.apiClient.writeReturnsObject || obj.apiClient.createReturnsObject
+	if isObject && obj.asyncFor("create", resp) != nil {
+		resultString, err = obj.waitForOperation("create", resp, resultString)
+		if err != nil {
+			return err
+		}
+		if resultString == "" && obj.id == "" {
+			return fmt.Errorf("the create operation finished, but the object's id is not known; the object *may* have been created. Set result_uri_key to read the created object, set id_from_header, or include the id in the object's data")
+		}
+		returnsObject = resultString != ""
+	}
 
-	/* We will need to sync state as well as get the object's ID */
-	if obj.apiClient.writeReturnsObject || obj.apiClient.createReturnsObject {
+	/* We will need to sync state as well as get the object's ID.
+	   An empty response (such as 204 No Content) has neither, so the
+	   object is read instead if its id is known */
+	if returnsObject && !isEmptyResponse(resultString) {
 		if obj.debug {
-			log.Printf("api_object.go: Parsing response from POST to update internal structures (write_returns_object=%t, create_returns_object=%t)...\n",
-				obj.apiClient.writeReturnsObject, obj.apiClient.createReturnsObject)
+			obj.log("DEBUG", "Parsing response from POST to update internal structures", map[string]interface{}{"write_returns_object": obj.apiClient.writeReturnsObject, "create_returns_object": obj.apiClient.createReturnsObject})
 		}
 		err = obj.updateState(resultString)
 		/* Yet another failsafe. In case something terrible went wrong internally,
@@ -299,15 +1026,213 @@ func (obj *APIObject) createObject() error {
 			return fmt.Errorf("internal validation failed; object ID is not set, but *may* have been created; this should never happen")
 		}
 	} else {
+		if obj.id == "" {
+			return fmt.Errorf("the API returned an empty response to the create request, so the object's id is not known; the object *may* have been created. Set id_from_header, or include the id in the object's data")
+		}
 		if obj.debug {
-			log.Printf("api_object.go: Requesting created object from API (write_returns_object=%t, create_returns_object=%t)...\n",
-				obj.apiClient.writeReturnsObject, obj.apiClient.createReturnsObject)
+			obj.log("DEBUG", "Requesting created object from API", map[string]interface{}{"write_returns_object": obj.apiClient.writeReturnsObject, "create_returns_object": obj.apiClient.createReturnsObject})
 		}
 		err = obj.readObject()
 	}
+
+	if err == nil && setUpdateOnlyKeys {
+		if obj.debug {
+			obj.log("DEBUG", "Updating the object to set its update_only_keys", map[string]interface{}{"id": obj.id})
+		}
+		err = obj.updateObject()
+	}
 	return err
 }
 
+/*
+Whether a failed create should be tried again because the error
+
+	response matches one of the retry_create_on patterns (for example,
+	a parent object that has not propagated yet). Waits with the
+	client's retry backoff before returning true
+*/
+func (obj *APIObject) retryCreate(err error, attempt int, deadline time.Time) bool {
+	var apiErr *APIError
+	if len(obj.retryCreatePatterns) == 0 || !errors.As(err, &apiErr) {
+		return false
+	}
+
+	value := apiErr.body
+	if obj.retryCreateKey != "" {
+		var data map[string]interface{}
+		if decodeJSON([]byte(apiErr.body), &data) != nil {
+			return false
+		}
+		found, err := GetObjectAtKey(data, obj.retryCreateKey, obj.debug)
+		if err != nil {
+			return false
+		}
+		if str, ok := found.(string); ok {
+			value = str
+		} else {
+			b, _ := json.Marshal(found)
+			value = string(b)
+		}
+	}
+
+	for _, re := range obj.retryCreatePatterns {
+		if !re.MatchString(value) {
+			continue
+		}
+		wait := obj.apiClient.retryPolicy.backoff(attempt)
+		if time.Now().Add(wait).After(deadline) {
+			obj.log("WARN", "Not retrying create - retry_create_on timeout would be exceeded", map[string]interface{}{"id": obj.id, "timeout_seconds": obj.retryCreateTimeout})
+			return false
+		}
+		obj.log("WARN", "Create failed with an error matching retry_create_on. Retrying", map[string]interface{}{"pattern": re.String(), "wait": wait.String(), "error": err.Error()})
+		return sleepContext(obj.context(), wait) == nil
+	}
+	return false
+}
+
+/*
+Get the id of a newly created object from the id_from_header response
+
+	header. With id_from_header_regex, the first capture group (or the
+	whole match) is the id. Otherwise, the id is the last path segment
+	of the header value, which suits Location: /api/objects/1234
+*/
+func (obj *APIObject) idFromResponseHeader(resp *http.Response) (string, error) {
+	value := ""
+	if resp != nil {
+		value = resp.Header.Get(obj.idFromHeader)
+	}
+	if value == "" {
+		return "", fmt.Errorf("the object may have been created, but the response has no %s header to read its id from", obj.idFromHeader)
+	}
+
+	id := ""
+	if obj.idFromHeaderRegex != nil {
+		match := obj.idFromHeaderRegex.FindStringSubmatch(value)
+		if len(match) > 1 {
+			id = match[1]
+		} else if len(match) == 1 {
+			id = match[0]
+		}
+	} else {
+		value = strings.TrimRight(strings.SplitN(value, "?", 2)[0], "/")
+		id = value[strings.LastIndex(value, "/")+1:]
+	}
+
+	if id == "" {
+		return "", fmt.Errorf("the object may have been created, but its id could not be found in the %s header '%s'", obj.idFromHeader, value)
+	}
+	if obj.debug {
+		obj.log("DEBUG", "Set id from header", map[string]interface{}{"id": id, "header": obj.idFromHeader})
+	}
+	return id, nil
+}
+
+/*
+Send the exists_check request. A 2xx response means the object
+
+	exists, and one of gone_status_codes that it does not
+*/
+func (obj *APIObject) checkExists() (bool, error) {
+	method := obj.existsCheck["method"]
+	if method == "" {
+		method = "HEAD"
+	}
+	path := obj.existsCheck["path"]
+	if path == "" {
+		path = obj.getPath
+	}
+	if strings.Contains(path, "{id}") && obj.id == "" {
+		return false, fmt.Errorf("cannot check whether the object exists before creating it; its id is not known yet (set it in data or id_template, or set exists_check's path)")
+	}
+
+	_, _, err := obj.exchange(method, obj.expandPath(path), "", obj.requestHeaders("read", nil))
+	if err != nil {
+		if obj.isGoneStatusCode(responseCode(err)) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check whether the object exists before creating it: %v", err)
+	}
+	return true, nil
+}
+
+/*
+Called when exists_check finds the object already exists, which is
+
+	handled like a conflict on create: with create_conflict_behavior
+	update it is updated, and with adopt it is taken into state as it
+	is (the check found it, so there is nothing to search for)
+*/
+func (obj *APIObject) createExistingObject() error {
+	existsErr := fmt.Errorf("object '%s' already exists", obj.id)
+	switch obj.createConflictBehavior {
+	case "update":
+		return obj.updateExistingObject(existsErr)
+	case "adopt":
+		obj.log("INFO", "Object already exists. Taking it into state as it is (create_conflict_behavior=adopt)", map[string]interface{}{"id": obj.id})
+		if err := obj.readObject(); err != nil {
+			return err
+		}
+		if obj.id == "" {
+			return fmt.Errorf("%v (it could not be read)", existsErr)
+		}
+		return nil
+	}
+	return fmt.Errorf("%v; set create_conflict_behavior to update or adopt to manage it", existsErr)
+}
+
+/*
+Called when create_conflict_behavior is update and the object
+
+	already exists. Update it in place instead of failing the create
+*/
+func (obj *APIObject) updateExistingObject(createErr error) error {
+	if obj.id == "" {
+		return fmt.Errorf("%v (the object already exists, but its id is not known so it cannot be updated)", createErr)
+	}
+	obj.log("INFO", "Object already exists. Updating it instead (create_conflict_behavior=update)", map[string]interface{}{"id": obj.id})
+
+	/* Read first so copy_keys can be honored */
+	err := obj.readObject()
+	if err != nil {
+		return err
+	}
+	if obj.id == "" {
+		return fmt.Errorf("%v (the object already exists, but it could not be read)", createErr)
+	}
+	return obj.updateObject()
+}
+
+/*
+Called when create_conflict_behavior is adopt and the object
+
+	already exists. Find it with adopt_search and manage it from now on
+*/
+func (obj *APIObject) adoptExistingObject(createErr error) error {
+	searchKey := obj.adoptSearch["search_key"]
+	if searchKey == "" {
+		return fmt.Errorf("%v (the object already exists, but adopt_search has no search_key to find it by)", createErr)
+	}
+
+	/* Unless told otherwise, look for the value the object was created with */
+	searchValue := obj.adoptSearch["search_value"]
+	if searchValue == "" {
+		var err error
+		searchValue, err = GetStringAtKey(obj.data, searchKey, obj.debug)
+		if err != nil {
+			return fmt.Errorf("%v (the object already exists, but '%s' could not be found in data to search for it: %s)", createErr, searchKey, err)
+		}
+	}
+
+	_, err := obj.findObject(obj.adoptSearch["query_string"], searchKey, searchValue, obj.adoptSearch["results_key"])
+	if err != nil {
+		return fmt.Errorf("%v (the object already exists, but it could not be found: %s)", createErr, err)
+	}
+	obj.log("INFO", "Adopted existing object (create_conflict_behavior=adopt)", map[string]interface{}{"id": obj.id})
+
+	return obj.readObject()
+}
+
 func (obj *APIObject) readObject() error {
 	if obj.id == "" {
 		return fmt.Errorf("cannot read an object unless the ID has been set")
@@ -316,32 +1241,53 @@ func (obj *APIObject) readObject() error {
 	getPath := obj.getPath
 	if obj.queryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+			obj.log("DEBUG", "Adding query string", map[string]interface{}{"query_string": obj.queryString})
 		}
-		getPath = fmt.Sprintf("%s?%s", obj.getPath, obj.queryString)
+		getPath = appendQueryString(obj.getPath, obj.queryString)
 	}
 
-	resultString, err := obj.apiClient.sendRequest(obj.readMethod, strings.Replace(getPath, "{id}", obj.id, -1), "")
+	/* For APIs whose reads are POST calls, such as a describe or search */
+	readData := obj.expandPlaceholders(obj.readData)
+	if obj.debug && readData != "" {
+		obj.log("DEBUG", "Using read data", map[string]interface{}{"read_data": obj.apiClient.logBody(readData)})
+	}
+
+	resultString, _, err := obj.send(obj.readMethod, obj.expandPath(getPath), readData, obj.requestHeaders("read", nil))
 	if err != nil {
-		if strings.Contains(err.Error(), "unexpected response code '404'") {
-			log.Printf("api_object.go: 404 error while refreshing state for '%s' at path '%s'. Removing from state.", obj.id, obj.getPath)
+		if code := responseCode(err); obj.isGoneStatusCode(code) {
+			obj.log("INFO", "Object is gone. Removing from state", map[string]interface{}{"status_code": code, "id": obj.id, "path": obj.getPath})
 			obj.id = ""
 			return nil
 		}
 		return err
 	}
 
+	if obj.isEmptyReadResponse(resultString) && obj.readSearch["search_key"] == "" {
+		switch obj.readEmptyResponse {
+		case "gone":
+			obj.log("INFO", "Empty response while refreshing state. Removing from state", map[string]interface{}{"id": obj.id, "path": obj.getPath})
+			obj.id = ""
+			return nil
+		case "keep":
+			obj.log("INFO", "Empty response while refreshing state. Keeping the last known state", map[string]interface{}{"id": obj.id, "path": obj.getPath})
+			obj.apiData = obj.priorAPIData
+			obj.apiResponse = obj.priorResponse
+			return nil
+		}
+		return fmt.Errorf("the API returned an empty response when reading '%s' at path '%s'; set read_empty_response to handle this", obj.id, obj.getPath)
+	}
+
 	searchKey := obj.readSearch["search_key"]
 	searchValue := obj.readSearch["search_value"]
 
 	if searchKey != "" && searchValue != "" {
 
-		obj.searchPath = strings.Replace(obj.getPath, "{id}", obj.id, -1)
+		obj.searchPath = obj.expandPath(obj.getPath)
 
 		queryString := obj.readSearch["query_string"]
 		if obj.queryString != "" {
 			if obj.debug {
-				log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+				obj.log("DEBUG", "Adding query string", map[string]interface{}{"query_string": obj.queryString})
 			}
 			queryString = fmt.Sprintf("%s&%s", obj.readSearch["query_string"], obj.queryString)
 		}
@@ -352,85 +1298,342 @@ func (obj *APIObject) readObject() error {
 			return nil
 		}
 		objFoundString, _ := json.Marshal(objFound)
-		return obj.updateState(string(objFoundString))
+		resultString = string(objFoundString)
+	} else if strings.HasPrefix(strings.TrimSpace(resultString), "[") {
+		/* Some APIs answer reads with a list, even of one object */
+		element, found, err := obj.selectFromArray(resultString)
+		if err != nil {
+			return err
+		}
+		if !found {
+			obj.log("INFO", "Object is not in the list returned from its path. Removing from state", map[string]interface{}{"id": obj.id, "path": obj.getPath})
+			obj.id = ""
+			return nil
+		}
+		resultString = element
 	}
 
-	return obj.updateState(resultString)
+	err = obj.updateState(resultString)
+	if err == nil && obj.isMarkedDeleted() {
+		obj.log("INFO", "deleted_key marks the object as deleted. Removing from state", map[string]interface{}{"deleted_key": obj.deletedKey, "id": obj.id, "path": obj.getPath})
+		obj.id = ""
+	}
+	return err
 }
 
-func (obj *APIObject) updateObject() error {
-	if obj.id == "" {
-		return fmt.Errorf("cannot update an object unless the ID has been set")
+/*
+Find the object in a read response that is a JSON array: the element
+
+	whose id (see id_attribute) is the object's id, or the only element
+	when it has no id to tell. An only element with another id is some
+	other object, so the object is not found
+*/
+func (obj *APIObject) selectFromArray(resultString string) (string, bool, error) {
+	var elements []interface{}
+	if err := decodeJSON([]byte(resultString), &elements); err != nil {
+		return "", false, err
+	}
+
+	for _, element := range elements {
+		hash, ok := element.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, err := obj.idFrom(hash); err == nil && id == obj.id {
+			b, _ := json.Marshal(hash)
+			return string(b), true, nil
+		}
+	}
+
+	if len(elements) == 1 {
+		if hash, ok := elements[0].(map[string]interface{}); ok && !obj.hasID(hash) {
+			b, _ := json.Marshal(hash)
+			return string(b), true, nil
+		}
 	}
+	return "", false, nil
+}
+
+/* Whether data has an id (see id_attribute) at all */
+func (obj *APIObject) hasID(data map[string]interface{}) bool {
+	_, err := obj.idFrom(data)
+	return err == nil
+}
 
-	b, _ := json.Marshal(obj.data)
+/* Whether the API returned the object, but deleted_key says it has been deleted */
+func (obj *APIObject) isMarkedDeleted() bool {
+	if obj.deletedKey == "" {
+		return false
+	}
+	value, err := GetStringAtKey(obj.apiData, obj.deletedKey, obj.debug)
+	if err != nil {
+		return false
+	}
+	for _, deleted := range obj.deletedValues {
+		if value == deleted {
+			return true
+		}
+	}
+	return false
+}
 
-	updateData, _ := json.Marshal(obj.updateData)
+/*
+The path (before placeholders are filled), body and extra headers of
+
+	the request to update the object
+*/
+func (obj *APIObject) updateRequest() (string, []byte, map[string]string, error) {
+	/* create_only_keys are never sent again */
+	data := omitKeys(obj.data, obj.createOnlyKeys)
+	b, _ := json.Marshal(obj.resolveNulls(data))
+	var headers map[string]string
+
+	/* Keep the server's defaults when sending the whole object */
+	if obj.mergeServerDefaults && obj.updateMode != "merge_patch" {
+		server := obj.apiData
+		if len(server) == 0 {
+			/* The state has sensitive_keys masked, which must not be sent back over the real values */
+			server = stripServerKeys(map[string]interface{}{}, obj.priorAPIData, obj.sensitiveKeys)
+		}
+		b, _ = json.Marshal(obj.resolveNulls(omitKeys(mergeServerDefaults(obj.data, obj.priorData, server), obj.createOnlyKeys)))
+		if obj.debug {
+			obj.log("DEBUG", "Using data merged with server defaults", map[string]interface{}{"data": obj.apiClient.logBody(string(b))})
+		}
+	}
+
+	/* Only send what changed since the last apply */
+	if obj.updateMode == "merge_patch" {
+		b, _ = json.Marshal(mergePatch(obj.resolveNulls(omitKeys(obj.priorData, obj.createOnlyKeys)), obj.resolveNulls(data)))
+		headers = map[string]string{"Content-Type": "application/merge-patch+json"}
+		if obj.debug {
+			obj.log("DEBUG", "Using merge patch", map[string]interface{}{"patch": obj.apiClient.logBody(string(b))})
+		}
+	}
+
+	updateData, _ := json.Marshal(obj.resolveNulls(obj.expandDataPlaceholders(obj.updateData)))
 	if string(updateData) != "{}" {
 		if obj.debug {
-			log.Printf("api_object.go: Using update data '%s'", string(updateData))
+			obj.log("DEBUG", "Using update data", map[string]interface{}{"update_data": obj.apiClient.logBody(string(updateData))})
 		}
 		b = updateData
 	}
+	b = obj.withVersion(b)
+
+	if body, ok, err := obj.renderBodyTemplate("update"); ok {
+		if err != nil {
+			return "", nil, nil, err
+		}
+		b = []byte(body)
+	}
 
 	putPath := obj.putPath
 	if obj.queryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+			obj.log("DEBUG", "Adding query string", map[string]interface{}{"query_string": obj.queryString})
 		}
-		putPath = fmt.Sprintf("%s?%s", obj.putPath, obj.queryString)
+		putPath = appendQueryString(obj.putPath, obj.queryString)
 	}
+	return putPath, b, headers, nil
+}
+
+func (obj *APIObject) updateObject() (err error) {
+	if obj.id == "" {
+		return fmt.Errorf("cannot update an object unless the ID has been set")
+	}
+
+	auditPath, auditCode := "", 0
+	defer func() { obj.audit("update", obj.id, obj.updateMethod, auditPath, auditCode, err) }()
 
-	resultString, err := obj.apiClient.sendRequest(obj.updateMethod, strings.Replace(putPath, "{id}", obj.id, -1), string(b))
+	if err = obj.runHooks("pre_update"); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			err = obj.runHooks("post_update")
+		}
+	}()
+
+	/* The state has sensitive_keys masked, so merge with what the server really has */
+	if obj.mergeServerDefaults && obj.updateMode != "merge_patch" && len(obj.apiData) == 0 && len(obj.sensitiveKeys) > 0 {
+		if err = obj.readObject(); err != nil {
+			return err
+		}
+	}
+
+	putPath, b, headers, err := obj.updateRequest()
 	if err != nil {
 		return err
 	}
 
-	if obj.apiClient.writeReturnsObject {
+	resultString, resp, err := obj.send(obj.updateMethod, obj.expandPath(putPath), string(b), obj.requestHeaders("update", headers))
+	auditPath, auditCode = obj.expandPath(putPath), obj.lastStatusCode
+	isObject, err := obj.checkStatus("update", resp, err)
+	if err != nil {
+		return obj.versionConflict("update", err)
+	}
+	if !isObject {
+		resultString = ""
+	}
+
+	/* An async update responds with the operation rather than the object */
+	returnsObject := obj.apiClient.writeReturnsObject
+	if isObject && obj.asyncFor("update", resp) != nil {
+		resultString, err = obj.waitForOperation("update", resp, resultString)
+		if err != nil {
+			return err
+		}
+		returnsObject = resultString != ""
+	}
+
+	/* An empty response (such as 204 No Content) has nothing to parse */
+	if returnsObject && !isEmptyResponse(resultString) {
 		if obj.debug {
-			log.Printf("api_object.go: Parsing response from PUT to update internal structures (write_returns_object=true)...\n")
+			obj.log("DEBUG", "Parsing response from PUT to update internal structures (write_returns_object=true)", nil)
 		}
 		err = obj.updateState(resultString)
 	} else {
 		if obj.debug {
-			log.Printf("api_object.go: Requesting updated object from API (write_returns_object=false)...\n")
+			obj.log("DEBUG", "Requesting updated object from API (write_returns_object=false)", nil)
 		}
 		err = obj.readObject()
 	}
 	return err
 }
 
-func (obj *APIObject) deleteObject() error {
+func (obj *APIObject) deleteObject() (err error) {
 	if obj.id == "" {
-		log.Printf("WARNING: Attempting to delete an object that has no id set. Assuming this is OK.\n")
+		obj.log("WARN", "Attempting to delete an object that has no id set. Assuming this is OK", nil)
 		return nil
 	}
 
+	/* waitForDestroy clears the id, so remember it for the post_destroy hooks */
+	id := obj.id
+	auditPath, auditCode := "", 0
+	defer func() { obj.audit("destroy", id, obj.destroyMethod, auditPath, auditCode, err) }()
+
+	if err = obj.runHooks("pre_destroy"); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			obj.id = id
+			err = obj.runHooks("post_destroy")
+		}
+	}()
+
 	deletePath := obj.deletePath
 	if obj.queryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", obj.queryString)
+			obj.log("DEBUG", "Adding query string", map[string]interface{}{"query_string": obj.queryString})
+		}
+		deletePath = appendQueryString(obj.deletePath, obj.queryString)
+	}
+
+	/* Some APIs refuse to delete protected objects until protection is switched off */
+	if obj.disableProtection["path"] != "" {
+		method := obj.disableProtection["method"]
+		if method == "" {
+			method = obj.updateMethod
+		}
+		disablePath := obj.expandPath(obj.disableProtection["path"])
+		obj.log("INFO", "Disabling deletion protection", map[string]interface{}{"id": obj.id, "method": method, "path": disablePath})
+		if _, _, err := obj.exchange(method, disablePath, obj.disableProtection["data"], obj.requestHeaders("", nil)); err != nil {
+			return fmt.Errorf("failed to disable deletion protection before deleting '%s': %v", obj.id, err)
 		}
-		deletePath = fmt.Sprintf("%s?%s", obj.deletePath, obj.queryString)
 	}
 
 	b := []byte{}
-	destroyData, _ := json.Marshal(obj.destroyData)
+	destroyData, _ := json.Marshal(obj.resolveNulls(obj.expandDataPlaceholders(obj.destroyData)))
 	if string(destroyData) != "{}" {
 		if obj.debug {
-			log.Printf("api_object.go: Using destroy data '%s'", string(destroyData))
+			obj.log("DEBUG", "Using destroy data", map[string]interface{}{"destroy_data": obj.apiClient.logBody(string(destroyData))})
 		}
-		b = destroyData
+		b = obj.withVersion(destroyData)
 	}
 
-	_, err := obj.apiClient.sendRequest(obj.destroyMethod, strings.Replace(deletePath, "{id}", obj.id, -1), string(b))
+	if body, ok, err := obj.renderBodyTemplate("destroy"); ok {
+		if err != nil {
+			return err
+		}
+		b = []byte(body)
+	}
+
+	resultString, resp, err := obj.send(obj.destroyMethod, obj.expandPath(deletePath), string(b), obj.requestHeaders("destroy", nil))
+	auditPath, auditCode = obj.expandPath(deletePath), obj.lastStatusCode
+	isObject, err := obj.checkStatus("destroy", resp, err)
 	if err != nil {
-		return err
+		return obj.versionConflict("destroy", err)
+	}
+
+	/* Accepted error codes (such as 404 for objects that are already gone) start no operation */
+	if isObject {
+		if _, err := obj.waitForOperation("destroy", resp, resultString); err != nil {
+			return err
+		}
 	}
 
+	if obj.destroyWaitTimeout > 0 {
+		return obj.waitForDestroy()
+	}
 	return nil
 }
 
+/*
+Poll the object until reading it says it is gone (see gone_status_codes
+
+	and deleted_key) for APIs that delete objects asynchronously
+*/
+func (obj *APIObject) waitForDestroy() error {
+	id := obj.id
+	pollInterval := time.Duration(obj.destroyPollInterval) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(obj.destroyWaitTimeout) * time.Second)
+
+	for {
+		err := obj.readObject()
+		if err != nil {
+			return err
+		}
+		if obj.id == "" {
+			return nil
+		}
+		if time.Now().Add(pollInterval).After(deadline) {
+			return fmt.Errorf("timed out after %d seconds waiting for '%s' to be deleted", obj.destroyWaitTimeout, id)
+		}
+		if obj.debug {
+			obj.log("DEBUG", "Object still exists. Checking again", map[string]interface{}{"id": id, "next_check": pollInterval.String()})
+		}
+		if err := sleepContext(obj.context(), pollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+/* Whether a read responding with code means the object no longer exists */
+func (obj *APIObject) isGoneStatusCode(code int) bool {
+	if len(obj.goneStatusCodes) == 0 {
+		return code == http.StatusNotFound
+	}
+	for _, gone := range obj.goneStatusCodes {
+		if code == gone {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Whether a delete responding with code means the object is already gone.
+
+	Only 404 and 410 from gone_status_codes count: codes like 400 or 403
+	mean an object is gone when reading it, but a refused delete otherwise
+*/
+func (obj *APIObject) isDeletedStatusCode(code int) bool {
+	return (code == http.StatusNotFound || code == http.StatusGone) && obj.isGoneStatusCode(code)
+}
+
 func (obj *APIObject) findObject(queryString string, searchKey string, searchValue string, resultsKey string) (map[string]interface{}, error) {
 	var objFound map[string]interface{}
 	var dataArray []interface{}
@@ -442,15 +1645,15 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 	searchPath := obj.searchPath
 	if queryString != "" {
 		if obj.debug {
-			log.Printf("api_object.go: Adding query string '%s'", queryString)
+			obj.log("DEBUG", "Adding query string", map[string]interface{}{"query_string": queryString})
 		}
-		searchPath = fmt.Sprintf("%s?%s", obj.searchPath, queryString)
+		searchPath = appendQueryString(obj.searchPath, queryString)
 	}
 
 	if obj.debug {
-		log.Printf("api_object.go: Calling API on path '%s'", searchPath)
+		obj.log("DEBUG", "Calling API to search", map[string]interface{}{"path": searchPath})
 	}
-	resultString, err := obj.apiClient.sendRequest(obj.apiClient.readMethod, searchPath, "")
+	resultString, _, err := obj.send(obj.apiClient.readMethod, searchPath, "", obj.requestHeaders("read", nil))
 	if err != nil {
 		return objFound, err
 	}
@@ -459,10 +1662,10 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 	   Parse it seeking JSON data
 	*/
 	if obj.debug {
-		log.Printf("api_object.go: Response received... parsing")
+		obj.log("DEBUG", "Response received... parsing", nil)
 	}
 	var result interface{}
-	err = json.Unmarshal([]byte(resultString), &result)
+	err = decodeJSON([]byte(resultString), &result)
 	if err != nil {
 		return objFound, err
 	}
@@ -471,7 +1674,7 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 		var tmp interface{}
 
 		if obj.debug {
-			log.Printf("api_object.go: Locating '%s' in the results", resultsKey)
+			obj.log("DEBUG", "Locating results_key in the results", map[string]interface{}{"results_key": resultsKey})
 		}
 
 		/* First verify the data we got back is a hash */
@@ -488,7 +1691,7 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 		}
 	} else {
 		if obj.debug {
-			log.Printf("api_object.go: results_key is not set - coaxing data to array of interfaces")
+			obj.log("DEBUG", "results_key is not set - coaxing data to array of interfaces", nil)
 		}
 		if dataArray, ok = result.([]interface{}); !ok {
 			return objFound, fmt.Errorf("api_object.go: The results of a GET to '%s' did not return an array. It is a '%s'. Perhaps you meant to add a results_key?", searchPath, reflect.TypeOf(result))
@@ -504,8 +1707,7 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 		}
 
 		if obj.debug {
-			log.Printf("api_object.go: Examining %v", hash)
-			log.Printf("api_object.go:   Comparing '%s' to the value in '%s'", searchValue, searchKey)
+			obj.log("DEBUG", "Examining search result", map[string]interface{}{"result": fmt.Sprintf("%v", hash), "search_key": searchKey, "search_value": searchValue})
 		}
 
 		tmp, err := GetStringAtKey(hash, searchKey, obj.debug)
@@ -516,18 +1718,18 @@ func (obj *APIObject) findObject(queryString string, searchKey string, searchVal
 		/* We found our record */
 		if tmp == searchValue {
 			objFound = hash
-			obj.id, err = GetStringAtKey(hash, obj.idAttribute, obj.debug)
+			obj.id, err = obj.idFrom(hash)
 			if err != nil {
-				return objFound, (fmt.Errorf("failed to find id_attribute '%s' in the record: %s", obj.idAttribute, err))
+				return objFound, (fmt.Errorf("failed to find %s in the record: %s", obj.idDescription(), err))
 			}
 
 			if obj.debug {
-				log.Printf("api_object.go: Found ID '%s'", obj.id)
+				obj.log("DEBUG", "Found id", map[string]interface{}{"id": obj.id})
 			}
 
 			/* But there is no id attribute??? */
 			if obj.id == "" {
-				return objFound, (fmt.Errorf(fmt.Sprintf("The object for '%s'='%s' did not have the id attribute '%s', or the value was empty.", searchKey, searchValue, obj.idAttribute)))
+				return objFound, (fmt.Errorf(fmt.Sprintf("The object for '%s'='%s' did not have %s, or the value was empty.", searchKey, searchValue, obj.idDescription())))
 			}
 			break
 		}
@@ -3,7 +3,13 @@ package restapi
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
@@ -312,3 +318,865 @@ func TestAPIObject(t *testing.T) {
 		log.Println("api_object_test.go: Done")
 	}
 }
+
+func TestAPIObjectMergePatchUpdate(t *testing.T) {
+	var method, contentType, body string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, contentType, body = r.Method, r.Header.Get("Content-Type"), string(b)
+		w.Write([]byte(`{"id":"1","name":"new","tags":{"a":"1"},"server_managed":true}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, err := NewAPIObject(client, &apiObjectOpts{
+		path:         "/api/objects",
+		updateMethod: "PATCH",
+		updateMode:   "merge_patch",
+		id:           "1",
+		priorData:    `{"id":"1","name":"old","tags":{"a":"1","b":"2"}}`,
+		data:         `{"id":"1","name":"new","tags":{"a":"1"}}`,
+	})
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if method != "PATCH" || contentType != "application/merge-patch+json" {
+		t.Fatalf("api_object_test.go: Expected a PATCH with a merge patch content type but got %s with '%s'", method, contentType)
+	}
+	if body != `{"name":"new","tags":{"b":null}}` {
+		t.Fatalf("api_object_test.go: Expected only the changed keys to be sent but got %s", body)
+	}
+}
+
+func TestAPIObjectMergeServerDefaultsSensitive(t *testing.T) {
+	var sent string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			b, _ := io.ReadAll(r.Body)
+			sent = string(b)
+		}
+		w.Write([]byte(`{"id":"1","name":"old","region":"eu","credentials":{"user":"admin","password":"hunter2"}}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	opts := func() *apiObjectOpts {
+		return &apiObjectOpts{
+			path:                "/api/objects",
+			id:                  "1",
+			mergeServerDefaults: true,
+			sensitiveKeys:       []string{"credentials/password"},
+			priorData:           `{"id":"1","name":"old"}`,
+			/* As stored in state, with the sensitive key masked */
+			priorResponse: `{"id":"1","name":"old","region":"eu","credentials":{"user":"admin","password":"(sensitive value)"}}`,
+			data:          `{"id":"1","name":"new"}`,
+		}
+	}
+
+	/* Updates read the real values first */
+	object, err := NewAPIObject(client, opts())
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if sent != `{"credentials":{"password":"hunter2","user":"admin"},"id":"1","name":"new","region":"eu"}` {
+		t.Fatalf("api_object_test.go: Expected the server defaults with the real password but sent %s", sent)
+	}
+
+	/* Without a read (as for planned_request), the masked value is left out rather than sent */
+	object, _ = NewAPIObject(client, opts())
+	_, body, _, _ := object.updateRequest()
+	if strings.Contains(string(body), "sensitive value") {
+		t.Fatalf("api_object_test.go: Expected the masked password to be left out but got %s", body)
+	}
+}
+
+func TestAPIObjectCreateConflictUpdate(t *testing.T) {
+	var methods []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == "POST" {
+			http.Error(w, `{"error":"already exists"}`, http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"id":"settings","enabled":true}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	for _, behavior := range []string{"", "update"} {
+		methods = nil
+		object, err := NewAPIObject(client, &apiObjectOpts{
+			path:                   "/api/settings",
+			createConflictBehavior: behavior,
+			data:                   `{"id":"settings","enabled":true}`,
+		})
+		if err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+
+		err = object.createObject()
+		if behavior == "" {
+			if responseCode(err) != http.StatusConflict {
+				t.Fatalf("api_object_test.go: Expected the conflict to be returned by default but got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("api_object_test.go: Expected the existing object to be updated: %s", err)
+		}
+		if fmt.Sprint(methods) != "[POST GET PUT GET]" {
+			t.Fatalf("api_object_test.go: Expected the object to be read and updated after the conflict but got %v", methods)
+		}
+	}
+}
+
+func TestAPIObjectCreateConflictAdopt(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			http.Error(w, `{"error":"name already taken"}`, http.StatusUnprocessableEntity)
+		case r.URL.Path == "/api/objects":
+			w.Write([]byte(`{"items":[{"id":"6","name":"other"},{"id":"7","name":"mine"}]}`))
+		case r.URL.Path == "/api/objects/7":
+			w.Write([]byte(`{"id":"7","name":"mine"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, createReturnsObject: true})
+	object, err := NewAPIObject(client, &apiObjectOpts{
+		path:                   "/api/objects",
+		createConflictBehavior: "adopt",
+		adoptSearch:            map[string]string{"search_key": "name", "results_key": "items"},
+		data:                   `{"name":"mine"}`,
+	})
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: Expected the existing object to be adopted: %s", err)
+	}
+	if object.id != "7" || object.apiData["name"] != "mine" {
+		t.Fatalf("api_object_test.go: Expected object 7 to be adopted but got '%s' with %v", object.id, object.apiData)
+	}
+}
+
+func TestAPIObjectGoneStatusCodes(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	for _, codes := range [][]int{nil, {404, 410}} {
+		object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", goneStatusCodes: codes})
+		err := object.readObject()
+		if codes == nil {
+			if err == nil {
+				t.Fatalf("api_object_test.go: Expected 410 to be an error when gone_status_codes is not set")
+			}
+			continue
+		}
+		if err != nil || object.id != "" {
+			t.Fatalf("api_object_test.go: Expected 410 to remove the object but got id '%s' and error %v", object.id, err)
+		}
+	}
+}
+
+func TestAPIObjectDeletedKey(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/objects/1" {
+			w.Write([]byte(`{"id":"1","meta":{"state":"ACTIVE"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"2","meta":{"state":"DELETED"}}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	for id, expected := range map[string]string{"1": "1", "2": ""} {
+		object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: id, deletedKey: "meta/state", deletedValues: []string{"DELETED", "PURGED"}})
+		if err := object.readObject(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+		if object.id != expected {
+			t.Fatalf("api_object_test.go: Expected id '%s' after reading object %s but got '%s'", expected, id, object.id)
+		}
+	}
+}
+
+func TestAPIObjectDestroyWait(t *testing.T) {
+	var reads int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		/* Deletion finishes in the background on the third read */
+		if atomic.AddInt32(&reads, 1) < 3 {
+			w.Write([]byte(`{"id":"1","status":"DELETING"}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", destroyWaitTimeout: 10, destroyPollInterval: 1})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if reads != 3 {
+		t.Fatalf("api_object_test.go: Expected the object to be read until it was gone but got %d reads", reads)
+	}
+
+	/* Never goes away */
+	atomic.StoreInt32(&reads, -100)
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", destroyWaitTimeout: 1, destroyPollInterval: 1})
+	if err := object.deleteObject(); err == nil {
+		t.Fatalf("api_object_test.go: Expected waiting for the object to be deleted to time out")
+	}
+}
+
+func TestAPIObjectDisableProtection(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests = append(requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, b))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:              "/api/objects",
+		id:                "1",
+		disableProtection: map[string]string{"path": "/api/objects/{id}/protection", "method": "PATCH", "data": `{"enabled":false}`},
+	})
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if fmt.Sprint(requests) != `[PATCH /api/objects/1/protection {"enabled":false} DELETE /api/objects/1 ]` {
+		t.Fatalf("api_object_test.go: Expected protection to be disabled before deleting but got %v", requests)
+	}
+}
+
+func TestAPIObjectIDFromHeader(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.Header().Set("Location", "/api/objects/42/?view=full")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Write([]byte(`{"id":"42","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, createReturnsObject: true})
+	for regex, expected := range map[string]string{"": "42", `objects/(\d+)`: "42", `\d+`: "42"} {
+		object, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", idFromHeader: "Location", idFromHeaderRegex: regex, data: `{"name":"Foo"}`})
+		if err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+		if err := object.createObject(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+		if object.id != expected || object.apiData["name"] != "Foo" {
+			t.Fatalf("api_object_test.go: Expected id '%s' from the Location header but got '%s' with %v", expected, object.id, object.apiData)
+		}
+	}
+
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", idFromHeader: "X-Object-Id", data: `{"name":"Foo"}`})
+	if err := object.createObject(); err == nil {
+		t.Fatalf("api_object_test.go: Expected an error when the id header is missing")
+	}
+}
+
+func TestAPIObjectIDTemplate(t *testing.T) {
+	var paths []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{"org_id":"acme","name":"bob","meta":{"version":3}}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, createReturnsObject: true})
+	opts := &apiObjectOpts{
+		path:       "/orgs/users",
+		getPath:    "/orgs/{org_id}/users/{name}",
+		idTemplate: "{org_id}:{name}@{meta/version}",
+	}
+	object, _ := NewAPIObject(client, opts)
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if object.id != "acme:bob@3" {
+		t.Fatalf("api_object_test.go: Expected id 'acme:bob@3' from id_template but got '%s'", object.id)
+	}
+
+	/* As after an import, where only the id is known */
+	opts.id = "acme:bob@3"
+	object, _ = NewAPIObject(client, opts)
+	if err := object.readObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if fmt.Sprint(paths) != "[POST /orgs/users GET /orgs/acme/users/bob]" {
+		t.Fatalf("api_object_test.go: Expected the id_template keys to be used in paths but got %v", paths)
+	}
+}
+
+func TestAPIObjectPathPlaceholders(t *testing.T) {
+	var paths []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{"id":"9","name":"foo","tenant":{"id":"t1"},"zone":"z1"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, createReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:    "/tenants/{tenant/id}/rules",
+		putPath: "/zones/{zone}/rules/{name}",
+		data:    `{"name":"foo","tenant":{"id":"t1"}}`,
+	})
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	/* A later run only knows the server's keys from the last response in state */
+	object, _ = NewAPIObject(client, &apiObjectOpts{
+		path:          "/tenants/{tenant/id}/rules",
+		getPath:       "/zones/{zone}/rules/{id}",
+		id:            "9",
+		priorResponse: `{"zone":"z1"}`,
+	})
+	if err := object.readObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	if fmt.Sprint(paths) != "[POST /tenants/t1/rules PUT /zones/z1/rules/foo GET /tenants/t1/rules/9 GET /zones/z1/rules/9]" {
+		t.Fatalf("api_object_test.go: Expected placeholders to be replaced in paths but got %v", paths)
+	}
+}
+
+func TestAPIObjectBodyTemplate(t *testing.T) {
+	var bodies []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.Method+" "+string(b))
+		w.Write([]byte(`{"id":"1","version":4}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, err := NewAPIObject(client, &apiObjectOpts{
+		path: "/api/objects",
+		data: `{"id":"1","name":"Foo","tags":["a"]}`,
+		bodyTemplates: map[string]string{
+			"create":  `{"item": {"name": {{ json .data.name }}, "tags": {{ json .data.tags }}}}`,
+			"update":  `{"id": "{{ .id }}", "name": {{ json .data.name }}, "version": {{ .api_data.version }}}`,
+			"destroy": `action=delete&id={{ .id }}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	for _, operation := range []func() error{object.createObject, object.updateObject, object.deleteObject} {
+		if err := operation(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+	}
+	expected := `[POST {"item": {"name": "Foo", "tags": ["a"]}} PUT {"id": "1", "name": "Foo", "version": 4} DELETE action=delete&id=1]`
+	if fmt.Sprint(bodies) != expected {
+		t.Fatalf("api_object_test.go: Expected bodies from the templates but got %v", bodies)
+	}
+
+	/* The provider's environment, with its credentials, is not readable */
+	if _, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", bodyTemplates: map[string]string{"update": `{{ env "REST_API_PASSWORD" }}`}}); err == nil {
+		t.Fatalf("api_object_test.go: Expected an error for a template that uses env")
+	}
+
+	/* Mistakes are reported rather than sent */
+	if _, err := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", bodyTemplates: map[string]string{"create": "{{ .data"}}); err == nil {
+		t.Fatalf("api_object_test.go: Expected an error for a template that does not parse")
+	}
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", bodyTemplates: map[string]string{"update": "{{ .nope }}"}})
+	if err := object.updateObject(); err == nil {
+		t.Fatalf("api_object_test.go: Expected an error for a template that uses a missing key")
+	}
+}
+
+func TestAPIObjectHeaders(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, fmt.Sprintf("%s %s %s %s", r.Method, r.Header.Get("X-Tenant"), r.Header.Get("Accept"), r.Header.Get("Prefer")))
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, headers: map[string]string{"X-Tenant": "provider", "Accept": "application/json"}})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:    "/api/objects",
+		data:    `{"id":"1"}`,
+		headers: map[string]string{"X-Tenant": "object"},
+		operationHeaders: map[string]map[string]string{
+			"create": {"Prefer": "return=minimal"},
+			"read":   {"Accept": "application/vnd.object+json"},
+		},
+	})
+	for _, operation := range []func() error{object.createObject, object.deleteObject} {
+		if err := operation(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+	}
+	expected := "[POST object application/json return=minimal GET object application/vnd.object+json  DELETE object application/json ]"
+	if fmt.Sprint(requests) != expected {
+		t.Fatalf("api_object_test.go: Expected object and operation headers over the provider's but got %v", requests)
+	}
+}
+
+func TestAPIObjectMethodOverrides(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, createMethod: "PUT", updateMethod: "PATCH", destroyMethod: "DELETE", writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:          "/api/objects",
+		putPath:       "/api/objects/{id}/update",
+		deletePath:    "/api/objects/{id}?action=delete",
+		queryString:   "v=2",
+		createMethod:  "POST",
+		updateMethod:  "POST",
+		destroyMethod: "POST",
+		data:          `{"id":"1"}`,
+	})
+	for _, operation := range []func() error{object.createObject, object.updateObject, object.deleteObject} {
+		if err := operation(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+	}
+	expected := "[POST /api/objects?v=2 POST /api/objects/1/update?v=2 POST /api/objects/1?action=delete&v=2]"
+	if fmt.Sprint(requests) != expected {
+		t.Fatalf("api_object_test.go: Expected the object's methods and paths to be used but got %v", requests)
+	}
+}
+
+func TestAPIObjectExtractOutputs(t *testing.T) {
+	client, _ := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:8081/", timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path: "/api/objects",
+		id:   "1",
+		extract: map[string]string{
+			"ip_address": "$.interfaces[?(@.primary)].ip",
+			"port":       "config/port",
+			"tags":       "$.tags",
+			"missing":    "$.nope",
+		},
+	})
+	if err := object.updateState(`{"interfaces":[{"ip":"10.0.0.1"},{"ip":"10.0.0.2","primary":true}],"config":{"port":8080},"tags":["a","b"]}`); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	outputs := object.extractOutputs()
+	expected := map[string]string{"ip_address": "10.0.0.2", "port": "8080", "tags": `["a","b"]`}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Fatalf("api_object_test.go: Expected outputs %v but got %v", expected, outputs)
+	}
+}
+
+func TestAPIObjectNumericPrecision(t *testing.T) {
+	var body string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.Write(b)
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":12345678901234567891,"rate":0.10000000000000000001}`})
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if body != `{"id":12345678901234567891,"rate":0.10000000000000000001}` {
+		t.Fatalf("api_object_test.go: Expected numbers to be sent as they are but got %s", body)
+	}
+	if object.id != "12345678901234567891" {
+		t.Fatalf("api_object_test.go: Expected the exact id but got %s", object.id)
+	}
+}
+
+func TestAPIObjectNullValues(t *testing.T) {
+	var bodies []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	data := `{"id":"1","nickname":null,"manager":"__null__","meta":{"note":null,"tags":["a","__null__"]}}`
+	for _, opts := range []*apiObjectOpts{
+		{path: "/api/objects", data: data},
+		{path: "/api/objects", data: data, omitNullKeys: true},
+		{path: "/api/objects", data: data, omitNullKeys: true, nullValue: "__null__"},
+	} {
+		object, _ := NewAPIObject(client, opts)
+		if err := object.createObject(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+	}
+
+	expected := []string{
+		`{"id":"1","manager":"__null__","meta":{"note":null,"tags":["a","__null__"]},"nickname":null}`,
+		`{"id":"1","manager":"__null__","meta":{"tags":["a","__null__"]}}`,
+		`{"id":"1","manager":null,"meta":{"tags":["a",null]}}`,
+	}
+	if !reflect.DeepEqual(bodies, expected) {
+		t.Fatalf("api_object_test.go: Expected bodies %v but got %v", expected, bodies)
+	}
+}
+
+func TestAPIObjectReadArrayResponse(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/objects/single":
+			w.Write([]byte(`[{"name":"only"}]`))
+		case "/api/objects/other":
+			w.Write([]byte(`[{"id":"9","name":"nine"}]`))
+		default:
+			w.Write([]byte(`[{"id":"1","name":"one"},{"id":"2","name":"two"}]`))
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	for id, expected := range map[string]string{"2": "two", "single": "only", "3": "", "other": ""} {
+		object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: id})
+		if err := object.readObject(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+		if expected == "" {
+			if object.id != "" {
+				t.Fatalf("api_object_test.go: Expected object %s to be removed when it is not in the list", id)
+			}
+			continue
+		}
+		if object.apiData["name"] != expected {
+			t.Fatalf("api_object_test.go: Expected to read '%s' for object %s but got %v", expected, id, object.apiData)
+		}
+	}
+}
+
+func TestAPIObjectEmptyResponses(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		if r.Method != "GET" || r.URL.Path == "/api/objects/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"id":"1","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	/* Empty responses to writes are followed by a read */
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":"1","name":"Foo"}`})
+	for _, operation := range []func() error{object.createObject, object.updateObject} {
+		if err := operation(); err != nil {
+			t.Fatalf("api_object_test.go: %s", err)
+		}
+	}
+	if fmt.Sprint(requests) != "[POST GET PUT GET]" || object.apiData["name"] != "Foo" {
+		t.Fatalf("api_object_test.go: Expected empty write responses to be followed by reads but got %v", requests)
+	}
+
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"name":"Foo"}`})
+	if err := object.createObject(); err == nil {
+		t.Fatalf("api_object_test.go: Expected an error when an empty create response leaves the id unknown")
+	}
+
+	for behavior, expectedID := range map[string]string{"": "", "keep": "empty", "gone": ""} {
+		object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "empty", readEmptyResponse: behavior, priorResponse: `{"name":"Foo"}`})
+		err := object.readObject()
+		if (behavior == "") != (err != nil) {
+			t.Fatalf("api_object_test.go: Unexpected error for read_empty_response '%s': %v", behavior, err)
+		}
+		if err == nil && (object.id != expectedID || (behavior == "keep" && object.apiData["name"] != "Foo")) {
+			t.Fatalf("api_object_test.go: Expected id '%s' for read_empty_response '%s' but got '%s' with %v", expectedID, behavior, object.id, object.apiData)
+		}
+	}
+
+	/* Placeholder bodies listed in empty_response_bodies count as empty */
+	svr = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{ "data": null }`))
+	}))
+	defer svr.Close()
+	client, _ = NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", readEmptyResponse: "gone", emptyResponseBodies: []string{"{}", `{"data":null}`}})
+	if err := object.readObject(); err != nil || object.id != "" {
+		t.Fatalf("api_object_test.go: Expected a placeholder body to remove the object from state but got id '%s' (%v)", object.id, err)
+	}
+}
+
+func TestAPIObjectSuccessCodes(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"already exists"}`))
+		case "DELETE":
+			w.WriteHeader(http.StatusNotFound)
+		case "PUT":
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Write([]byte(`{"id":"1","name":"Foo"}`))
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":"1","name":"Foo"}`})
+	if err := object.createObject(); err == nil {
+		t.Fatalf("api_object_test.go: Expected a 409 response to create to fail by default")
+	}
+	if err := object.deleteObject(); err == nil {
+		t.Fatalf("api_object_test.go: Expected a 404 response to destroy to fail by default")
+	}
+
+	object, _ = NewAPIObject(client, &apiObjectOpts{
+		path:         "/api/objects",
+		data:         `{"id":"1","name":"Foo"}`,
+		successCodes: map[string][]int{"create": {201, 409}, "update": {200}, "destroy": {200, 404, 410}},
+	})
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: Expected 409 in create_success_codes to be accepted: %s", err)
+	}
+	if object.apiData["name"] != "Foo" {
+		t.Fatalf("api_object_test.go: Expected the object to be read after an accepted 409 but got %v", object.apiData)
+	}
+	if err := object.updateObject(); err == nil || !strings.Contains(err.Error(), "unexpected response code '202'") {
+		t.Fatalf("api_object_test.go: Expected a 202 response to fail when not in update_success_codes but got %v", err)
+	}
+	if err := object.deleteObject(); err != nil {
+		t.Fatalf("api_object_test.go: Expected 404 in destroy_success_codes to be accepted: %s", err)
+	}
+}
+
+func TestAPIObjectRetryCreateOn(t *testing.T) {
+	var attempts int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":"PARENT_NOT_FOUND","message":"parent not yet propagated"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"1","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true, retryWaitMin: 0.01})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":"1","name":"Foo"}`})
+	if err := object.createObject(); err == nil || atomic.LoadInt32(&attempts) != 1 {
+		t.Fatalf("api_object_test.go: Expected the create to fail without retrying by default")
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":"1","name":"Foo"}`, retryCreatePatterns: []string{"^PARENT_"}, retryCreateKey: "$.error.code", retryCreateTimeout: 10})
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: Expected the create to be retried until it succeeds: %s", err)
+	}
+	if atomic.LoadInt32(&attempts) != 3 {
+		t.Fatalf("api_object_test.go: Expected 3 create attempts but got %d", attempts)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", data: `{"id":"1","name":"Foo"}`, retryCreatePatterns: []string{"quota exceeded"}, retryCreateTimeout: 10})
+	if err := object.createObject(); err == nil || atomic.LoadInt32(&attempts) != 1 {
+		t.Fatalf("api_object_test.go: Expected errors not matching retry_create_on to fail without retrying")
+	}
+}
+
+func TestAPIObjectCreateAndUpdateOnlyKeys(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+string(body))
+		w.Write([]byte(`{"id":"1","name":"Foo","enabled":true}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:           "/api/objects",
+		data:           `{"id":"1","name":"Foo","password":"hunter2","enabled":true}`,
+		createOnlyKeys: []string{"password"},
+		updateOnlyKeys: []string{"enabled"},
+	})
+	if err := object.createObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+
+	expected := `[POST {"id":"1","name":"Foo","password":"hunter2"} PUT {"enabled":true,"id":"1","name":"Foo"}]`
+	if fmt.Sprint(requests) != expected {
+		t.Fatalf("api_object_test.go: Expected requests %s but got %v", expected, requests)
+	}
+}
+
+func TestAPIObjectReadData(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/api/objects/describe" || string(body) != `{"id":"1234","zone":"eu"}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id":"1234","zone":"eu","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:       "/api/objects",
+		getPath:    "/api/objects/describe",
+		id:         "1234",
+		data:       `{"zone":"eu","name":"Foo"}`,
+		readMethod: "POST",
+		readData:   `{"id":"{id}","zone":"{data.zone}"}`,
+	})
+	if err := object.readObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if object.apiData["name"] != "Foo" {
+		t.Fatalf("api_object_test.go: Expected to read the object with a POST but got %v", object.apiData)
+	}
+}
+
+func TestAPIObjectExistsCheck(t *testing.T) {
+	var requests []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "POST" {
+			w.Write([]byte(`{"id":"new","name":"New"}`))
+			return
+		}
+		if r.URL.Path != "/api/objects/existing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"id":"existing","name":"Old"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	tests := []struct {
+		id       string
+		behavior string
+		fails    bool
+		expected string
+	}{
+		{"existing", "", true, "[HEAD /api/objects/existing]"},
+		{"existing", "adopt", false, "[HEAD /api/objects/existing GET /api/objects/existing]"},
+		{"existing", "update", false, "[HEAD /api/objects/existing GET /api/objects/existing PUT /api/objects/existing]"},
+		{"new", "fail", false, "[HEAD /api/objects/new POST /api/objects]"},
+	}
+
+	for _, test := range tests {
+		requests = nil
+		object, _ := NewAPIObject(client, &apiObjectOpts{
+			path:                   "/api/objects",
+			data:                   `{"id":"` + test.id + `","name":"New"}`,
+			existsCheck:            map[string]string{},
+			createConflictBehavior: test.behavior,
+		})
+		err := object.createObject()
+		if test.fails != (err != nil) {
+			t.Fatalf("api_object_test.go: Unexpected error for create_conflict_behavior '%s': %v", test.behavior, err)
+		}
+		if fmt.Sprint(requests) != test.expected {
+			t.Fatalf("api_object_test.go: Expected requests %s for create_conflict_behavior '%s' but got %v", test.expected, test.behavior, requests)
+		}
+	}
+}
+
+func TestAPIObjectIDAttributes(t *testing.T) {
+	client, _ := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:8081/", timeout: 2, idAttributes: []string{"uuid", "id", "data/id"}})
+	tests := map[string]string{
+		`{"uuid":"a1","id":"2"}`:       "a1",
+		`{"id":"2"}`:                   "2",
+		`{"data":{"id":3},"name":"x"}`: "3",
+	}
+	for response, expected := range tests {
+		object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects"})
+		if err := object.updateState(response); err != nil || object.id != expected {
+			t.Fatalf("api_object_test.go: Expected id '%s' from %s but got '%s' (%v)", expected, response, object.id, err)
+		}
+	}
+
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects"})
+	if err := object.updateState(`{"name":"x"}`); err == nil {
+		t.Fatalf("api_object_test.go: Expected an error when none of id_attributes is found")
+	}
+
+	/* A resource's own id_attribute wins over the provider's id_attributes */
+	object, _ = NewAPIObject(client, &apiObjectOpts{path: "/api/objects", idAttribute: "name"})
+	if err := object.updateState(`{"uuid":"a1","name":"x"}`); err != nil || object.id != "x" {
+		t.Fatalf("api_object_test.go: Expected id_attribute to override the provider's id_attributes but got '%s' (%v)", object.id, err)
+	}
+}
+
+func TestAPIObjectCopyKeyPaths(t *testing.T) {
+	client, _ := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:8081/", timeout: 2, copyKeyPaths: map[string]string{
+		"revision":      "$.metadata.versions[0].revision",
+		"spec/etag":     "metadata/etag",
+		"missing_value": "metadata/missing",
+	}})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", data: `{"name":"Foo","spec":{"size":1}}`})
+	object.updateState(`{"id":"1","metadata":{"etag":"abc","versions":[{"revision":7}]}}`)
+
+	data, _ := json.Marshal(object.data)
+	expected := `{"name":"Foo","revision":7,"spec":{"etag":"abc","size":1}}`
+	if string(data) != expected {
+		t.Fatalf("api_object_test.go: Expected data %s after copying copy_key_paths but got %s", expected, data)
+	}
+}
+
+func TestAPIObjectLastResponse(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-1")
+		w.Header().Set("X-Internal", "secret")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"1","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", captureResponseHeaders: []string{"x-request-id", "X-Missing"}})
+	if err := object.readObject(); err != nil {
+		t.Fatalf("api_object_test.go: %s", err)
+	}
+	if object.lastStatusCode != http.StatusAccepted || object.lastDuration <= 0 {
+		t.Fatalf("api_object_test.go: Expected the last response to be recorded but got status %d after %s", object.lastStatusCode, object.lastDuration)
+	}
+	headers := object.capturedResponseHeaders()
+	if !reflect.DeepEqual(headers, map[string]string{"x-request-id": "req-1"}) {
+		t.Fatalf("api_object_test.go: Expected only the captured headers but got %v", headers)
+	}
+}
@@ -0,0 +1,415 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* Valid values for openapi_validation */
+var openAPIValidationModes = []string{"warn", "error"}
+
+/*
+An OpenAPI 3 document for openapi_spec_file, which requests and
+
+	responses with JSON bodies are checked against. Only the parts of
+	JSON schema APIs describe payloads with are supported: $ref to
+	components, type, nullable, enum, properties, required,
+	additionalProperties, items, allOf, anyOf and oneOf. Other keywords
+	the spec uses are listed in unsupported, so they can be warned about
+*/
+type openAPISpec struct {
+	file        string
+	mode        string
+	paths       []openAPIPath
+	components  map[string]interface{}
+	unsupported []string
+}
+
+/* A path of the spec, such as /api/objects/{id}, split into its segments */
+type openAPIPath struct {
+	segments   []string
+	operations map[string]interface{}
+}
+
+/* Load openapi_spec_file (in JSON or YAML), or return nil if it is not set */
+func newOpenAPISpec(file string, mode string) (*openAPISpec, error) {
+	if file == "" {
+		return nil, nil
+	}
+	if mode == "" {
+		mode = "warn"
+	}
+	if !contains(openAPIValidationModes, mode) {
+		return nil, fmt.Errorf("openapi_validation '%s' is invalid - must be one of %v", mode, openAPIValidationModes)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("openapi_spec_file '%s' could not be read: %v", file, err)
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("openapi_spec_file '%s' is not valid JSON or YAML: %v", file, err)
+	}
+	doc, ok := stringKeys(raw).(map[string]interface{})
+	if !ok || doc["paths"] == nil {
+		return nil, fmt.Errorf("openapi_spec_file '%s' has no paths", file)
+	}
+
+	spec := &openAPISpec{file: file, mode: mode, components: map[string]interface{}{}, unsupported: findUnsupportedOpenAPIKeywords(doc)}
+	if components, ok := doc["components"].(map[string]interface{}); ok {
+		spec.components = components
+	}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for p, operations := range paths {
+		ops, ok := operations.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		spec.paths = append(spec.paths, openAPIPath{segments: strings.Split(strings.Trim(p, "/"), "/"), operations: ops})
+	}
+	return spec, nil
+}
+
+/* Keywords of OpenAPI and JSON schema that requests and responses are not checked against */
+var unsupportedOpenAPIKeywords = []string{
+	"format", "pattern", "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+	"minLength", "maxLength", "minItems", "maxItems", "uniqueItems", "minProperties", "maxProperties",
+	"discriminator", "not", "parameters",
+}
+
+/*
+The unsupported keywords doc uses, sorted. Keys of properties and
+
+	schemas are names rather than keywords, so a property called format
+	is not mistaken for one
+*/
+func findUnsupportedOpenAPIKeywords(doc map[string]interface{}) []string {
+	found := map[string]bool{}
+	if doc["servers"] != nil {
+		found["servers"] = true
+	}
+	var walk func(v interface{}, names bool)
+	walk = func(v interface{}, names bool) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, val := range v {
+				if !names && contains(unsupportedOpenAPIKeywords, key) {
+					found[key] = true
+				}
+				walk(val, !names && (key == "properties" || key == "schemas"))
+			}
+		case []interface{}:
+			for _, val := range v {
+				walk(val, false)
+			}
+		}
+	}
+	walk(doc["paths"], true)
+	walk(doc["components"], false)
+
+	keywords := make([]string, 0, len(found))
+	for keyword := range found {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+/*
+YAML mappings with keys that are not strings (such as response codes)
+
+	decode to map[interface{}]interface{}, so make every key a string
+*/
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = stringKeys(val)
+		}
+		return m
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = stringKeys(val)
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = stringKeys(val)
+		}
+		return v
+	}
+	return v
+}
+
+/*
+The operation for method on path (which may have a query string), or
+
+	nil if the spec has none. Paths with more literal segments win, so
+	/objects/search is preferred to /objects/{id}
+*/
+func (spec *openAPISpec) operation(method string, path string) map[string]interface{} {
+	if u, err := url.Parse(path); err == nil {
+		path = u.Path
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best map[string]interface{}
+	bestLiterals := -1
+	for _, p := range spec.paths {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		literals := 0
+		matches := true
+		for i, s := range p.segments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+				continue
+			}
+			if s != segments[i] {
+				matches = false
+				break
+			}
+			literals++
+		}
+		if !matches || literals <= bestLiterals {
+			continue
+		}
+		if op, ok := p.operations[strings.ToLower(method)].(map[string]interface{}); ok {
+			best, bestLiterals = op, literals
+		}
+	}
+	return best
+}
+
+/* The JSON schema of content (a requestBody's or a response's), or nil if it has none */
+func (spec *openAPISpec) jsonSchema(holder interface{}) interface{} {
+	m, _ := spec.resolve(holder).(map[string]interface{})
+	content, _ := m["content"].(map[string]interface{})
+	keys := make([]string, 0, len(content))
+	for k := range content {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.Contains(k, "json") {
+			media, _ := content[k].(map[string]interface{})
+			return media["schema"]
+		}
+	}
+	return nil
+}
+
+/* Problems with the body of a request, or nil if it matches the spec */
+func (spec *openAPISpec) validateRequest(method string, path string, body string) []string {
+	op := spec.operation(method, path)
+	if op == nil {
+		return []string{fmt.Sprintf("the spec has no operation for %s %s", method, path)}
+	}
+	requestBody, _ := spec.resolve(op["requestBody"]).(map[string]interface{})
+	if requestBody == nil {
+		return nil
+	}
+	if body == "" {
+		if required, _ := requestBody["required"].(bool); required {
+			return []string{"the request body is required"}
+		}
+		return nil
+	}
+	return spec.validateBody("request body", spec.jsonSchema(requestBody), body)
+}
+
+/* Problems with a response to a request, or nil if it matches the spec */
+func (spec *openAPISpec) validateResponse(method string, path string, statusCode int, body string) []string {
+	op := spec.operation(method, path)
+	if op == nil {
+		return nil
+	}
+	responses, _ := op["responses"].(map[string]interface{})
+	code := strconv.Itoa(statusCode)
+	response, ok := responses[code]
+	if !ok {
+		response, ok = responses[code[:1]+"XX"]
+	}
+	if !ok {
+		response, ok = responses["default"]
+	}
+	if !ok {
+		return []string{fmt.Sprintf("the spec has no response with status code %d", statusCode)}
+	}
+	if body == "" {
+		return nil
+	}
+	return spec.validateBody("response body", spec.jsonSchema(response), body)
+}
+
+func (spec *openAPISpec) validateBody(name string, schema interface{}, body string) []string {
+	if schema == nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		return []string{fmt.Sprintf("the %s is not valid JSON: %v", name, err)}
+	}
+	return spec.validate(name, schema, value)
+}
+
+/* Follow a $ref to #/components/... */
+func (spec *openAPISpec) resolve(schema interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		m, ok := schema.(map[string]interface{})
+		if !ok {
+			return schema
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		var target interface{} = spec.components
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/components/"), "/") {
+			t, _ := target.(map[string]interface{})
+			target = t[part]
+		}
+		schema = target
+	}
+	return schema
+}
+
+/* Problems with value at location (such as "request body.tags[0]") against schema */
+func (spec *openAPISpec) validate(location string, schema interface{}, value interface{}) []string {
+	s, ok := spec.resolve(schema).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	if value == nil {
+		if nullable, _ := s["nullable"].(bool); nullable || s["type"] == nil {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s must not be null", location)}
+	}
+
+	var problems []string
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			problems = append(problems, spec.validate(location, sub, value)...)
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if alternatives, ok := s[key].([]interface{}); ok {
+			matched := false
+			for _, sub := range alternatives {
+				if len(spec.validate(location, sub, value)) == 0 {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				problems = append(problems, fmt.Sprintf("%s does not match any schema of its %s", location, key))
+			}
+		}
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s is %v, which is not one of %v", location, value, enum))
+		}
+	}
+
+	if t, ok := s["type"].(string); ok && !jsonTypeMatches(t, value) {
+		return append(problems, fmt.Sprintf("%s must be of type %s but is %s", location, t, jsonTypeName(value)))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := s["properties"].(map[string]interface{})
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := v[fmt.Sprint(r)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s is missing required property '%v'", location, r))
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if property, ok := properties[k]; ok {
+				problems = append(problems, spec.validate(location+"."+k, property, v[k])...)
+			} else if additional, ok := s["additionalProperties"].(bool); ok && !additional {
+				problems = append(problems, fmt.Sprintf("%s has property '%s', which the spec does not allow", location, k))
+			} else if additional, ok := s["additionalProperties"].(map[string]interface{}); ok {
+				problems = append(problems, spec.validate(location+"."+k, additional, v[k])...)
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				problems = append(problems, spec.validate(fmt.Sprintf("%s[%d]", location, i), items, item)...)
+			}
+		}
+	}
+	return problems
+}
+
+func jsonTypeMatches(t string, value interface{}) bool {
+	switch t {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == t
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+/*
+Check a request or its response against openapi_spec_file. With
+
+	openapi_validation of warn, problems are logged and nil is returned
+*/
+func (client *APIClient) checkOpenAPI(what string, method string, path string, problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if client.openAPI.mode == "warn" {
+		client.log(logTransport, "WARN", fmt.Sprintf("The %s does not match openapi_spec_file", what), map[string]interface{}{"method": method, "path": path, "problems": strings.Join(problems, "; ")})
+		return nil
+	}
+	return fmt.Errorf("%s %s: the %s does not match openapi_spec_file '%s': %s", method, path, what, client.openAPI.file, strings.Join(problems, "; "))
+}
@@ -0,0 +1,183 @@
+package restapi
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testOpenAPISpec = `
+openapi: 3.0.0
+paths:
+  /api/objects:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Object'
+      responses:
+        201:
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Object'
+  /api/objects/{id}:
+    get:
+      responses:
+        2XX:
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Object'
+components:
+  schemas:
+    Object:
+      type: object
+      required: [id, name]
+      additionalProperties: false
+      properties:
+        id:
+          type: string
+        name:
+          type: string
+        size:
+          type: integer
+        colour:
+          type: string
+          enum: [red, green]
+        tags:
+          type: array
+          items:
+            type: string
+`
+
+func TestOpenAPIValidate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(file, []byte(testOpenAPISpec), 0o600); err != nil {
+		t.Fatalf("api_openapi_test.go: %s", err)
+	}
+	spec, err := newOpenAPISpec(file, "")
+	if err != nil {
+		t.Fatalf("api_openapi_test.go: %s", err)
+	}
+
+	requests := []struct {
+		method  string
+		path    string
+		body    string
+		problem string
+	}{
+		{"POST", "/api/objects", `{"id":"1","name":"foo","size":3,"colour":"red","tags":["a"]}`, ""},
+		{"POST", "/api/objects", `{"id":"1"}`, "request body is missing required property 'name'"},
+		{"POST", "/api/objects", `{"id":"1","name":"foo","size":1.5}`, "request body.size must be of type integer but is number"},
+		{"POST", "/api/objects", `{"id":"1","name":"foo","colour":"blue"}`, "request body.colour is blue, which is not one of [red green]"},
+		{"POST", "/api/objects", `{"id":"1","name":"foo","tags":[1]}`, "request body.tags[0] must be of type string but is number"},
+		{"POST", "/api/objects", `{"id":"1","name":"foo","owner":"me"}`, "has property 'owner', which the spec does not allow"},
+		{"POST", "/api/objects", "", "the request body is required"},
+		{"GET", "/api/objects/1?full=true", "", ""},
+		{"DELETE", "/api/objects/1", "", "the spec has no operation for DELETE /api/objects/1"},
+	}
+	for _, r := range requests {
+		problems := strings.Join(spec.validateRequest(r.method, r.path, r.body), "; ")
+		if (r.problem == "" && problems != "") || !strings.Contains(problems, r.problem) {
+			t.Fatalf("api_openapi_test.go: Expected %s %s %s to have the problem '%s' but got '%s'", r.method, r.path, r.body, r.problem, problems)
+		}
+	}
+
+	if problems := spec.validateResponse("POST", "/api/objects", 201, `{"id":"1","name":"foo"}`); len(problems) != 0 {
+		t.Fatalf("api_openapi_test.go: Expected the response to match but got %v", problems)
+	}
+	if problems := spec.validateResponse("GET", "/api/objects/1", 200, `{"id":1,"name":"foo"}`); len(problems) != 1 || problems[0] != "response body.id must be of type string but is number" {
+		t.Fatalf("api_openapi_test.go: Expected the response id to be reported but got %v", problems)
+	}
+	if problems := spec.validateResponse("POST", "/api/objects", 200, ""); len(problems) != 1 {
+		t.Fatalf("api_openapi_test.go: Expected the undocumented status code to be reported but got %v", problems)
+	}
+
+	if len(spec.unsupported) != 0 {
+		t.Fatalf("api_openapi_test.go: Expected no unsupported keywords but got %v", spec.unsupported)
+	}
+
+	if _, err := newOpenAPISpec(file, "strict"); err == nil {
+		t.Fatalf("api_openapi_test.go: Expected an invalid openapi_validation to be refused")
+	}
+}
+
+func TestOpenAPIUnsupportedKeywords(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "openapi.yaml")
+	spec := `
+openapi: 3.0.0
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /api/objects/{id}:
+    parameters:
+      - name: id
+        in: path
+    get:
+      responses:
+        200:
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  format:
+                    type: string
+                  email:
+                    type: string
+                    format: email
+                  size:
+                    type: integer
+                    minimum: 1
+`
+	if err := os.WriteFile(file, []byte(spec), 0o600); err != nil {
+		t.Fatalf("api_openapi_test.go: %s", err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	if _, err := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:1", timeout: 2, openAPISpecFile: file}); err != nil {
+		t.Fatalf("api_openapi_test.go: %s", err)
+	}
+	if !strings.Contains(logged.String(), "format, minimum, parameters, servers") {
+		t.Fatalf("api_openapi_test.go: Expected a warning listing the unsupported keywords but got %s", logged.String())
+	}
+}
+
+func TestOpenAPIValidationError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "openapi.yaml")
+	if err := os.WriteFile(file, []byte(testOpenAPISpec), 0o600); err != nil {
+		t.Fatalf("api_openapi_test.go: %s", err)
+	}
+
+	sent := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id":"1","name":"foo","size":"big"}`)
+	}))
+	defer svr.Close()
+
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, openAPISpecFile: file, openAPIValidation: "error"})
+	if err != nil {
+		t.Fatalf("api_openapi_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("POST", "/api/objects", `{"id":"1"}`); err == nil || sent != 0 {
+		t.Fatalf("api_openapi_test.go: Expected the request to fail without being sent but got %v after %d requests", err, sent)
+	}
+	_, err = client.sendRequest("POST", "/api/objects", `{"id":"1","name":"foo"}`)
+	if err == nil || sent != 1 || !strings.Contains(err.Error(), "the response does not match openapi_spec_file") {
+		t.Fatalf("api_openapi_test.go: Expected the response to fail validation but got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package restapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+/*
+Status codes that are retried when retry_status_codes is not set.
+
+	429 is always handled separately (see max_throttle_retries)
+*/
+var defaultRetryStatusCodes = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+/* Classes of network errors that are retried when retry_network_errors is not set */
+var defaultRetryNetworkErrors = []string{
+	"connection_reset",
+	"connection_refused",
+	"eof",
+	"dns",
+	"tls_handshake_timeout",
+}
+
+/*
+Classes of network errors that happen before the request is sent, so
+
+	it is retried whatever its method. The others can happen after the
+	server has processed it, so only idempotent methods are retried
+*/
+var unsentNetworkErrors = []string{
+	"connection_refused",
+	"dns",
+	"tls_handshake_timeout",
+}
+
+/* All classes of network errors, see classifyNetworkError */
+var networkErrorClasses = []string{
+	"connection_reset",
+	"connection_refused",
+	"eof",
+	"dns",
+	"tls_handshake_timeout",
+	"timeout",
+}
+
+/*
+retryPolicy controls how failed requests are retried. The wait before
+
+	retry N is waitMin * multiplier^N, capped at waitMax and then spread
+	by +/- jitter (a fraction of the wait) so that many resources failing
+	at once do not retry in lockstep. budget caps the total time spent
+	waiting between retries by all requests of the client, which is
+	tracked in spent.
+*/
+type retryPolicy struct {
+	maxRetries         int
+	maxThrottleRetries int
+	waitMin            time.Duration
+	waitMax            time.Duration
+	multiplier         float64
+	jitter             float64
+	budget             time.Duration
+	statusCodes        []int
+	networkErrors      []string
+
+	mutex sync.Mutex
+	spent time.Duration
+}
+
+func newRetryPolicy(opt *apiClientOpt) (*retryPolicy, error) {
+	policy := &retryPolicy{
+		maxRetries:         opt.maxRetries,
+		maxThrottleRetries: opt.maxThrottleRetries,
+		waitMin:            secondsToDuration(opt.retryWaitMin),
+		waitMax:            secondsToDuration(opt.retryWaitMax),
+		multiplier:         opt.retryMultiplier,
+		jitter:             opt.retryJitter,
+		budget:             secondsToDuration(opt.retryBudget),
+		statusCodes:        opt.retryStatusCodes,
+		networkErrors:      opt.retryNetworkErrors,
+	}
+
+	if policy.maxRetries < 0 || policy.maxThrottleRetries < 0 {
+		return nil, errors.New("max_retries and max_throttle_retries must not be negative")
+	}
+	if policy.jitter < 0 || policy.jitter > 1 {
+		return nil, errors.New("retry_jitter must be between 0 and 1")
+	}
+
+	/* Sane defaults */
+	if policy.waitMin <= 0 {
+		policy.waitMin = time.Second
+	}
+	if policy.waitMax <= 0 {
+		policy.waitMax = 30 * time.Second
+	}
+	if policy.waitMax < policy.waitMin {
+		return nil, errors.New("retry_wait_max must not be less than retry_wait_min")
+	}
+	if policy.multiplier < 1 {
+		policy.multiplier = 2
+	}
+	if len(policy.statusCodes) == 0 {
+		policy.statusCodes = defaultRetryStatusCodes
+	}
+	if len(policy.networkErrors) == 0 {
+		policy.networkErrors = defaultRetryNetworkErrors
+	}
+
+	return policy, nil
+}
+
+/* Whether another attempt should be made after a failed attempt of method */
+func (policy *retryPolicy) shouldRetry(method string, attempt int, resp *http.Response, err error) bool {
+	if attempt >= policy.maxRetries {
+		return false
+	}
+
+	/* No response at all means the request failed on the network. Sending
+	   a POST again after a reset could create the object twice */
+	if resp == nil {
+		class := classifyNetworkError(err)
+		if class == "" || !contains(policy.networkErrors, class) {
+			return false
+		}
+		return contains(unsentNetworkErrors, class) || contains(idempotentMethods, method)
+	}
+
+	for _, code := range policy.statusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Sort a transport error into one of networkErrorClasses so that
+
+	users can choose which ones are worth retrying. Returns "" for
+	errors that are not network related (such as a bad request URL)
+*/
+func classifyNetworkError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection_reset"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	/* net/http does not export its TLS handshake timeout error */
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return "tls_handshake_timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return ""
+}
+
+/*
+Take wait out of the retry budget, if it is set. Returns false
+
+	(taking nothing) when the budget does not have wait left
+*/
+func (policy *retryPolicy) spendBudget(wait time.Duration) bool {
+	if policy.budget <= 0 {
+		return true
+	}
+	policy.mutex.Lock()
+	defer policy.mutex.Unlock()
+
+	if policy.spent+wait > policy.budget {
+		return false
+	}
+	policy.spent += wait
+	return true
+}
+
+/* How long to wait before the next attempt, where attempt 0 is the first retry */
+func (policy *retryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(policy.waitMin) * math.Pow(policy.multiplier, float64(attempt))
+	wait = math.Min(wait, float64(policy.waitMax))
+	if policy.jitter > 0 {
+		wait = wait * (1 + policy.jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(wait)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+/*
+Parse a Retry-After header, which is either a number of seconds
+
+	or an HTTP date. Returns false if the header is missing or invalid
+*/
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+/*
+throttle records 429 responses from the API. While throttled, all
+
+	requests made by the client wait, and a summary of the throttling
+	is reported to the user as a warning diagnostic.
+*/
+type throttle struct {
+	mutex    sync.Mutex
+	until    time.Time
+	events   int
+	waited   time.Duration
+	reported int
+}
+
+func (t *throttle) pause(wait time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.events++
+	t.waited += wait
+	if until := time.Now().Add(wait); until.After(t.until) {
+		t.until = until
+	}
+}
+
+/* Wait until the API stops throttling requests (if it is) or ctx is done */
+func (client *APIClient) waitForThrottle(ctx context.Context) error {
+	t := client.throttle
+	t.mutex.Lock()
+	wait := time.Until(t.until)
+	t.mutex.Unlock()
+
+	if wait > 0 {
+		client.log(logTransport, "INFO", "API is throttling requests. Waiting", map[string]interface{}{"wait": wait.String()})
+		return sleepContext(ctx, wait)
+	}
+	return nil
+}
+
+/* Sleep for wait, or until ctx is done (returning its error) */
+func sleepContext(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* Summarize throttling seen during the run, once per new batch of 429s */
+func (t *throttle) warning() diag.Diagnostics {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.events == t.reported {
+		return nil
+	}
+	t.reported = t.events
+
+	return diag.Diagnostics{{
+		Severity: diag.Warning,
+		Summary:  "API requests were throttled",
+		Detail: fmt.Sprintf("The API responded with 429 Too Many Requests %d time(s) during this run, delaying requests by %s in total. "+
+			"Consider lowering rate_limit or max_parallel_requests.", t.events, t.waited.Round(time.Millisecond)),
+	}}
+}
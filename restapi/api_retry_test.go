@@ -0,0 +1,239 @@
+package restapi
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy, err := newRetryPolicy(&apiClientOpt{
+		maxRetries:      5,
+		retryWaitMin:    1,
+		retryWaitMax:    10,
+		retryMultiplier: 3,
+	})
+	if err != nil {
+		t.Fatalf("api_retry_test.go: %s", err)
+	}
+
+	expected := []time.Duration{1 * time.Second, 3 * time.Second, 9 * time.Second, 10 * time.Second}
+	for attempt, wait := range expected {
+		if res := policy.backoff(attempt); res != wait {
+			t.Errorf("api_retry_test.go: Expected a wait of %s for attempt %d but got %s", wait, attempt, res)
+		}
+	}
+
+	policy.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		res := policy.backoff(1)
+		if res < 1500*time.Millisecond || res > 4500*time.Millisecond {
+			t.Fatalf("api_retry_test.go: Wait of %s with jitter 0.5 is outside the expected range", res)
+		}
+	}
+
+	if _, err := newRetryPolicy(&apiClientOpt{retryJitter: 2}); err == nil {
+		t.Fatalf("api_retry_test.go: Expected an error for retry_jitter greater than 1")
+	}
+	if _, err := newRetryPolicy(&apiClientOpt{retryWaitMin: 5, retryWaitMax: 1}); err == nil {
+		t.Fatalf("api_retry_test.go: Expected an error when retry_wait_max is less than retry_wait_min")
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	policy, _ := newRetryPolicy(&apiClientOpt{maxRetries: 2})
+
+	if !policy.shouldRetry("GET", 0, &http.Response{StatusCode: 503}, nil) {
+		t.Errorf("api_retry_test.go: Expected a 503 to be retried")
+	}
+	if policy.shouldRetry("GET", 0, &http.Response{StatusCode: 400}, nil) {
+		t.Errorf("api_retry_test.go: Expected a 400 not to be retried")
+	}
+	if policy.shouldRetry("GET", 2, &http.Response{StatusCode: 503}, nil) {
+		t.Errorf("api_retry_test.go: Expected no retry once max_retries is reached")
+	}
+}
+
+func TestAPIClientRetries(t *testing.T) {
+	var requests int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxRetries: 3, retryWaitMin: 0.01})
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("api_retry_test.go: Expected the request to succeed after retries: %s", err)
+	}
+	if res != "It works!" || requests != 3 {
+		t.Fatalf("api_retry_test.go: Expected 'It works!' after 3 requests but got '%s' after %d", res, requests)
+	}
+
+	/* The budget does not allow even the first retry */
+	atomic.StoreInt32(&requests, 0)
+	client, _ = NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxRetries: 3, retryWaitMin: 1, retryBudget: 0.5})
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("api_retry_test.go: Expected the request to fail when the retry budget is exhausted")
+	}
+	if requests != 1 {
+		t.Fatalf("api_retry_test.go: Expected 1 request when the retry budget is exhausted but got %d", requests)
+	}
+
+	/* The budget is shared by every request of the client */
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	atomic.StoreInt32(&requests, 0)
+	client, _ = NewAPIClient(&apiClientOpt{uri: failing.URL, timeout: 2, maxRetries: 1, retryWaitMin: 0.1, retryBudget: 0.15})
+	client.sendRequest("GET", "/first", "")
+	client.sendRequest("GET", "/second", "")
+	if requests != 3 {
+		t.Fatalf("api_retry_test.go: Expected only the first request to be retried within the budget but got %d requests", requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		wait   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"Mon, 01 Jan 2024 12:00:10 GMT", 10 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		wait, ok := parseRetryAfter(test.header, now)
+		if wait != test.wait || ok != test.ok {
+			t.Fatalf("api_retry_test.go: Expected '%s' to parse to %s/%t but got %s/%t", test.header, test.wait, test.ok, wait, ok)
+		}
+	}
+}
+
+func TestAPIClientThrottling(t *testing.T) {
+	var requests int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	/* 429 is retried even though max_retries is not set */
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxThrottleRetries: 1})
+	start := time.Now()
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("api_retry_test.go: Expected the request to succeed after being throttled: %s", err)
+	}
+	if res != "It works!" || requests != 2 {
+		t.Fatalf("api_retry_test.go: Expected 'It works!' after 2 requests but got '%s' after %d", res, requests)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("api_retry_test.go: Expected Retry-After to be honored but the request took %s", elapsed)
+	}
+
+	diags := client.throttle.warning()
+	if len(diags) != 1 || diags[0].Severity != diag.Warning {
+		t.Fatalf("api_retry_test.go: Expected one throttling warning but got %v", diags)
+	}
+	if diags := client.throttle.warning(); diags != nil {
+		t.Fatalf("api_retry_test.go: Expected the throttling warning to be reported only once but got %v", diags)
+	}
+
+	/* Waits longer than retry_wait_max are cut short */
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer slow.Close()
+	client, _ = NewAPIClient(&apiClientOpt{uri: slow.URL, timeout: 2, maxThrottleRetries: 1, retryWaitMin: 0.01, retryWaitMax: 0.1})
+	start = time.Now()
+	if _, err := client.sendRequest("GET", "/ok", ""); err == nil {
+		t.Fatalf("api_retry_test.go: Expected the request to fail once throttle retries are used up")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("api_retry_test.go: Expected Retry-After to be capped at retry_wait_max but the request took %s", elapsed)
+	}
+}
+
+func TestClassifyNetworkError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, "connection_reset"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, "connection_refused"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: io.EOF}, "eof"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{Err: "no such host", Name: "x"}}, "dns"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: errors.New("net/http: TLS handshake timeout")}, "tls_handshake_timeout"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}, "dns"},
+		{errors.New("unsupported protocol scheme"), ""},
+	}
+	for _, test := range tests {
+		if class := classifyNetworkError(test.err); class != test.class {
+			t.Fatalf("api_retry_test.go: Expected '%s' to be classified as '%s' but got '%s'", test.err, test.class, class)
+		}
+	}
+
+	policy, _ := newRetryPolicy(&apiClientOpt{maxRetries: 1})
+	if !policy.shouldRetry("GET", 0, nil, tests[0].err) {
+		t.Fatalf("api_retry_test.go: Expected a connection reset to be retried by default")
+	}
+	/* A POST may have been processed before the connection was reset, but not before it was refused */
+	if policy.shouldRetry("POST", 0, nil, tests[0].err) || policy.shouldRetry("POST", 0, nil, tests[2].err) {
+		t.Fatalf("api_retry_test.go: Expected a POST not to be retried after a reset or EOF")
+	}
+	if !policy.shouldRetry("POST", 0, nil, tests[1].err) {
+		t.Fatalf("api_retry_test.go: Expected a POST to be retried when the connection was refused")
+	}
+	policy, _ = newRetryPolicy(&apiClientOpt{maxRetries: 1, retryNetworkErrors: []string{"dns"}})
+	if policy.shouldRetry("GET", 0, nil, tests[0].err) {
+		t.Fatalf("api_retry_test.go: Expected a connection reset not to be retried when only dns is configured")
+	}
+}
+
+func TestAPIClientRetriesNetworkErrors(t *testing.T) {
+	var requests int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		/* Drop the connection without a response the first time */
+		if atomic.AddInt32(&requests, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte("It works!"))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, maxRetries: 1, retryWaitMin: 0.01})
+	res, err := client.sendRequest("GET", "/ok", "")
+	if err != nil {
+		t.Fatalf("api_retry_test.go: Expected the request to succeed after the dropped connection: %s", err)
+	}
+	if res != "It works!" || requests != 2 {
+		t.Fatalf("api_retry_test.go: Expected 'It works!' after 2 requests but got '%s' after %d", res, requests)
+	}
+}
@@ -0,0 +1,63 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+/* Operations that may have a body_template */
+var bodyTemplateOperations = []string{"create", "update", "destroy"}
+
+var bodyTemplateFuncs = template.FuncMap{
+	/* Render a value as JSON, so strings are quoted and escaped and maps and lists can be embedded */
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseBodyTemplates(templates map[string]string) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template)
+	for _, operation := range bodyTemplateOperations {
+		if templates[operation] == "" {
+			continue
+		}
+		tmpl, err := template.New(operation).Funcs(bodyTemplateFuncs).Option("missingkey=error").Parse(templates[operation])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing the %s body_template: %v", operation, err)
+		}
+		parsed[operation] = tmpl
+	}
+	return parsed, nil
+}
+
+/*
+Render the body_template of an operation. Templates can use .id,
+
+	.data (the object's data) and .api_data (the object as last read
+	from the API). Returns false if the operation has no template.
+*/
+func (obj *APIObject) renderBodyTemplate(operation string) (string, bool, error) {
+	tmpl, ok := obj.bodyTemplates[operation]
+	if !ok {
+		return "", false, nil
+	}
+
+	apiData := obj.apiData
+	if len(apiData) == 0 {
+		apiData = obj.priorAPIData
+	}
+
+	var body bytes.Buffer
+	err := tmpl.Execute(&body, map[string]interface{}{
+		"id":       obj.id,
+		"data":     obj.data,
+		"api_data": apiData,
+	})
+	if err != nil {
+		return "", true, fmt.Errorf("error rendering the %s body_template: %v", operation, err)
+	}
+	return body.String(), true, nil
+}
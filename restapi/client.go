@@ -0,0 +1,141 @@
+package restapi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+)
+
+/*
+ClientOptions configure an APIClient built with NewClient, for tools
+
+	and other providers that send requests the way this provider does.
+	Each option works like the provider attribute of the same name.
+	The client is part of this package, so importing it also imports
+	the plugin SDK the provider is built on
+*/
+type ClientOptions struct {
+	/* URI of the API. Request paths are appended to it. Required */
+	URI string
+	/* Headers sent with every request */
+	Headers map[string]string
+	/* Credentials for basic auth */
+	Username string
+	Password string
+	/* Adds credentials to every request in place of Username and Password, for other schemes */
+	Authenticator Authenticator
+	/* Skip verifying the server's certificate */
+	Insecure bool
+	/* Client certificate and key files, and CA certificates to trust */
+	CertFile    string
+	KeyFile     string
+	CACertsFile string
+	/* The time a request may take, including reading the response. 0 means no limit */
+	Timeout time.Duration
+	/* Requests per second. 0 means no limit */
+	RateLimit float64
+	/* Retries of failed requests (see max_retries), and the status codes that are retried */
+	MaxRetries       int
+	RetryStatusCodes []int
+	/* Trimmed from the start of responses (see xssi_prefix) */
+	XSSIPrefix string
+	/* Path to the message in JSON error responses, for APIError.Message (see error_message_key) */
+	ErrorMessageKey string
+	/* Header to send a new id for each request in (see request_id_header) */
+	RequestIDHeader string
+	/* Log requests and responses */
+	Debug bool
+	/* Sends requests in place of the client's own, such as a mock in unit tests. The TLS options do not apply to it */
+	Transport http.RoundTripper
+}
+
+/*Response is the final response to a request sent with APIClient.Do*/
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+/*NewClient makes an APIClient with opts*/
+func NewClient(opts ClientOptions) (*APIClient, error) {
+	rateLimit := opts.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = math.MaxFloat64
+	}
+	return NewAPIClient(&apiClientOpt{
+		uri:              opts.URI,
+		headers:          opts.Headers,
+		username:         opts.Username,
+		password:         opts.Password,
+		insecure:         opts.Insecure,
+		certFile:         opts.CertFile,
+		keyFile:          opts.KeyFile,
+		caCertsFile:      opts.CACertsFile,
+		timeout:          int(math.Ceil(opts.Timeout.Seconds())),
+		rateLimit:        rateLimit,
+		maxRetries:       opts.MaxRetries,
+		retryStatusCodes: opts.RetryStatusCodes,
+		xssiPrefix:       opts.XSSIPrefix,
+		errorMessageKey:  opts.ErrorMessageKey,
+		requestIDHeader:  opts.RequestIDHeader,
+		debug:            opts.Debug,
+		transport:        opts.Transport,
+		authenticator:    opts.Authenticator,
+	})
+}
+
+/*
+Do sends a request to path (appended to the client's URI) with body
+
+	and headers (which take precedence over the client's), retrying it
+	as configured. Responses with a status code outside of 2xx return
+	an *APIError along with the response. Gives up with ctx's error
+	once ctx is done
+*/
+func (client *APIClient) Do(ctx context.Context, method string, path string, body string, headers map[string]string) (*Response, error) {
+	resultBody, resp, err := client.sendRequestContext(ctx, method, path, body, headers)
+	if resp == nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: resultBody}, err
+}
+
+/*
+DoStream sends a request with body (nil for none) read as it is sent,
+
+	and returns the response with its body unread, for the caller to
+	read and close. Neither has to fit in memory, so it suits large
+	uploads and downloads. It is not retried, since body cannot be read
+	twice, and only ctx limits how long it takes. Responses with a
+	status code outside of 2xx return an *APIError and no response
+*/
+func (client *APIClient) DoStream(ctx context.Context, method string, path string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return client.sendRequestStream(ctx, method, path, body, headers)
+}
+
+/*
+DoJSON sends a request like DoStream and decodes the JSON response
+
+	into v as it is read, without holding the whole body as a string.
+	Numbers decode as json.Number when v holds interface{} values
+*/
+func (client *APIClient) DoJSON(ctx context.Context, method string, path string, body io.Reader, headers map[string]string, v interface{}) error {
+	resp, err := client.sendRequestStream(ctx, method, path, body, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	if prefix, err := reader.Peek(len(client.xssiPrefix)); err == nil && string(prefix) == client.xssiPrefix {
+		reader.Discard(len(prefix))
+	}
+	if err := decodeJSONReader(reader, v); err != nil {
+		return fmt.Errorf("the response to %s %s is not valid JSON: %w", method, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package restapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientDo(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/objects/1":
+			w.Header().Set("X-Version", r.Header.Get("X-Version"))
+			w.Write([]byte(`{"id":"1"}`))
+		case "/api/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"no such object"}}`))
+		}
+	}))
+	defer svr.Close()
+
+	client, err := NewClient(ClientOptions{URI: svr.URL, Timeout: 2 * time.Second, MaxRetries: 5, RetryStatusCodes: []int{503}, ErrorMessageKey: "error/message"})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	resp, err := client.Do(context.Background(), "GET", "/api/objects/1", "", map[string]string{"X-Version": "2"})
+	if err != nil || resp.StatusCode != 200 || resp.Body != `{"id":"1"}` || resp.Header.Get("X-Version") != "2" {
+		t.Fatalf("client_test.go: Unexpected response %+v (%v)", resp, err)
+	}
+
+	resp, err = client.Do(context.Background(), "GET", "/api/objects/2", "", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode() != 404 || apiErr.Message() != "no such object" || resp == nil || resp.StatusCode != 404 {
+		t.Fatalf("client_test.go: Expected an APIError with the 404 and its message but got %v", err)
+	}
+
+	/* Retries stop once the context is done */
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.Do(ctx, "GET", "/api/busy", "", nil)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("client_test.go: Expected the retries to stop at the context deadline but got %v after %s", err, time.Since(start))
+	}
+
+	if _, err := NewClient(ClientOptions{}); err == nil {
+		t.Fatalf("client_test.go: Expected an error without a URI")
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	var requested []string
+	/* A mock transport, so no listener is needed */
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.Method+" "+req.URL.String()+" "+req.Header.Get("Authorization"))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id":"1"}`)),
+			Request:    req,
+		}, nil
+	})
+
+	client, err := NewClient(ClientOptions{URI: "https://api.example.com", Headers: map[string]string{"Authorization": "Bearer abc"}, Transport: transport})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	resp, err := client.Do(context.Background(), "GET", "/api/objects/1", "", nil)
+	if err != nil || resp.Body != `{"id":"1"}` {
+		t.Fatalf("client_test.go: Expected the mock's response but got %+v (%v)", resp, err)
+	}
+	if len(requested) != 1 || requested[0] != "GET https://api.example.com/api/objects/1 Bearer abc" {
+		t.Fatalf("client_test.go: Expected the request to go to the mock but got %v", requested)
+	}
+}
+
+func TestClientDoStream(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`)]}'` + "\n"))
+		fmt.Fprintf(w, `{"received":%d,"items":[`, n)
+		for i := 0; i < 1000; i++ {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			fmt.Fprintf(w, `{"id":%d}`, i)
+		}
+		w.Write([]byte("]}"))
+	}))
+	defer svr.Close()
+
+	client, err := NewClient(ClientOptions{URI: svr.URL, XSSIPrefix: `)]}'` + "\n"})
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+
+	/* The upload is read as it is sent, so it could as well be a file */
+	upload := io.LimitReader(zeroReader{}, 1<<20)
+	var result struct {
+		Received int
+		Items    []map[string]interface{}
+	}
+	if err := client.DoJSON(context.Background(), "POST", "/api/uploads", upload, nil, &result); err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	if result.Received != 1<<20 || len(result.Items) != 1000 || result.Items[999]["id"] != json.Number("999") {
+		t.Fatalf("client_test.go: Unexpected result %d bytes received and %d items", result.Received, len(result.Items))
+	}
+
+	limited, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, maxResponseSize: 100})
+	resp, err := limited.sendRequestStream(context.Background(), "GET", "/api/uploads", nil, nil)
+	if err != nil {
+		t.Fatalf("client_test.go: %s", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err == nil || len(b) != 100 || !strings.Contains(err.Error(), "exceeds max_response_size of 100 bytes") {
+		t.Fatalf("client_test.go: Expected reading past max_response_size to fail after 100 bytes but read %d (%v)", len(b), err)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = '0'
+	}
+	return len(p), nil
+}
@@ -1,15 +1,34 @@
 package restapi
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
+/*
+Wrap a CRUD function so that any throttling (429 responses) seen by
+
+	the client is surfaced to the user as a warning diagnostic
+*/
+func withThrottleWarning(f func(context.Context, *schema.ResourceData, interface{}) error) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+	return func(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+		diags := diag.FromErr(f(ctx, d, meta))
+		if client, ok := meta.(*APIClient); ok {
+			diags = append(diags, client.throttle.warning()...)
+		}
+		return diags
+	}
+}
+
 /*
 After any operation that returns API data, we'll stuff
 
@@ -18,11 +37,23 @@ After any operation that returns API data, we'll stuff
 */
 func setResourceState(obj *APIObject, d *schema.ResourceData) {
 	apiData := make(map[string]string)
-	for k, v := range obj.apiData {
+	for k, v := range obj.stateAPIData() {
 		apiData[k] = fmt.Sprintf("%v", v)
 	}
 	d.Set("api_data", apiData)
-	d.Set("api_response", obj.apiResponse)
+	d.Set("api_response", obj.stateAPIResponse())
+	/* Always set for resources (even when empty) so outputs is not planned as unknown. Data sources have no outputs */
+	if obj.extract != nil {
+		d.Set("outputs", obj.extractOutputs())
+	}
+	if obj.useETag {
+		d.Set("etag", obj.etag)
+	}
+	if obj.captureResponseHeaders != nil && obj.lastStatusCode != 0 {
+		d.Set("last_status_code", obj.lastStatusCode)
+		d.Set("last_response_headers", obj.capturedResponseHeaders())
+		d.Set("last_request_duration_ms", obj.lastDuration.Milliseconds())
+	}
 }
 
 /*
@@ -37,14 +68,23 @@ func GetStringAtKey(data map[string]interface{}, path string, debug bool) (strin
 	}
 
 	/* JSON supports strings, numbers, objects and arrays. Allow a string OR number here */
-	t := fmt.Sprintf("%T", res)
-	if t == "string" {
-		return res.(string), nil
-	} else if t == "float64" {
-		return strconv.FormatFloat(res.(float64), 'f', -1, 64), nil
-	} else {
-		return "", fmt.Errorf("object at path '%s' is not a JSON string or number (float64) - the go fmt package says it is '%T'", path, res)
+	if value, err := jsonScalarString(res); err == nil {
+		return value, nil
+	}
+	return "", fmt.Errorf("object at path '%s' is not a JSON string or number (float64) - the go fmt package says it is '%T'", path, res)
+}
+
+/* A JSON string or number as a string */
+func jsonScalarString(res interface{}) (string, error) {
+	switch value := res.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case json.Number:
+		return value.String(), nil
 	}
+	return "", fmt.Errorf("'%v' is not a JSON string or number", res)
 }
 
 /*
@@ -66,15 +106,22 @@ GetObjectAtKey is a handy helper that will dig through a map and find something
 Result:
 attrs/id => 1234
 config/foo => "abc"
+
+Paths starting with $. or $[, such as $.items[?primary].id, are JSONPath
+(see jsonpath.go)
 */
 func GetObjectAtKey(data map[string]interface{}, path string, debug bool) (interface{}, error) {
+	if isJSONPath(path) {
+		return getObjectAtJSONPath(data, path, debug)
+	}
+
 	hash := data
 
 	parts := strings.Split(path, "/")
 	part := ""
 	seen := ""
 	if debug {
-		log.Printf("common.go:GetObjectAtKey: Locating results_key in parts: %v...", parts)
+		log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Locating key", map[string]interface{}{"parts": parts}))
 	}
 
 	for len(parts) > 1 {
@@ -89,17 +136,17 @@ func GetObjectAtKey(data map[string]interface{}, path string, debug bool) (inter
 		/* See if this key exists in the hash at this point */
 		if _, ok := hash[part]; ok {
 			if debug {
-				log.Printf("common.go:GetObjectAtKey:  %s - exists", part)
+				log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part exists", map[string]interface{}{"part": part}))
 			}
 			seen += "/" + part
 			if tmp, ok := hash[part].(map[string]interface{}); ok {
 				if debug {
-					log.Printf("common.go:GetObjectAtKey:    %s - is a map", part)
+					log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part is a map", map[string]interface{}{"part": part}))
 				}
 				hash = tmp
 			} else if tmp, ok := hash[part].([]interface{}); ok {
 				if debug {
-					log.Printf("common.go:GetObjectAtKey:    %s - is a list", part)
+					log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part is a list", map[string]interface{}{"part": part}))
 				}
 				mapString := make(map[string]interface{})
 				for key, value := range tmp {
@@ -109,13 +156,13 @@ func GetObjectAtKey(data map[string]interface{}, path string, debug bool) (inter
 				hash = mapString
 			} else {
 				if debug {
-					log.Printf("common.go:GetObjectAtKey:    %s - is a %T", part, hash[part])
+					log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part is not a map or list", map[string]interface{}{"part": part, "type": fmt.Sprintf("%T", hash[part])}))
 				}
 				return nil, fmt.Errorf("GetObjectAtKey: Object at '%s' is not a map. Is this the right path?", seen)
 			}
 		} else {
 			if debug {
-				log.Printf("common.go:GetObjectAtKey:  %s - MISSING", part)
+				log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part is missing", map[string]interface{}{"part": part}))
 			}
 			return nil, fmt.Errorf("GetObjectAtKey: Failed to find '%s' in returned data structure after finding '%s'. Available: %s", part, seen, strings.Join(GetKeys(hash), ","))
 		}
@@ -125,13 +172,13 @@ func GetObjectAtKey(data map[string]interface{}, path string, debug bool) (inter
 	part = parts[0] /* One last time */
 	if _, ok := hash[part]; !ok {
 		if debug {
-			log.Printf("common.go:GetObjectAtKey:  %s - MISSING (available: %s)", part, strings.Join(GetKeys(hash), ","))
+			log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part is missing", map[string]interface{}{"part": part, "available": strings.Join(GetKeys(hash), ",")}))
 		}
 		return nil, fmt.Errorf("GetObjectAtKey: Resulting map at '%s' does not have key '%s'. Available: %s", seen, part, strings.Join(GetKeys(hash), ","))
 	}
 
 	if debug {
-		log.Printf("common.go:GetObjectAtKey:  %s - exists (%v)", part, hash[part])
+		log.Print(formatLog(logObject, "DEBUG", "GetObjectAtKey: Part exists", map[string]interface{}{"part": part, "value": hash[part]}))
 	}
 
 	return hash[part], nil
@@ -146,6 +193,34 @@ func GetKeys(hash map[string]interface{}) []string {
 	return keys
 }
 
+/*
+Replace file with b, writing to a temporary file in the same directory
+
+	first and renaming it into place, so the file is never seen half
+	written. The temporary file has a unique name, so concurrent writers
+	(other clients, or other provider processes) don't write over it
+*/
+func writeFileAtomic(file string, b []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
 /*
 GetEnvOrDefault is a helper function that returns the value of the
 given environment variable, if one exists, or the default value
@@ -183,10 +258,78 @@ func StringToList(input string) []string {
 	return output
 }
 
+/*
+AsyncSettings describe how to wait for an operation the API finishes
+
+	in the background: poll the operation URL (StatusPath filled from
+	the response, found at RedirectUriKey in the response, or in its
+	Location header) until the value at SearchKey is SearchValue (or
+	one of SearchValues), or one of FailureValues. Without a SearchKey,
+	the operation is done once the operation URL stops responding with
+	202 Accepted. With a CallbackKey, the operation URL is a queue of
+	callbacks the API has made, and the operation is followed by its
+	entry in the queue. With an EventsPath, a Server-Sent Events stream
+	is watched instead, and with a WebsocketPath, the messages on a
+	WebSocket. The object is then read from the URL at ResultUriKey in
+	the final status, if set. Meanwhile, the value at ProgressKey is
+	logged
+*/
 type AsyncSettings struct {
+	StatusPath             string
 	RedirectUriKey         string
 	SearchKey              string
 	SearchValue            string
+	SearchValues           []string
+	CaseInsensitive        bool
+	FailureValues          []string
+	CallbackKey            string
+	EventsPath             string
+	EventType              string
+	WebsocketPath          string
+	ResultUriKey           string
+	ProgressKey            string
 	PollInterval           int
 	MaximumPollingDuration int
 }
+
+/* Build AsyncSettings from a *_async block, or nil if it is not set */
+func expandAsyncSettings(configured []interface{}) *AsyncSettings {
+	if len(configured) == 0 || configured[0] == nil {
+		return nil
+	}
+	block := configured[0].(map[string]interface{})
+	return &AsyncSettings{
+		StatusPath:             block["status_path"].(string),
+		RedirectUriKey:         block["redirect_uri_key"].(string),
+		SearchKey:              block["status_key"].(string),
+		SearchValue:            block["status_value"].(string),
+		SearchValues:           expandStringList(block["status_values"].([]interface{})),
+		CaseInsensitive:        block["case_insensitive"].(bool),
+		FailureValues:          expandStringList(block["failure_values"].([]interface{})),
+		CallbackKey:            block["callback_key"].(string),
+		EventsPath:             block["events_path"].(string),
+		EventType:              block["event_type"].(string),
+		WebsocketPath:          block["websocket_path"].(string),
+		ResultUriKey:           block["result_uri_key"].(string),
+		ProgressKey:            block["progress_key"].(string),
+		PollInterval:           block["poll_interval"].(int),
+		MaximumPollingDuration: block["timeout"].(int),
+	}
+}
+
+/*
+Suppress diffs between JSON strings that only differ in formatting
+
+	(key order, whitespace or 1 vs 1.0) rather than content
+*/
+func suppressEquivalentJSON(k, old, new string, d *schema.ResourceData) bool {
+	return jsonEquivalent(old, new)
+}
+
+func jsonEquivalent(a string, b string) bool {
+	var objA, objB interface{}
+	if decodeJSON([]byte(a), &objA) != nil || decodeJSON([]byte(b), &objB) != nil {
+		return false
+	}
+	return jsonValuesEqual(objA, objB)
+}
@@ -163,3 +163,24 @@ func TestGetListStringAtKey(t *testing.T) {
 		t.Fatalf("Error: Expected '2', but got %s", res)
 	}
 }
+
+func TestSuppressEquivalentJSON(t *testing.T) {
+	tests := []struct {
+		old      string
+		new      string
+		suppress bool
+	}{
+		{`{"a":1,"b":"x"}`, `{ "b": "x", "a": 1 }`, true},
+		{`{"a":1}`, "{\n  \"a\": 1.0\n}", true},
+		{`{"a":{"b":[1,2]}}`, `{"a": {"b": [1, 2]}}`, true},
+		{`{"a":{"b":[1,2]}}`, `{"a":{"b":[2,1]}}`, false},
+		{`{"a":1}`, `{"a":"1"}`, false},
+		{``, `{}`, false},
+	}
+
+	for _, test := range tests {
+		if suppress := suppressEquivalentJSON("data", test.old, test.new, nil); suppress != test.suppress {
+			t.Errorf("common_test.go: Expected suppressing the diff from %s to %s to be %t", test.old, test.new, test.suppress)
+		}
+	}
+}
@@ -1,14 +1,14 @@
 package restapi
 
 import (
-	"log"
+	"context"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func dataSourceRestAPI() *schema.Resource {
 	return &schema.Resource{
-		Read: dataSourceRestAPIRead,
+		ReadContext: withThrottleWarning(dataSourceRestAPIRead),
 		Description: "Performs a cURL get command on the specified url.",
 
 		Schema: map[string]*schema.Schema{
@@ -64,7 +64,7 @@ func dataSourceRestAPI() *schema.Resource {
 			"api_data": {
 				Type:        schema.TypeMap,
 				Elem:        &schema.Schema{Type: schema.TypeString},
-				Description: "After data from the API server is read, this map will include k/v pairs usable in other terraform resources as readable objects. Currently the value is the golang fmt package's representation of the value (simple primitives are set as expected, but complex types like arrays and maps contain golang formatting).",
+				Description: "After data from the API server is read, this map will include k/v pairs usable in other terraform resources as readable objects. Currently the value is the golang fmt package's representation of the value (simple primitives are set as expected, but complex types like arrays and maps contain golang formatting). The provider SDK has no attribute type for arbitrary nested data, so for typed values use `jsondecode(api_response)`.",
 				Computed:    true,
 			},
 			"api_response": {
@@ -77,14 +77,17 @@ func dataSourceRestAPI() *schema.Resource {
 	}
 }
 
-func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
+func dataSourceRestAPIRead(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
 	path := d.Get("path").(string)
 	searchPath := d.Get("search_path").(string)
 	queryString := d.Get("query_string").(string)
 	debug := d.Get("debug").(bool)
 	client := meta.(*APIClient)
+	client.lockOperation()
+	defer client.unlockOperation()
+
 	if debug {
-		log.Printf("datasource_api_object.go: Data routine called.")
+		client.log(logObject, "DEBUG", "Data routine called", nil)
 	}
 
 	readQueryString := d.Get("read_query_string").(string)
@@ -98,10 +101,11 @@ func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 	idAttribute := d.Get("id_attribute").(string)
 
 	if debug {
-		log.Printf("datasource_api_object.go:\npath: %s\nsearch_path: %s\nquery_string: %s\nsearch_key: %s\nsearch_value: %s\nresults_key: %s\nid_attribute: %s", path, searchPath, queryString, searchKey, searchValue, resultsKey, idAttribute)
+		client.log(logObject, "DEBUG", "Searching for the data source's object", map[string]interface{}{"path": path, "search_path": searchPath, "query_string": queryString, "search_key": searchKey, "search_value": searchValue, "results_key": resultsKey, "id_attribute": idAttribute})
 	}
 
 	opts := &apiObjectOpts{
+		ctx:         ctx,
 		path:        path,
 		searchPath:  searchPath,
 		debug:       debug,
@@ -120,7 +124,7 @@ func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 
 	/* Back to terraform-specific stuff. Create an api_object with the ID and refresh it object */
 	if debug {
-		log.Printf("datasource_api_object.go: Attempting to construct api_object to refresh data")
+		obj.log("DEBUG", "Attempting to construct api_object to refresh data", nil)
 	}
 
 	d.SetId(obj.id)
@@ -128,7 +132,7 @@ func dataSourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 	err = obj.readObject()
 	if err == nil {
 		/* Setting terraform ID tells terraform the object was created or it exists */
-		log.Printf("datasource_api_object.go: Data resource. Returned id is '%s'\n", obj.id)
+		obj.log("DEBUG", "Data resource", map[string]interface{}{"id": obj.id})
 		d.SetId(obj.id)
 		setResourceState(obj, d)
 	}
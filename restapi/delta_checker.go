@@ -2,6 +2,7 @@ package restapi
 
 import (
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -57,13 +58,13 @@ func getDelta(recordedResource map[string]interface{}, actualResource map[string
 		} else if reflect.TypeOf(valRecorded).Kind() == reflect.Slice {
 			// Since we don't support ignoring differences in lists (besides ignoring the list as a
 			// whole), it is safe to deep compare the two list values.
-			if !reflect.DeepEqual(valRecorded, valActual) {
+			if !jsonValuesEqual(valRecorded, valActual) {
 				modifiedResource[key] = valActual
 				hasChanges = true
 			} else {
 				modifiedResource[key] = valRecorded
 			}
-		} else if valRecorded != valActual {
+		} else if !jsonValuesEqual(valRecorded, valActual) {
 			modifiedResource[key] = valActual
 			hasChanges = true
 		} else {
@@ -94,6 +95,69 @@ func getDelta(recordedResource map[string]interface{}, actualResource map[string
 	return modifiedResource, hasChanges
 }
 
+/*
+ * Returns a copy of actualResource where the keys at the given paths no longer differ from recordedResource:
+ * they are removed if recordedResource does not have them and take the recorded value otherwise.
+ * Paths are '/' delimited, and a '*' component matches any key or array index.
+ */
+func stripServerKeys(recordedResource map[string]interface{}, actualResource map[string]interface{}, paths []string) map[string]interface{} {
+	for _, path := range paths {
+		path = strings.Trim(path, "/")
+		if path == "" {
+			continue
+		}
+		actualResource = _stripPath(recordedResource, actualResource, strings.Split(path, "/")).(map[string]interface{})
+	}
+	return actualResource
+}
+
+func _stripPath(recorded interface{}, actual interface{}, parts []string) interface{} {
+	part, rest := parts[0], parts[1:]
+
+	switch actualValue := actual.(type) {
+	case map[string]interface{}:
+		recordedMap, _ := recorded.(map[string]interface{})
+		stripped := make(map[string]interface{}, len(actualValue))
+		for key, val := range actualValue {
+			stripped[key] = val
+		}
+		for key, val := range actualValue {
+			if part != "*" && part != key {
+				continue
+			}
+			valRecorded, inRecorded := recordedMap[key]
+			if len(rest) > 0 {
+				stripped[key] = _stripPath(valRecorded, val, rest)
+			} else if inRecorded {
+				stripped[key] = valRecorded
+			} else {
+				delete(stripped, key)
+			}
+		}
+		return stripped
+	case []interface{}:
+		// Only keys inside array elements can be stripped, not the elements themselves
+		if len(rest) == 0 {
+			return actual
+		}
+		recordedList, _ := recorded.([]interface{})
+		stripped := make([]interface{}, len(actualValue))
+		copy(stripped, actualValue)
+		for i, val := range actualValue {
+			if part != "*" && part != strconv.Itoa(i) {
+				continue
+			}
+			var valRecorded interface{}
+			if i < len(recordedList) {
+				valRecorded = recordedList[i]
+			}
+			stripped[i] = _stripPath(valRecorded, val, rest)
+		}
+		return stripped
+	}
+	return actual
+}
+
 /*
  * Modifies an ignoreList to be relative to a descended path.
  * E.g. given descendPath = "bar", and the ignoreList [foo, bar.alpha, bar.bravo], this returns [alpha, bravo]
@@ -121,3 +185,44 @@ func contains(list []string, elem string) bool {
 	}
 	return false
 }
+
+/*
+ * Returns a copy of actualResource without the keys that recordedResource does not have, in it or in any nested map.
+ * Used with merge_server_defaults, where keys the user never set are server defaults rather than drift.
+ */
+func dropServerDefaults(recordedResource map[string]interface{}, actualResource map[string]interface{}) map[string]interface{} {
+	dropped := make(map[string]interface{}, len(recordedResource))
+	for key, valActual := range actualResource {
+		valRecorded, ok := recordedResource[key]
+		if !ok {
+			continue
+		}
+		subMapRecorded, okRecorded := valRecorded.(map[string]interface{})
+		subMapActual, okActual := valActual.(map[string]interface{})
+		if okRecorded && okActual {
+			valActual = dropServerDefaults(subMapRecorded, subMapActual)
+		}
+		dropped[key] = valActual
+	}
+	return dropped
+}
+
+/*
+ * Returns a copy of data without the keys at the given paths, which use the dot syntax of ignore_changes_to.
+ * Used to leave create_only_keys out of updates and update_only_keys out of creates.
+ */
+func omitKeys(data map[string]interface{}, paths []string) map[string]interface{} {
+	omitted := make(map[string]interface{}, len(data))
+	for key, val := range data {
+		if contains(paths, key) {
+			continue
+		}
+		if subMap, ok := val.(map[string]interface{}); ok {
+			if deeperPaths := _descendIgnoreList(key, paths); len(deeperPaths) > 0 {
+				val = omitKeys(subMap, deeperPaths)
+			}
+		}
+		omitted[key] = val
+	}
+	return omitted
+}
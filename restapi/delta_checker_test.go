@@ -327,3 +327,89 @@ func TestHasDeltaModifiedResource(t *testing.T) {
 		t.Errorf("delta_checker_test.go: Unexpected delta: expected %v but got %v", expectedOutput, modified)
 	}
 }
+
+func TestStripServerKeys(t *testing.T) {
+	recordedInput := map[string]interface{}{
+		"name": "Joey",
+		"etag": "abc",
+		"rules": []interface{}{
+			map[string]interface{}{"port": 80},
+		},
+	}
+
+	actualInput := map[string]interface{}{
+		"name":       "Joey",
+		"etag":       "def",
+		"updated_at": "2024-01-01",
+		"rules": []interface{}{
+			map[string]interface{}{"port": 80, "hit_count": 12},
+			map[string]interface{}{"port": 443, "hit_count": 3},
+		},
+		"metadata": map[string]interface{}{"revision": 7, "owner": "ops"},
+	}
+
+	expectedOutput := map[string]interface{}{
+		"name": "Joey",
+		"etag": "abc",
+		"rules": []interface{}{
+			map[string]interface{}{"port": 80},
+			map[string]interface{}{"port": 443},
+		},
+		"metadata": map[string]interface{}{"owner": "ops"},
+	}
+
+	stripped := stripServerKeys(recordedInput, actualInput, []string{"etag", "updated_at", "/metadata/revision", "rules/*/hit_count", "missing/key"})
+	if !reflect.DeepEqual(expectedOutput, stripped) {
+		t.Errorf("delta_checker_test.go: Unexpected stripped resource: expected %v but got %v", expectedOutput, stripped)
+	}
+	if _, ok := actualInput["updated_at"]; !ok {
+		t.Errorf("delta_checker_test.go: stripServerKeys modified its input")
+	}
+}
+
+func TestDropServerDefaults(t *testing.T) {
+	recordedInput := map[string]interface{}{
+		"name":   "Joey",
+		"config": map[string]interface{}{"size": 1},
+		"tags":   []interface{}{"a"},
+	}
+
+	actualInput := map[string]interface{}{
+		"name":       "Joey",
+		"created_at": "2024-01-01",
+		"config":     map[string]interface{}{"size": 2, "tier": "basic"},
+		"tags":       []interface{}{"a", "b"},
+	}
+
+	expectedOutput := map[string]interface{}{
+		"name":   "Joey",
+		"config": map[string]interface{}{"size": 2},
+		"tags":   []interface{}{"a", "b"},
+	}
+
+	dropped := dropServerDefaults(recordedInput, actualInput)
+	if !reflect.DeepEqual(expectedOutput, dropped) {
+		t.Errorf("delta_checker_test.go: Unexpected resource without server defaults: expected %v but got %v", expectedOutput, dropped)
+	}
+}
+
+func TestOmitKeys(t *testing.T) {
+	input := map[string]interface{}{
+		"name":     "Joey",
+		"password": "hunter2",
+		"metadata": map[string]interface{}{"owner": "ops", "token": "abc"},
+	}
+
+	expectedOutput := map[string]interface{}{
+		"name":     "Joey",
+		"metadata": map[string]interface{}{"owner": "ops"},
+	}
+
+	omitted := omitKeys(input, []string{"password", "metadata.token", "missing.key"})
+	if !reflect.DeepEqual(omitted, expectedOutput) {
+		t.Errorf("delta_checker_test.go: Expected %v but got %v", expectedOutput, omitted)
+	}
+	if _, ok := input["password"]; !ok {
+		t.Errorf("delta_checker_test.go: omitKeys modified its input")
+	}
+}
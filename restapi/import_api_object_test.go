@@ -1,11 +1,16 @@
 package restapi
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func TestAccRestApiObject_importBasic(t *testing.T) {
@@ -51,11 +56,56 @@ func TestAccRestApiObject_importBasic(t *testing.T) {
 				ImportStateId:       "1234",
 				ImportStateIdPrefix: "/api/objects/",
 				ImportStateVerify:   true,
-				/* create_response isn't populated during import (we don't know the API response from creation) */
-				ImportStateVerifyIgnore: []string{"debug", "data", "create_response"},
+				/* create_response and planned_request aren't populated during import (we don't know the API response from creation, and nothing is planned).
+				   last_request_duration_ms is however long the import's read took */
+				ImportStateVerifyIgnore: []string{"debug", "data", "create_response", "planned_request", "last_request_duration_ms"},
 			},
 		},
 	})
 
 	svr.Shutdown()
 }
+
+func TestParseImportID(t *testing.T) {
+	attrs, err := parseImportID("path=/v2/users, id=123,id_attribute=uuid,read_path=/v2/users/{id}?view=full")
+	if err != nil {
+		t.Fatalf("import_api_object_test.go: %s", err)
+	}
+	expected := map[string]string{"path": "/v2/users", "id": "123", "id_attribute": "uuid", "read_path": "/v2/users/{id}?view=full"}
+	if !reflect.DeepEqual(attrs, expected) {
+		t.Fatalf("import_api_object_test.go: Expected %v but got %v", expected, attrs)
+	}
+
+	for _, input := range []string{"path=/v2/users", "id=123", "path=/v2/users,id=123,data={}", "path=/v2/users,id"} {
+		if _, err := parseImportID(input); err == nil {
+			t.Fatalf("import_api_object_test.go: Expected '%s' to be an invalid import id", input)
+		}
+	}
+}
+
+func TestResourceRestAPIImportLiveData(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/users/123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"uuid":"123","name":"Foo","etag":"abc","meta":{"created":"today","team":"ops"}}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{})
+	d.SetId("path=/v2/users,id=123,id_attribute=uuid,ignore_server_keys=etag;meta/created")
+
+	imported, err := resourceRestAPIImport(context.Background(), d, client)
+	if err != nil || len(imported) != 1 {
+		t.Fatalf("import_api_object_test.go: Expected the import to succeed but got %v", err)
+	}
+	if d.Id() != "123" || d.Get("path") != "/v2/users" || d.Get("id_attribute") != "uuid" {
+		t.Fatalf("import_api_object_test.go: Expected the attributes from the import id to be set but got id '%s', path '%s'", d.Id(), d.Get("path"))
+	}
+	expected := `{"meta":{"team":"ops"},"name":"Foo","uuid":"123"}`
+	if d.Get("data") != expected {
+		t.Fatalf("import_api_object_test.go: Expected data %s but got %s", expected, d.Get("data"))
+	}
+}
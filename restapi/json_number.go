@@ -0,0 +1,94 @@
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"regexp"
+)
+
+/* Matches strings that are valid JSON numbers */
+var jsonNumberRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+/*
+Like json.Unmarshal, but numbers are decoded as json.Number instead
+
+	of float64. Sending data back to the API then does not turn large
+	ids into 1.2345678901234568e+19 or round high-precision decimals.
+*/
+func decodeJSON(data []byte, v interface{}) error {
+	return decodeJSONReader(bytes.NewReader(data), v)
+}
+
+/* Like decodeJSON, but decodes as it reads from r instead of from a copy of all of it */
+func decodeJSONReader(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after the top-level JSON value")
+	}
+	return nil
+}
+
+/* The exact value of a JSON number, whether it was decoded as json.Number or not */
+func jsonNumberValue(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		if !jsonNumberRegexp.MatchString(string(n)) {
+			return nil, false
+		}
+		return new(big.Rat).SetString(string(n))
+	case float64:
+		if r := new(big.Rat).SetFloat64(n); r != nil {
+			return r, true
+		}
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), true
+	}
+	return nil, false
+}
+
+/*
+Deep comparison of decoded JSON values where numbers are equal if
+
+	their values are, so 1, 1.0 and 1e0 are the same number
+*/
+func jsonValuesEqual(a interface{}, b interface{}) bool {
+	if numA, ok := jsonNumberValue(a); ok {
+		numB, ok := jsonNumberValue(b)
+		return ok && numA.Cmp(numB) == 0
+	}
+
+	switch valA := a.(type) {
+	case map[string]interface{}:
+		valB, ok := b.(map[string]interface{})
+		if !ok || len(valA) != len(valB) {
+			return false
+		}
+		for key, val := range valA {
+			other, ok := valB[key]
+			if !ok || !jsonValuesEqual(val, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		valB, ok := b.([]interface{})
+		if !ok || len(valA) != len(valB) {
+			return false
+		}
+		for i := range valA {
+			if !jsonValuesEqual(valA[i], valB[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
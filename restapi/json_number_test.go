@@ -0,0 +1,55 @@
+package restapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	input := `{"id":12345678901234567891,"amount":0.12345678901234567890123,"small":1e-7,"list":[1.50]}`
+	var data map[string]interface{}
+	if err := decodeJSON([]byte(input), &data); err != nil {
+		t.Fatalf("json_number_test.go: %s", err)
+	}
+	output, _ := json.Marshal(data)
+	if string(output) != `{"amount":0.12345678901234567890123,"id":12345678901234567891,"list":[1.50],"small":1e-7}` {
+		t.Fatalf("json_number_test.go: Expected numbers to survive decoding and encoding but got %s", output)
+	}
+
+	if id, _ := GetStringAtKey(data, "id", false); id != "12345678901234567891" {
+		t.Fatalf("json_number_test.go: Expected the exact id but got %s", id)
+	}
+
+	if err := decodeJSON([]byte(`{"a":1} {"b":2}`), &data); err == nil {
+		t.Fatalf("json_number_test.go: Expected an error for data after the JSON object")
+	}
+}
+
+func TestJSONValuesEqual(t *testing.T) {
+	tests := []struct {
+		a     string
+		b     string
+		equal bool
+	}{
+		{`1`, `1.0`, true},
+		{`100`, `1e2`, true},
+		{`12345678901234567890`, `12345678901234567891`, false},
+		{`{"a":[1,{"b":2.50}]}`, `{"a":[1.0,{"b":2.5}]}`, true},
+		{`{"a":1}`, `{"a":1,"b":2}`, false},
+		{`[1,2]`, `[2,1]`, false},
+		{`"1"`, `1`, false},
+	}
+
+	for _, test := range tests {
+		var a, b interface{}
+		decodeJSON([]byte(test.a), &a)
+		decodeJSON([]byte(test.b), &b)
+		if jsonValuesEqual(a, b) != test.equal {
+			t.Errorf("json_number_test.go: Expected %s and %s to be equal: %t", test.a, test.b, test.equal)
+		}
+	}
+
+	if !jsonValuesEqual(float64(3), json.Number("3.0")) {
+		t.Errorf("json_number_test.go: Expected float64 and json.Number numbers to be compared by value")
+	}
+}
@@ -0,0 +1,256 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+A small subset of JSONPath, enough to find ids in nested and
+
+	array-shaped responses:
+	  $.data.id             child keys
+	  $['odd key']          quoted child keys
+	  $.items[0].id         array indexes (negative counts from the end)
+	  $.items[*].id         every element
+	  $.items[?(@.primary)].id            elements where primary is truthy
+	  $.items[?(@.type == 'main')].id     elements where type is (or is not, !=) a value
+	The leading $ is optional, and filters may be written without
+	the parentheses and @. (so items[?primary].id also works)
+*/
+type jsonPathStep struct {
+	key      string
+	index    *int
+	wildcard bool
+	filter   *jsonPathFilter
+}
+
+type jsonPathFilter struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+/*
+Whether a key path should be treated as JSONPath rather than a
+
+	'/'-delimited path. A leading $ on its own is not enough, since
+	keys such as $id are common, and keys containing [ are left to
+	'/'-delimited paths so they resolve as they always have
+*/
+func isJSONPath(path string) bool {
+	return path == "$" || strings.HasPrefix(path, "$.") || strings.HasPrefix(path, "$[")
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	rest := strings.TrimPrefix(path, "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+
+	steps := []jsonPathStep{}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in JSONPath '%s'", path)
+			}
+			if key == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{key: key})
+			}
+			rest = rest[end+1:]
+		case '[':
+			end := closingBracket(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [ in JSONPath '%s'", path)
+			}
+			step, err := parseJSONPathBracket(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("%v in JSONPath '%s'", err, path)
+			}
+			steps = append(steps, step)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in JSONPath '%s'", rest[0], path)
+		}
+	}
+	return steps, nil
+}
+
+/* Index of the ] that closes the [ at the start of s, skipping quoted strings */
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseJSONPathBracket(expr string) (jsonPathStep, error) {
+	switch {
+	case expr == "*":
+		return jsonPathStep{wildcard: true}, nil
+	case strings.HasPrefix(expr, "?"):
+		filter, err := parseJSONPathFilter(expr[1:])
+		return jsonPathStep{filter: filter}, err
+	case len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0]:
+		return jsonPathStep{key: expr[1 : len(expr)-1]}, nil
+	}
+
+	index, err := strconv.Atoi(expr)
+	if err != nil {
+		return jsonPathStep{}, fmt.Errorf("invalid index '%s'", expr)
+	}
+	return jsonPathStep{index: &index}, nil
+}
+
+func parseJSONPathFilter(expr string) (*jsonPathFilter, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+		expr = strings.TrimSpace(expr[1 : len(expr)-1])
+	}
+
+	filter := &jsonPathFilter{}
+	for _, op := range []string{"==", "!="} {
+		if i := strings.Index(expr, op); i >= 0 {
+			literal := strings.TrimSpace(expr[i+len(op):])
+			if strings.HasPrefix(literal, "'") && strings.HasSuffix(literal, "'") && len(literal) >= 2 {
+				literal = strconv.Quote(literal[1 : len(literal)-1])
+			}
+			if err := decodeJSON([]byte(literal), &filter.value); err != nil {
+				return nil, fmt.Errorf("invalid filter value '%s'", literal)
+			}
+			filter.op = op
+			expr = strings.TrimSpace(expr[:i])
+			break
+		}
+	}
+
+	expr = strings.TrimPrefix(strings.TrimPrefix(expr, "@"), ".")
+	if expr == "" {
+		return nil, fmt.Errorf("empty filter")
+	}
+	filter.path = strings.Split(expr, ".")
+	return filter, nil
+}
+
+func (filter *jsonPathFilter) matches(node interface{}) bool {
+	for _, key := range filter.path {
+		hash, ok := node.(map[string]interface{})
+		if !ok {
+			return filter.op == "!="
+		}
+		if node, ok = hash[key]; !ok {
+			return filter.op == "!="
+		}
+	}
+
+	switch filter.op {
+	case "==":
+		return jsonValuesEqual(node, filter.value)
+	case "!=":
+		return !jsonValuesEqual(node, filter.value)
+	}
+	switch v := node.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case json.Number:
+		value, ok := jsonNumberValue(v)
+		return ok && value.Sign() != 0
+	}
+	return true
+}
+
+func (step jsonPathStep) apply(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if step.key != "" {
+			if child, ok := v[step.key]; ok {
+				return []interface{}{child}
+			}
+			return nil
+		}
+		if step.wildcard {
+			keys := GetKeys(v)
+			sort.Strings(keys)
+			results := []interface{}{}
+			for _, key := range keys {
+				results = append(results, v[key])
+			}
+			return results
+		}
+	case []interface{}:
+		switch {
+		case step.index != nil:
+			i := *step.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return []interface{}{v[i]}
+			}
+		case step.wildcard:
+			return v
+		case step.filter != nil:
+			results := []interface{}{}
+			for _, element := range v {
+				if step.filter.matches(element) {
+					results = append(results, element)
+				}
+			}
+			return results
+		}
+	}
+	return nil
+}
+
+/* Like GetObjectAtKey, but for JSONPath. Returns the first match */
+func getObjectAtJSONPath(data map[string]interface{}, path string, debug bool) (interface{}, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []interface{}{data}
+	for _, step := range steps {
+		next := []interface{}{}
+		for _, node := range nodes {
+			next = append(next, step.apply(node)...)
+		}
+		nodes = next
+	}
+
+	if debug {
+		log.Print(formatLog(logObject, "DEBUG", "JSONPath matched", map[string]interface{}{"path": path, "matches": len(nodes)}))
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("GetObjectAtKey: JSONPath '%s' did not match anything in the returned data structure", path)
+	}
+	return nodes[0], nil
+}
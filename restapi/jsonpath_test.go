@@ -0,0 +1,51 @@
+package restapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetObjectAtJSONPath(t *testing.T) {
+	data := map[string]interface{}{}
+	json.Unmarshal([]byte(`{
+		"$id": "schema",
+		"tags[0]": "literal",
+		"data": { "id": "top", "odd key": "odd" },
+		"items": [
+			{ "id": 1, "type": "backup" },
+			{ "id": 2, "type": "main", "primary": true },
+			{ "id": 3, "meta": { "state": "active" } }
+		]
+	}`), &data)
+
+	tests := map[string]string{
+		"$.data.id":                                 "top",
+		"$['data']['odd key']":                      "odd",
+		"$.items[0].id":                             "1",
+		"$.items[-1].id":                            "3",
+		"$.items[*].id":                             "1",
+		"$.items[?primary].id":                      "2",
+		"$.items[?(@.primary)].id":                  "2",
+		"$.items[?(@.type == 'main')].id":           "2",
+		"$.items[?(@.type != 'backup')].id":         "2",
+		"$.items[?(@.meta.state == \"active\")].id": "3",
+		"$.items[?(@.id == 3)].id":                  "3",
+		"$id":                                       "schema",
+		"tags[0]":                                   "literal",
+	}
+	for path, expected := range tests {
+		res, err := GetStringAtKey(data, path, false)
+		if err != nil {
+			t.Fatalf("jsonpath_test.go: Failed to get '%s': %s", path, err)
+		}
+		if res != expected {
+			t.Fatalf("jsonpath_test.go: Expected '%s' at '%s' but got '%s'", expected, path, res)
+		}
+	}
+
+	for _, path := range []string{"$.items[5].id", "$.items[?missing].id", "$.items[0", "$.items[abc]", "$..id"} {
+		if _, err := GetObjectAtKey(data, path, false); err == nil {
+			t.Fatalf("jsonpath_test.go: Expected an error for '%s'", path)
+		}
+	}
+}
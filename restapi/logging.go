@@ -0,0 +1,166 @@
+package restapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+/*
+Subsystems of the provider's logs. Their level can be set apart from
+
+	the provider's with TF_LOG_PROVIDER_RESTAPI_TRANSPORT and so on
+*/
+const (
+	logTransport = "transport"
+	logAuth      = "auth"
+	logAsync     = "async"
+	logObject    = "object"
+)
+
+/*
+Add the provider's subsystem loggers to ctx, which must carry the
+
+	SDK's provider logger (as the context of ConfigureContextFunc does)
+*/
+func withSubsystemLoggers(ctx context.Context) context.Context {
+	for _, subsystem := range []string{logTransport, logAuth, logAsync, logObject} {
+		ctx = tflog.NewSubsystem(ctx, subsystem, tflog.WithLevelFromEnv("TF_LOG_PROVIDER_RESTAPI", strings.ToUpper(subsystem)))
+	}
+	return ctx
+}
+
+/*
+Log msg at level (TRACE, DEBUG, INFO, WARN or ERROR) to a subsystem
+
+	logger. Clients built outside of the provider (such as in tests)
+	have no log context, so their logs go to the standard logger
+*/
+func (client *APIClient) log(subsystem string, level string, msg string, fields map[string]interface{}) {
+	if client.logCtx == nil {
+		log.Print(formatLog(subsystem, level, msg, fields))
+		return
+	}
+
+	switch level {
+	case "TRACE":
+		tflog.SubsystemTrace(client.logCtx, subsystem, msg, fields)
+	case "DEBUG":
+		tflog.SubsystemDebug(client.logCtx, subsystem, msg, fields)
+	case "INFO":
+		tflog.SubsystemInfo(client.logCtx, subsystem, msg, fields)
+	case "WARN":
+		tflog.SubsystemWarn(client.logCtx, subsystem, msg, fields)
+	default:
+		tflog.SubsystemError(client.logCtx, subsystem, msg, fields)
+	}
+}
+
+/* A log line for the standard logger, such as "[DEBUG] transport: Sending request: method=GET" */
+func formatLog(subsystem string, level string, msg string, fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s: %s", level, subsystem, msg)
+	for i, k := range keys {
+		if i == 0 {
+			b.WriteString(":")
+		}
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+/*
+Log a debug message about a request, such as its dumps. These go to
+
+	debug_log_file if it is set, rather than the transport subsystem
+*/
+func (client *APIClient) debugLog(msg string, fields map[string]interface{}) {
+	if client.debugFile == nil {
+		client.log(logTransport, "DEBUG", msg, fields)
+		return
+	}
+	if err := client.debugFile.write(time.Now().Format(time.RFC3339Nano) + " " + formatLog(logTransport, "DEBUG", msg, fields) + "\n"); err != nil {
+		client.log(logTransport, "WARN", "Failed to write debug_log_file", map[string]interface{}{"file": client.debugFile.name, "error": err.Error()})
+	}
+}
+
+/* How many rotated debug_log_file files (file.1 to file.3) are kept */
+const debugLogBackups = 3
+
+/*
+debug_log_file, which is rotated (file to file.1, file.1 to file.2
+
+	and so on) before a write would make it larger than maxSize bytes
+*/
+type rotatingFile struct {
+	mutex   sync.Mutex
+	name    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+/* Open debug_log_file to append to, or return nil if it is not set */
+func openRotatingFile(name string, maxSize int64) (*rotatingFile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	r := &rotatingFile{name: name, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, fmt.Errorf("debug_log_file '%s' could not be opened: %v", name, err)
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) write(line string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.WriteString(line)
+	r.size += int64(n)
+	return err
+}
+
+/* Must hold the mutex */
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	for i := debugLogBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.name, i), fmt.Sprintf("%s.%d", r.name, i+1))
+	}
+	if err := os.Rename(r.name, r.name+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
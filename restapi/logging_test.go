@@ -0,0 +1,55 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatLog(t *testing.T) {
+	line := formatLog(logTransport, "WARN", "Retrying request", map[string]interface{}{"path": "/api/objects", "method": "GET", "retry": 1})
+	if line != "[WARN] transport: Retrying request: method=GET path=/api/objects retry=1" {
+		t.Fatalf("logging_test.go: Unexpected log line: %s", line)
+	}
+	if line := formatLog(logAuth, "DEBUG", "Authenticating with a GCP service account", nil); line != "[DEBUG] auth: Authenticating with a GCP service account" {
+		t.Fatalf("logging_test.go: Unexpected log line: %s", line)
+	}
+}
+
+func TestDebugLogFile(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer svr.Close()
+
+	file := filepath.Join(t.TempDir(), "debug.log")
+	client, err := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, debug: true, debugLogFile: file, debugLogMaxSize: 2048})
+	if err != nil {
+		t.Fatalf("logging_test.go: %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := client.sendRequest("GET", "/api/objects/1", ""); err != nil {
+			t.Fatalf("logging_test.go: %s", err)
+		}
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil || !strings.Contains(string(b), "[DEBUG] transport: Response body: body={\"id\":\"1\"}") {
+		t.Fatalf("logging_test.go: Expected the dumps to be written to debug_log_file but got %s (%v)", b, err)
+	}
+	if len(b) > 2048 {
+		t.Errorf("logging_test.go: Expected debug_log_file to be rotated at 2048 bytes but it is %d", len(b))
+	}
+	for i := 1; i <= debugLogBackups; i++ {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", file, i)); err != nil {
+			t.Errorf("logging_test.go: Expected rotated file %d to be kept: %s", i, err)
+		}
+	}
+	if _, err := os.Stat(file + ".4"); err == nil {
+		t.Errorf("logging_test.go: Expected only %d rotated files to be kept", debugLogBackups)
+	}
+}
@@ -0,0 +1,71 @@
+package restapi
+
+/* Valid values for update_mode */
+var updateModes = []string{"put", "merge_patch"}
+
+/*
+Build an RFC 7386 JSON merge patch that turns prior into desired.
+
+	Only keys whose values changed are included, objects are patched
+	recursively and keys removed from desired are set to null so the
+	server deletes them. Arrays are always replaced as a whole.
+*/
+func mergePatch(prior map[string]interface{}, desired map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+
+	for key, desiredValue := range desired {
+		priorValue, ok := prior[key]
+		if !ok {
+			patch[key] = desiredValue
+			continue
+		}
+		if jsonValuesEqual(priorValue, desiredValue) {
+			continue
+		}
+
+		priorMap, priorIsMap := priorValue.(map[string]interface{})
+		desiredMap, desiredIsMap := desiredValue.(map[string]interface{})
+		if priorIsMap && desiredIsMap {
+			patch[key] = mergePatch(priorMap, desiredMap)
+		} else {
+			patch[key] = desiredValue
+		}
+	}
+
+	for key := range prior {
+		if _, ok := desired[key]; !ok {
+			patch[key] = nil
+		}
+	}
+
+	return patch
+}
+
+/*
+Add the server's defaults to desired, so that sending it as a whole
+
+	(with update_mode put) does not reset them. Server defaults are the
+	keys in server that are neither in desired nor in prior, since keys
+	in prior were set by the user and have since been removed.
+	Objects are merged recursively and arrays are never merged.
+*/
+func mergeServerDefaults(desired map[string]interface{}, prior map[string]interface{}, server map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(server))
+	for key, serverValue := range server {
+		if _, ok := prior[key]; !ok {
+			merged[key] = serverValue
+		}
+	}
+
+	for key, desiredValue := range desired {
+		desiredMap, desiredIsMap := desiredValue.(map[string]interface{})
+		serverMap, serverIsMap := server[key].(map[string]interface{})
+		if desiredIsMap && serverIsMap {
+			priorMap, _ := prior[key].(map[string]interface{})
+			desiredValue = mergeServerDefaults(desiredMap, priorMap, serverMap)
+		}
+		merged[key] = desiredValue
+	}
+
+	return merged
+}
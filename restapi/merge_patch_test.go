@@ -0,0 +1,61 @@
+package restapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		prior   string
+		desired string
+		patch   string
+	}{
+		{`{"a":1,"b":"x"}`, `{"a":1,"b":"x"}`, `{}`},
+		{`{"a":1,"b":"x"}`, `{"a":2,"b":"x"}`, `{"a":2}`},
+		{`{"a":1,"b":"x"}`, `{"a":1}`, `{"b":null}`},
+		{`{"a":1}`, `{"a":1,"c":[1,2]}`, `{"c":[1,2]}`},
+		{`{"o":{"x":1,"y":2}}`, `{"o":{"x":1,"y":3}}`, `{"o":{"y":3}}`},
+		{`{"o":{"x":1,"y":2}}`, `{"o":{"x":1}}`, `{"o":{"y":null}}`},
+		{`{"l":[1,2,3]}`, `{"l":[1,2]}`, `{"l":[1,2]}`},
+		{`{"o":{"x":1}}`, `{"o":"flat"}`, `{"o":"flat"}`},
+	}
+
+	for _, test := range tests {
+		var prior, desired map[string]interface{}
+		json.Unmarshal([]byte(test.prior), &prior)
+		json.Unmarshal([]byte(test.desired), &desired)
+
+		patch, _ := json.Marshal(mergePatch(prior, desired))
+		if string(patch) != test.patch {
+			t.Fatalf("merge_patch_test.go: Expected patch from %s to %s to be %s but got %s", test.prior, test.desired, test.patch, patch)
+		}
+	}
+}
+
+func TestMergeServerDefaults(t *testing.T) {
+	tests := []struct {
+		desired  string
+		prior    string
+		server   string
+		expected string
+	}{
+		{`{"a":2}`, `{"a":1}`, `{"a":1,"tier":"basic"}`, `{"a":2,"tier":"basic"}`},
+		{`{"a":1}`, `{"a":1,"b":"x"}`, `{"a":1,"b":"x","tier":"basic"}`, `{"a":1,"tier":"basic"}`},
+		{`{"o":{"x":2}}`, `{"o":{"x":1}}`, `{"o":{"x":1,"y":0},"id":"7"}`, `{"id":"7","o":{"x":2,"y":0}}`},
+		{`{"l":[1]}`, `{"l":[1]}`, `{"l":[1,2]}`, `{"l":[1]}`},
+		{`{"a":1}`, `{}`, `{}`, `{"a":1}`},
+	}
+
+	for _, test := range tests {
+		var desired, prior, server map[string]interface{}
+		json.Unmarshal([]byte(test.desired), &desired)
+		json.Unmarshal([]byte(test.prior), &prior)
+		json.Unmarshal([]byte(test.server), &server)
+
+		merged, _ := json.Marshal(mergeServerDefaults(desired, prior, server))
+		if string(merged) != test.expected {
+			t.Fatalf("merge_patch_test.go: Expected %s merged with server defaults from %s to be %s but got %s", test.desired, test.server, test.expected, merged)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package restapi
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+normalizeOpts describes the rules from a resource's normalize block.
+
+	They are applied to both the recorded data and the API response
+	before looking for remote changes so that differences the API
+	does not care about do not show up as drift.
+*/
+type normalizeOpts struct {
+	lowercaseKeys  bool
+	trimWhitespace bool
+	numericStrings bool
+	sortArrays     [][]string
+	setArrays      [][]string
+}
+
+func (opts *normalizeOpts) normalizeObject(data map[string]interface{}) map[string]interface{} {
+	return opts.normalize(data, []string{}).(map[string]interface{})
+}
+
+/* Returns a normalized copy of value, where path is the location of value in the object */
+func (opts *normalizeOpts) normalize(value interface{}, path []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			if opts.lowercaseKeys {
+				key = strings.ToLower(key)
+			}
+			normalized[key] = opts.normalize(val, append(path[:len(path):len(path)], key))
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, val := range v {
+			normalized[i] = opts.normalize(val, append(path[:len(path):len(path)], strconv.Itoa(i)))
+		}
+		isSet := matchesAnyPath(opts.setArrays, path, opts.lowercaseKeys)
+		if isSet || matchesAnyPath(opts.sortArrays, path, opts.lowercaseKeys) {
+			sort.SliceStable(normalized, func(i, j int) bool {
+				return lessJSON(normalized[i], normalized[j])
+			})
+		}
+		if isSet {
+			normalized = dedupeSorted(normalized)
+		}
+		return normalized
+	case string:
+		if opts.trimWhitespace {
+			v = strings.TrimSpace(v)
+		}
+		if opts.numericStrings {
+			if jsonNumberRegexp.MatchString(v) {
+				return json.Number(v)
+			}
+		}
+		return v
+	}
+	return value
+}
+
+/* Whether path matches one of patterns, where "*" matches any key or array index */
+func matchesAnyPath(patterns [][]string, path []string, lowercaseKeys bool) bool {
+	for _, pattern := range patterns {
+		if len(pattern) != len(path) {
+			continue
+		}
+		matches := true
+		for i, part := range pattern {
+			if lowercaseKeys {
+				part = strings.ToLower(part)
+			}
+			if part != "*" && part != path[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+/* Remove repeated elements from a sorted array */
+func dedupeSorted(list []interface{}) []interface{} {
+	deduped := make([]interface{}, 0, len(list))
+	for i, val := range list {
+		if i > 0 && jsonValuesEqual(val, list[i-1]) {
+			continue
+		}
+		deduped = append(deduped, val)
+	}
+	return deduped
+}
+
+/* Numbers are ordered numerically and anything else by its JSON encoding, which sorts map keys */
+func lessJSON(a interface{}, b interface{}) bool {
+	numA, okA := jsonNumberValue(a)
+	numB, okB := jsonNumberValue(b)
+	if okA && okB {
+		return numA.Cmp(numB) < 0
+	}
+	jsonA, _ := json.Marshal(a)
+	jsonB, _ := json.Marshal(b)
+	return string(jsonA) < string(jsonB)
+}
+
+/*
+Undo normalizing in delta, the getDelta of normalized recorded and
+
+	actual objects, so what is written back to data keeps the form the
+	configuration uses. Values that are equal once normalized come from
+	recorded, and the rest from actual
+*/
+func (opts *normalizeOpts) denormalizeDelta(delta map[string]interface{}, recorded map[string]interface{}, actual map[string]interface{}, path []string) map[string]interface{} {
+	result := make(map[string]interface{}, len(delta))
+	for key, value := range delta {
+		keyPath := append(path[:len(path):len(path)], key)
+		recordedKey, recordedValue, inRecorded := opts.rawKey(recorded, key)
+		actualKey, actualValue, inActual := opts.rawKey(actual, key)
+		deltaMap, deltaIsMap := value.(map[string]interface{})
+		recordedMap, recordedIsMap := recordedValue.(map[string]interface{})
+		actualMap, actualIsMap := actualValue.(map[string]interface{})
+
+		switch {
+		case inRecorded && jsonValuesEqual(value, opts.normalize(recordedValue, keyPath)):
+			result[recordedKey] = recordedValue
+		case deltaIsMap && recordedIsMap && actualIsMap:
+			result[recordedKey] = opts.denormalizeDelta(deltaMap, recordedMap, actualMap, keyPath)
+		case inRecorded && inActual:
+			result[recordedKey] = actualValue
+		case inActual:
+			result[actualKey] = actualValue
+		case inRecorded:
+			result[recordedKey] = value
+		default:
+			result[key] = value
+		}
+	}
+	return result
+}
+
+/* The key of m that normalizes to key, and its value */
+func (opts *normalizeOpts) rawKey(m map[string]interface{}, key string) (string, interface{}, bool) {
+	if !opts.lowercaseKeys {
+		value, ok := m[key]
+		return key, value, ok
+	}
+	for k, value := range m {
+		if strings.ToLower(k) == key {
+			return k, value, true
+		}
+	}
+	return key, nil, false
+}
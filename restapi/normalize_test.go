@@ -0,0 +1,53 @@
+package restapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		opts     normalizeOpts
+		input    string
+		expected string
+	}{
+		{normalizeOpts{}, `{"Name":" a ","n":"1","l":[2,1]}`, `{"Name":" a ","l":[2,1],"n":"1"}`},
+		{normalizeOpts{lowercaseKeys: true}, `{"Name":"A","Inner":{"Key":1}}`, `{"inner":{"key":1},"name":"A"}`},
+		{normalizeOpts{trimWhitespace: true}, `{"name":"  a\n","list":[" b "]}`, `{"list":["b"],"name":"a"}`},
+		{normalizeOpts{numericStrings: true}, `{"a":"1","b":"1.50","c":"abc","d":"NaN"}`, `{"a":1,"b":1.50,"c":"abc","d":"NaN"}`},
+		{normalizeOpts{sortArrays: [][]string{{"tags"}}}, `{"tags":["b","a"],"other":["b","a"]}`, `{"other":["b","a"],"tags":["a","b"]}`},
+		{normalizeOpts{sortArrays: [][]string{{"rules", "*", "ports"}}}, `{"rules":[{"ports":[443,80]},{"ports":[22,21]}]}`, `{"rules":[{"ports":[80,443]},{"ports":[21,22]}]}`},
+		{normalizeOpts{sortArrays: [][]string{{"Rules"}}, lowercaseKeys: true}, `{"RULES":[{"b":1},{"a":2}]}`, `{"rules":[{"a":2},{"b":1}]}`},
+		{normalizeOpts{sortArrays: [][]string{{"tags"}}}, `{"tags":["b","a","b"]}`, `{"tags":["a","b","b"]}`},
+		{normalizeOpts{setArrays: [][]string{{"tags"}}}, `{"tags":["b","a","b"]}`, `{"tags":["a","b"]}`},
+		{normalizeOpts{setArrays: [][]string{{"rules"}}}, `{"rules":[{"b":1},{"a":2},{"b":1.0}]}`, `{"rules":[{"a":2},{"b":1}]}`},
+	}
+
+	for _, test := range tests {
+		var input map[string]interface{}
+		json.Unmarshal([]byte(test.input), &input)
+
+		normalized, _ := json.Marshal(test.opts.normalizeObject(input))
+		if string(normalized) != test.expected {
+			t.Errorf("normalize_test.go: Expected %s to normalize to %s but got %s", test.input, test.expected, normalized)
+		}
+	}
+}
+
+func TestNormalizeDenormalizeDelta(t *testing.T) {
+	opts := normalizeOpts{trimWhitespace: true, lowercaseKeys: true, sortArrays: [][]string{{"tags"}}}
+	var recorded, actual map[string]interface{}
+	json.Unmarshal([]byte(`{"Name":" Foo ","Tags":["b","a"],"Spec":{"Size":"1","Mode":" fast"},"Gone":"x"}`), &recorded)
+	json.Unmarshal([]byte(`{"name":"Foo","tags":["a","b"],"spec":{"size":"2","mode":"fast"},"new":"y"}`), &actual)
+
+	delta, hasDifferences := getDelta(opts.normalizeObject(recorded), opts.normalizeObject(actual), []string{})
+	if !hasDifferences {
+		t.Fatalf("normalize_test.go: Expected differences")
+	}
+	/* Only real changes are taken from the API, so data keeps its own form */
+	result, _ := json.Marshal(opts.denormalizeDelta(delta, recorded, actual, []string{}))
+	expected := `{"Gone":null,"Name":" Foo ","Spec":{"Mode":" fast","Size":"2"},"Tags":["b","a"],"new":"y"}`
+	if string(result) != expected {
+		t.Fatalf("normalize_test.go: Expected %s but got %s", expected, result)
+	}
+}
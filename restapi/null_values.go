@@ -0,0 +1,59 @@
+package restapi
+
+/*
+Prepare data for a request body. With omit_null_keys, keys whose value
+
+	is null are left out, so optional values in a jsonencode() can be
+	set to null to omit them. With null_value, strings equal to it are
+	sent as null, so a field can still be cleared explicitly.
+*/
+func (obj *APIObject) resolveNulls(data map[string]interface{}) map[string]interface{} {
+	if !obj.omitNullKeys && obj.nullValue == "" {
+		return data
+	}
+	return obj._resolveNulls(data).(map[string]interface{})
+}
+
+func (obj *APIObject) _resolveNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			if val == nil && obj.omitNullKeys {
+				continue
+			}
+			resolved[key] = obj._resolveNulls(val)
+		}
+		return resolved
+	case []interface{}:
+		/* Array elements cannot be left out without moving the others, so only null_value applies */
+		resolved := make([]interface{}, len(v))
+		for i, val := range v {
+			resolved[i] = obj._resolveNulls(val)
+		}
+		return resolved
+	case string:
+		if obj.nullValue != "" && v == obj.nullValue {
+			return nil
+		}
+	}
+	return value
+}
+
+/* With omit_null_keys, null values in a response are the same as missing keys */
+func (obj *APIObject) dropNullKeys(data map[string]interface{}) map[string]interface{} {
+	if !obj.omitNullKeys {
+		return data
+	}
+	dropped := make(map[string]interface{}, len(data))
+	for key, val := range data {
+		switch v := val.(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			val = obj.dropNullKeys(v)
+		}
+		dropped[key] = val
+	}
+	return dropped
+}
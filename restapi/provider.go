@@ -1,11 +1,15 @@
 package restapi
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net/url"
+	"strconv"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 /*Provider implements the REST API provider*/
@@ -18,6 +22,18 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_URI", nil),
 				Description: "URI of the REST API endpoint. This serves as the base of all requests.",
 			},
+			"failover_uris": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Additional base URIs of the same API (such as the standby of an HA pair). When the active endpoint cannot be reached, requests fail over to the next one in order, starting with `uri`.",
+			},
+			"health_check_path": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_HEALTH_CHECK_PATH", nil),
+				Description: "When set along with `failover_uris`, a GET to this path must return a 2xx response for an endpoint to be used. The first request and every failover pick the first healthy endpoint.",
+			},
 			"insecure": {
 				Type:        schema.TypeBool,
 				Optional:    true,
@@ -42,23 +58,107 @@ func Provider() *schema.Provider {
 				Optional:    true,
 				Description: "A map of header names and values to set on all outbound requests. This is useful if you want to use a script via the 'external' provider or provide a pre-approved token or change Content-Type from `application/json`. If `username` and `password` are set and Authorization is one of the headers defined here, the BASIC auth credentials take precedence.",
 			},
+			"host_header": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_HOST_HEADER", nil),
+				Description: "When set, this value is sent as the HTTP Host header instead of the host in `uri`. This is useful when reaching an API by IP address or through a shared ingress. Setting `Host` in `headers` has no effect.",
+			},
 			"use_cookies": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_USE_COOKIES", nil),
 				Description: "Enable cookie jar to persist session.",
 			},
+			"force_http2": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_FORCE_HTTP2", nil),
+				Description: "When using https, attempt to negotiate HTTP/2 with the server even though custom TLS settings are in use. Cannot be combined with `disable_http2`.",
+			},
+			"disable_http2": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_DISABLE_HTTP2", nil),
+				Description: "When set, the provider will only speak HTTP/1.1 to the server. This is useful for gateways that misbehave when HTTP/2 is negotiated. Cannot be combined with `force_http2`.",
+			},
+			"use_http3": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_USE_HTTP3", nil),
+				Description: "EXPERIMENTAL: When set, requests are sent using HTTP/3 (QUIC) instead of HTTP/1.1 or HTTP/2. The server must support HTTP/3 over https and proxy settings from the environment are not honored.",
+			},
+			"unix_socket": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_UNIX_SOCKET", nil),
+				Description: "When set, all connections are made to this unix domain socket instead of the host in `uri`. The `uri` must still be set (such as `http://localhost/api`) and its path is used as the base of all requests.",
+			},
+			"host_overrides": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A map of hostnames to the `ip` or `ip:port` the provider should connect to instead of resolving the hostname, similar to an /etc/hosts entry. TLS verification and the Host header continue to use the hostname. This is useful for blue/green backends or endpoints not yet in DNS.",
+			},
 			"timeout": {
 				Type:        schema.TypeInt,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_TIMEOUT", 0),
-				Description: "When set, will cause requests taking longer than this time (in seconds) to be aborted.",
+				Description: "When set, will cause requests taking longer than this time (in seconds) to be aborted. This includes reading the whole response, so consider `connect_timeout` and `response_header_timeout` instead for APIs returning large responses.",
+			},
+			"connect_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CONNECT_TIMEOUT", 0),
+				Description: "When set, establishing a connection to the API server fails after this many seconds so unreachable hosts fail fast. Zero means the operating system's limit applies.",
+			},
+			"tls_handshake_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_TLS_HANDSHAKE_TIMEOUT", 0),
+				Description: "When set, the TLS handshake fails after this many seconds. Zero means no limit.",
+			},
+			"response_header_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_RESPONSE_HEADER_TIMEOUT", 0),
+				Description: "When set, requests fail if the server has not sent the response headers this many seconds after the request was written. Reading the response body is not limited. Zero means no limit.",
+			},
+			"max_idle_conns": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_IDLE_CONNS", 0),
+				Description: "When set, limits the number of idle (keep-alive) connections kept open across all hosts. Zero means no limit.",
+			},
+			"max_idle_conns_per_host": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_IDLE_CONNS_PER_HOST", 0),
+				Description: "When set, limits the number of idle (keep-alive) connections kept open to each host. Defaults to golang's default of 2, which causes connection churn during large applies.",
+			},
+			"max_conns_per_host": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_CONNS_PER_HOST", 0),
+				Description: "When set, limits the total number of connections (active and idle) to each host. Requests beyond this limit wait for a connection to become available. Zero means no limit.",
+			},
+			"idle_conn_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_IDLE_CONN_TIMEOUT", 0),
+				Description: "When set, idle (keep-alive) connections are closed after this many seconds. Zero means idle connections are kept until the server closes them.",
 			},
 			"id_attribute": {
 				Type:        schema.TypeString,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_ID_ATTRIBUTE", nil),
-				Description: "When set, this key will be used to operate on REST objects. For example, if the ID is set to 'name', changes to the API object will be to http://foo.com/bar/VALUE_OF_NAME. This value may also be a '/'-delimeted path to the id attribute if it is multple levels deep in the data (such as `attributes/id` in the case of an object `{ \"attributes\": { \"id\": 1234 }, \"config\": { \"name\": \"foo\", \"something\": \"bar\"}}`",
+				Description: "When set, this key will be used to operate on REST objects. For example, if the ID is set to 'name', changes to the API object will be to http://foo.com/bar/VALUE_OF_NAME. This value may also be a '/'-delimeted path to the id attribute if it is multple levels deep in the data (such as `attributes/id` in the case of an object `{ \"attributes\": { \"id\": 1234 }, \"config\": { \"name\": \"foo\", \"something\": \"bar\"}}`. For ids in arrays or behind a condition, use a JSONPath such as `$.items[0].id` or `$.items[?(@.primary)].id` (any path starting with `$.` or `$[` is treated as JSONPath).",
+			},
+			"id_attributes": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Paths to the id (each as in `id_attribute`) tried in order until one is found, such as `[\"uuid\", \"id\", \"data/id\"]`, for APIs whose endpoints return ids under different keys. Takes the place of `id_attribute`, unless a resource sets its own `id_attribute`.",
 			},
 			"create_method": {
 				Type:        schema.TypeString,
@@ -78,6 +178,20 @@ func Provider() *schema.Provider {
 				Description: "Defaults to `PUT`. The HTTP method used to UPDATE objects of this type on the API server.",
 				Optional:    true,
 			},
+			"update_mode": {
+				Type:         schema.TypeString,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_UPDATE_MODE", nil),
+				Description:  "Defaults to `put`, which sends the whole `data` object on UPDATE. With `merge_patch`, only the keys that changed since the last apply are sent as an RFC 7386 JSON merge patch (with `Content-Type: application/merge-patch+json`), so server-managed fields left out of `data` are not reset. This is normally combined with `update_method = \"PATCH\"`.",
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(updateModes, false),
+			},
+			"payload_format": {
+				Type:         schema.TypeString,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_PAYLOAD_FORMAT", nil),
+				Description:  "Defaults to `json`. The format request bodies are sent in: `json`, `yaml` or `form` (`application/x-www-form-urlencoded`, for flat objects). `data` and the other attributes stay JSON and are converted to this format, which also sets the `Content-Type` and `Accept` headers unless `headers` does (`form` asks for JSON). Responses are decoded by their `Content-Type`, falling back to JSON and then this format.",
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(payloadFormats(), false),
+			},
 			"destroy_method": {
 				Type:        schema.TypeString,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_DESTROY_METHOD", nil),
@@ -92,16 +206,22 @@ func Provider() *schema.Provider {
 				Optional:    true,
 				Description: "When set, any PUT to the API for an object will copy these keys from the data the provider has gathered about the object. This is useful if internal API information must also be provided with updates, such as the revision of the object.",
 			},
+			"copy_key_paths": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Like `copy_keys`, but a map of keys in the data sent with updates to the paths their values are copied from in the API response. Keys may be `/`-delimited paths such as `metadata/revision`, and the paths may be JSONPath (see `id_attribute`), so values nested deep in responses can be sent back under another name.",
+			},
 			"write_returns_object": {
 				Type:        schema.TypeBool,
 				Optional:    true,
-				DefaultFunc: schema.EnvDefaultFunc("REST_API_WRO", nil),
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{"REST_API_WRITE_RETURNS_OBJECT", "REST_API_WRO"}, nil),
 				Description: "Set this when the API returns the object created on all write operations (POST, PUT). This is used by the provider to refresh internal data structures.",
 			},
 			"create_returns_object": {
 				Type:        schema.TypeBool,
 				Optional:    true,
-				DefaultFunc: schema.EnvDefaultFunc("REST_API_CRO", nil),
+				DefaultFunc: schema.MultiEnvDefaultFunc([]string{"REST_API_CREATE_RETURNS_OBJECT", "REST_API_CRO"}, nil),
 				Description: "Set this when the API returns the object created only on creation operations (POST). This is used by the provider to refresh internal data structures.",
 			},
 			"xssi_prefix": {
@@ -110,12 +230,205 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_XSSI_PREFIX", nil),
 				Description: "Trim the xssi prefix from response string, if present, before parsing.",
 			},
+			"error_message_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_ERROR_MESSAGE_KEY", nil),
+				Description: "The path to the error message in JSON error responses (a JSONPath such as `$.errors[0].message` or a `/`-delimited path such as `error/message`, as with `id_attribute`). When set and found, errors show this message instead of the whole response body. `application/problem+json` (RFC 7807) responses are always shown as their `title` and `detail`.",
+			},
+			"request_id_header": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_REQUEST_ID_HEADER", nil),
+				Description: "When set, such as to `X-Request-Id`, every request is sent with this header set to a new random id (unless `headers` sets it), which is logged. Errors show the id the API returned in this header, or else the one sent, to find the failed request in the API's logs.",
+			},
+			"curl_on_error": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CURL_ON_ERROR", nil),
+				Description: "When set to `true`, errors from failed requests include a `curl` command that sends the request again, to reproduce and iterate on it outside of Terraform. Values of sensitive headers (see `sensitive_headers`) are left to environment variables named after the header, such as `$AUTHORIZATION`, and `sensitive_keys` are masked in the body and query string, along with query parameters such as `api_key`, `token` and `password`. With `debug`, the command for every request is logged. Defaults to `false`.",
+			},
 			"rate_limit": {
 				Type:        schema.TypeFloat,
 				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_RATE_LIMIT", math.MaxFloat64),
 				Description: "Set this to limit the number of requests per second made to the API.",
 			},
+			"rate_limits": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Additional rate limits applied to requests matching a path prefix and/or HTTP methods, such as a lower limit for writes than for reads. Blocks are evaluated in order and the first match is used instead of `rate_limit`. Requests matching no block use `rate_limit`.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path_prefix": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Only requests whose path (relative to `uri`) begins with this value match. Matches all paths when omitted.",
+						},
+						"methods": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Optional:    true,
+							Description: "Only requests using one of these HTTP methods match. Matches all methods when omitted.",
+						},
+						"rate_limit": {
+							Type:        schema.TypeFloat,
+							Required:    true,
+							Description: "The number of requests per second allowed for matching requests.",
+						},
+					},
+				},
+			},
+			"max_parallel_requests": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_PARALLEL_REQUESTS", 0),
+				Description: "When set, no more than this many requests will be in flight to the API at any time, regardless of terraform's `-parallelism`. Zero means no limit.",
+			},
+			"serialize": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_SERIALIZE", nil),
+				Description: "When set, create, read, update and delete operations are performed one at a time, including all requests each operation makes. This is useful for APIs that reject concurrent changes.",
+			},
+			"max_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_RETRIES", 0),
+				Description: "When set, requests failing with one of the `retry_status_codes` or `retry_network_errors` are retried up to this many times with exponential backoff.",
+			},
+			"retry_status_codes": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Optional:    true,
+				Description: "The HTTP status codes that cause a request to be retried when `max_retries` is set. Defaults to 502, 503 and 504. 429 is handled by `max_throttle_retries`.",
+			},
+			"retry_network_errors": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: validation.StringInSlice(networkErrorClasses, false)},
+				Optional:    true,
+				Description: "The kinds of network errors that cause a request to be retried when `max_retries` is set. Valid values are `connection_reset`, `connection_refused`, `eof`, `dns`, `tls_handshake_timeout` and `timeout`. Defaults to all of these except `timeout`, since a request that timed out may still have been processed by the server. For the same reason, `connection_reset`, `eof` and `timeout` only retry idempotent methods (GET, HEAD, OPTIONS, TRACE, PUT and DELETE), so that a POST is never sent twice.",
+			},
+			"max_throttle_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_THROTTLE_RETRIES", 5),
+				Description: "The number of times a request receiving 429 Too Many Requests is retried. The wait honors the Retry-After header when sent, up to `retry_wait_max` (otherwise the retry backoff is used), and pauses all requests to the API. Throttling is summarized in a warning. Defaults to `5`.",
+			},
+			"retry_wait_min": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_RETRY_WAIT_MIN", 1.0),
+				Description: "The time in seconds to wait before the first retry. Defaults to `1`.",
+			},
+			"retry_wait_max": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_RETRY_WAIT_MAX", 30.0),
+				Description: "The maximum time in seconds to wait between retries. Defaults to `30`.",
+			},
+			"retry_multiplier": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_RETRY_MULTIPLIER", 2.0),
+				Description: "The factor the wait grows by after each retry. Defaults to `2`.",
+			},
+			"retry_jitter": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_RETRY_JITTER", 0.2),
+				Description: "The fraction (between 0 and 1) by which each wait is randomly lengthened or shortened so that many resources failing at once do not retry in lockstep. Defaults to `0.2`.",
+			},
+			"retry_budget": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_RETRY_BUDGET", 0.0),
+				Description: "When set, the maximum total time in seconds spent waiting between retries, across all requests made by the provider during the run. Once a retry would exceed the budget, failed requests are no longer retried. Zero means no limit.",
+			},
+			"max_response_size": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_RESPONSE_SIZE", 0),
+				Description: "When set, responses with a body larger than this many bytes are rejected with an error instead of being read into memory. Zero means no limit.",
+			},
+			"sensitive_headers": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Headers whose values are redacted from the debug log, in addition to `Authorization`, `Proxy-Authorization`, `Cookie`, `Set-Cookie`, `X-Api-Key` and `X-Auth-Token`.",
+			},
+			"sensitive_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Keys of request and response bodies whose values are masked in the debug log for every request, such as `token` or `credentials/secret` (see the `sensitive_keys` of `restapi_object`, which are also masked).",
+			},
+			"debug_log_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_DEBUG_LOG_FILE", nil),
+				Description: "When set, the request and response dumps logged with `debug` are appended to this file instead of Terraform's log. It is rotated to `.1` (keeping up to three old files, `.1` being the newest) when it would grow past `debug_log_max_size`.",
+			},
+			"debug_log_max_size": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_DEBUG_LOG_MAX_SIZE", 10),
+				Description: "The size in megabytes `debug_log_file` may grow to before it is rotated, or 0 to never rotate it. Defaults to `10`.",
+			},
+			"max_logged_body_size": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_LOGGED_BODY_SIZE", 0),
+				Description: "The number of bytes of request and response bodies written to the debug log. Longer bodies are cut, noting that they were and their full length, so large payloads do not swamp `TF_LOG` output. Defaults to `0`, which logs whole bodies.",
+			},
+			"max_logged_header_size": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_MAX_LOGGED_HEADER_SIZE", 0),
+				Description: "Like `max_logged_body_size`, for the value of each header in the debug log. Defaults to `0`, which logs whole values.",
+			},
+			"metrics_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_METRICS_FILE", nil),
+				Description: "When set, a JSON summary of the requests made during the run (counts by method and status code, retries, time spent waiting for rate limits, total API time and a histogram of request durations) is written to this file. It is replaced after every request, and covers a single provider configuration in a single provider process: Terraform starts a new process for each command (and for the plan and apply of `terraform apply`), so the file summarizes the last of them, and provider configurations sharing a file overwrite each other. Use a different file for each provider configuration.",
+			},
+			"har_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_HAR_FILE", nil),
+				Description: "When set, every request and response of the run is recorded to this file in the HAR (HTTP Archive) format, to attach reproducible traces to support tickets. Headers and body keys that are redacted from the debug log (see `sensitive_headers` and `sensitive_keys`) are redacted here too, as are query parameters named in `sensitive_keys` and ones such as `api_key`, `token` and `password`. Each request is added to the file as it is made. Use a different file for each provider configuration.",
+			},
+			"cassette_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CASSETTE_FILE", nil),
+				Description: "A YAML file of recorded requests and responses, so tests can run without the API or its credentials. With `cassette_mode` `record`, requests are sent to the API and every exchange is added to the file, after any already in it, so that the separate provider processes of plan, apply and each test step all end up in one recording. Delete the file to record from scratch. With `replay`, nothing is sent and each request is answered with the first unused recorded response to the same method, path and query, and body, or else to the same method, path and query, failing if there is none. Sensitive header values (see `sensitive_headers`), `sensitive_keys` and OAuth tokens in bodies are masked in the file, and bodies are masked the same way before they are matched.",
+			},
+			"cassette_mode": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CASSETTE_MODE", nil),
+				Description: "Whether to `record` or `replay` `cassette_file`. Defaults to `replay`.",
+			},
+			"audit_log": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_AUDIT_LOG", nil),
+				Description: "When set, every create, update and destroy is recorded with its time, object id, method, URI, path, status code, whether it succeeded (and its error if not) and `audit_metadata`. A file is appended to, one line of JSON per record. An `http://` or `https://` URL is sent each record as JSON in a POST. Failing to record an operation is logged, but does not fail it. Terraform does not tell providers resource addresses, so add them with the resource's `audit_metadata` where needed.",
+			},
+			"audit_metadata": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Values added to every record in `audit_log`, such as the pipeline, change ticket or user applying the configuration. A resource's own `audit_metadata` is merged over these.",
+			},
+			"metrics_statsd_address": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_METRICS_STATSD_ADDRESS", nil),
+				Description: "When set, a `host:port` to send request metrics to over UDP in the statsd format as requests are made: `restapi.requests.METHOD.STATUS` and `restapi.retries` counters, and `restapi.request_time` and `restapi.rate_limit_wait` timers.",
+			},
 			"test_path": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -128,6 +441,24 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_DEBUG", nil),
 				Description: "Enabling this will cause lots of debug information to be printed to STDOUT by the API client.",
 			},
+			"openapi_spec_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_OPENAPI_SPEC_FILE", nil),
+				Description: "An OpenAPI 3 document (in JSON or YAML) to check requests and successful responses against, catching payloads the API would reject (or that the provider would misread) before they reach production. Requests for operations the spec does not have, and JSON bodies that do not match their schema, are reported as `openapi_validation` says. Schemas may use `$ref` to components, `type`, `nullable`, `enum`, `properties`, `required`, `additionalProperties`, `items`, `allOf`, `anyOf` and `oneOf`; other keywords (such as `format`, `pattern` and `minimum`), parameters and `servers` are not checked, and a warning listing the ones the spec uses is logged when it is loaded.",
+			},
+			"openapi_validation": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_OPENAPI_VALIDATION", nil),
+				Description: "Whether a request or response that does not match `openapi_spec_file` is logged as a warning (`warn`) or fails (`error`). Failing requests are not sent. Defaults to `warn`.",
+			},
+			"dry_run": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_DRY_RUN", nil),
+				Description: "When set to `true`, requests with methods other than `GET`, `HEAD` and `OPTIONS` are not sent. Each is logged (with sensitive headers and keys redacted) and fails with an error showing its method, URL and body, to check paths and data against a production API safely. Since nothing is changed, the resources they are for are not saved to state. Defaults to `false`.",
+			},
 			"oauth_client_credentials": {
 				Type:        schema.TypeList,
 				Optional:    true,
@@ -137,16 +468,19 @@ func Provider() *schema.Provider {
 					Schema: map[string]*schema.Schema{
 						"oauth_client_id": {
 							Type:        schema.TypeString,
+							DefaultFunc: schema.EnvDefaultFunc("REST_API_OAUTH_CLIENT_ID", nil),
 							Description: "client id",
 							Required:    true,
 						},
 						"oauth_client_secret": {
 							Type:        schema.TypeString,
+							DefaultFunc: schema.EnvDefaultFunc("REST_API_OAUTH_CLIENT_SECRET", nil),
 							Description: "client secret",
 							Required:    true,
 						},
 						"oauth_token_endpoint": {
 							Type:        schema.TypeString,
+							DefaultFunc: schema.EnvDefaultFunc("REST_API_OAUTH_TOKEN_ENDPOINT", nil),
 							Description: "oauth token endpoint",
 							Required:    true,
 						},
@@ -183,6 +517,7 @@ func Provider() *schema.Provider {
 						},
 						"service_account_key": {
 							Type:        schema.TypeString,
+							DefaultFunc: schema.EnvDefaultFunc("REST_API_GCP_SERVICE_ACCOUNT_KEY", nil),
 							Optional:    true,
 							Description: "service account key",
 							Sensitive:   true,
@@ -214,6 +549,50 @@ func Provider() *schema.Provider {
 				DefaultFunc: schema.EnvDefaultFunc("REST_API_KEY_FILE", nil),
 				Description: "When set with the cert_file parameter, the provider will load a client certificate as a file for mTLS authentication. Note that this mechanism simply delegates to golang's tls.LoadX509KeyPair which does not support passphrase protected private keys. The most robust security protections available to the key_file are simple file system permissions.",
 			},
+			"cacerts_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CACERTS_FILE", nil),
+				Description: "When set, the provider will trust the PEM encoded CA certificates in this file when verifying the API server instead of the system trust store. May be combined with `cacerts_string`.",
+			},
+			"cacerts_string": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_CACERTS_STRING", nil),
+				Description: "When set, the provider will trust the PEM encoded CA certificates in this string when verifying the API server instead of the system trust store. May be combined with `cacerts_file`.",
+			},
+			"tls_min_version": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_TLS_MIN_VERSION", nil),
+				ValidateFunc: validation.StringInSlice([]string{"1.0", "1.1", "1.2", "1.3"}, false),
+				Description:  "The minimum TLS version the provider will negotiate with the API server. One of `1.0`, `1.1`, `1.2` or `1.3`. Defaults to golang's default (currently `1.2`).",
+			},
+			"tls_max_version": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("REST_API_TLS_MAX_VERSION", nil),
+				ValidateFunc: validation.StringInSlice([]string{"1.0", "1.1", "1.2", "1.3"}, false),
+				Description:  "The maximum TLS version the provider will negotiate with the API server. One of `1.0`, `1.1`, `1.2` or `1.3`.",
+			},
+			"tls_cipher_suites": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "When set, only these cipher suites will be offered to the API server for TLS 1.2 and below. Names are the IANA names used by golang's crypto/tls package, such as `TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256`. TLS 1.3 cipher suites are not configurable.",
+			},
+			"pinned_cert_sha256": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "When set, the API server must present a (leaf) certificate whose public key (SubjectPublicKeyInfo) SHA-256 hash matches one of these values, base64 or hex encoded. Pins of intermediate or CA certificates are not matched, since normal certificate chain verification is skipped, making this a safer alternative to `insecure` for self-signed endpoints. The base64 value can be obtained with `openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64`.",
+			},
+			"tls_server_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("REST_API_TLS_SERVER_NAME", nil),
+				Description: "When set, this name is sent via SNI and used to verify the API server's certificate instead of the host in `uri`. This is useful when reaching an API by IP address or through split-horizon DNS.",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			/* Could only get terraform to recognize this resource if
@@ -224,43 +603,102 @@ func Provider() *schema.Provider {
 		DataSourcesMap: map[string]*schema.Resource{
 			"restapi_object": dataSourceRestAPI(),
 		},
-		ConfigureFunc: configureProvider,
+		ConfigureContextFunc: configureProviderContext,
 	}
 }
 
-func configureProvider(d *schema.ResourceData) (interface{}, error) {
+/* Configure the client to log through the SDK's logger (see logging.go) */
+func configureProviderContext(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	client, err := configureProvider(withSubsystemLoggers(ctx), d)
+	return client, diag.FromErr(err)
+}
+
+func configureProvider(ctx context.Context, d *schema.ResourceData) (interface{}, error) {
 
 	/* As "data-safe" as terraform says it is, you'd think
 	   it would have already coaxed this to a slice FOR me */
 	copyKeys := make([]string, 0)
-	if iCopyKeys := d.Get("copy_keys"); iCopyKeys != nil {
-		for _, v := range iCopyKeys.([]interface{}) {
-			copyKeys = append(copyKeys, v.(string))
-		}
+	for _, v := range getListOrEnv(d, "copy_keys") {
+		copyKeys = append(copyKeys, v.(string))
 	}
 
-	headers := make(map[string]string)
-	if iHeaders := d.Get("headers"); iHeaders != nil {
-		for k, v := range iHeaders.(map[string]interface{}) {
-			headers[k] = v.(string)
-		}
+	headers, err := getMapOrEnv(d, "headers")
+	if err != nil {
+		return nil, err
+	}
+	auditMetadata, err := getMapOrEnv(d, "audit_metadata")
+	if err != nil {
+		return nil, err
+	}
+	hostOverrides, err := getMapOrEnv(d, "host_overrides")
+	if err != nil {
+		return nil, err
+	}
+	copyKeyPaths, err := getMapOrEnv(d, "copy_key_paths")
+	if err != nil {
+		return nil, err
 	}
 
 	opt := &apiClientOpt{
-		uri:                 d.Get("uri").(string),
-		insecure:            d.Get("insecure").(bool),
-		username:            d.Get("username").(string),
-		password:            d.Get("password").(string),
-		headers:             headers,
-		useCookies:          d.Get("use_cookies").(bool),
-		timeout:             d.Get("timeout").(int),
-		idAttribute:         d.Get("id_attribute").(string),
-		copyKeys:            copyKeys,
-		writeReturnsObject:  d.Get("write_returns_object").(bool),
-		createReturnsObject: d.Get("create_returns_object").(bool),
-		xssiPrefix:          d.Get("xssi_prefix").(string),
-		rateLimit:           d.Get("rate_limit").(float64),
-		debug:               d.Get("debug").(bool),
+		uri:                   d.Get("uri").(string),
+		insecure:              d.Get("insecure").(bool),
+		username:              d.Get("username").(string),
+		password:              d.Get("password").(string),
+		headers:               headers,
+		hostHeader:            d.Get("host_header").(string),
+		useCookies:            d.Get("use_cookies").(bool),
+		forceHTTP2:            d.Get("force_http2").(bool),
+		disableHTTP2:          d.Get("disable_http2").(bool),
+		useHTTP3:              d.Get("use_http3").(bool),
+		unixSocket:            d.Get("unix_socket").(string),
+		hostOverrides:         hostOverrides,
+		timeout:               d.Get("timeout").(int),
+		maxIdleConns:          d.Get("max_idle_conns").(int),
+		maxIdleConnsPerHost:   d.Get("max_idle_conns_per_host").(int),
+		maxConnsPerHost:       d.Get("max_conns_per_host").(int),
+		idleConnTimeout:       d.Get("idle_conn_timeout").(int),
+		connectTimeout:        d.Get("connect_timeout").(int),
+		tlsHandshakeTimeout:   d.Get("tls_handshake_timeout").(int),
+		responseHeaderTimeout: d.Get("response_header_timeout").(int),
+		idAttribute:           d.Get("id_attribute").(string),
+		idAttributes:          expandStringList(getListOrEnv(d, "id_attributes")),
+		copyKeys:              copyKeys,
+		copyKeyPaths:          copyKeyPaths,
+		writeReturnsObject:    d.Get("write_returns_object").(bool),
+		createReturnsObject:   d.Get("create_returns_object").(bool),
+		xssiPrefix:            d.Get("xssi_prefix").(string),
+		errorMessageKey:       d.Get("error_message_key").(string),
+		requestIDHeader:       d.Get("request_id_header").(string),
+		curlOnError:           d.Get("curl_on_error").(bool),
+		rateLimit:             d.Get("rate_limit").(float64),
+		maxResponseSize:       int64(d.Get("max_response_size").(int)),
+		sensitiveHeaders:      expandStringList(getListOrEnv(d, "sensitive_headers")),
+		maxLoggedBodySize:     d.Get("max_logged_body_size").(int),
+		maxLoggedHeaderSize:   d.Get("max_logged_header_size").(int),
+		sensitiveKeys:         expandStringList(getListOrEnv(d, "sensitive_keys")),
+		metricsFile:           d.Get("metrics_file").(string),
+		debugLogFile:          d.Get("debug_log_file").(string),
+		debugLogMaxSize:       int64(d.Get("debug_log_max_size").(int)) * 1024 * 1024,
+		harFile:               d.Get("har_file").(string),
+		cassetteFile:          d.Get("cassette_file").(string),
+		cassetteMode:          d.Get("cassette_mode").(string),
+		auditLog:              d.Get("audit_log").(string),
+		auditMetadata:         auditMetadata,
+		metricsStatsdAddress:  d.Get("metrics_statsd_address").(string),
+		maxParallelRequests:   d.Get("max_parallel_requests").(int),
+		serialize:             d.Get("serialize").(bool),
+		maxRetries:            d.Get("max_retries").(int),
+		maxThrottleRetries:    d.Get("max_throttle_retries").(int),
+		retryWaitMin:          d.Get("retry_wait_min").(float64),
+		retryWaitMax:          d.Get("retry_wait_max").(float64),
+		retryMultiplier:       d.Get("retry_multiplier").(float64),
+		retryJitter:           d.Get("retry_jitter").(float64),
+		retryBudget:           d.Get("retry_budget").(float64),
+		openAPISpecFile:       d.Get("openapi_spec_file").(string),
+		openAPIValidation:     d.Get("openapi_validation").(string),
+		dryRun:                d.Get("dry_run").(bool),
+		debug:                 d.Get("debug").(bool),
+		logCtx:                ctx,
 	}
 
 	if v, ok := d.GetOk("create_method"); ok {
@@ -272,12 +710,45 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 	if v, ok := d.GetOk("update_method"); ok {
 		opt.updateMethod = v.(string)
 	}
+	if v, ok := d.GetOk("update_mode"); ok {
+		opt.updateMode = v.(string)
+	}
+	if v, ok := d.GetOk("payload_format"); ok {
+		opt.payloadFormat = v.(string)
+	}
 	if v, ok := d.GetOk("destroy_method"); ok {
 		opt.destroyMethod = v.(string)
 	}
-	if v, ok := d.GetOk("oauth_client_credentials"); ok {
-		oauthConfig := v.([]interface{})[0].(map[string]interface{})
-
+	if v := getListOrEnv(d, "retry_network_errors"); v != nil {
+		opt.retryNetworkErrors = expandStringList(v)
+	}
+	for _, code := range getListOrEnv(d, "retry_status_codes") {
+		/* Codes from the environment variable are strings */
+		if s, ok := code.(string); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a comma-separated list of status codes: %v", envName("retry_status_codes"), err)
+			}
+			code = n
+		}
+		opt.retryStatusCodes = append(opt.retryStatusCodes, code.(int))
+	}
+	rateLimits, err := getBlocksOrEnv(d, "rate_limits")
+	if err != nil {
+		return nil, err
+	}
+	for _, iBucket := range rateLimits {
+		bucket := iBucket.(map[string]interface{})
+		pathPrefix, _ := bucket["path_prefix"].(string)
+		methods, _ := bucket["methods"].([]interface{})
+		rateLimit, _ := bucket["rate_limit"].(float64)
+		opt.rateLimitBuckets = append(opt.rateLimitBuckets, rateLimitBucket{
+			pathPrefix: pathPrefix,
+			methods:    expandStringList(methods),
+			rateLimit:  rateLimit,
+		})
+	}
+	if oauthConfig := getOAuthClientCredentials(d); oauthConfig != nil {
 		opt.oauthClientID = oauthConfig["oauth_client_id"].(string)
 		opt.oauthClientSecret = oauthConfig["oauth_client_secret"].(string)
 		opt.oauthTokenURL = oauthConfig["oauth_token_endpoint"].(string)
@@ -294,8 +765,7 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 			opt.oauthEndpointParams = setVals
 		}
 	}
-	if v, ok := d.GetOk("gcp_oauth_settings"); ok {
-		gcpOauthSettings := v.([]interface{})[0].(map[string]interface{})
+	if gcpOauthSettings := getGCPOauthSettings(d); gcpOauthSettings != nil {
 		opt.GCPOauthConfig = &GCPOauthConfig{
 			scopes:            expandStringSet(gcpOauthSettings["scopes"].([]interface{})),
 			serviceAccountKey: gcpOauthSettings["service_account_key"].(string),
@@ -314,8 +784,38 @@ func configureProvider(d *schema.ResourceData) (interface{}, error) {
 	if v, ok := d.GetOk("key_string"); ok {
 		opt.keyString = v.(string)
 	}
+	if v, ok := d.GetOk("tls_server_name"); ok {
+		opt.tlsServerName = v.(string)
+	}
+	if v, ok := d.GetOk("tls_min_version"); ok {
+		opt.tlsMinVersion = v.(string)
+	}
+	if v, ok := d.GetOk("tls_max_version"); ok {
+		opt.tlsMaxVersion = v.(string)
+	}
+	if v := getListOrEnv(d, "tls_cipher_suites"); v != nil {
+		opt.tlsCipherSuites = expandStringList(v)
+	}
+	if v := getListOrEnv(d, "failover_uris"); v != nil {
+		opt.failoverURIs = expandStringList(v)
+	}
+	if v, ok := d.GetOk("health_check_path"); ok {
+		opt.healthCheckPath = v.(string)
+	}
+	if v := getListOrEnv(d, "pinned_cert_sha256"); v != nil {
+		opt.pinnedCertSHA256 = expandStringList(v)
+	}
+	if v, ok := d.GetOk("cacerts_file"); ok {
+		opt.caCertsFile = v.(string)
+	}
+	if v, ok := d.GetOk("cacerts_string"); ok {
+		opt.caCertsString = v.(string)
+	}
 
 	client, err := NewAPIClient(opt)
+	if err != nil {
+		return nil, err
+	}
 
 	if v, ok := d.GetOk("test_path"); ok {
 		testPath := v.(string)
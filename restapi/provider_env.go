@@ -0,0 +1,110 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+/*
+The SDK only calls a DefaultFunc for strings, numbers and booleans, so
+
+	list, map and block attributes of the provider fall back to their
+	environment variables here instead. Each is named like the others,
+	REST_API_ and the attribute's name in upper case
+*/
+func envName(key string) string {
+	return "REST_API_" + strings.ToUpper(key)
+}
+
+/* The list attribute key, or its environment variable split on commas when it is not set */
+func getListOrEnv(d *schema.ResourceData, key string) []interface{} {
+	if v, ok := d.GetOk(key); ok {
+		return v.([]interface{})
+	}
+	return splitEnvList(os.Getenv(envName(key)))
+}
+
+func splitEnvList(v string) []interface{} {
+	if v == "" {
+		return nil
+	}
+	var list []interface{}
+	for _, item := range strings.Split(v, ",") {
+		list = append(list, strings.TrimSpace(item))
+	}
+	return list
+}
+
+/* The map attribute key, or its environment variable (a JSON object of strings) when it is not set */
+func getMapOrEnv(d *schema.ResourceData, key string) (map[string]string, error) {
+	m := make(map[string]string)
+	if v, ok := d.GetOk(key); ok {
+		for k, val := range v.(map[string]interface{}) {
+			m[k] = val.(string)
+		}
+		return m, nil
+	}
+	if env := os.Getenv(envName(key)); env != "" {
+		if err := json.Unmarshal([]byte(env), &m); err != nil {
+			return nil, fmt.Errorf("%s must be a JSON object of strings: %v", envName(key), err)
+		}
+	}
+	return m, nil
+}
+
+/* The blocks of attribute key, or its environment variable (a JSON list of objects) when it is not set */
+func getBlocksOrEnv(d *schema.ResourceData, key string) ([]interface{}, error) {
+	if v, ok := d.GetOk(key); ok {
+		return v.([]interface{}), nil
+	}
+	var blocks []map[string]interface{}
+	if env := os.Getenv(envName(key)); env != "" {
+		if err := json.Unmarshal([]byte(env), &blocks); err != nil {
+			return nil, fmt.Errorf("%s must be a JSON list of objects: %v", envName(key), err)
+		}
+	}
+	list := make([]interface{}, len(blocks))
+	for i, block := range blocks {
+		list[i] = block
+	}
+	return list, nil
+}
+
+/*
+The oauth_client_credentials block, or one made from REST_API_OAUTH_*
+
+	variables when the block is not set and the client id is. Scopes are
+	split on commas
+*/
+func getOAuthClientCredentials(d *schema.ResourceData) map[string]interface{} {
+	if v, ok := d.GetOk("oauth_client_credentials"); ok {
+		return v.([]interface{})[0].(map[string]interface{})
+	}
+	if os.Getenv("REST_API_OAUTH_CLIENT_ID") == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"oauth_client_id":      os.Getenv("REST_API_OAUTH_CLIENT_ID"),
+		"oauth_client_secret":  os.Getenv("REST_API_OAUTH_CLIENT_SECRET"),
+		"oauth_token_endpoint": os.Getenv("REST_API_OAUTH_TOKEN_ENDPOINT"),
+		"oauth_scopes":         splitEnvList(os.Getenv("REST_API_OAUTH_SCOPES")),
+	}
+}
+
+/* The gcp_oauth_settings block, or one made from REST_API_GCP_* variables when the block is not set and either is */
+func getGCPOauthSettings(d *schema.ResourceData) map[string]interface{} {
+	if v, ok := d.GetOk("gcp_oauth_settings"); ok {
+		return v.([]interface{})[0].(map[string]interface{})
+	}
+	if os.Getenv("REST_API_GCP_SERVICE_ACCOUNT_KEY") == "" && os.Getenv("REST_API_GCP_SCOPES") == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"scopes":              splitEnvList(os.Getenv("REST_API_GCP_SCOPES")),
+		"service_account_key": os.Getenv("REST_API_GCP_SERVICE_ACCOUNT_KEY"),
+	}
+}
@@ -104,3 +104,38 @@ func TestResourceProvider_RequireTestPath(t *testing.T) {
 
 	svr.Shutdown()
 }
+
+func TestResourceProvider_Env(t *testing.T) {
+	t.Setenv("REST_API_HEADERS", `{"X-Team":"ci"}`)
+	t.Setenv("REST_API_COPY_KEYS", "etag, revision")
+	t.Setenv("REST_API_RETRY_STATUS_CODES", "429,503")
+	t.Setenv("REST_API_RATE_LIMITS", `[{"methods":["POST"],"rate_limit":2}]`)
+	t.Setenv("REST_API_WRITE_RETURNS_OBJECT", "true")
+	t.Setenv("REST_API_GCP_SERVICE_ACCOUNT_KEY", "")
+
+	rp := Provider()
+	raw := map[string]interface{}{
+		"uri":                "http://foo.bar/baz",
+		"retry_status_codes": []interface{}{500},
+	}
+	if err := rp.Configure(context.TODO(), terraform.NewResourceConfigRaw(raw)); err != nil {
+		t.Fatalf("Provider failed with error: %v", err)
+	}
+	client := rp.Meta().(*APIClient)
+	if client.headers["X-Team"] != "ci" || len(client.copyKeys) != 2 || client.copyKeys[1] != "revision" || !client.writeReturnsObject {
+		t.Fatalf("provider_test.go: Expected the attributes to be read from the environment but got headers %v, copy_keys %v and write_returns_object %v", client.headers, client.copyKeys, client.writeReturnsObject)
+	}
+	if len(client.rateLimitBuckets) != 1 || client.rateLimitBuckets[0].methods[0] != "POST" {
+		t.Fatalf("provider_test.go: Expected a rate_limits block from the environment but got %+v", client.rateLimitBuckets)
+	}
+	/* What the configuration sets wins */
+	if len(client.retryPolicy.statusCodes) != 1 || client.retryPolicy.statusCodes[0] != 500 {
+		t.Fatalf("provider_test.go: Expected the configured retry_status_codes but got %v", client.retryPolicy.statusCodes)
+	}
+
+	t.Setenv("REST_API_HEADERS", "X-Team=ci")
+	rp = Provider()
+	if err := rp.Configure(context.TODO(), terraform.NewResourceConfigRaw(raw)); err == nil {
+		t.Fatalf("provider_test.go: Expected an error for headers that are not JSON")
+	}
+}
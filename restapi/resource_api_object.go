@@ -1,6 +1,7 @@
 package restapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 func resourceRestAPI() *schema.Resource {
@@ -16,22 +18,24 @@ func resourceRestAPI() *schema.Resource {
 	isDataSensitive, _ := strconv.ParseBool(GetEnvOrDefault("API_DATA_IS_SENSITIVE", "false"))
 
 	return &schema.Resource{
-		Create: resourceRestAPICreate,
-		Read:   resourceRestAPIRead,
-		Update: resourceRestAPIUpdate,
-		Delete: resourceRestAPIDelete,
-		Exists: resourceRestAPIExists,
+		CreateContext: withThrottleWarning(resourceRestAPICreate),
+		ReadContext:   withThrottleWarning(resourceRestAPIRead),
+		UpdateContext: withThrottleWarning(resourceRestAPIUpdate),
+		DeleteContext: withThrottleWarning(resourceRestAPIDelete),
+		Exists:        resourceRestAPIExists,
 
 		Description: "Acting as a wrapper of cURL, this object supports POST, GET, PUT and DELETE on the specified url",
 
 		Importer: &schema.ResourceImporter{
-			State: resourceRestAPIImport,
+			StateContext: resourceRestAPIImport,
 		},
 
+		CustomizeDiff: resourceRestAPICustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"path": {
 				Type:        schema.TypeString,
-				Description: "The API path on top of the base URL set in the provider that represents objects of this type on the API server.",
+				Description: "The API path on top of the base URL set in the provider that represents objects of this type on the API server. This and the other `*_path` attributes may contain placeholders such as `/tenants/{tenant_id}/rules/{name}`. Placeholders work the same everywhere (`*_path`, `hooks`, `read_data`, `update_data`, `destroy_data` and the paths of `*_async` blocks): `{id}` is the object's id, `{data.KEY}`, `{prior_data.KEY}` and `{api_data.KEY}` are values from `data`, from `data` as of the last apply and from the last API response, and a bare `{KEY}` is its part of the id with `id_template`, otherwise its value in `data` or the API response. Keys may be paths, as with `id_attribute`. In paths, values other than the id are escaped. To import such an object, use its concrete path.",
 				Required:    true,
 			},
 			"create_path": {
@@ -59,11 +63,40 @@ func resourceRestAPI() *schema.Resource {
 				Description: "Defaults to `read_method` set on the provider. Allows per-resource override of `read_method` (see `read_method` provider config documentation)",
 				Optional:    true,
 			},
+			"read_data": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   isDataSensitive,
+				Description: "A request body to send when reading the object, for APIs whose reads are POST requests (such as a describe or search call) along with `read_method = \"POST\"`. Placeholders are replaced as in `path`, for example `{\"id\": \"{id}\"}`.",
+			},
 			"update_method": {
 				Type:        schema.TypeString,
 				Description: "Defaults to `update_method` set on the provider. Allows per-resource override of `update_method` (see `update_method` provider config documentation)",
 				Optional:    true,
 			},
+			"update_mode": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `update_mode` set on the provider. Allows per-resource override of `update_mode` (see `update_mode` provider config documentation)",
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(updateModes, false),
+			},
+			"payload_format": {
+				Type:         schema.TypeString,
+				Description:  "Defaults to `payload_format` set on the provider. Allows per-resource override of `payload_format` (see `payload_format` provider config documentation)",
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(payloadFormats(), false),
+			},
+			"create_conflict_behavior": {
+				Type:         schema.TypeString,
+				Description:  "What to do when the API responds to a create with 409 Conflict because the object already exists. Defaults to `fail`. With `update`, the existing object is read and updated with `data` instead, which is useful for singleton or configuration style endpoints. The object's id must be known from `data` or `object_id`. With `adopt`, a 409 or 422 response causes the existing object to be found with `adopt_search` and its id to be taken into state, as if it had been imported. This also decides what happens when `exists_check` finds the object: it fails the create, is updated, or is taken into state as it is.",
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"fail", "update", "adopt"}, false),
+			},
+			"adopt_search": {
+				Type:        schema.TypeMap,
+				Description: "How to find an existing object when `create_conflict_behavior` is `adopt`. This map takes `search_key`, `search_value`, `results_key` and `query_string` (see datasource config documentation). `search_value` defaults to the value of `search_key` in `data`.",
+				Optional:    true,
+			},
 			"destroy_method": {
 				Type:        schema.TypeString,
 				Description: "Defaults to `destroy_method` set on the provider. Allows per-resource override of `destroy_method` (see `destroy_method` provider config documentation)",
@@ -71,7 +104,7 @@ func resourceRestAPI() *schema.Resource {
 			},
 			"destroy_path": {
 				Type:        schema.TypeString,
-				Description: "Defaults to `path/{id}`. The API path that represents where to DESTROY (DELETE) objects of this type on the API server. The string `{id}` will be replaced with the terraform ID of the object.",
+				Description: "Defaults to `path/{id}`. The API path that represents where to DESTROY (DELETE) objects of this type on the API server. The string `{id}` will be replaced with the terraform ID of the object. Like the other `*_path` attributes, it may include a query string (such as `/objects/{id}?action=delete` with `destroy_method = \"POST\"`), to which `query_string` is added.",
 				Optional:    true,
 			},
 			"id_attribute": {
@@ -79,16 +112,39 @@ func resourceRestAPI() *schema.Resource {
 				Description: "Defaults to `id_attribute` set on the provider. Allows per-resource override of `id_attribute` (see `id_attribute` provider config documentation)",
 				Optional:    true,
 			},
+			"id_attributes": {
+				Type:          schema.TypeList,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				Optional:      true,
+				Description:   "Defaults to `id_attributes` set on the provider. Paths to the id (each as in `id_attribute`) tried in order until one is found. Takes the place of `id_attribute`.",
+				ConflictsWith: []string{"id_attribute"},
+			},
+			"id_template": {
+				Type:        schema.TypeString,
+				Description: "For APIs that have no single id field, a template to build the object's id from several keys of the API response (or `data`), such as `{org_id}:{name}`. Each key may be a path, as with `id_attribute`, and takes the place of `id_attribute`. The `*_path` attributes may use the template's keys (such as `/orgs/{org_id}/users/{name}`), which are taken back out of the id so this also works after an import.",
+				Optional:    true,
+			},
+			"id_from_header": {
+				Type:        schema.TypeString,
+				Description: "The name of a response header, such as `Location`, to read the id of a newly created object from. By default the id is the last path segment of the header value. Use this for APIs that respond to a create with an empty body. If `create_returns_object` or `write_returns_object` is also set, a non-empty create response is still used to set the object's state.",
+				Optional:    true,
+			},
+			"id_from_header_regex": {
+				Type:        schema.TypeString,
+				Description: "A regular expression to find the id in the `id_from_header` header value. The first capture group is the id, or the whole match if the expression has no groups.",
+				Optional:    true,
+			},
 			"object_id": {
 				Type:        schema.TypeString,
 				Description: "Defaults to the id learned by the provider during normal operations and `id_attribute`. Allows you to set the id manually. This is used in conjunction with the `*_path` attributes.",
 				Optional:    true,
 			},
 			"data": {
-				Type:        schema.TypeString,
-				Description: "Valid JSON object that this provider will manage with the API server.",
-				Optional:    true,
-				Sensitive:   isDataSensitive,
+				Type:             schema.TypeString,
+				Description:      "Valid JSON object that this provider will manage with the API server. Changes that only affect formatting (key order, whitespace or `1` vs `1.0`) are not treated as changes.",
+				Optional:         true,
+				Sensitive:        isDataSensitive,
+				DiffSuppressFunc: suppressEquivalentJSON,
 				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
 					v := val.(string)
 					if v != "" {
@@ -101,6 +157,12 @@ func resourceRestAPI() *schema.Resource {
 					return warns, errs
 				},
 			},
+			"force_new_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A list of paths to keys in `data` (such as `name` or `spec/region`, see `id_attribute`) whose values the API does not allow to be changed. When any of them change, the object is destroyed and recreated instead of updated.",
+			},
 			"debug": {
 				Type:        schema.TypeBool,
 				Description: "Whether to emit verbose debug output while working with the API object on the server.",
@@ -111,6 +173,42 @@ func resourceRestAPI() *schema.Resource {
 				Description: "Custom search for `read_path`. This map will take `search_key`, `search_value`, `results_key` and `query_string` (see datasource config documentation)",
 				Optional:    true,
 			},
+			"headers": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A map of header names and values to set on all requests about this object, over the provider's `headers`.",
+			},
+			"create_headers": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Headers to set on create requests only, over `headers` (for example `Prefer = \"return=representation\"`).",
+			},
+			"read_headers": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Headers to set on read (and `read_search`) requests only, over `headers` (for example a different `Accept`).",
+			},
+			"update_headers": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Headers to set on update requests only, over `headers`.",
+			},
+			"destroy_headers": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Headers to set on destroy requests only, over `headers`.",
+			},
+			"audit_metadata": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Values added to the records of this object in the provider's `audit_log`, over the provider's `audit_metadata`.",
+			},
 			"query_string": {
 				Type:        schema.TypeString,
 				Description: "Query string to be included in the path",
@@ -121,7 +219,7 @@ func resourceRestAPI() *schema.Resource {
 				Elem: &schema.Schema{
 					Type: schema.TypeString,
 				},
-				Description: "After data from the API server is read, this map will include k/v pairs usable in other terraform resources as readable objects. Currently the value is the golang fmt package's representation of the value (simple primitives are set as expected, but complex types like arrays and maps contain golang formatting).",
+				Description: "After data from the API server is read, this map will include k/v pairs usable in other terraform resources as readable objects. Currently the value is the golang fmt package's representation of the value (simple primitives are set as expected, but complex types like arrays and maps contain golang formatting). The provider SDK has no attribute type for arbitrary nested data, so for typed values use `jsondecode(api_response)`, or `extract` for single values.",
 				Computed:    true,
 				Sensitive:   isDataSensitive,
 			},
@@ -131,6 +229,81 @@ func resourceRestAPI() *schema.Resource {
 				Computed:    true,
 				Sensitive:   isDataSensitive,
 			},
+			"capture_response_headers": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "The names of response headers (such as `X-Request-Id`) to keep in `last_response_headers`.",
+			},
+			"last_status_code": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The HTTP status code of the last response about this object, for use in postconditions and debugging.",
+			},
+			"last_response_headers": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Computed:    true,
+				Description: "The headers listed in `capture_response_headers` from the last response about this object.",
+			},
+			"last_request_duration_ms": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "How long the last request about this object took, in milliseconds, including any retries.",
+			},
+			"extract": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A map of output names to paths in the API response (a JSONPath such as `$.network.interfaces[0].ip` or a `/`-delimited path, as with `id_attribute`). The values are set in `outputs`.",
+			},
+			"merge_server_defaults": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "When true, keys in the API response that are not in `data` are treated as server defaults. They do not count as remote changes, and updates with `update_mode = \"put\"` send them back along with `data` so they are not reset. Keys removed from `data` are still removed. Defaults to `false`.",
+			},
+			"read_empty_response": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"error", "keep", "gone"}, false),
+				Description:  "What to do when reading the object returns an empty body (such as 204 No Content) or one of `empty_response_bodies`. Defaults to `error`. With `keep`, the last known API response is kept, for APIs that only confirm the object exists. With `gone`, the object is removed from state and recreated. Empty responses to create and update requests are always handled by reading the object instead.",
+			},
+			"empty_response_bodies": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "JSON bodies (such as `{}`, `null` or `{\"data\": null}`) that count as an empty response when reading the object, for APIs that answer 200 with a placeholder for objects that are gone. They are handled as set by `read_empty_response`, so with `gone` the object is recreated.",
+			},
+			"omit_null_keys": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "When true, keys in `data` whose value is null are left out of requests instead of being sent as null, so optional values in a `jsonencode()` can be set to null to omit them. Null values in the API response are then treated like missing keys. Defaults to `false`.",
+			},
+			"null_value": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "A string (such as `__null__`) that is sent as JSON null wherever it appears as a value in `data`, `update_data` or `destroy_data`. Together with `omit_null_keys`, this separates clearing a field from leaving it out.",
+			},
+			"sensitive_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A list of paths to keys (such as `password` or `credentials/*/secret`, using the syntax of `ignore_server_keys`) whose values are masked in `api_data`, `api_response`, `create_response` and `outputs`, and in debug logs, to keep secrets such as generated passwords out of state, plans and logs. Remote changes to these keys are still detected.",
+			},
+			"outputs": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Computed:    true,
+				Sensitive:   isDataSensitive,
+				Description: "The values found in the API response for each entry of `extract`, so they can be referenced as `restapi_object.x.outputs[\"ip_address\"]` instead of decoding `api_response`. Strings are set as they are and other values as JSON. Paths that are not in the response are left out.",
+			},
+			"planned_request": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "The request the plan would send to create or update the object, with `method`, `url` and `body` (with `sensitive_keys` masked), after paths and data are filled in. `body` is left out when it comes from `body_template`, which is only rendered when the request is sent. Set at plan time so plan checks and `check` blocks can verify what would be sent. Unknown if the data or path are not known until apply, and left as it was when nothing changes.",
+				Computed:    true,
+				Sensitive:   isDataSensitive,
+			},
 			"create_response": {
 				Type:        schema.TypeString,
 				Description: "The raw body of the HTTP response returned when creating the object.",
@@ -145,10 +318,11 @@ func resourceRestAPI() *schema.Resource {
 				Description: "Any changes to these values will result in recreating the resource instead of updating.",
 			},
 			"update_data": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Valid JSON object to pass during to update requests.",
-				Sensitive:   isDataSensitive,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "Valid JSON object to pass during to update requests. String values may contain placeholders, as in `path`. A value that is only a placeholder, such as `\"{api_data.revision}\"`, keeps the type of the value it refers to.",
+				Sensitive:        isDataSensitive,
+				DiffSuppressFunc: suppressEquivalentJSON,
 				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
 					v := val.(string)
 					if v != "" {
@@ -162,10 +336,11 @@ func resourceRestAPI() *schema.Resource {
 				},
 			},
 			"destroy_data": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "Valid JSON object to pass during to destroy requests.",
-				Sensitive:   isDataSensitive,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "Valid JSON object to pass during to destroy requests. Placeholders such as `{api_data.revision}` are replaced as in `update_data`.",
+				Sensitive:        isDataSensitive,
+				DiffSuppressFunc: suppressEquivalentJSON,
 				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
 					v := val.(string)
 					if v != "" {
@@ -186,6 +361,310 @@ func resourceRestAPI() *schema.Resource {
 				Sensitive:   isDataSensitive,
 				// TODO ValidateFunc not supported for lists, but should probably validate that the ignore paths are valid
 			},
+			"create_only_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A list of keys in `data` (using the dot syntax of `ignore_changes_to`) that are only sent when creating the object, such as an initial password. They are left out of updates, and remote changes to them are ignored.",
+			},
+			"update_only_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A list of keys in `data` (using the dot syntax of `ignore_changes_to`) that the API does not accept when creating the object. They are left out of the create request and set by an update right after it.",
+			},
+			"gone_status_codes": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Optional:    true,
+				Description: "The HTTP status codes that mean the object no longer exists when reading it. The object is then removed from state and recreated instead of failing the refresh. Defaults to 404. Some APIs also use 410, or even 400 or 403, for deleted objects. When deleting the object, only 404 and 410 from this list count as success, since other codes can mean the delete was refused; see `destroy_success_codes`.",
+			},
+			"create_success_codes": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Optional:    true,
+				Description: "The HTTP status codes that count as a successful create. Defaults to any 2xx. For idempotent APIs, a code such as 409 can be added; the object is then read instead of parsing the response.",
+			},
+			"update_success_codes": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Optional:    true,
+				Description: "The HTTP status codes that count as a successful update. Defaults to any 2xx.",
+			},
+			"destroy_success_codes": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeInt},
+				Optional:    true,
+				Description: "The HTTP status codes that count as a successful destroy. Defaults to any 2xx. Add 404 and 410 so that objects that were already deleted do not fail the run.",
+			},
+			"deleted_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "For APIs that keep returning deleted objects, the path to a key in the read response (such as `status` or `meta/state`, see `id_attribute`) that tells whether the object was deleted. When its value is one of `deleted_values`, the object is removed from state and recreated.",
+			},
+			"deleted_values": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "The values of `deleted_key` that mean the object was deleted, such as `DELETED`.",
+			},
+			"destroy_wait": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "When set, destroying the object waits until reading it reports the object is gone (see `gone_status_codes` and `deleted_key`). This is useful for APIs that delete objects in the background, so that dependent resources are not destroyed too early.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"timeout": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     300,
+							Description: "How many seconds to wait for the object to be gone before failing.",
+						},
+						"poll_interval": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     5,
+							Description: "How many seconds to wait between reads of the object.",
+						},
+					},
+				},
+			},
+			"retry_create_on": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Retry failed creates whose error response matches one of `patterns`, with the provider's retry backoff. This is useful for transient errors such as a parent object that has not propagated yet.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"patterns": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Required:    true,
+							Description: "Regular expressions matched against the error response body (or the value at `key`).",
+						},
+						"key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The path to match `patterns` against in JSON error responses (a JSONPath such as `$.error.code` or a `/`-delimited path, as with `id_attribute`). Defaults to the whole response body.",
+						},
+						"timeout": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     300,
+							Description: "How many seconds to keep retrying the create before failing.",
+						},
+					},
+				},
+			},
+			"create_async": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "For APIs that create objects in the background and return an operation to follow, poll the operation until it is done, then read the object (or take it from `result_uri_key`). The object's id must be known from `data` or `id_from_header`, or be read from the result (see `result_uri_key`); the operation in the create response has its own id, which is not taken for the object's.",
+				Elem:        asyncSchema("create_async"),
+			},
+			"update_async": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "For APIs that update objects in the background and return an operation to follow, poll the operation until it is done, then read the object.",
+				Elem:        asyncSchema("update_async"),
+			},
+			"destroy_async": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "For APIs that delete objects in the background and return an operation to follow, poll the operation until it is done before the destroy finishes, so dependent resources are not destroyed too early.",
+				Elem:        asyncSchema("destroy_async"),
+			},
+			"follow_accepted": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "When true, a create, update or destroy that responds with 202 Accepted and a `Location` header waits for the operation: the `Location` is polled (honoring `Retry-After`, otherwise every 5 seconds for up to 300 seconds) until it stops responding with 202. Operations with a `*_async` block use it instead. Defaults to `false`.",
+			},
+			"destroy_behavior": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Defaults to `delete`. With `abandon`, destroying the object only removes it from state and leaves it on the API server, for objects the API does not allow to be deleted. To disable an object instead of deleting it, keep `delete` and point `destroy_method`, `destroy_path` and `destroy_data` at the API's disable request.",
+				ValidateFunc: validation.StringInSlice([]string{"delete", "abandon"}, false),
+			},
+			"prevent_destroy_remote": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "When true, destroying (or replacing) the object fails unless the environment variable `REST_API_ALLOW_DESTROY_PROTECTED` is set to `true`. Unlike the `prevent_destroy` lifecycle argument, this is kept in state so it also protects objects removed from the configuration.",
+			},
+			"version_key": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The `/`-delimited path to a version field in the API response (such as `metadata/resourceVersion`) for optimistic concurrency. The version the object was last read at is set in update requests and in `destroy_data`, and changes to it are not treated as drift. A 409 or 412 response then means someone else changed the object in the meantime. Since the version is also written to requests, JSONPath is not supported.",
+				ValidateFunc: func(val interface{}, key string) (warns []string, errs []error) {
+					if isJSONPath(val.(string)) {
+						errs = append(errs, fmt.Errorf("%s must be a '/'-delimited path, not JSONPath, since the version is written to requests at it", key))
+					}
+					return warns, errs
+				},
+			},
+			"use_etag": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "When true, the `ETag` header of the last response about the object is kept in `etag` and sent as `If-Match` with update and destroy requests, for APIs that use ETags for optimistic concurrency. The ETag is kept across runs, so no read is needed right before each write. A 412 response then means someone else changed the object in the meantime. Defaults to `false`.",
+			},
+			"etag": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The last ETag the API returned for the object, when `use_etag` is set.",
+			},
+			"exists_check": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "A request sent before creating the object to find out whether it already exists, for create-if-not-exists workflows where a duplicate must not be created. A 2xx response means the object exists and a `gone_status_codes` response that it does not. An existing object is handled as set by `create_conflict_behavior`. The object's id must be known, from `data` or `id_template`, unless `path` does not need it.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Defaults to the object's read path. The API path to send the request to, with placeholders as in `path`.",
+						},
+						"method": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Defaults to `HEAD`. The HTTP method of the request, `HEAD` or `GET`.",
+							ValidateFunc: validation.StringInSlice([]string{"HEAD", "GET"}, false),
+						},
+					},
+				},
+			},
+			"disable_protection": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "A request sent before deleting the object, for APIs that refuse to delete objects until their deletion protection is switched off.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The API path to send the request to. The string `{id}` will be replaced with the terraform ID of the object.",
+						},
+						"method": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Defaults to `update_method`. The HTTP method of the request.",
+						},
+						"data": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The body of the request, such as `{\"deletion_protection\": false}`.",
+						},
+					},
+				},
+			},
+			"body_template": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Go templates (see https://pkg.go.dev/text/template) for request bodies that cannot be expressed as plain JSON data. Templates can use `.id`, `.data` (the object's `data`) and `.api_data` (the object as last read from the API), and the `json` function renders a value as JSON (so `json .data.spec` embeds the whole `spec` of `data`). A template takes precedence over `data`, `update_data` and `destroy_data`.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"create": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The body sent to create the object.",
+						},
+						"update": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The body sent to update the object.",
+						},
+						"destroy": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The body sent to destroy the object.",
+						},
+					},
+				},
+			},
+			"hooks": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Extra requests sent before or after the object is created, updated or destroyed, such as triggering a deployment after each update. Hooks for the same point run in the order they are listed. Placeholders in `path` and `data` are replaced as in the resource's `path`.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"when": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "When to send the request. One of `pre_create`, `post_create`, `pre_update`, `post_update`, `pre_destroy` or `post_destroy`.",
+							ValidateFunc: validation.StringInSlice(hookTimes, false),
+						},
+						"path": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The API path to send the request to.",
+						},
+						"method": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "POST",
+							Description: "The HTTP method of the request.",
+						},
+						"data": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "The body of the request.",
+						},
+						"ignore_errors": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "When true, a failed request is logged instead of failing the operation.",
+						},
+					},
+				},
+			},
+			"ignore_server_keys": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "A list of paths to server-managed keys (such as `updated_at`, `etag` or `metadata/revision`) that are stripped from the API response before looking for remote changes, so they never cause a diff. Nested keys are separated by `/` and `*` matches any key or array index (for example `rules/*/hit_count`). The keys are still available in `api_data` and `api_response`.",
+			},
+			"normalize": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Rules applied to both `data` and the API response before looking for remote changes, so that differences the API does not care about are not treated as drift.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"lowercase_keys": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Compare keys case-insensitively.",
+						},
+						"trim_whitespace": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Ignore leading and trailing whitespace in string values.",
+						},
+						"numeric_strings": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Compare strings holding a number (such as `\"8080\"`) as that number.",
+						},
+						"sort_arrays": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Optional:    true,
+							Description: "Paths to arrays whose order does not matter, separated by `/` with `*` matching any key or array index (for example `tags` or `rules/*/ports`).",
+						},
+						"set_arrays": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Optional:    true,
+							Description: "Paths to arrays that are compared as sets, so neither the order nor repeated elements matter. Uses the same syntax as `sort_arrays`.",
+						},
+					},
+				},
+			},
 			"ignore_all_server_changes": {
 				Type:        schema.TypeBool,
 				Description: "By default Terraform will attempt to revert changes to remote resources. Set this to 'true' to ignore any remote changes. Default: false",
@@ -201,9 +680,31 @@ func resourceRestAPI() *schema.Resource {
    than the "id" passed on the command line, we have to use an opinionated
    view of the API paths to figure out how to read that object
    from the API */
-func resourceRestAPIImport(d *schema.ResourceData, meta interface{}) (imported []*schema.ResourceData, err error) {
+func resourceRestAPIImport(ctx context.Context, d *schema.ResourceData, meta interface{}) (imported []*schema.ResourceData, err error) {
+	client := meta.(*APIClient)
+	client.lockOperation()
+	defer client.unlockOperation()
+
 	input := d.Id()
 
+	/* Structured ids such as path=/v2/users,id=123,id_attribute=uuid set attributes directly */
+	if strings.Contains(input, "=") && !strings.HasPrefix(input, "/") {
+		attrs, err := parseImportID(input)
+		if err != nil {
+			return imported, err
+		}
+		for key, value := range attrs {
+			switch key {
+			case "id":
+			case "ignore_server_keys":
+				d.Set(key, strings.Split(value, ";"))
+			default:
+				d.Set(key, value)
+			}
+		}
+		return importObject(ctx, d, meta, attrs["id"])
+	}
+
 	hasTrailingSlash := strings.HasSuffix(input, "/")
 	var n int
 	if hasTrailingSlash {
@@ -226,22 +727,67 @@ func resourceRestAPIImport(d *schema.ResourceData, meta interface{}) (imported [
 		id = input[n+1:]
 	}
 
-	d.Set("data", fmt.Sprintf(`{ "id": "%s" }`, id))
+	return importObject(ctx, d, meta, id)
+}
+
+/* Attributes that can be set in a structured import id, besides id */
+var importIDAttributes = []string{"path", "id_attribute", "read_path", "update_path", "destroy_path", "query_string", "read_method", "ignore_server_keys"}
+
+/*
+Parse a structured import id of comma separated key=value pairs,
+
+	such as path=/v2/users,id=123,id_attribute=uuid. path and id are
+	required, and importIDAttributes may be set as well. The paths in
+	ignore_server_keys are separated by ;
+*/
+func parseImportID(input string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, pair := range strings.Split(input, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || (key != "id" && !contains(importIDAttributes, key)) {
+			return nil, fmt.Errorf("invalid import id '%s': '%s' is not one of id=..., %s=...", input, pair, strings.Join(importIDAttributes, "=..., "))
+		}
+		attrs[key] = strings.TrimSpace(parts[1])
+	}
+	if attrs["path"] == "" || attrs["id"] == "" {
+		return nil, fmt.Errorf("invalid import id '%s': path and id are required, as in path=/api/objects,id=1234", input)
+	}
+	return attrs, nil
+}
+
+/* Read the object being imported, once path and the other attributes needed to find it are set */
+func importObject(ctx context.Context, d *schema.ResourceData, meta interface{}, id string) (imported []*schema.ResourceData, err error) {
+	/* Until the object is read, data only has the id (under id_attribute when that is a plain key) */
+	idAttribute := d.Get("id_attribute").(string)
+	if idAttribute == "" || strings.Contains(idAttribute, "/") || isJSONPath(idAttribute) {
+		idAttribute = "id"
+	}
+	data, _ := json.Marshal(map[string]string{idAttribute: id})
+	d.Set("data", string(data))
 	d.SetId(id)
+	d.Set("ignore_all_server_changes", false)
 
 	/* Troubleshooting is hard enough. Emit log messages so TF_LOG
 	   has useful information in case an import isn't working */
 	d.Set("debug", true)
 
-	obj, err := makeAPIObject(d, meta)
+	obj, err := makeAPIObject(ctx, d, meta)
 	if err != nil {
 		return imported, err
 	}
-	log.Printf("resource_api_object.go: Import routine called. Object built:\n%s\n", obj.toString())
+	obj.log("DEBUG", "Import routine called", map[string]interface{}{"object": obj.toString()})
 
 	err = obj.readObject()
 	if err == nil {
 		setResourceState(obj, d)
+
+		/* Take data from the live object, so that configuration generated
+		   with terraform plan -generate-config-out is ready to use */
+		liveData := stripServerKeys(map[string]interface{}{}, obj.apiData, expandStringList(d.Get("ignore_server_keys").([]interface{})))
+		data, _ := json.Marshal(liveData)
+		d.Set("data", string(data))
+
 		/* Data that we set in the state above must be passed along
 		   as an item in the stack of imported data */
 		imported = append(imported, d)
@@ -250,40 +796,49 @@ func resourceRestAPIImport(d *schema.ResourceData, meta interface{}) (imported [
 	return imported, err
 }
 
-func resourceRestAPICreate(d *schema.ResourceData, meta interface{}) error {
-	obj, err := makeAPIObject(d, meta)
+func resourceRestAPICreate(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*APIClient)
+	client.lockOperation()
+	defer client.unlockOperation()
+
+	obj, err := makeAPIObject(ctx, d, meta)
 	if err != nil {
 		return err
 	}
-	log.Printf("resource_api_object.go: Create routine called. Object built:\n%s\n", obj.toString())
+	obj.log("DEBUG", "Create routine called", map[string]interface{}{"object": obj.toString()})
 
 	err = obj.createObject()
-	if err == nil {
+	/* When a later step (such as the update for update_only_keys) fails, the object
+	   still exists, so it is saved (and tainted) rather than created again next time */
+	if err == nil || (obj.created && obj.id != "") {
 		/* Setting terraform ID tells terraform the object was created or it exists */
 		d.SetId(obj.id)
 		setResourceState(obj, d)
 		/* Only set during create for APIs that don't return sensitive data on subsequent retrieval */
-		d.Set("create_response", obj.apiResponse)
+		d.Set("create_response", obj.stateAPIResponse())
 	}
 	return err
 }
 
-func resourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
-	obj, err := makeAPIObject(d, meta)
+func resourceRestAPIRead(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*APIClient)
+	client.lockOperation()
+	defer client.unlockOperation()
+
+	obj, err := makeAPIObject(ctx, d, meta)
 	if err != nil {
 		if strings.Contains(err.Error(), "error parsing data provided") {
-			log.Printf("resource_api_object.go: WARNING! The data passed from Terraform's state is invalid! %v", err)
-			log.Printf("resource_api_object.go: Continuing with partially constructed object...")
+			obj.log("WARN", "The data passed from Terraform's state is invalid! Continuing with partially constructed object", map[string]interface{}{"error": err.Error()})
 		} else {
 			return err
 		}
 	}
-	log.Printf("resource_api_object.go: Read routine called. Object built:\n%s\n", obj.toString())
+	obj.log("DEBUG", "Read routine called", map[string]interface{}{"object": obj.toString()})
 
 	err = obj.readObject()
 	if err == nil {
 		/* Setting terraform ID tells terraform the object was created or it exists */
-		log.Printf("resource_api_object.go: Read resource. Returned id is '%s'\n", obj.id)
+		obj.log("DEBUG", "Read resource", map[string]interface{}{"id": obj.id})
 		d.SetId(obj.id)
 
 		setResourceState(obj, d)
@@ -297,13 +852,37 @@ func resourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 					ignoreList = append(ignoreList, s.(string));
 				}
 			}
+			ignoreList = append(ignoreList, obj.createOnlyKeys...)
+
+			// Server-managed keys never count as drift
+			actualResource := obj.apiData
+			if v, ok := d.GetOk("ignore_server_keys"); ok {
+				actualResource = stripServerKeys(obj.data, obj.apiData, expandStringList(v.([]interface{})))
+			}
+			if obj.versionKey != "" {
+				actualResource = stripServerKeys(obj.data, actualResource, []string{obj.versionKey})
+			}
+			if obj.mergeServerDefaults {
+				actualResource = dropServerDefaults(obj.data, actualResource)
+			}
+
+			recordedResource := obj.dropNullKeys(obj.resolveNulls(obj.data))
+			actualResource = obj.dropNullKeys(actualResource)
 
 			// This checks if there were any changes to the remote resource that will need to be corrected
 			// by comparing the current state with the response returned by the api.
-			modifiedResource, hasDifferences := getDelta(obj.data, obj.apiData, ignoreList)
+			modifiedResource, hasDifferences := getDelta(recordedResource, actualResource, ignoreList)
+
+			// Normalizing only decides whether something changed, so data keeps the form the configuration uses
+			if normalize := expandNormalizeOpts(d.Get("normalize").([]interface{})); normalize != nil {
+				modifiedResource, hasDifferences = getDelta(normalize.normalizeObject(recordedResource), normalize.normalizeObject(actualResource), ignoreList)
+				if hasDifferences {
+					modifiedResource = normalize.denormalizeDelta(modifiedResource, recordedResource, actualResource, []string{})
+				}
+			}
 
 			if hasDifferences {
-				log.Printf("resource_api_object.go: Found differences in remote resource\n")
+				obj.log("INFO", "Found differences in remote resource", map[string]interface{}{"id": obj.id})
 				encoded, err := json.Marshal(modifiedResource)
 				if err != nil {
 					return err
@@ -317,23 +896,148 @@ func resourceRestAPIRead(d *schema.ResourceData, meta interface{}) error {
 	return err
 }
 
-func resourceRestAPIUpdate(d *schema.ResourceData, meta interface{}) error {
-	obj, err := makeAPIObject(d, meta)
+/*
+Mark data as requiring replacement when a key listed in force_new_keys
+
+	changes, mirroring ForceNew on native resources, and set
+	planned_request
+*/
+func resourceRestAPICustomizeDiff(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if err := validateAsyncBlocks(d); err != nil {
+		return err
+	}
+	replace, err := forceNewFromKeys(d)
 	if err != nil {
 		return err
 	}
+	return setPlannedRequest(d, meta, replace || d.HasChange("force_new"))
+}
+
+/* Check what the *_async blocks' schema cannot: that a status_key has a value to wait for */
+func validateAsyncBlocks(d *schema.ResourceDiff) error {
+	for _, operation := range []string{"create", "update", "destroy"} {
+		block := operation + "_async"
+		if !d.NewValueKnown(block + ".0.status_value") || !d.NewValueKnown(block + ".0.status_values") {
+			continue
+		}
+		settings := expandAsyncSettings(d.Get(block).([]interface{}))
+		if settings != nil && settings.SearchKey != "" && settings.SearchValue == "" && len(settings.SearchValues) == 0 {
+			return fmt.Errorf("%s: status_key requires status_value or status_values", block)
+		}
+	}
+	return nil
+}
+
+/* Force replacement if a key in force_new_keys changed, returning whether it did */
+func forceNewFromKeys(d *schema.ResourceDiff) (bool, error) {
+	if d.Id() == "" || !d.HasChange("data") {
+		return false, nil
+	}
+
+	forceNewKeys := expandStringList(d.Get("force_new_keys").([]interface{}))
+	if len(forceNewKeys) == 0 {
+		return false, nil
+	}
+
+	oldData, newData := d.GetChange("data")
+	oldObj := make(map[string]interface{})
+	newObj := make(map[string]interface{})
+	/* Invalid JSON is reported by the data validation */
+	if decodeJSON([]byte(oldData.(string)), &oldObj) != nil || decodeJSON([]byte(newData.(string)), &newObj) != nil {
+		return false, nil
+	}
+
+	for _, path := range forceNewKeys {
+		oldValue, _ := GetObjectAtKey(oldObj, path, false)
+		newValue, _ := GetObjectAtKey(newObj, path, false)
+		if !jsonValuesEqual(oldValue, newValue) {
+			log.Print(formatLog(logObject, "INFO", "Key in force_new_keys changed - object must be recreated", map[string]interface{}{"key": path}))
+			return true, d.ForceNew("data")
+		}
+	}
+	return false, nil
+}
+
+/*
+Set planned_request to the request that creating (if there is no id or
+
+	replace is set) or updating the object would send, if anything
+	changed. A request that cannot be built yet is left to fail at apply
+*/
+func setPlannedRequest(d *schema.ResourceDiff, meta interface{}, replace bool) error {
+	client, ok := meta.(*APIClient)
+	if !ok {
+		return nil
+	}
+	create := d.Id() == "" || replace
+	if !create && len(d.GetChangedKeysPrefix("")) == 0 {
+		return nil
+	}
+	for _, key := range []string{"data", "path", "object_id", "create_path", "update_path", "query_string", "update_data"} {
+		if !d.NewValueKnown(key) {
+			return d.SetNewComputed("planned_request")
+		}
+	}
 
-	/* If copy_keys is not empty, we have to grab the latest
-	   data so we can copy anything needed before the update */
+	opts, err := buildAPIObjectOpts(d)
+	if err != nil {
+		return nil
+	}
+	if create {
+		opts.id = d.Get("object_id").(string)
+		opts.priorResponse = ""
+	}
+	obj, err := NewAPIObject(client, opts)
+	if err != nil {
+		client.log(logObject, "DEBUG", "Not setting planned_request", map[string]interface{}{"error": err.Error()})
+		return nil
+	}
+
+	method, path, body, operation := obj.updateMethod, "", []byte{}, "update"
+	if create {
+		method, operation = obj.createMethod, "create"
+		path, body, err = obj.createRequest()
+	} else {
+		path, body, _, err = obj.updateRequest()
+	}
+	if err != nil {
+		client.log(logObject, "DEBUG", "Not setting planned_request", map[string]interface{}{"error": err.Error()})
+		return nil
+	}
+
+	planned := map[string]interface{}{
+		"method": method,
+		/* Checking endpoints' health would send requests during plan */
+		"url": client.currentURI() + obj.expandPath(path),
+	}
+	/* Templates are rendered from the object as it is read when the request
+	   is sent, so what plan would show may not be what is sent */
+	if _, templated := obj.bodyTemplates[operation]; !templated {
+		planned["body"] = client.sensitiveKeys.mask(string(body))
+	}
+	return d.SetNew("planned_request", planned)
+}
+
+func resourceRestAPIUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*APIClient)
-	if len(client.copyKeys) > 0 {
+	client.lockOperation()
+	defer client.unlockOperation()
+
+	obj, err := makeAPIObject(ctx, d, meta)
+	if err != nil {
+		return err
+	}
+
+	/* If copy_keys or copy_key_paths is not empty, we have to grab
+	   the latest data so we can copy anything needed before the update */
+	if len(client.copyKeys) > 0 || len(client.copyKeyPaths) > 0 {
 		err = obj.readObject()
 		if err != nil {
 			return err
 		}
 	}
 
-	log.Printf("resource_api_object.go: Update routine called. Object built:\n%s\n", obj.toString())
+	obj.log("DEBUG", "Update routine called", map[string]interface{}{"object": obj.toString()})
 
 	err = obj.updateObject()
 	if err == nil {
@@ -342,17 +1046,33 @@ func resourceRestAPIUpdate(d *schema.ResourceData, meta interface{}) error {
 	return err
 }
 
-func resourceRestAPIDelete(d *schema.ResourceData, meta interface{}) error {
-	obj, err := makeAPIObject(d, meta)
+func resourceRestAPIDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*APIClient)
+	client.lockOperation()
+	defer client.unlockOperation()
+
+	obj, err := makeAPIObject(ctx, d, meta)
 	if err != nil {
 		return err
 	}
-	log.Printf("resource_api_object.go: Delete routine called. Object built:\n%s\n", obj.toString())
+	obj.log("DEBUG", "Delete routine called", map[string]interface{}{"object": obj.toString()})
+
+	if d.Get("destroy_behavior").(string) == "abandon" {
+		obj.log("INFO", "Abandoning object (destroy_behavior=abandon). It is removed from state but not deleted from the API", map[string]interface{}{"id": obj.id})
+		return nil
+	}
+
+	if d.Get("prevent_destroy_remote").(bool) {
+		if allow, _ := strconv.ParseBool(GetEnvOrDefault("REST_API_ALLOW_DESTROY_PROTECTED", "false")); !allow {
+			return fmt.Errorf("'%s' at '%s' has prevent_destroy_remote set and cannot be destroyed. Set prevent_destroy_remote to false first, or set REST_API_ALLOW_DESTROY_PROTECTED=true to destroy it anyway", obj.id, d.Get("path").(string))
+		}
+	}
 
 	err = obj.deleteObject()
 	if err != nil {
-		if strings.Contains(err.Error(), "404") {
-			/* 404 means it doesn't exist. Call that good enough */
+		if code := responseCode(err); obj.isDeletedStatusCode(code) {
+			/* 404 (or 410 in gone_status_codes) means it doesn't exist. Call that good enough */
+			obj.log("INFO", "Object is already gone", map[string]interface{}{"status_code": code, "id": obj.id})
 			err = nil
 		}
 	}
@@ -360,16 +1080,19 @@ func resourceRestAPIDelete(d *schema.ResourceData, meta interface{}) error {
 }
 
 func resourceRestAPIExists(d *schema.ResourceData, meta interface{}) (exists bool, err error) {
-	obj, err := makeAPIObject(d, meta)
+	client := meta.(*APIClient)
+	client.lockOperation()
+	defer client.unlockOperation()
+
+	obj, err := makeAPIObject(context.Background(), d, meta)
 	if err != nil {
 		if strings.Contains(err.Error(), "error parsing data provided") {
-			log.Printf("resource_api_object.go: WARNING! The data passed from Terraform's state is invalid! %v", err)
-			log.Printf("resource_api_object.go: Continuing with partially constructed object...")
+			obj.log("WARN", "The data passed from Terraform's state is invalid! Continuing with partially constructed object", map[string]interface{}{"error": err.Error()})
 		} else {
 			return exists, err
 		}
 	}
-	log.Printf("resource_api_object.go: Exists routine called. Object built: %s\n", obj.toString())
+	obj.log("DEBUG", "Exists routine called", map[string]interface{}{"object": obj.toString()})
 
 	/* Assume all errors indicate the object just doesn't exist.
 	This may not be a good assumption... */
@@ -384,11 +1107,12 @@ func resourceRestAPIExists(d *schema.ResourceData, meta interface{}) (exists boo
    for the various calls terraform will use. Unfortunately,
    terraform cannot just reuse objects, so each CRUD operation
    results in a new object created */
-func makeAPIObject(d *schema.ResourceData, meta interface{}) (*APIObject, error) {
+func makeAPIObject(ctx context.Context, d *schema.ResourceData, meta interface{}) (*APIObject, error) {
 	opts, err := buildAPIObjectOpts(d)
 	if err != nil {
 		return nil, err
 	}
+	opts.ctx = ctx
 
 	caller := "unknown"
 	pc, _, _, ok := runtime.Caller(1)
@@ -397,14 +1121,26 @@ func makeAPIObject(d *schema.ResourceData, meta interface{}) (*APIObject, error)
 		parts := strings.Split(details.Name(), ".")
 		caller = parts[len(parts)-1]
 	}
-	log.Printf("resource_rest_api.go: Constructing new APIObject in makeAPIObject (called by %s)", caller)
+	meta.(*APIClient).log(logObject, "DEBUG", "Constructing new APIObject in makeAPIObject", map[string]interface{}{"caller": caller})
 
 	obj, err := NewAPIObject(meta.(*APIClient), opts)
 
 	return obj, err
 }
 
-func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
+/*
+What buildAPIObjectOpts reads a resource's attributes from: its
+
+	*schema.ResourceData, or its *schema.ResourceDiff while planning
+*/
+type resourceAttributes interface {
+	Get(key string) interface{}
+	GetOk(key string) (interface{}, bool)
+	GetChange(key string) (interface{}, interface{})
+	Id() string
+}
+
+func buildAPIObjectOpts(d resourceAttributes) (*apiObjectOpts, error) {
 	opts := &apiObjectOpts{
 		path: d.Get("path").(string),
 	}
@@ -413,6 +1149,11 @@ func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
 	if v, ok := d.GetOk("id_attribute"); ok {
 		opts.idAttribute = v.(string)
 	}
+	opts.idAttributes = expandStringList(d.Get("id_attributes").([]interface{}))
+
+	opts.idTemplate = d.Get("id_template").(string)
+	opts.idFromHeader = d.Get("id_from_header").(string)
+	opts.idFromHeaderRegex = d.Get("id_from_header_regex").(string)
 
 	/* Allow user to specify the ID manually */
 	if v, ok := d.GetOk("object_id"); ok {
@@ -422,7 +1163,7 @@ func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
 		opts.id = d.Id()
 	}
 
-	log.Printf("resource_rest_api.go: buildAPIObjectOpts routine called for id '%s'\n", opts.id)
+	log.Print(formatLog(logObject, "DEBUG", "buildAPIObjectOpts routine called", map[string]interface{}{"id": opts.id}))
 
 	if v, ok := d.GetOk("create_path"); ok {
 		opts.postPath = v.(string)
@@ -439,12 +1180,93 @@ func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
 	if v, ok := d.GetOk("read_method"); ok {
 		opts.readMethod = v.(string)
 	}
+	if v, ok := d.GetOk("read_data"); ok {
+		opts.readData = v.(string)
+	}
 	if v, ok := d.GetOk("update_method"); ok {
 		opts.updateMethod = v.(string)
 	}
 	if v, ok := d.GetOk("update_data"); ok {
 		opts.updateData = v.(string)
 	}
+	if v, ok := d.GetOk("update_mode"); ok {
+		opts.updateMode = v.(string)
+	}
+	if v, ok := d.GetOk("payload_format"); ok {
+		opts.payloadFormat = v.(string)
+	}
+	if v, ok := d.GetOk("gone_status_codes"); ok {
+		for _, code := range v.([]interface{}) {
+			opts.goneStatusCodes = append(opts.goneStatusCodes, code.(int))
+		}
+	}
+	opts.createOnlyKeys = expandStringList(d.Get("create_only_keys").([]interface{}))
+	opts.updateOnlyKeys = expandStringList(d.Get("update_only_keys").([]interface{}))
+	if v, ok := d.GetOk("deleted_key"); ok {
+		opts.deletedKey = v.(string)
+	}
+	if v, ok := d.GetOk("deleted_values"); ok {
+		opts.deletedValues = expandStringList(v.([]interface{}))
+	}
+	if v, ok := d.GetOk("destroy_wait"); ok && len(v.([]interface{})) > 0 && v.([]interface{})[0] != nil {
+		destroyWait := v.([]interface{})[0].(map[string]interface{})
+		opts.destroyWaitTimeout = destroyWait["timeout"].(int)
+		opts.destroyPollInterval = destroyWait["poll_interval"].(int)
+	}
+	opts.asyncSettings = make(map[string]*AsyncSettings)
+	opts.followAccepted = d.Get("follow_accepted").(bool)
+	for _, operation := range []string{"create", "update", "destroy"} {
+		if settings := expandAsyncSettings(d.Get(operation + "_async").([]interface{})); settings != nil {
+			opts.asyncSettings[operation] = settings
+		}
+	}
+	if v, ok := d.GetOk("retry_create_on"); ok && len(v.([]interface{})) > 0 && v.([]interface{})[0] != nil {
+		retryCreate := v.([]interface{})[0].(map[string]interface{})
+		opts.retryCreatePatterns = expandStringList(retryCreate["patterns"].([]interface{}))
+		opts.retryCreateKey = retryCreate["key"].(string)
+		opts.retryCreateTimeout = retryCreate["timeout"].(int)
+	}
+	if v, ok := d.GetOk("version_key"); ok {
+		opts.versionKey = v.(string)
+	}
+	opts.useETag = d.Get("use_etag").(bool)
+	opts.etag = d.Get("etag").(string)
+	if v, ok := d.GetOk("exists_check"); ok && len(v.([]interface{})) > 0 {
+		/* An empty block is a check with the defaults */
+		opts.existsCheck = make(map[string]string)
+		if block, ok := v.([]interface{})[0].(map[string]interface{}); ok {
+			for key, val := range block {
+				opts.existsCheck[key] = val.(string)
+			}
+		}
+	}
+	if v, ok := d.GetOk("disable_protection"); ok && len(v.([]interface{})) > 0 && v.([]interface{})[0] != nil {
+		opts.disableProtection = make(map[string]string)
+		for key, val := range v.([]interface{})[0].(map[string]interface{}) {
+			opts.disableProtection[key] = val.(string)
+		}
+	}
+	if v, ok := d.GetOk("body_template"); ok && len(v.([]interface{})) > 0 && v.([]interface{})[0] != nil {
+		opts.bodyTemplates = make(map[string]string)
+		for key, val := range v.([]interface{})[0].(map[string]interface{}) {
+			opts.bodyTemplates[key] = val.(string)
+		}
+	}
+	if v, ok := d.GetOk("hooks"); ok {
+		for _, iHook := range v.([]interface{}) {
+			hook := iHook.(map[string]interface{})
+			opts.hooks = append(opts.hooks, apiHook{
+				when:         hook["when"].(string),
+				method:       hook["method"].(string),
+				path:         hook["path"].(string),
+				data:         hook["data"].(string),
+				ignoreErrors: hook["ignore_errors"].(bool),
+			})
+		}
+	}
+	if v, ok := d.GetOk("create_conflict_behavior"); ok {
+		opts.createConflictBehavior = v.(string)
+	}
 	if v, ok := d.GetOk("destroy_method"); ok {
 		opts.destroyMethod = v.(string)
 	}
@@ -460,13 +1282,57 @@ func buildAPIObjectOpts(d *schema.ResourceData) (*apiObjectOpts, error) {
 
 	readSearch := expandReadSearch(d.Get("read_search").(map[string]interface{}))
 	opts.readSearch = readSearch
+	opts.adoptSearch = expandReadSearch(d.Get("adopt_search").(map[string]interface{}))
+	opts.headers = expandReadSearch(d.Get("headers").(map[string]interface{}))
+	opts.extract = expandReadSearch(d.Get("extract").(map[string]interface{}))
+	opts.auditMetadata = expandReadSearch(d.Get("audit_metadata").(map[string]interface{}))
+	opts.captureResponseHeaders = expandStringList(d.Get("capture_response_headers").([]interface{}))
+	opts.sensitiveKeys = expandStringList(d.Get("sensitive_keys").([]interface{}))
+	opts.mergeServerDefaults = d.Get("merge_server_defaults").(bool)
+	opts.omitNullKeys = d.Get("omit_null_keys").(bool)
+	opts.readEmptyResponse = d.Get("read_empty_response").(string)
+	opts.emptyResponseBodies = expandStringList(d.Get("empty_response_bodies").([]interface{}))
+	opts.nullValue = d.Get("null_value").(string)
+	opts.successCodes = make(map[string][]int)
+	opts.followAccepted = d.Get("follow_accepted").(bool)
+	for _, operation := range []string{"create", "update", "destroy"} {
+		for _, code := range d.Get(operation + "_success_codes").([]interface{}) {
+			opts.successCodes[operation] = append(opts.successCodes[operation], code.(int))
+		}
+	}
+	opts.operationHeaders = make(map[string]map[string]string)
+	for _, operation := range []string{"create", "read", "update", "destroy"} {
+		opts.operationHeaders[operation] = expandReadSearch(d.Get(operation + "_headers").(map[string]interface{}))
+	}
 
 	opts.data = d.Get("data").(string)
+	priorData, _ := d.GetChange("data")
+	opts.priorData = priorData.(string)
+	opts.priorResponse = d.Get("api_response").(string)
 	opts.debug = d.Get("debug").(bool)
 
 	return opts, nil
 }
 
+func expandNormalizeOpts(v []interface{}) *normalizeOpts {
+	if len(v) == 0 || v[0] == nil {
+		return nil
+	}
+	block := v[0].(map[string]interface{})
+	opts := &normalizeOpts{
+		lowercaseKeys:  block["lowercase_keys"].(bool),
+		trimWhitespace: block["trim_whitespace"].(bool),
+		numericStrings: block["numeric_strings"].(bool),
+	}
+	for _, path := range expandStringList(block["sort_arrays"].([]interface{})) {
+		opts.sortArrays = append(opts.sortArrays, strings.Split(strings.Trim(path, "/"), "/"))
+	}
+	for _, path := range expandStringList(block["set_arrays"].([]interface{})) {
+		opts.setArrays = append(opts.setArrays, strings.Split(strings.Trim(path, "/"), "/"))
+	}
+	return opts
+}
+
 func expandReadSearch(v map[string]interface{}) (readSearch map[string]string) {
 	readSearch = make(map[string]string)
 	for key, val := range v {
@@ -475,3 +1341,104 @@ func expandReadSearch(v map[string]interface{}) (readSearch map[string]string) {
 
 	return
 }
+
+/* The schema of the *_async blocks, see AsyncSettings */
+func asyncSchema(name string) *schema.Resource {
+	/* The ways to follow an operation, of which only one can be set */
+	conflicts := func(key string) []string {
+		others := []string{}
+		for _, other := range []string{"status_path", "redirect_uri_key", "events_path", "websocket_path"} {
+			if other != key {
+				others = append(others, name+".0."+other)
+			}
+		}
+		return others
+	}
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"status_path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: conflicts("status_path"),
+				Description:   "The path to poll for the operation's status, with placeholders as in the resource's `path`, such as `/operations/{operation_id}`. A bare `{KEY}` (`{id}` included) is first looked for in the response that started the operation. Only one of `status_path`, `redirect_uri_key`, `events_path` and `websocket_path` can be set.",
+			},
+			"redirect_uri_key": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: conflicts("redirect_uri_key"),
+				Description:   "The path to the operation URL in the response (see `id_attribute`). Defaults to the response's `Location` header.",
+			},
+			"status_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The path to the operation's status in responses from the operation URL (see `id_attribute`), such as `status`, `state/phase` or the JSONPath `$.conditions[?(@.type=='Ready')].status`. Requires `status_value` or `status_values`. If not set, the operation is done once the operation URL stops responding with 202 Accepted.",
+			},
+			"status_value": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The value of `status_key` once the operation is done, such as `SUCCEEDED`.",
+			},
+			"status_values": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "For APIs with several success states: values of `status_key` that mean the operation is done, such as `[\"SUCCEEDED\", \"COMPLETED\"]`, as well as `status_value`.",
+			},
+			"case_insensitive": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "When true, `status_value`, `status_values` and `failure_values` match `status_key` regardless of case. Defaults to `false`.",
+			},
+			"failure_values": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "Values of `status_key` that mean the operation failed, such as `FAILED`, so it is not polled until the timeout.",
+			},
+			"callback_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "For APIs that report finished operations by calling back rather than through a status URL: the path (see `id_attribute`) to the operation's id, both in the response that started it and in its callback. The callbacks are read from `status_path`, which must respond with a JSON list of the callbacks received, or from the events at `events_path` or messages at `websocket_path`. The operation is done once its callback is received and its `status_key` (if set) is `status_value`.",
+			},
+			"events_path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: conflicts("events_path"),
+				Description:   "For event-driven APIs: the path of a Server-Sent Events stream to watch, rather than polling, for an event whose data has `status_key` set to `status_value`. Placeholders are filled like in `status_path`. With `callback_key`, only events for the operation count. Otherwise, in case the operation finished before the stream was open, the status at the `Location` of the response that started it (if any) is checked once the stream is open.",
+			},
+			"event_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Only watch `events_path` for events of this type (their `event:` field), such as `operation.completed`.",
+			},
+			"websocket_path": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: conflicts("websocket_path"),
+				Description:   "For APIs that stream progress over a WebSocket: its path on the API server, or a full `ws://` or `wss://` URL on the host of `uri` or `failover_uris`, to watch for a message whose data has `status_key` set to `status_value`. Placeholders are filled like in `status_path`, and the operation's `Location` is checked like with `events_path`. With `callback_key`, only messages for the operation count.",
+			},
+			"progress_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The path to the operation's progress (such as a percentage) in its status (see `id_attribute`), which is logged at each check while the operation runs so long operations can be followed with `TF_LOG=INFO`.",
+			},
+			"result_uri_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "For APIs that only return the object at a result link once the operation is done: the path to its URL in the final status (see `id_attribute`). The response from the URL is used as the object, as if the create or update had returned it.",
+			},
+			"poll_interval": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     5,
+				Description: "How many seconds to wait between checks of the operation's status.",
+			},
+			"timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     300,
+				Description: "How many seconds to wait for the operation to finish before failing.",
+			},
+		},
+	}
+}
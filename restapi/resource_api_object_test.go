@@ -12,13 +12,20 @@ package restapi
   "github.com/hashicorp/terraform/config"
 */
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/Mastercard/terraform-provider-restapi/fakeserver"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 // example.Widget represents a concrete Go type that represents an API resource
@@ -106,6 +113,324 @@ func TestAccRestApiObject_Basic(t *testing.T) {
 	svr.Shutdown()
 }
 
+func TestAccRestApiObject_ForceNewKeys(t *testing.T) {
+	debug := false
+	apiServerObjects := make(map[string]map[string]interface{})
+
+	svr := fakeserver.NewFakeServer(8082, apiServerObjects, true, debug, "")
+	os.Setenv("REST_API_URI", "http://127.0.0.1:8082")
+
+	params := map[string]interface{}{"force_new_keys": []string{"spec/region"}}
+
+	/* create_response is only set on create, so it shows whether the object was recreated */
+	resource.UnitTest(t, resource.TestCase{
+		Providers: testAccProviders,
+		PreCheck:  func() { svr.StartInBackground() },
+		Steps: []resource.TestStep{
+			{
+				Config: generateTestResource("Foo", `{ "id": "1234", "name": "Foo", "spec": { "region": "east" } }`, params),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "create_response", `{"id":"1234","name":"Foo","spec":{"region":"east"}}`),
+				),
+			},
+			/* Other keys are updated in place */
+			{
+				Config: generateTestResource("Foo", `{ "id": "1234", "name": "Bar", "spec": { "region": "east" } }`, params),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "api_data.name", "Bar"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "create_response", `{"id":"1234","name":"Foo","spec":{"region":"east"}}`),
+				),
+			},
+			/* Changing a force_new_keys value recreates the object */
+			{
+				Config: generateTestResource("Foo", `{ "id": "1234", "name": "Bar", "spec": { "region": "west" } }`, params),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "create_response", `{"id":"1234","name":"Bar","spec":{"region":"west"}}`),
+				),
+			},
+		},
+	})
+
+	svr.Shutdown()
+}
+
+func TestAccRestApiObject_PlannedRequest(t *testing.T) {
+	debug := false
+	apiServerObjects := make(map[string]map[string]interface{})
+
+	svr := fakeserver.NewFakeServer(8082, apiServerObjects, true, debug, "")
+	os.Setenv("REST_API_URI", "http://127.0.0.1:8082")
+
+	params := map[string]interface{}{"sensitive_keys": []string{"secret"}}
+
+	resource.UnitTest(t, resource.TestCase{
+		Providers: testAccProviders,
+		PreCheck:  func() { svr.StartInBackground() },
+		Steps: []resource.TestStep{
+			{
+				Config: generateTestResource("Foo", `{ "id": "1234", "name": "Foo", "secret": "s3cret" }`, params),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.method", "POST"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.url", "http://127.0.0.1:8082/api/objects"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.body", `{"id":"1234","name":"Foo","secret":"(sensitive value)"}`),
+				),
+			},
+			{
+				Config: generateTestResource("Foo", `{ "id": "1234", "name": "Bar", "secret": "s3cret" }`, params),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.method", "PUT"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.url", "http://127.0.0.1:8082/api/objects/1234"),
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.body", `{"id":"1234","name":"Bar","secret":"(sensitive value)"}`),
+				),
+			},
+			{
+				/* Bodies from templates, which are rendered when the request is sent, are left out */
+				Config: `
+resource "restapi_object" "Foo" {
+  path = "/api/objects"
+  data = "{ \"id\": \"1234\", \"name\": \"Baz\" }"
+  ignore_server_keys = ["secret"]
+  body_template {
+    update = "{\"id\": \"1234\", \"name\": \"Baz\", \"secret\": {{ json .api_data.secret }}}"
+  }
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("restapi_object.Foo", "planned_request.method", "PUT"),
+					resource.TestCheckNoResourceAttr("restapi_object.Foo", "planned_request.body"),
+				),
+			},
+		},
+	})
+
+	svr.Shutdown()
+}
+
+func TestAccRestApiObject_AsyncValidation(t *testing.T) {
+	t.Setenv("REST_API_URI", "http://127.0.0.1:8082")
+
+	resource.UnitTest(t, resource.TestCase{
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+resource "restapi_object" "Foo" {
+  path = "/api/objects"
+  data = "{ \"id\": \"1234\" }"
+  create_async {
+    status_key = "status"
+  }
+}
+`,
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile("create_async: status_key requires status_value or status_values"),
+			},
+			{
+				Config: `
+resource "restapi_object" "Foo" {
+  path = "/api/objects"
+  data = "{ \"id\": \"1234\" }"
+  destroy_async {
+    status_path  = "/operations/{operation_id}"
+    events_path  = "/events"
+    status_key   = "status"
+    status_value = "done"
+  }
+}
+`,
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile("conflicts with"),
+			},
+		},
+	})
+}
+
+func TestAccRestApiObject_Normalize(t *testing.T) {
+	debug := false
+	apiServerObjects := make(map[string]map[string]interface{})
+
+	svr := fakeserver.NewFakeServer(8086, apiServerObjects, true, debug, "")
+	os.Setenv("REST_API_URI", "http://127.0.0.1:8086")
+
+	/* generateTestResource only produces attributes, so add the block by hand */
+	config := generateTestResource("Foo", `{ "id": "1234", "name": "Foo", "port": 8080, "tags": ["a", "b"] }`, map[string]interface{}{})
+	config = strings.Replace(config, `path = "/api/objects"`, `path = "/api/objects"
+normalize {
+  trim_whitespace = true
+  numeric_strings = true
+  sort_arrays     = ["tags"]
+}`, 1)
+
+	resource.UnitTest(t, resource.TestCase{
+		Providers: testAccProviders,
+		PreCheck:  func() { svr.StartInBackground() },
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+			},
+			/* Changes that only differ by normalization are not drift */
+			{
+				PreConfig: func() {
+					apiServerObjects["1234"]["name"] = " Foo "
+					apiServerObjects["1234"]["port"] = "8080"
+					apiServerObjects["1234"]["tags"] = []interface{}{"b", "a"}
+				},
+				Config:   config,
+				PlanOnly: true,
+			},
+			/* Real changes still are */
+			{
+				PreConfig: func() {
+					apiServerObjects["1234"]["name"] = "Bar"
+				},
+				Config:             config,
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+
+	svr.Shutdown()
+}
+
+func TestResourceRestAPIDeletePreventDestroyRemote(t *testing.T) {
+	var deletes int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			deletes++
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path":                   "/api/objects",
+		"prevent_destroy_remote": true,
+	})
+	d.SetId("1")
+
+	t.Setenv("REST_API_ALLOW_DESTROY_PROTECTED", "")
+	if err := resourceRestAPIDelete(context.Background(), d, client); err == nil || deletes != 0 {
+		t.Fatalf("resource_api_object_test.go: Expected a protected object not to be deleted but got %d deletes and error %v", deletes, err)
+	}
+
+	t.Setenv("REST_API_ALLOW_DESTROY_PROTECTED", "true")
+	if err := resourceRestAPIDelete(context.Background(), d, client); err != nil || deletes != 1 {
+		t.Fatalf("resource_api_object_test.go: Expected REST_API_ALLOW_DESTROY_PROTECTED to allow deleting but got %d deletes and error %v", deletes, err)
+	}
+}
+
+func TestResourceRestAPICreateFollowUpFails(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id":"1","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path":             "/api/objects",
+		"data":             `{"name":"Foo","enabled":true}`,
+		"update_only_keys": []interface{}{"enabled"},
+	})
+
+	if err := resourceRestAPICreate(context.Background(), d, client); err == nil || d.Id() != "1" {
+		t.Fatalf("resource_api_object_test.go: Expected the failed update to be returned with the created object's id saved but got id '%s' and error %v", d.Id(), err)
+	}
+}
+
+func TestResourceRestAPIUpdateCopyKeyPaths(t *testing.T) {
+	var updated string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			b, _ := io.ReadAll(r.Body)
+			updated = string(b)
+		}
+		w.Write([]byte(`{"id":"1","name":"Bar","meta":{"revision":"7"}}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, copyKeyPaths: map[string]string{"revision": "meta/revision"}})
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path": "/api/objects",
+		"data": `{"name":"Bar"}`,
+	})
+	d.SetId("1")
+
+	if err := resourceRestAPIUpdate(context.Background(), d, client); err != nil {
+		t.Fatalf("resource_api_object_test.go: %s", err)
+	}
+	if !strings.Contains(updated, `"revision":"7"`) {
+		t.Fatalf("resource_api_object_test.go: Expected the update to carry the copied revision but sent %s", updated)
+	}
+}
+
+func TestResourceRestAPIDeleteAbandon(t *testing.T) {
+	var requests int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path":             "/api/objects",
+		"destroy_behavior": "abandon",
+	})
+	d.SetId("1")
+
+	if err := resourceRestAPIDelete(context.Background(), d, client); err != nil || requests != 0 {
+		t.Fatalf("resource_api_object_test.go: Expected an abandoned object not to be deleted but got %d requests and error %v", requests, err)
+	}
+}
+
+func TestResourceRestAPIDeleteGone(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/objects/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/api/objects/error":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("error 404"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2})
+	for id, expectError := range map[string]bool{"missing": false, "gone": true, "error": true} {
+		d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{"path": "/api/objects"})
+		d.SetId(id)
+		if err := resourceRestAPIDelete(context.Background(), d, client); expectError != (err != nil) {
+			t.Fatalf("resource_api_object_test.go: Unexpected error deleting '%s': %v", id, err)
+		}
+	}
+
+	/* APIs that answer 410 for deleted objects list it in gone_status_codes */
+	d := schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path":              "/api/objects",
+		"gone_status_codes": []interface{}{404, 410},
+	})
+	d.SetId("gone")
+	if err := resourceRestAPIDelete(context.Background(), d, client); err != nil {
+		t.Fatalf("resource_api_object_test.go: Expected a 410 in gone_status_codes to count as deleted but got %v", err)
+	}
+
+	/* Other gone_status_codes only mean gone when reading: a 400 to DELETE is a failure */
+	d = schema.TestResourceDataRaw(t, resourceRestAPI().Schema, map[string]interface{}{
+		"path":              "/api/objects",
+		"gone_status_codes": []interface{}{400, 404},
+	})
+	d.SetId("error")
+	if err := resourceRestAPIDelete(context.Background(), d, client); err == nil {
+		t.Fatalf("resource_api_object_test.go: Expected a 400 to DELETE to fail even though it is in gone_status_codes")
+	}
+}
+
 /* This function generates a terraform JSON configuration from
    a name, JSON data and a list of params to set by coaxing it
    all to maps and then serializing to JSON */
@@ -117,6 +442,10 @@ func generateTestResource(name string, data string, params map[string]interface{
 	}
 	for k, v := range params {
 		entry := fmt.Sprintf(`%s = "%v"`, k, v)
+		if list, ok := v.([]string); ok {
+			strList, _ := json.Marshal(list)
+			entry = fmt.Sprintf(`%s = %s`, k, strList)
+		}
 		config = append(config, entry)
 	}
 	strConfig := ""
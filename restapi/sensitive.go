@@ -0,0 +1,208 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* What the values of sensitive_keys are replaced with */
+const sensitiveMask = "(sensitive value)"
+
+/*
+Replace the values at paths (separated by / with * matching any key
+
+	or array index, as in ignore_server_keys) with sensitiveMask. data
+	is not modified
+*/
+func maskSensitiveKeys(data map[string]interface{}, paths []string) map[string]interface{} {
+	for _, path := range paths {
+		path = strings.Trim(path, "/")
+		if path == "" {
+			continue
+		}
+		data = _maskPath(data, strings.Split(path, "/")).(map[string]interface{})
+	}
+	return data
+}
+
+func _maskPath(data interface{}, parts []string) interface{} {
+	part, rest := parts[0], parts[1:]
+
+	switch value := data.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(value))
+		for key, val := range value {
+			switch {
+			case part != "*" && part != key:
+				masked[key] = val
+			case len(rest) > 0:
+				masked[key] = _maskPath(val, rest)
+			default:
+				masked[key] = sensitiveMask
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(value))
+		for i, val := range value {
+			switch {
+			case part != "*" && part != strconv.Itoa(i):
+				masked[i] = val
+			case len(rest) > 0:
+				masked[i] = _maskPath(val, rest)
+			default:
+				masked[i] = sensitiveMask
+			}
+		}
+		return masked
+	}
+	return data
+}
+
+/*
+Mask sensitive keys in a JSON body. Bodies that are not JSON objects
+
+	are returned as they are
+*/
+func maskSensitiveJSON(body string, paths []string) string {
+	if len(paths) == 0 {
+		return body
+	}
+	var data map[string]interface{}
+	if err := decodeJSON([]byte(body), &data); err != nil {
+		return body
+	}
+	b, _ := json.Marshal(maskSensitiveKeys(data, paths))
+	return string(b)
+}
+
+/*
+sensitiveKeys collects the sensitive_keys of every object using the
+
+	client, so request and response bodies can be masked in its debug
+	logs. Masking keys of other objects too does no harm
+*/
+type sensitiveKeys struct {
+	mutex sync.Mutex
+	paths map[string]bool
+}
+
+func (keys *sensitiveKeys) add(paths []string) {
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+
+	if keys.paths == nil {
+		keys.paths = make(map[string]bool)
+	}
+	for _, path := range paths {
+		keys.paths[path] = true
+	}
+}
+
+/* Whether name is one of the keys (a top level path) */
+func (keys *sensitiveKeys) has(name string) bool {
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+	return keys.paths[name]
+}
+
+func (keys *sensitiveKeys) mask(body string) string {
+	keys.mutex.Lock()
+	paths := []string{}
+	for path := range keys.paths {
+		paths = append(paths, path)
+	}
+	keys.mutex.Unlock()
+
+	return maskSensitiveJSON(body, paths)
+}
+
+/* Keys of OAuth token responses, which are masked in cassette_file along with sensitive_keys */
+var oauthTokenKeys = []string{"access_token", "refresh_token", "id_token"}
+
+/* A request or response body as it is recorded in cassette_file */
+func (client *APIClient) maskCassetteBody(body string) string {
+	return maskSensitiveJSON(client.sensitiveKeys.mask(body), oauthTokenKeys)
+}
+
+/* Query parameters whose values are always redacted, along with sensitive_keys */
+var defaultSensitiveQueryParams = []string{"access_token", "api_key", "apikey", "client_secret", "key", "password", "secret", "sig", "signature", "token"}
+
+/* Whether the value of a query parameter is redacted wherever URLs are recorded */
+func (client *APIClient) isSensitiveQueryParam(name string) bool {
+	for _, sensitive := range defaultSensitiveQueryParams {
+		if strings.EqualFold(sensitive, name) {
+			return true
+		}
+	}
+	return client.sensitiveKeys.has(name)
+}
+
+/* u with the values of sensitive query parameters redacted, keeping their order */
+func (client *APIClient) redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil && client.isSensitiveQueryParam(unescaped) {
+			params[i] = name + "=" + url.QueryEscape(sensitiveMask)
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(params, "&")
+	return redacted.String()
+}
+
+/* Headers that are always redacted from debug logs, along with sensitive_headers */
+var defaultSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token"}
+
+/* Whether the value of a header is redacted from debug logs */
+func (client *APIClient) isSensitiveHeader(name string) bool {
+	for _, sensitive := range append(defaultSensitiveHeaders, client.sensitiveHeaders...) {
+		if http.CanonicalHeaderKey(sensitive) == http.CanonicalHeaderKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Redact the values of sensitive headers in a dump of a request or
+
+	response, and truncate values longer than max_logged_header_size
+*/
+func (client *APIClient) redactHeaders(dump string) string {
+	lines := strings.Split(dump, "\r\n")
+	for i, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || i == 0 {
+			continue
+		}
+		if client.isSensitiveHeader(name) {
+			lines[i] = name + ": " + sensitiveMask
+		} else {
+			lines[i] = name + ": " + truncateForLog(strings.TrimPrefix(value, " "), client.maxLoggedHeaderSize)
+		}
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+/* A body for the debug log, with sensitive keys masked and truncated to max_logged_body_size */
+func (client *APIClient) logBody(body string) string {
+	return truncateForLog(client.sensitiveKeys.mask(body), client.maxLoggedBodySize)
+}
+
+/* s cut to max bytes (if max is above 0), saying how long it was */
+func truncateForLog(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return fmt.Sprintf("%s... (truncated, %d bytes in total)", s[:max], len(s))
+}
@@ -0,0 +1,116 @@
+package restapi
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaskSensitiveJSON(t *testing.T) {
+	tests := []struct {
+		paths    []string
+		input    string
+		expected string
+	}{
+		{nil, `{"password":"x"}`, `{"password":"x"}`},
+		{[]string{"password"}, `{"name":"a","password":"x"}`, `{"name":"a","password":"(sensitive value)"}`},
+		{[]string{"missing"}, `{"name":"a"}`, `{"name":"a"}`},
+		{[]string{"credentials/*/secret"}, `{"credentials":[{"id":1,"secret":"x"},{"id":2,"secret":"y"}]}`, `{"credentials":[{"id":1,"secret":"(sensitive value)"},{"id":2,"secret":"(sensitive value)"}]}`},
+		{[]string{"keys/0"}, `{"keys":["x","y"]}`, `{"keys":["(sensitive value)","y"]}`},
+		{[]string{"auth"}, `{"auth":{"token":"x"}}`, `{"auth":"(sensitive value)"}`},
+		{[]string{"password"}, `not json`, `not json`},
+	}
+
+	for _, test := range tests {
+		masked := maskSensitiveJSON(test.input, test.paths)
+		if masked != test.expected {
+			t.Errorf("sensitive_test.go: Expected %s to be masked to %s but got %s", test.input, test.expected, masked)
+		}
+	}
+}
+
+func TestAPIObjectSensitiveKeys(t *testing.T) {
+	client, _ := NewAPIClient(&apiClientOpt{uri: "http://127.0.0.1:8081/", timeout: 2})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", sensitiveKeys: []string{"password"}})
+	object.updateState(`{"id":"1","password":"hunter2"}`)
+
+	if object.apiData["password"] != "hunter2" {
+		t.Fatalf("sensitive_test.go: Expected the real password to be kept for detecting changes")
+	}
+	if strings.Contains(object.stateAPIResponse(), "hunter2") || object.stateAPIData()["password"] != sensitiveMask {
+		t.Fatalf("sensitive_test.go: Expected the password to be masked in state but got %s", object.stateAPIResponse())
+	}
+	if strings.Contains(client.sensitiveKeys.mask(`{"password":"hunter2"}`), "hunter2") {
+		t.Fatalf("sensitive_test.go: Expected the client to mask the object's sensitive keys in logs")
+	}
+}
+
+func TestDebugOutputRedaction(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=cookie-secret")
+		w.Write([]byte(`{"id":"1","token":"response-secret"}`))
+	}))
+	defer svr.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, err := NewAPIClient(&apiClientOpt{
+		uri:              svr.URL,
+		timeout:          2,
+		debug:            true,
+		username:         "user",
+		password:         "password-secret",
+		headers:          map[string]string{"X-Vendor-Key": "header-secret", "X-Api-Key": "api-key-secret"},
+		sensitiveHeaders: []string{"x-vendor-key"},
+		sensitiveKeys:    []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("sensitive_test.go: %s", err)
+	}
+	if _, err := client.sendRequest("POST", "/api/objects", `{"id":"1","token":"request-secret"}`); err != nil {
+		t.Fatalf("sensitive_test.go: %s", err)
+	}
+
+	for _, secret := range []string{"password-secret", "header-secret", "api-key-secret", "cookie-secret", "request-secret", "response-secret", "dXNlcjpwYXNzd29yZC1zZWNyZXQ="} {
+		if strings.Contains(logs.String(), secret) {
+			t.Fatalf("sensitive_test.go: Expected '%s' to be redacted from the debug log:\n%s", secret, logs.String())
+		}
+	}
+	if !strings.Contains(logs.String(), "Authorization: (sensitive value)") {
+		t.Fatalf("sensitive_test.go: Expected the Authorization header to be logged as redacted:\n%s", logs.String())
+	}
+}
+
+func TestDebugOutputTruncation(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace", strings.Repeat("t", 100))
+		w.Write([]byte(`{"id":"1","items":"` + strings.Repeat("x", 1000) + `"}`))
+	}))
+	defer svr.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, debug: true, maxLoggedBodySize: 20, maxLoggedHeaderSize: 10})
+	body, err := client.sendRequest("GET", "/api/objects/1", "")
+	if err != nil {
+		t.Fatalf("sensitive_test.go: %s", err)
+	}
+	if len(body) != 1021 {
+		t.Fatalf("sensitive_test.go: Expected only the logged body to be truncated but got %d bytes", len(body))
+	}
+
+	if !strings.Contains(logs.String(), `body={"id":"1","items":"x... (truncated, 1021 bytes in total)`) {
+		t.Errorf("sensitive_test.go: Expected the body to be truncated in the debug log:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "X-Trace: tttttttttt... (truncated, 100 bytes in total)") {
+		t.Errorf("sensitive_test.go: Expected the header to be truncated in the debug log:\n%s", logs.String())
+	}
+}
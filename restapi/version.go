@@ -0,0 +1,89 @@
+package restapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+Return a copy of data with value set at a '/'-delimited path such as
+
+	metadata/resourceVersion. Maps along the path are copied rather
+	than modified, and created when missing
+*/
+func setAtKey(data map[string]interface{}, path string, value interface{}) map[string]interface{} {
+	parts := strings.SplitN(strings.Trim(path, "/"), "/", 2)
+	copied := make(map[string]interface{}, len(data)+1)
+	for key, val := range data {
+		copied[key] = val
+	}
+
+	if len(parts) == 1 {
+		copied[parts[0]] = value
+		return copied
+	}
+	child, _ := copied[parts[0]].(map[string]interface{})
+	copied[parts[0]] = setAtKey(child, parts[1], value)
+	return copied
+}
+
+/* The version of the object as last read from the API, if version_key is set */
+func (obj *APIObject) currentVersion() (interface{}, bool) {
+	if obj.versionKey == "" {
+		return nil, false
+	}
+	apiData := obj.apiData
+	if len(apiData) == 0 {
+		apiData = obj.priorAPIData
+	}
+	version, err := GetObjectAtKey(apiData, obj.versionKey, obj.debug)
+	if err != nil {
+		obj.log("WARN", "version_key is not in the last API response", map[string]interface{}{"version_key": obj.versionKey, "error": err.Error()})
+		return nil, false
+	}
+	return version, true
+}
+
+/*
+Set the version the object was last read at in a JSON request body,
+
+	so the API can reject the request if the object changed since.
+	Bodies that are not JSON objects are returned as they are
+*/
+func (obj *APIObject) withVersion(body []byte) []byte {
+	version, ok := obj.currentVersion()
+	if !ok {
+		return body
+	}
+	var data map[string]interface{}
+	if err := decodeJSON(body, &data); err != nil {
+		return body
+	}
+	b, _ := json.Marshal(setAtKey(data, obj.versionKey, version))
+	if obj.debug {
+		obj.log("DEBUG", "Sending version at version_key", map[string]interface{}{"version": version, "version_key": obj.versionKey})
+	}
+	return b
+}
+
+/*
+Explain a 409 or 412 response to a request that carried the object's
+
+	version or ETag: someone else changed the object since it was last read
+*/
+func (obj *APIObject) versionConflict(operation string, err error) error {
+	code := responseCode(err)
+	if code != http.StatusConflict && code != http.StatusPreconditionFailed {
+		return err
+	}
+	if obj.useETag && obj.etag != "" && code == http.StatusPreconditionFailed {
+		return fmt.Errorf("the API rejected the %s of '%s' because the object changed on the server after it was last seen with ETag %s; refresh and apply again: %v", operation, obj.id, obj.etag, err)
+	}
+	version, ok := obj.currentVersion()
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("the API rejected the %s of '%s' because the object changed on the server after it was last read at version %v (version_key %s); refresh and apply again: %v", operation, obj.id, version, obj.versionKey, err)
+}
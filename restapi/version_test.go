@@ -0,0 +1,121 @@
+package restapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetAtKey(t *testing.T) {
+	tests := []struct {
+		input    string
+		path     string
+		expected string
+	}{
+		{`{"name":"a"}`, "version", `{"name":"a","version":7}`},
+		{`{"name":"a","metadata":{"uid":"x"}}`, "metadata/resourceVersion", `{"metadata":{"resourceVersion":7,"uid":"x"},"name":"a"}`},
+		{`{"name":"a"}`, "/metadata/resourceVersion", `{"metadata":{"resourceVersion":7},"name":"a"}`},
+		{`{"metadata":"flat"}`, "metadata/resourceVersion", `{"metadata":{"resourceVersion":7}}`},
+	}
+
+	for _, test := range tests {
+		var input map[string]interface{}
+		json.Unmarshal([]byte(test.input), &input)
+		before, _ := json.Marshal(input)
+
+		b, _ := json.Marshal(setAtKey(input, test.path, 7))
+		if string(b) != test.expected {
+			t.Errorf("version_test.go: Expected setting %s in %s to give %s but got %s", test.path, test.input, test.expected, b)
+		}
+		if after, _ := json.Marshal(input); string(after) != string(before) {
+			t.Errorf("version_test.go: setAtKey modified its input %s", test.input)
+		}
+	}
+}
+
+func TestVersionKeyValidation(t *testing.T) {
+	validate := resourceRestAPI().Schema["version_key"].ValidateFunc
+	if _, errs := validate("metadata/resourceVersion", "version_key"); len(errs) != 0 {
+		t.Errorf("version_test.go: Expected a '/'-delimited version_key to be valid but got %v", errs)
+	}
+	if _, errs := validate("$.metadata.resourceVersion", "version_key"); len(errs) == 0 {
+		t.Errorf("version_test.go: Expected a JSONPath version_key to be rejected")
+	}
+}
+
+func TestAPIObjectVersionKey(t *testing.T) {
+	var bodies []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if !strings.Contains(string(body), `"resourceVersion":"42"`) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"id":"1","name":"Bar","metadata":{"resourceVersion":"43"}}`))
+	}))
+	defer svr.Close()
+
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{
+		path:          "/api/objects",
+		id:            "1",
+		data:          `{"id":"1","name":"Bar"}`,
+		destroyData:   `{"force":true}`,
+		versionKey:    "metadata/resourceVersion",
+		priorResponse: `{"id":"1","name":"Foo","metadata":{"resourceVersion":"42"}}`,
+	})
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("version_test.go: Expected the update to carry the last read version: %s (sent %v)", err, bodies)
+	}
+
+	/* The update response moved the object on to version 43, which the server now rejects */
+	err := object.deleteObject()
+	if err == nil || !strings.Contains(err.Error(), "changed on the server after it was last read at version 43") {
+		t.Fatalf("version_test.go: Expected a conflict explaining the stale version but got %v", err)
+	}
+	if !strings.Contains(bodies[1], `"force":true`) {
+		t.Fatalf("version_test.go: Expected the version to be added to destroy_data but sent %s", bodies[1])
+	}
+}
+
+func TestAPIObjectUseETag(t *testing.T) {
+	current := `"v2"`
+	var ifMatch []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch = append(ifMatch, r.Header.Get("If-Match"))
+		if r.Method != "GET" && r.Header.Get("If-Match") != current {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Method == "PUT" {
+			current = `"v3"`
+		}
+		w.Header().Set("ETag", current)
+		w.Write([]byte(`{"id":"1","name":"Foo"}`))
+	}))
+	defer svr.Close()
+
+	/* The ETag from state is used without reading the object first */
+	client, _ := NewAPIClient(&apiClientOpt{uri: svr.URL, timeout: 2, writeReturnsObject: true})
+	object, _ := NewAPIObject(client, &apiObjectOpts{path: "/api/objects", id: "1", data: `{"id":"1","name":"Foo"}`, useETag: true, etag: `"v2"`})
+	if err := object.updateObject(); err != nil {
+		t.Fatalf("version_test.go: Expected the update to carry the ETag from state: %s", err)
+	}
+	if object.etag != `"v3"` {
+		t.Fatalf("version_test.go: Expected the ETag of the update response to be kept but got %s", object.etag)
+	}
+
+	/* Someone else changed the object */
+	current = `"v4"`
+	err := object.deleteObject()
+	if err == nil || !strings.Contains(err.Error(), `after it was last seen with ETag "v3"`) {
+		t.Fatalf("version_test.go: Expected a conflict explaining the stale ETag but got %v", err)
+	}
+	if ifMatch[0] != `"v2"` || ifMatch[1] != `"v3"` {
+		t.Fatalf("version_test.go: Expected If-Match headers with the last ETags but got %v", ifMatch)
+	}
+}